@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a command of the CLI. It runs itself or one of its subcommands.
+// A command with run and subcommands only runs a subcommand if it is named by the first argument.
+type command struct {
+	name string
+	// args is the synopsis of the arguments, e.g. "<file>". Without it run is not called with arguments
+	args    string
+	summary string
+	// flags registers the flags of the command
+	flags func(fs *flag.FlagSet)
+	// before is called after the flags are parsed and before a subcommand is run
+	before func(fs *flag.FlagSet) error
+	// run runs the command with the arguments left after its flags
+	run func(fs *flag.FlagSet, args []string) error
+	// defaultArgs returns the arguments used if no subcommand is given
+	defaultArgs func(fs *flag.FlagSet) []string
+	commands    []*command
+}
+
+// execute parses the flags of c from args and runs c or the subcommand named by the first remaining argument.
+// path is the name c was called with, including the names of its parents.
+// It returns flag.ErrHelp if help was requested.
+func (c *command) execute(path string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(out)
+	if c.flags != nil {
+		c.flags(fs)
+	}
+	fs.Usage = func() {
+		c.printUsage(path, fs, out)
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if c.before != nil {
+		err = c.before(fs)
+		if err != nil {
+			return err
+		}
+	}
+
+	args = fs.Args()
+	if c.run != nil && (len(args) == 0 || c.find(args[0]) == nil) {
+		if len(args) > 0 && c.args == "" {
+			return fmt.Errorf("unexpected argument %q, see %s -h", args[0], path)
+		}
+		return c.run(fs, args)
+	}
+
+	if len(args) == 0 && c.defaultArgs != nil {
+		args = c.defaultArgs(fs)
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("missing command, see %s -h", path)
+	}
+	name := args[0]
+	if name == "help" {
+		return c.help(path, args[1:], out)
+	}
+	sub := c.find(name)
+	if sub == nil {
+		return fmt.Errorf("unknown command %q, see %s -h", name, path)
+	}
+	return sub.execute(path+" "+sub.name, args[1:], out)
+}
+
+// help prints the usage of the subcommand named by args.
+func (c *command) help(path string, args []string, out io.Writer) error {
+	cmd := c
+	for _, name := range args {
+		sub := cmd.find(name)
+		if sub == nil {
+			return fmt.Errorf("unknown command %q, see %s -h", name, path)
+		}
+		cmd = sub
+		path += " " + name
+	}
+	return cmd.execute(path, []string{"-h"}, out)
+}
+
+func (c *command) find(name string) *command {
+	for _, sub := range c.commands {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// printUsage writes the synopsis, subcommands and flags of c to out.
+func (c *command) printUsage(path string, fs *flag.FlagSet, out io.Writer) {
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) {
+		hasFlags = true
+	})
+
+	synopsis := []string{"Usage:", path}
+	if hasFlags {
+		synopsis = append(synopsis, "[flags]")
+	}
+	if len(c.commands) > 0 && c.run != nil {
+		synopsis = append(synopsis, "[command]")
+	} else if len(c.commands) > 0 {
+		synopsis = append(synopsis, "<command>")
+	}
+	if c.args != "" {
+		synopsis = append(synopsis, c.args)
+	}
+	_, _ = fmt.Fprintln(out, strings.Join(synopsis, " "))
+	if c.summary != "" {
+		_, _ = fmt.Fprintf(out, "\n%s\n", c.summary)
+	}
+
+	if len(c.commands) > 0 {
+		_, _ = fmt.Fprintln(out, "\nCommands:")
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for _, sub := range c.commands {
+			_, _ = fmt.Fprintf(w, "  %s\t%s\n", sub.name, sub.summary)
+		}
+		_ = w.Flush()
+		_, _ = fmt.Fprintf(out, "\nRun '%s help <command>' for the usage of a command.\n", path)
+	}
+
+	if hasFlags {
+		_, _ = fmt.Fprintln(out, "\nFlags:")
+		fs.PrintDefaults()
+	}
+}
+
+// isHelp checks if err was returned because help was requested.
+func isHelp(err error) bool {
+	return errors.Is(err, flag.ErrHelp)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testCommand(calls *[]string) *command {
+	var verbose bool
+	return &command{
+		summary: "Test app.",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&verbose, "v", false, "verbose output")
+		},
+		before: func(_ *flag.FlagSet) error {
+			*calls = append(*calls, "before")
+			return nil
+		},
+		commands: []*command{
+			{
+				name:    "greet",
+				args:    "<name>",
+				summary: "Greet someone",
+				run: func(_ *flag.FlagSet, args []string) error {
+					if verbose {
+						*calls = append(*calls, "verbose")
+					}
+					*calls = append(*calls, append([]string{"greet"}, args...)...)
+					return nil
+				},
+				commands: []*command{
+					{
+						name:    "all",
+						summary: "Greet everyone",
+						run: func(_ *flag.FlagSet, _ []string) error {
+							*calls = append(*calls, "greet all")
+							return nil
+						},
+					},
+				},
+			},
+			{
+				name:    "fail",
+				summary: "Always fails",
+				run: func(_ *flag.FlagSet, _ []string) error {
+					return errors.New("failed")
+				},
+			},
+		},
+	}
+}
+
+func TestCommand_execute(t *testing.T) {
+	var calls []string
+	var out bytes.Buffer
+
+	err := testCommand(&calls).execute("app", []string{"-v", "greet", "Bob"}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"before", "verbose", "greet", "Bob"}, calls)
+
+	calls = nil
+	err = testCommand(&calls).execute("app", []string{"greet", "all"}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"before", "greet all"}, calls)
+
+	err = testCommand(&calls).execute("app", []string{"fail"}, &out)
+	assert.EqualError(t, err, "failed")
+
+	err = testCommand(&calls).execute("app", []string{"unknown"}, &out)
+	assert.EqualError(t, err, `unknown command "unknown", see app -h`)
+
+	calls = nil
+	err = testCommand(&calls).execute("app", []string{"greet", "all", "Bob"}, &out)
+	assert.EqualError(t, err, `unexpected argument "Bob", see app greet all -h`)
+	err = testCommand(&calls).execute("app", []string{"fail", "now"}, &out)
+	assert.EqualError(t, err, `unexpected argument "now", see app fail -h`)
+	assert.Equal(t, []string{"before", "before"}, calls)
+
+	err = testCommand(&calls).execute("app", []string{"-x"}, &out)
+	assert.Error(t, err)
+
+	out.Reset()
+	err = testCommand(&calls).execute("app", nil, &out)
+	assert.EqualError(t, err, "missing command, see app -h")
+	assert.Contains(t, out.String(), "Usage: app [flags] <command>")
+}
+
+func TestCommand_defaultArgs(t *testing.T) {
+	var calls []string
+	var out bytes.Buffer
+
+	cmd := testCommand(&calls)
+	cmd.defaultArgs = func(_ *flag.FlagSet) []string {
+		return []string{"greet", "Alice"}
+	}
+	err := cmd.execute("app", nil, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"before", "greet", "Alice"}, calls)
+}
+
+func TestCommand_help(t *testing.T) {
+	var calls []string
+	var out bytes.Buffer
+
+	err := testCommand(&calls).execute("app", []string{"-h"}, &out)
+	assert.True(t, isHelp(err))
+	assert.Contains(t, out.String(), "Test app.")
+	assert.Contains(t, out.String(), "greet  Greet someone")
+	assert.Contains(t, out.String(), "verbose output")
+
+	out.Reset()
+	err = testCommand(&calls).execute("app", []string{"help", "greet"}, &out)
+	assert.True(t, isHelp(err))
+	assert.Contains(t, out.String(), "Usage: app greet [command] <name>")
+
+	err = testCommand(&calls).execute("app", []string{"help", "unknown"}, &out)
+	assert.EqualError(t, err, `unknown command "unknown", see app -h`)
+	assert.False(t, isHelp(err))
+}
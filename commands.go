@@ -0,0 +1,401 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/config"
+	"github.com/elivlo/SpotifyHistorySaver/export"
+	"github.com/elivlo/SpotifyHistorySaver/login"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/elivlo/SpotifyHistorySaver/server"
+	"github.com/elivlo/SpotifyHistorySaver/spotifySaver"
+	"github.com/elivlo/SpotifyHistorySaver/webhook"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// sourceSpotify is the source of the import command for Spotify's extended streaming history
+const sourceSpotify = "spotify"
+
+// newCLI creates the commands of the app. The config is loaded before any command runs.
+// Without command the deprecated flags are translated to their command, by default run.
+func newCLI() *command {
+	var (
+		configFile, logLevel, logFormat string
+		createDb, migrate, loginFlag    bool
+		importFile                      string
+	)
+	return &command{
+		summary: "Saves your Spotify listening history to a database.",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&configFile, "config", "", "config: will load this config file instead of config.yml")
+			fs.StringVar(&logLevel, "log_level", "", "log_level: will override the configured log level")
+			fs.StringVar(&logFormat, "log_format", "", "log_format: will override the configured log format (text/json)")
+
+			fs.BoolVar(&createDb, "create_db", false, "deprecated: use db create")
+			fs.BoolVar(&migrate, "migrate", false, "deprecated: use db migrate")
+			fs.BoolVar(&loginFlag, "login", false, "deprecated: use login")
+			fs.StringVar(&importFile, "import", "", "deprecated: use import <file>")
+			fs.Bool("playback", false, "deprecated: use run -playback")
+			fs.Bool("serve", false, "deprecated: use run -serve")
+		},
+		before: func(fs *flag.FlagSet) error {
+			c, err := config.Load(configFile)
+			if err != nil {
+				return err
+			}
+			fs.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "log_level":
+					c.Log.Level = logLevel
+				case "log_format":
+					c.Log.Format = logFormat
+				}
+			})
+			cfg = c
+			return nil
+		},
+		defaultArgs: func(fs *flag.FlagSet) []string {
+			var args []string
+			switch {
+			case createDb:
+				args = []string{"db", "create"}
+			case migrate:
+				args = []string{"db", "migrate"}
+			case loginFlag:
+				args = []string{"login"}
+			case importFile != "":
+				args = []string{"import", importFile}
+			default:
+				args = []string{"run"}
+				fs.Visit(func(f *flag.Flag) {
+					if f.Name == "playback" || f.Name == "serve" {
+						args = append(args, "-"+f.Name+"="+f.Value.String())
+					}
+				})
+				if len(args) == 1 {
+					return args
+				}
+			}
+			log.Warnf("The flags -create_db, -migrate, -login, -import, -playback and -serve are deprecated, use: %v", args)
+			return args
+		},
+		commands: []*command{
+			runCommand(),
+			serveCommand(),
+			loginCommand(),
+			dbCommand(),
+			importCommand(),
+			listenBrainzCommand(),
+			webhooksCommand(),
+			exportCommand(),
+			reportCommand(),
+			configCommand(),
+		},
+	}
+}
+
+// runCommand saves the history until it is interrupted.
+func runCommand() *command {
+	var playback, serve bool
+	return &command{
+		name:    "run",
+		summary: "Save the history until interrupted (default command)",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&playback, "playback", false, "playback: will additionally track the currently playing song to capture skips and partial plays")
+			fs.BoolVar(&serve, "serve", false, "serve: will additionally serve the HTTP API, dashboard, metrics and health endpoints")
+		},
+		run: func(fs *flag.FlagSet, _ []string) error {
+			fs.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "playback":
+					cfg.Polling.Playback = playback
+				case "serve":
+					cfg.Server.Enabled = serve
+				}
+			})
+			err := setupApp()
+			if err != nil {
+				return err
+			}
+
+			s, err := spotifySaver.NewSpotifySaver(log, cfg.Database.Env)
+			if err != nil {
+				return err
+			}
+			var srv *server.Server
+			if cfg.Server.Enabled {
+				srv = newServer(models.DB, s)
+			}
+			return startApp(s, srv)
+		},
+	}
+}
+
+// serveCommand serves the HTTP API without saving the history, e.g. next to a running saver.
+func serveCommand() *command {
+	return &command{
+		name:    "serve",
+		summary: "Serve the HTTP API and dashboard without saving the history",
+		run: func(_ *flag.FlagSet, _ []string) error {
+			cfg.Server.Enabled = true
+			err := setupApp()
+			if err != nil {
+				return err
+			}
+			return startServer(server.NewServer(models.DB, cfg.Server.Address, cfg.Server.APIKey, log))
+		},
+	}
+}
+
+// startServer serves srv until the app is interrupted.
+func startServer(srv *server.Server) error {
+	err := srv.Listen()
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go srv.StartServer(&wg, stopOnInterrupt())
+	wg.Wait()
+	return nil
+}
+
+func loginCommand() *command {
+	return &command{
+		name:    "login",
+		summary: "Get an OAuth2 token for your Spotify account",
+		run: func(_ *flag.FlagSet, _ []string) error {
+			err := setupApp()
+			if err != nil {
+				return err
+			}
+			return loginAccount(login.NewLogin(cfg.Spotify.CallbackURI, cfg.Spotify.ClientID, cfg.Spotify.ClientSecret, log))
+		},
+		commands: []*command{
+			{
+				name:    "lastfm",
+				summary: "Get a session for your Last.fm account to scrobble new plays",
+				run: func(_ *flag.FlagSet, _ []string) error {
+					err := setupApp()
+					if err != nil {
+						return err
+					}
+					return loginLastFM(newLastFMClient())
+				},
+			},
+		},
+	}
+}
+
+func dbCommand() *command {
+	var yes bool
+	return &command{
+		name:    "db",
+		summary: "Manage the database",
+		commands: []*command{
+			{
+				name:    "create",
+				summary: "Create the database",
+				run: func(_ *flag.FlagSet, _ []string) error {
+					err := setupApp()
+					if err != nil {
+						return err
+					}
+					return createDB(models.DB)
+				},
+			},
+			{
+				name:    "migrate",
+				summary: "Migrate the database to the current schema",
+				run: func(_ *flag.FlagSet, _ []string) error {
+					err := setupApp()
+					if err != nil {
+						return err
+					}
+					return migrateDB(models.DB)
+				},
+			},
+			{
+				name:    "rollback",
+				args:    "[n]",
+				summary: "Revert the last n migrations, by default one",
+				flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&yes, "yes", false, "yes: revert migrations that delete saved plays without asking")
+				},
+				run: func(_ *flag.FlagSet, args []string) error {
+					n := 1
+					if len(args) > 1 {
+						return fmt.Errorf("expected at most one number of migrations, got %d arguments", len(args))
+					}
+					if len(args) == 1 {
+						var err error
+						n, err = strconv.Atoi(args[0])
+						if err != nil || n < 1 {
+							return fmt.Errorf("number of migrations must be positive: %q", args[0])
+						}
+					}
+					err := setupApp()
+					if err != nil {
+						return err
+					}
+					return rollbackDB(models.DB, n, yes, os.Stdin, os.Stdout)
+				},
+			},
+			{
+				name:    "status",
+				summary: "List the applied and pending migrations",
+				run: func(_ *flag.FlagSet, _ []string) error {
+					err := setupApp()
+					if err != nil {
+						return err
+					}
+					return printMigrationStatus(models.DB, os.Stdout)
+				},
+			},
+		},
+	}
+}
+
+func importCommand() *command {
+	var source string
+	return &command{
+		name:    "import",
+		args:    "<file>",
+		summary: "Import a file of Spotify's extended streaming history or a Last.fm or ListenBrainz export",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&source, "source", sourceSpotify, "source: import an export of spotify (extended streaming history), "+
+				"lastfm or listenbrainz, as .csv or .json")
+		},
+		run: func(_ *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected one file to import, got %d", len(args))
+			}
+			if source != sourceSpotify && source != models.SourceLastFM && source != models.SourceListenBrainz {
+				return fmt.Errorf("unknown source %q, expected %s, %s or %s", source, sourceSpotify, models.SourceLastFM, models.SourceListenBrainz)
+			}
+			err := setupApp()
+			if err != nil {
+				return err
+			}
+			s, err := spotifySaver.NewSpotifySaver(log, cfg.Database.Env)
+			if err != nil {
+				return err
+			}
+			if source == sourceSpotify {
+				return importHistory(s, args[0])
+			}
+			return importScrobbles(s, source, args[0])
+		},
+	}
+}
+
+func listenBrainzCommand() *command {
+	return &command{
+		name:    "listenbrainz",
+		summary: "Submit plays to ListenBrainz",
+		commands: []*command{
+			{
+				name:    "backfill",
+				summary: "Submit all plays that were not submitted yet, resuming an interrupted backfill",
+				run: func(_ *flag.FlagSet, _ []string) error {
+					err := setupApp()
+					if err != nil {
+						return err
+					}
+					s, err := spotifySaver.NewSpotifySaver(log, cfg.Database.Env)
+					if err != nil {
+						return err
+					}
+					return backfillListenBrainz(s, newListenBrainzClient())
+				},
+			},
+		},
+	}
+}
+
+func webhooksCommand() *command {
+	return &command{
+		name:    "webhooks",
+		summary: "Post new plays to webhook targets",
+		commands: []*command{
+			{
+				name:    "test",
+				summary: "Post a test payload with a sample play to all configured targets",
+				run: func(_ *flag.FlagSet, _ []string) error {
+					err := cfg.Validate()
+					if err != nil {
+						return err
+					}
+					return testWebhooks(webhook.NewClient(), webhookTargets(), os.Stdout)
+				},
+			},
+		},
+	}
+}
+
+func exportCommand() *command {
+	var format, since, until, output string
+	var full bool
+	return &command{
+		name:    "export",
+		summary: "Export the history with tracks, albums and artists",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&format, "format", export.FormatCSV, "format: export as csv, jsonl, endsong (Spotify's extended streaming history) or parquet")
+			fs.StringVar(&since, "since", "", "since: only export plays on or after this date (YYYY-MM-DD)")
+			fs.StringVar(&until, "until", "", "until: only export plays on or before this date (YYYY-MM-DD)")
+			fs.StringVar(&output, "output", "", "output: file to write, by default history.<format> or endsong_0.json in the export directory, - for stdout. "+
+				"Directory for parquet, by default parquet in the export directory")
+			fs.BoolVar(&full, "full", false, "full: rewrite all partitions of a parquet export instead of only the latest and new ones")
+		},
+		run: func(_ *flag.FlagSet, _ []string) error {
+			if format == export.FormatParquet && (since != "" || until != "" || output == "-") {
+				return fmt.Errorf("parquet exports are partitioned by month and written to a directory, since, until and - are not supported")
+			}
+			period, err := parsePeriod(since, until)
+			if err != nil {
+				return err
+			}
+			err = setupApp()
+			if err != nil {
+				return err
+			}
+			if format == export.FormatParquet {
+				return exportParquet(models.DB, output, full)
+			}
+			return exportHistory(models.DB, format, period, output, os.Stdout)
+		},
+	}
+}
+
+func reportCommand() *command {
+	return &command{
+		name:    "report",
+		args:    "<top|time|wrapped> [flags]",
+		summary: "Print reports over the history, see report <report> -h",
+		run: func(_ *flag.FlagSet, args []string) error {
+			err := setupApp()
+			if err != nil {
+				return err
+			}
+			return runReport(models.DB, args, os.Stdout)
+		},
+	}
+}
+
+func configCommand() *command {
+	return &command{
+		name:    "config",
+		summary: "Show the configuration",
+		commands: []*command{
+			{
+				name:    "print",
+				summary: "Print the effective config with masked secrets",
+				run: func(_ *flag.FlagSet, _ []string) error {
+					return printConfig(os.Stdout)
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,591 @@
+package config
+
+import (
+	"fmt"
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFile is the config file that is loaded if it exists and no other file is given
+const DefaultFile = "config.yml"
+
+// Env variables overriding the config file.
+const (
+	// EnvConfigFile is the env variable name for the config file
+	EnvConfigFile = "CONFIG_FILE"
+
+	// EnvClientID is the env variable name for spotify client id
+	EnvClientID = "CLIENT_ID"
+	// EnvClientSecret is the env variable name for spotify client secret
+	EnvClientSecret = "CLIENT_SECRET"
+	// EnvCallbackURI is the env variable name for the URL used to log in to the spotify account
+	EnvCallbackURI = "CALLBACK_URI"
+	// EnvTokenFile is the env variable name for the file the OAuth token is saved to
+	EnvTokenFile = "TOKEN_FILE"
+
+	// GoEnv is the env variable that defines in which stage the app is running
+	// (development/production/test). It selects the connection of database.yml.
+	GoEnv = "GO_ENV"
+	// EnvDatabaseHost is the env variable name for the MySQL hostname
+	EnvDatabaseHost = "DATABASE_HOST"
+	// EnvDatabasePort is the env variable name for the MySQL port
+	EnvDatabasePort = "DATABASE_PORT"
+	// EnvDatabaseName is the env variable name for the database name
+	EnvDatabaseName = "DATABASE_NAME"
+	// EnvDatabaseUser is the env variable name for the database username
+	EnvDatabaseUser = "DATABASE_USER"
+	// EnvDatabasePassword is the env variable name for the database user password
+	EnvDatabasePassword = "DATABASE_PASSWORD"
+
+	// EnvHistoryInterval is the env variable name for the time between polls of the recently played history
+	EnvHistoryInterval = "POLL_HISTORY_INTERVAL"
+	// EnvPlayback is the env variable name to enable tracking the currently playing song
+	EnvPlayback = "POLL_PLAYBACK"
+	// EnvPlaybackInterval is the env variable name for the time between requests of the player state
+	EnvPlaybackInterval = "POLL_PLAYBACK_INTERVAL"
+	// EnvEnrichmentInterval is the env variable name for the time between runs of the enrichment
+	EnvEnrichmentInterval = "POLL_ENRICHMENT_INTERVAL"
+
+	// EnvServe is the env variable name to enable the HTTP API
+	EnvServe = "SERVE"
+	// EnvAPIAddress is the env variable name for the address the HTTP API listens on
+	EnvAPIAddress = "API_ADDRESS"
+	// EnvAPIKey is the env variable name for the key clients of the HTTP API have to send
+	EnvAPIKey = "API_KEY"
+	// EnvHealthMaxPollAge is the env variable name for the time without successful poll before /healthz fails
+	EnvHealthMaxPollAge = "HEALTH_MAX_POLL_AGE"
+	// EnvHealthMaxFailedPolls is the env variable name for the number of polls that may fail in a row before /healthz fails
+	EnvHealthMaxFailedPolls = "HEALTH_MAX_FAILED_POLLS"
+
+	// EnvLogLevel is the env variable name for the log level (trace/debug/info/warn/error)
+	EnvLogLevel = "LOG_LEVEL"
+	// EnvLogFormat is the env variable name for the log format (text/json)
+	EnvLogFormat = "LOG_FORMAT"
+
+	// EnvExportDirectory is the env variable name for the directory exports are written to
+	EnvExportDirectory = "EXPORT_DIRECTORY"
+
+	// EnvLastFMEnabled is the env variable name to enable scrobbling new plays to Last.fm
+	EnvLastFMEnabled = "LASTFM_ENABLED"
+	// EnvLastFMAPIKey is the env variable name for the API key of the Last.fm API account
+	EnvLastFMAPIKey = "LASTFM_API_KEY"
+	// EnvLastFMAPISecret is the env variable name for the shared secret of the Last.fm API account
+	EnvLastFMAPISecret = "LASTFM_API_SECRET"
+	// EnvLastFMSessionFile is the env variable name for the file the Last.fm session is saved to
+	EnvLastFMSessionFile = "LASTFM_SESSION_FILE"
+
+	// EnvListenBrainzEnabled is the env variable name to enable submitting new plays to ListenBrainz
+	EnvListenBrainzEnabled = "LISTENBRAINZ_ENABLED"
+	// EnvListenBrainzToken is the env variable name for the user token of ListenBrainz
+	EnvListenBrainzToken = "LISTENBRAINZ_TOKEN"
+	// EnvListenBrainzAPIURL is the env variable name for the root URL of the ListenBrainz API
+	EnvListenBrainzAPIURL = "LISTENBRAINZ_API_URL"
+
+	// EnvWebhookURL is the env variable name for the URL of the webhook target named DefaultWebhookTarget
+	EnvWebhookURL = "WEBHOOK_URL"
+	// EnvWebhookSecret is the env variable name for the secret of the webhook target named DefaultWebhookTarget
+	EnvWebhookSecret = "WEBHOOK_SECRET"
+	// EnvWebhooksMaxAttempts is the env variable name for the number of attempts before a webhook delivery is given up
+	EnvWebhooksMaxAttempts = "WEBHOOKS_MAX_ATTEMPTS"
+
+	// EnvMQTTEnabled is the env variable name to enable publishing to an MQTT broker
+	EnvMQTTEnabled = "MQTT_ENABLED"
+	// EnvMQTTBroker is the env variable name for the URL of the MQTT broker
+	EnvMQTTBroker = "MQTT_BROKER"
+	// EnvMQTTClientID is the env variable name for the client ID of the MQTT connection
+	EnvMQTTClientID = "MQTT_CLIENT_ID"
+	// EnvMQTTUsername is the env variable name for the username of the MQTT broker
+	EnvMQTTUsername = "MQTT_USERNAME"
+	// EnvMQTTPassword is the env variable name for the password of the MQTT broker
+	EnvMQTTPassword = "MQTT_PASSWORD"
+	// EnvMQTTDiscoveryPrefix is the env variable name for the topic prefix of Home Assistant discovery
+	EnvMQTTDiscoveryPrefix = "MQTT_DISCOVERY_PREFIX"
+)
+
+// DefaultWebhookTarget is the name of the webhook target configured by env variables
+const DefaultWebhookTarget = "default"
+
+// mqttSchemes are the URL schemes of MQTT brokers
+var mqttSchemes = map[string]bool{"tcp": true, "mqtt": true, "ssl": true, "tls": true, "mqtts": true, "ws": true, "wss": true}
+
+// masked replaces secrets when the config is printed
+const masked = "********"
+
+// Config is the complete configuration of the app.
+// It is loaded from defaults, the config file and env variables, in this order.
+type Config struct {
+	Spotify  Spotify  `yaml:"spotify"`
+	Database Database `yaml:"database"`
+	Polling  Polling  `yaml:"polling"`
+	Server   Server   `yaml:"server"`
+	Log      Log      `yaml:"log"`
+	Export   Export   `yaml:"export"`
+	LastFM   LastFM   `yaml:"lastfm"`
+
+	ListenBrainz ListenBrainz `yaml:"listenbrainz"`
+	Webhooks     Webhooks     `yaml:"webhooks"`
+	MQTT         MQTT         `yaml:"mqtt"`
+}
+
+// Spotify holds the credentials of the Spotify application.
+type Spotify struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	CallbackURI  string `yaml:"callback_uri"`
+	TokenFile    string `yaml:"token_file"`
+}
+
+// Database selects the connection of database.yml. Set fields override the ones of the connection.
+type Database struct {
+	Env      string `yaml:"env"`
+	Host     string `yaml:"host,omitempty"`
+	Port     string `yaml:"port,omitempty"`
+	Name     string `yaml:"name,omitempty"`
+	User     string `yaml:"user,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// Polling holds the intervals of the workers.
+type Polling struct {
+	HistoryInterval    time.Duration `yaml:"history_interval"`
+	Playback           bool          `yaml:"playback"`
+	PlaybackInterval   time.Duration `yaml:"playback_interval"`
+	EnrichmentInterval time.Duration `yaml:"enrichment_interval"`
+}
+
+// Server holds the settings of the HTTP API.
+type Server struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	APIKey  string `yaml:"api_key"`
+	Health  Health `yaml:"health"`
+}
+
+// Health holds the thresholds of the health endpoints.
+type Health struct {
+	MaxPollAge     time.Duration `yaml:"max_poll_age"`
+	MaxFailedPolls int           `yaml:"max_failed_polls"`
+}
+
+// Log holds level and format of the log.
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Export holds the settings of the exports.
+type Export struct {
+	Directory string `yaml:"directory"`
+}
+
+// LastFM holds the settings of scrobbling to Last.fm.
+type LastFM struct {
+	Enabled     bool   `yaml:"enabled"`
+	APIKey      string `yaml:"api_key"`
+	APISecret   string `yaml:"api_secret"`
+	SessionFile string `yaml:"session_file"`
+}
+
+// ListenBrainz holds the settings of submitting plays to ListenBrainz.
+type ListenBrainz struct {
+	Enabled bool   `yaml:"enabled"`
+	Token   string `yaml:"token"`
+	APIURL  string `yaml:"api_url"`
+}
+
+// Webhooks holds the targets newly saved plays are posted to.
+type Webhooks struct {
+	Targets     []WebhookTarget `yaml:"targets"`
+	MaxAttempts int             `yaml:"max_attempts"`
+}
+
+// WebhookTarget is a URL newly saved plays are posted to. Payloads are signed if Secret is set.
+type WebhookTarget struct {
+	Name   string `yaml:"name"`
+	URL    string `yaml:"url"`
+	Secret string `yaml:"secret,omitempty"`
+}
+
+// MQTT holds the settings of publishing plays and the player state to an MQTT broker.
+type MQTT struct {
+	Enabled  bool       `yaml:"enabled"`
+	Broker   string     `yaml:"broker"`
+	ClientID string     `yaml:"client_id"`
+	Username string     `yaml:"username"`
+	Password string     `yaml:"password"`
+	QoS      int        `yaml:"qos"`
+	Topics   MQTTTopics `yaml:"topics"`
+	// DiscoveryPrefix is the topic prefix of Home Assistant discovery, empty disables discovery
+	DiscoveryPrefix string `yaml:"discovery_prefix"`
+}
+
+// MQTTTopics are the topics published to. An empty topic disables its messages.
+type MQTTTopics struct {
+	Plays        string `yaml:"plays"`
+	LastPlayed   string `yaml:"last_played"`
+	NowPlaying   string `yaml:"now_playing"`
+	Availability string `yaml:"availability"`
+}
+
+// Default returns the config used if nothing is configured.
+func Default() Config {
+	return Config{
+		Spotify: Spotify{
+			CallbackURI: "http://localhost:8080/callback",
+			TokenFile:   "token.json",
+		},
+		Database: Database{
+			Env: "development",
+		},
+		Polling: Polling{
+			HistoryInterval:    45 * time.Minute,
+			PlaybackInterval:   10 * time.Second,
+			EnrichmentInterval: time.Hour,
+		},
+		Server: Server{
+			Address: ":8081",
+			Health: Health{
+				MaxPollAge:     2 * time.Hour,
+				MaxFailedPolls: 3,
+			},
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+		Export: Export{
+			Directory: "exports",
+		},
+		LastFM: LastFM{
+			SessionFile: "lastfm_session.json",
+		},
+		ListenBrainz: ListenBrainz{
+			APIURL: "https://api.listenbrainz.org",
+		},
+		Webhooks: Webhooks{
+			MaxAttempts: 10,
+		},
+		MQTT: MQTT{
+			Broker:   "tcp://localhost:1883",
+			ClientID: "spotify_history_saver",
+			QoS:      1,
+			Topics: MQTTTopics{
+				Plays:        "spotify_history/plays",
+				LastPlayed:   "spotify_history/last_played",
+				NowPlaying:   "spotify_history/now_playing",
+				Availability: "spotify_history/availability",
+			},
+			DiscoveryPrefix: "homeassistant",
+		},
+	}
+}
+
+// Load returns the defaults overridden by file and env variables.
+// An empty file loads DefaultFile or the file of CONFIG_FILE if they exist.
+func Load(file string) (Config, error) {
+	c := Default()
+
+	explicit := file != ""
+	if !explicit {
+		file = envy.Get(EnvConfigFile, "")
+		explicit = file != ""
+	}
+	if !explicit {
+		file = DefaultFile
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil && (explicit || !os.IsNotExist(err)) {
+		return c, fmt.Errorf("could not read config file: %v", err)
+	}
+	if err == nil {
+		err = yaml.UnmarshalStrict(data, &c)
+		if err != nil {
+			return c, fmt.Errorf("could not parse config file %s: %v", file, err)
+		}
+	}
+
+	err = c.applyEnv()
+	if err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// applyEnv overrides all fields whose env variable is set.
+func (c *Config) applyEnv() error {
+	setString(&c.Spotify.ClientID, EnvClientID)
+	setString(&c.Spotify.ClientSecret, EnvClientSecret)
+	setString(&c.Spotify.CallbackURI, EnvCallbackURI)
+	setString(&c.Spotify.TokenFile, EnvTokenFile)
+
+	setString(&c.Database.Env, GoEnv)
+	setString(&c.Database.Host, EnvDatabaseHost)
+	setString(&c.Database.Port, EnvDatabasePort)
+	setString(&c.Database.Name, EnvDatabaseName)
+	setString(&c.Database.User, EnvDatabaseUser)
+	setString(&c.Database.Password, EnvDatabasePassword)
+
+	setString(&c.Server.Address, EnvAPIAddress)
+	setString(&c.Server.APIKey, EnvAPIKey)
+
+	setString(&c.Log.Level, EnvLogLevel)
+	setString(&c.Log.Format, EnvLogFormat)
+
+	setString(&c.Export.Directory, EnvExportDirectory)
+
+	setString(&c.LastFM.APIKey, EnvLastFMAPIKey)
+	setString(&c.LastFM.APISecret, EnvLastFMAPISecret)
+	setString(&c.LastFM.SessionFile, EnvLastFMSessionFile)
+
+	setString(&c.ListenBrainz.Token, EnvListenBrainzToken)
+	setString(&c.ListenBrainz.APIURL, EnvListenBrainzAPIURL)
+
+	c.applyWebhookEnv()
+
+	setString(&c.MQTT.Broker, EnvMQTTBroker)
+	setString(&c.MQTT.ClientID, EnvMQTTClientID)
+	setString(&c.MQTT.Username, EnvMQTTUsername)
+	setString(&c.MQTT.Password, EnvMQTTPassword)
+	setString(&c.MQTT.DiscoveryPrefix, EnvMQTTDiscoveryPrefix)
+
+	for _, err := range []error{
+		setDuration(&c.Polling.HistoryInterval, EnvHistoryInterval),
+		setBool(&c.Polling.Playback, EnvPlayback),
+		setDuration(&c.Polling.PlaybackInterval, EnvPlaybackInterval),
+		setDuration(&c.Polling.EnrichmentInterval, EnvEnrichmentInterval),
+		setBool(&c.Server.Enabled, EnvServe),
+		setDuration(&c.Server.Health.MaxPollAge, EnvHealthMaxPollAge),
+		setInt(&c.Server.Health.MaxFailedPolls, EnvHealthMaxFailedPolls),
+		setBool(&c.LastFM.Enabled, EnvLastFMEnabled),
+		setBool(&c.ListenBrainz.Enabled, EnvListenBrainzEnabled),
+		setInt(&c.Webhooks.MaxAttempts, EnvWebhooksMaxAttempts),
+		setBool(&c.MQTT.Enabled, EnvMQTTEnabled),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyWebhookEnv overrides the target named DefaultWebhookTarget, it is added if only the env variables configure it.
+func (c *Config) applyWebhookEnv() {
+	targetURL, secret := envy.Get(EnvWebhookURL, ""), envy.Get(EnvWebhookSecret, "")
+	if targetURL == "" && secret == "" {
+		return
+	}
+	for i := range c.Webhooks.Targets {
+		if c.Webhooks.Targets[i].Name == DefaultWebhookTarget {
+			setString(&c.Webhooks.Targets[i].URL, EnvWebhookURL)
+			setString(&c.Webhooks.Targets[i].Secret, EnvWebhookSecret)
+			return
+		}
+	}
+	c.Webhooks.Targets = append(c.Webhooks.Targets, WebhookTarget{Name: DefaultWebhookTarget, URL: targetURL, Secret: secret})
+}
+
+func setString(field *string, env string) {
+	if v := envy.Get(env, ""); v != "" {
+		*field = v
+	}
+}
+
+func setDuration(field *time.Duration, env string) error {
+	v := envy.Get(env, "")
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("env key: %s is no duration: %s", env, v)
+	}
+	*field = d
+	return nil
+}
+
+func setBool(field *bool, env string) error {
+	v := envy.Get(env, "")
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("env key: %s is no boolean: %s", env, v)
+	}
+	*field = b
+	return nil
+}
+
+func setInt(field *int, env string) error {
+	v := envy.Get(env, "")
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("env key: %s is no number: %s", env, v)
+	}
+	*field = n
+	return nil
+}
+
+// Validate checks the whole config and returns one error listing all problems.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Spotify.ClientID != "", "spotify.client_id is required (env %s)", EnvClientID)
+	check(c.Spotify.ClientSecret != "", "spotify.client_secret is required (env %s)", EnvClientSecret)
+	u, err := url.Parse(c.Spotify.CallbackURI)
+	check(err == nil && u.IsAbs() && u.Host != "", "spotify.callback_uri is no absolute URL: %q", c.Spotify.CallbackURI)
+	check(c.Spotify.TokenFile != "", "spotify.token_file is required")
+
+	check(c.Database.Env != "", "database.env is required (env %s)", GoEnv)
+	if c.Database.Port != "" {
+		_, err = strconv.Atoi(c.Database.Port)
+		check(err == nil, "database.port is no number: %q", c.Database.Port)
+	}
+
+	check(c.Polling.HistoryInterval >= time.Minute, "polling.history_interval must be at least 1m: %v", c.Polling.HistoryInterval)
+	check(c.Polling.PlaybackInterval >= time.Second, "polling.playback_interval must be at least 1s: %v", c.Polling.PlaybackInterval)
+	check(c.Polling.EnrichmentInterval >= time.Minute, "polling.enrichment_interval must be at least 1m: %v", c.Polling.EnrichmentInterval)
+
+	if c.Server.Enabled {
+		check(c.Server.APIKey != "", "server.api_key is required if the server is enabled (env %s)", EnvAPIKey)
+		check(c.Server.Address != "", "server.address is required if the server is enabled")
+	}
+	check(c.Server.Health.MaxPollAge > 0, "server.health.max_poll_age must be positive: %v", c.Server.Health.MaxPollAge)
+	// Otherwise the worker is unhealthy between two successful polls
+	check(c.Server.Health.MaxPollAge <= 0 || c.Server.Health.MaxPollAge > c.Polling.HistoryInterval,
+		"server.health.max_poll_age must be longer than polling.history_interval (%v): %v",
+		c.Polling.HistoryInterval, c.Server.Health.MaxPollAge)
+	check(c.Server.Health.MaxFailedPolls > 0, "server.health.max_failed_polls must be positive: %d", c.Server.Health.MaxFailedPolls)
+
+	_, err = logrus.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level is invalid: %q", c.Log.Level)
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json: %q", c.Log.Format)
+
+	check(c.Export.Directory != "", "export.directory is required")
+
+	if c.LastFM.Enabled {
+		check(c.LastFM.APIKey != "", "lastfm.api_key is required if scrobbling is enabled (env %s)", EnvLastFMAPIKey)
+		check(c.LastFM.APISecret != "", "lastfm.api_secret is required if scrobbling is enabled (env %s)", EnvLastFMAPISecret)
+		check(c.LastFM.SessionFile != "", "lastfm.session_file is required if scrobbling is enabled")
+	}
+	if c.ListenBrainz.Enabled {
+		check(c.ListenBrainz.Token != "", "listenbrainz.token is required if submitting is enabled (env %s)", EnvListenBrainzToken)
+		check(c.ListenBrainz.APIURL != "", "listenbrainz.api_url is required if submitting is enabled")
+	}
+	names := map[string]bool{}
+	for i, target := range c.Webhooks.Targets {
+		check(target.Name != "", "webhooks.targets[%d].name is required", i)
+		check(target.Name == "" || !names[target.Name], "webhooks.targets[%d].name is not unique: %q", i, target.Name)
+		names[target.Name] = true
+		u, err := url.Parse(target.URL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"webhooks.targets[%d].url is no http or https URL: %q", i, target.URL)
+	}
+	check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive: %d", c.Webhooks.MaxAttempts)
+
+	if c.MQTT.Enabled {
+		u, err := url.Parse(c.MQTT.Broker)
+		check(err == nil && mqttSchemes[u.Scheme] && u.Host != "", "mqtt.broker is no tcp, ssl, ws or wss URL: %q", c.MQTT.Broker)
+		check(c.MQTT.ClientID != "", "mqtt.client_id is required if publishing is enabled (env %s)", EnvMQTTClientID)
+		check(c.MQTT.QoS >= 0 && c.MQTT.QoS <= 2, "mqtt.qos must be 0, 1 or 2: %d", c.MQTT.QoS)
+		for _, topic := range []struct{ name, value string }{
+			{"plays", c.MQTT.Topics.Plays},
+			{"last_played", c.MQTT.Topics.LastPlayed},
+			{"now_playing", c.MQTT.Topics.NowPlaying},
+			{"availability", c.MQTT.Topics.Availability},
+		} {
+			check(!strings.ContainsAny(topic.value, "+#"), "mqtt.topics.%s must not contain wildcards: %q", topic.name, topic.value)
+		}
+		check(!strings.ContainsAny(c.MQTT.DiscoveryPrefix, "+#"), "mqtt.discovery_prefix must not contain wildcards: %q", c.MQTT.DiscoveryPrefix)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// Masked returns a copy of the config with all secrets replaced.
+func (c Config) Masked() Config {
+	mask := func(s *string) {
+		if *s != "" {
+			*s = masked
+		}
+	}
+	mask(&c.Spotify.ClientSecret)
+	mask(&c.Database.Password)
+	mask(&c.Server.APIKey)
+	mask(&c.LastFM.APISecret)
+	mask(&c.ListenBrainz.Token)
+	mask(&c.MQTT.Password)
+	c.Webhooks.Targets = append([]WebhookTarget(nil), c.Webhooks.Targets...)
+	for i := range c.Webhooks.Targets {
+		mask(&c.Webhooks.Targets[i].Secret)
+	}
+	return c
+}
+
+// Print writes the config as YAML to w with all secrets masked.
+func (c Config) Print(w io.Writer) error {
+	data, err := yaml.Marshal(c.Masked())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Connect connects to the database.yml connection of Database.Env with the set fields of Database overriding it.
+// The connection is registered for Database.Env, so pop.Connect uses it as well.
+func (d Database) Connect() (*pop.Connection, error) {
+	if len(pop.Connections) == 0 {
+		err := pop.LoadConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("could not load database.yml: %v", err)
+		}
+	}
+	base, ok := pop.Connections[d.Env]
+	if !ok {
+		return nil, fmt.Errorf("could not find connection %s in database.yml", d.Env)
+	}
+	details := *base.Dialect.Details()
+	override := func(field *string, value string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	override(&details.Host, d.Host)
+	override(&details.Port, d.Port)
+	override(&details.Database, d.Name)
+	override(&details.User, d.User)
+	override(&details.Password, d.Password)
+	details.URL = ""
+
+	c, err := pop.NewConnection(&details)
+	if err == nil {
+		err = c.Open()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to database: %v", err)
+	}
+	pop.Connections[d.Env] = c
+	return c, nil
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"bytes"
+	"github.com/gobuffalo/envy"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "config_*.yml")
+	assert.NoError(t, err)
+	_, err = file.WriteString(content)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	return file.Name()
+}
+
+func validConfig() Config {
+	c := Default()
+	c.Spotify.ClientID = "client_id"
+	c.Spotify.ClientSecret = "client_secret"
+	return c
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		c, err := Load("")
+		assert.NoError(t, err)
+		assert.Equal(t, Default(), c)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		file := writeConfig(t, `
+spotify:
+  client_id: file_id
+  token_file: /data/token.json
+polling:
+  history_interval: 30m
+  playback: true
+server:
+  health:
+    max_failed_polls: 5
+`)
+		defer os.Remove(file)
+
+		c, err := Load(file)
+		assert.NoError(t, err)
+		assert.Equal(t, "file_id", c.Spotify.ClientID)
+		assert.Equal(t, "/data/token.json", c.Spotify.TokenFile)
+		assert.Equal(t, "http://localhost:8080/callback", c.Spotify.CallbackURI)
+		assert.Equal(t, 30*time.Minute, c.Polling.HistoryInterval)
+		assert.True(t, c.Polling.Playback)
+		assert.Equal(t, 10*time.Second, c.Polling.PlaybackInterval)
+		assert.Equal(t, 5, c.Server.Health.MaxFailedPolls)
+		assert.Equal(t, 2*time.Hour, c.Server.Health.MaxPollAge)
+
+		envy.Set(EnvClientID, "env_id")
+		envy.Set(EnvHistoryInterval, "20m")
+		envy.Set(EnvServe, "true")
+		envy.Set(EnvLastFMEnabled, "true")
+		envy.Set(EnvListenBrainzToken, "lb_token")
+		envy.Set(EnvWebhookURL, "https://example.com/hook")
+		envy.Set(EnvMQTTEnabled, "true")
+		envy.Set(EnvMQTTBroker, "ssl://broker:8883")
+		defer envy.Set(EnvClientID, "")
+		defer envy.Set(EnvHistoryInterval, "")
+		defer envy.Set(EnvServe, "")
+		defer envy.Set(EnvLastFMEnabled, "")
+		defer envy.Set(EnvListenBrainzToken, "")
+		defer envy.Set(EnvWebhookURL, "")
+		defer envy.Set(EnvMQTTEnabled, "")
+		defer envy.Set(EnvMQTTBroker, "")
+
+		c, err = Load(file)
+		assert.NoError(t, err)
+		assert.Equal(t, "env_id", c.Spotify.ClientID)
+		assert.Equal(t, 20*time.Minute, c.Polling.HistoryInterval)
+		assert.True(t, c.Server.Enabled)
+		assert.True(t, c.LastFM.Enabled)
+		assert.Equal(t, "lb_token", c.ListenBrainz.Token)
+		assert.Equal(t, "https://api.listenbrainz.org", c.ListenBrainz.APIURL)
+		assert.Equal(t, []WebhookTarget{{Name: "default", URL: "https://example.com/hook"}}, c.Webhooks.Targets)
+		assert.Equal(t, 10, c.Webhooks.MaxAttempts)
+		assert.True(t, c.MQTT.Enabled)
+		assert.Equal(t, "ssl://broker:8883", c.MQTT.Broker)
+		assert.Equal(t, "spotify_history/last_played", c.MQTT.Topics.LastPlayed)
+
+		envy.Set(EnvConfigFile, file)
+		defer envy.Set(EnvConfigFile, "")
+		c, err = Load("")
+		assert.NoError(t, err)
+		assert.Equal(t, "/data/token.json", c.Spotify.TokenFile)
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		file := writeConfig(t, "polling:\n  interval: 30m\n")
+		defer os.Remove(file)
+
+		_, err := Load(file)
+		assert.Contains(t, err.Error(), "could not parse config file")
+		assert.Contains(t, err.Error(), "field interval not found")
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		_, err := Load("not_existing.yml")
+		assert.Contains(t, err.Error(), "could not read config file:")
+	})
+
+	t.Run("InvalidEnv", func(t *testing.T) {
+		envy.Set(EnvHealthMaxFailedPolls, "many")
+		defer envy.Set(EnvHealthMaxFailedPolls, "")
+
+		_, err := Load("")
+		assert.EqualError(t, err, "env key: HEALTH_MAX_FAILED_POLLS is no number: many")
+
+		envy.Set(EnvHealthMaxFailedPolls, "")
+		envy.Set(EnvPlayback, "sometimes")
+		defer envy.Set(EnvPlayback, "")
+		_, err = Load("")
+		assert.EqualError(t, err, "env key: POLL_PLAYBACK is no boolean: sometimes")
+
+		envy.Set(EnvPlayback, "")
+		envy.Set(EnvPlaybackInterval, "often")
+		defer envy.Set(EnvPlaybackInterval, "")
+		_, err = Load("")
+		assert.EqualError(t, err, "env key: POLL_PLAYBACK_INTERVAL is no duration: often")
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, validConfig().Validate())
+
+	err := Default().Validate()
+	assert.Contains(t, err.Error(), "spotify.client_id is required (env CLIENT_ID)")
+	assert.Contains(t, err.Error(), "spotify.client_secret is required (env CLIENT_SECRET)")
+
+	c := validConfig()
+	c.Spotify.CallbackURI = "localhost:8080"
+	c.Database.Port = "mysql"
+	c.Polling.HistoryInterval = time.Second
+	c.Server.Enabled = true
+	c.Server.Health.MaxFailedPolls = 0
+	c.Log.Level = "loud"
+	c.Log.Format = "xml"
+	c.Export.Directory = ""
+	c.LastFM.Enabled = true
+	c.ListenBrainz.Enabled = true
+	c.Webhooks.Targets = []WebhookTarget{{Name: "home", URL: "https://example.com"}, {Name: "home", URL: "ftp://example.com"}, {URL: "http://"}}
+	c.Webhooks.MaxAttempts = 0
+	c.MQTT.Enabled = true
+	c.MQTT.Broker = "localhost:1883"
+	c.MQTT.ClientID = ""
+	c.MQTT.QoS = 3
+	c.MQTT.Topics.NowPlaying = "spotify/#"
+	assert.EqualError(t, c.Validate(), `invalid config:
+  - spotify.callback_uri is no absolute URL: "localhost:8080"
+  - database.port is no number: "mysql"
+  - polling.history_interval must be at least 1m: 1s
+  - server.api_key is required if the server is enabled (env API_KEY)
+  - server.health.max_failed_polls must be positive: 0
+  - log.level is invalid: "loud"
+  - log.format must be text or json: "xml"
+  - export.directory is required
+  - lastfm.api_key is required if scrobbling is enabled (env LASTFM_API_KEY)
+  - lastfm.api_secret is required if scrobbling is enabled (env LASTFM_API_SECRET)
+  - listenbrainz.token is required if submitting is enabled (env LISTENBRAINZ_TOKEN)
+  - webhooks.targets[1].name is not unique: "home"
+  - webhooks.targets[1].url is no http or https URL: "ftp://example.com"
+  - webhooks.targets[2].name is required
+  - webhooks.targets[2].url is no http or https URL: "http://"
+  - webhooks.max_attempts must be positive: 0
+  - mqtt.broker is no tcp, ssl, ws or wss URL: "localhost:1883"
+  - mqtt.client_id is required if publishing is enabled (env MQTT_CLIENT_ID)
+  - mqtt.qos must be 0, 1 or 2: 3
+  - mqtt.topics.now_playing must not contain wildcards: "spotify/#"`)
+
+	c = validConfig()
+	c.Server.Health.MaxPollAge = c.Polling.HistoryInterval
+	assert.EqualError(t, c.Validate(), `invalid config:
+  - server.health.max_poll_age must be longer than polling.history_interval (45m0s): 45m0s`)
+}
+
+func TestConfig_Print(t *testing.T) {
+	c := validConfig()
+	c.Server.APIKey = "api_key"
+	c.Database.Password = "db_password"
+	c.LastFM.APISecret = "lastfm_secret"
+	c.ListenBrainz.Token = "lb_token"
+	c.Webhooks.Targets = []WebhookTarget{{Name: "home", URL: "https://example.com", Secret: "wh_secret"}}
+	c.MQTT.Password = "mqtt_password"
+
+	var out bytes.Buffer
+	assert.NoError(t, c.Print(&out))
+	assert.Contains(t, out.String(), "client_id: client_id")
+	assert.Contains(t, out.String(), "history_interval: 45m0s")
+	assert.NotContains(t, out.String(), "client_secret: client_secret")
+	assert.NotContains(t, out.String(), "api_key: api_key")
+	assert.NotContains(t, out.String(), "db_password")
+	assert.NotContains(t, out.String(), "lastfm_secret")
+	assert.NotContains(t, out.String(), "lb_token")
+	assert.NotContains(t, out.String(), "wh_secret")
+	assert.NotContains(t, out.String(), "mqtt_password")
+	assert.Equal(t, "wh_secret", c.Webhooks.Targets[0].Secret)
+	assert.Equal(t, "client_secret", c.Spotify.ClientSecret)
+
+	masked := c.Masked()
+	assert.Equal(t, "********", masked.Spotify.ClientSecret)
+	assert.Equal(t, "********", masked.Server.APIKey)
+	assert.Equal(t, "********", masked.Database.Password)
+	assert.Equal(t, "", Default().Masked().Server.APIKey)
+}
+
+func TestDatabase_Connect(t *testing.T) {
+	db, err := Database{Env: "test", Name: "spotify_history_saver_test"}.Connect()
+	assert.NoError(t, err)
+	assert.Equal(t, "spotify_history_saver_test", db.Dialect.Details().Database)
+	assert.NoError(t, db.RawQuery("SELECT 1").Exec())
+
+	_, err = Database{Env: "staging"}.Connect()
+	assert.EqualError(t, err, "could not find connection staging in database.yml")
+}
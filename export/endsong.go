@@ -0,0 +1,123 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"io"
+	"strings"
+)
+
+const (
+	// FormatEndsong writes plays as JSON array in the format of Spotify's extended streaming history (endsong_*.json)
+	FormatEndsong = "endsong"
+
+	// EndsongTimeLayout is the layout of the ts field, always in UTC
+	EndsongTimeLayout = "2006-01-02T15:04:05Z"
+)
+
+// EndsongEntry is a play in the format of Spotify's extended streaming history. Values that are not saved
+// are null like in Spotify's own export.
+type EndsongEntry struct {
+	Ts                            string  `json:"ts"`
+	Username                      *string `json:"username"`
+	Platform                      *string `json:"platform"`
+	MsPlayed                      int     `json:"ms_played"`
+	ConnCountry                   *string `json:"conn_country"`
+	IPAddrDecrypted               *string `json:"ip_addr_decrypted"`
+	UserAgentDecrypted            *string `json:"user_agent_decrypted"`
+	MasterMetadataTrackName       *string `json:"master_metadata_track_name"`
+	MasterMetadataAlbumArtistName *string `json:"master_metadata_album_artist_name"`
+	MasterMetadataAlbumAlbumName  *string `json:"master_metadata_album_album_name"`
+	SpotifyTrackURI               *string `json:"spotify_track_uri"`
+	EpisodeName                   *string `json:"episode_name"`
+	EpisodeShowName               *string `json:"episode_show_name"`
+	SpotifyEpisodeURI             *string `json:"spotify_episode_uri"`
+	ReasonStart                   *string `json:"reason_start"`
+	ReasonEnd                     *string `json:"reason_end"`
+	Shuffle                       *bool   `json:"shuffle"`
+	Skipped                       *bool   `json:"skipped"`
+	Offline                       *bool   `json:"offline"`
+	OfflineTimestamp              *int64  `json:"offline_timestamp"`
+	IncognitoMode                 *bool   `json:"incognito_mode"`
+}
+
+// NewEndsongEntry converts play to an EndsongEntry. The device name is used as platform and the first artist as
+// album artist. Plays without measured play time count with the duration of the track or episode.
+// Tracks of imported scrobbles have no Spotify URI.
+func NewEndsongEntry(play models.ExportedPlay) EndsongEntry {
+	entry := EndsongEntry{
+		Ts:                           play.PlayedAt.UTC().Format(EndsongTimeLayout),
+		Platform:                     stringPtr(play.DeviceName),
+		MasterMetadataTrackName:      stringPtr(play.TrackName),
+		MasterMetadataAlbumAlbumName: stringPtr(play.AlbumName),
+		EpisodeName:                  stringPtr(play.EpisodeName),
+		EpisodeShowName:              stringPtr(play.ShowName),
+	}
+	if play.MsPlayed.Valid {
+		entry.MsPlayed = play.MsPlayed.Int
+	} else {
+		entry.MsPlayed = play.DurationMs.Int
+	}
+	if len(play.ArtistNames) > 0 {
+		entry.MasterMetadataAlbumArtistName = &play.ArtistNames[0]
+	}
+	if play.TrackID.Valid && !strings.Contains(play.TrackID.String, ":") {
+		uri := "spotify:track:" + play.TrackID.String
+		entry.SpotifyTrackURI = &uri
+	}
+	if play.EpisodeID.Valid {
+		uri := "spotify:episode:" + play.EpisodeID.String
+		entry.SpotifyEpisodeURI = &uri
+	}
+	return entry
+}
+
+// EndsongWriter writes plays as JSON array of EndsongEntry with one entry per line.
+type EndsongWriter struct {
+	w       *bufio.Writer
+	entries int
+	err     error
+}
+
+// NewEndsongWriter creates an EndsongWriter.
+func NewEndsongWriter(out io.Writer) *EndsongWriter {
+	return &EndsongWriter{w: bufio.NewWriter(out)}
+}
+
+// Write writes play as next entry of the array.
+func (e *EndsongWriter) Write(play models.ExportedPlay) error {
+	if e.err != nil {
+		return e.err
+	}
+	b, err := json.Marshal(NewEndsongEntry(play))
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if e.entries == 0 {
+		separator = "[\n"
+	}
+	_, e.err = e.w.WriteString(separator)
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+	e.entries++
+	return e.err
+}
+
+// Flush closes the array and writes all buffered entries.
+func (e *EndsongWriter) Flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	end := "\n]\n"
+	if e.entries == 0 {
+		end = "[]\n"
+	}
+	_, e.err = e.w.WriteString(end)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
@@ -0,0 +1,97 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/elivlo/SpotifyHistorySaver/spotifySaver"
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(_ []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestNewEndsongEntry(t *testing.T) {
+	play := testPlay()
+	play.DeviceName = nulls.NewString("Android OS 11 API 30 (Google, Pixel 5)")
+	entry := NewEndsongEntry(play)
+	assert.Equal(t, "2021-03-01T12:00:00Z", entry.Ts)
+	assert.Equal(t, "Android OS 11 API 30 (Google, Pixel 5)", *entry.Platform)
+	assert.Equal(t, 200000, entry.MsPlayed)
+	assert.Equal(t, "t_name, with comma", *entry.MasterMetadataTrackName)
+	assert.Equal(t, "a_name1", *entry.MasterMetadataAlbumArtistName)
+	assert.Equal(t, "al_name", *entry.MasterMetadataAlbumAlbumName)
+	assert.Equal(t, "spotify:track:t_id", *entry.SpotifyTrackURI)
+	assert.Nil(t, entry.SpotifyEpisodeURI)
+	assert.Nil(t, entry.Username)
+	assert.Nil(t, entry.Skipped)
+
+	entry = NewEndsongEntry(models.ExportedPlay{
+		PlayedAt:    playedAt.In(time.FixedZone("CET", 3600)),
+		MsPlayed:    nulls.NewInt(60000),
+		EpisodeID:   nulls.NewString("e_id"),
+		EpisodeName: nulls.NewString("e_name"),
+		ShowName:    nulls.NewString("s_name"),
+		DurationMs:  nulls.NewInt(3600000),
+	})
+	assert.Equal(t, "2021-03-01T12:00:00Z", entry.Ts)
+	assert.Equal(t, 60000, entry.MsPlayed)
+	assert.Equal(t, "spotify:episode:e_id", *entry.SpotifyEpisodeURI)
+	assert.Equal(t, "s_name", *entry.EpisodeShowName)
+	assert.Nil(t, entry.SpotifyTrackURI)
+	assert.Nil(t, entry.MasterMetadataAlbumArtistName)
+
+	imported := testPlay()
+	imported.TrackID = nulls.NewString("import:0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33")
+	entry = NewEndsongEntry(imported)
+	assert.Equal(t, "t_name, with comma", *entry.MasterMetadataTrackName)
+	assert.Nil(t, entry.SpotifyTrackURI)
+	b, err := json.Marshal(entry)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"spotify_track_uri":null`)
+}
+
+func TestEndsongWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewEndsongWriter(&out)
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, "[]\n", out.String())
+
+	out.Reset()
+	w = NewEndsongWriter(&out)
+	assert.NoError(t, w.Write(testPlay()))
+	second := testPlay()
+	second.PlayedAt = playedAt.Add(time.Hour)
+	assert.NoError(t, w.Write(second))
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, 4, strings.Count(out.String(), "\n"))
+	assert.Contains(t, out.String(), `"username":null`)
+
+	var entries []spotifySaver.ExtendedHistoryEntry
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &entries))
+	if assert.Equal(t, 2, len(entries)) {
+		assert.Equal(t, "t_id", entries[0].TrackID())
+		assert.Equal(t, playedAt, entries[0].Ts)
+		assert.Equal(t, 200000, entries[0].MsPlayed)
+		assert.Equal(t, "a_name1", entries[0].MasterMetadataAlbumArtistName)
+		assert.Equal(t, playedAt.Add(time.Hour), entries[1].Ts)
+	}
+
+	w = NewEndsongWriter(failingWriter{})
+	assert.NoError(t, w.Write(testPlay()))
+	assert.EqualError(t, w.Flush(), "disk full")
+}
+
+func TestFileName(t *testing.T) {
+	assert.Equal(t, "history.csv", FileName(FormatCSV))
+	assert.Equal(t, "history.jsonl", FileName(FormatJSONL))
+	assert.Equal(t, "endsong_0.json", FileName(FormatEndsong))
+}
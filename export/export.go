@@ -0,0 +1,161 @@
+// Package export writes the saved history to files for other tools.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FormatCSV writes plays as CSV with header
+	FormatCSV = "csv"
+	// FormatJSONL writes plays as JSON Lines, one JSON object per play
+	FormatJSONL = "jsonl"
+
+	// ListSeparator separates the artists of a play in CSV
+	ListSeparator = "; "
+)
+
+// Columns is the stable column schema of exported plays. New columns are only ever appended.
+var Columns = []string{
+	"id", "played_at", "ms_played",
+	"device_id", "device_name", "device_type",
+	"track_id", "track_name", "track_number", "disc_number", "duration_ms", "explicit",
+	"album_id", "album_name", "album_type", "release_date",
+	"artist_ids", "artist_names",
+	"episode_id", "episode_name", "show_id", "show_name",
+	"context_uri", "source",
+}
+
+// Writer writes plays in one format.
+type Writer interface {
+	Write(play models.ExportedPlay) error
+	// Flush writes buffered data and returns the first error that occurred
+	Flush() error
+}
+
+// NewWriter creates a Writer for format writing to out.
+func NewWriter(format string, out io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSVWriter(out), nil
+	case FormatJSONL:
+		return NewJSONLWriter(out), nil
+	case FormatEndsong:
+		return NewEndsongWriter(out), nil
+	}
+	return nil, fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatCSV, FormatJSONL, FormatEndsong)
+}
+
+// FileName returns the default file name of an export in format, e.g. "history.csv".
+func FileName(format string) string {
+	if format == FormatEndsong {
+		return "endsong_0.json"
+	}
+	return "history." + format
+}
+
+// Plays writes all plays in period from db to w and returns the number of written plays.
+// The plays are streamed from the database, the oldest first.
+func Plays(db *pop.Connection, period models.Period, w Writer) (int, error) {
+	count := 0
+	err := models.EachPlay(db, period, func(play models.ExportedPlay) error {
+		count++
+		return w.Write(play)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, w.Flush()
+}
+
+// CSVWriter writes plays as CSV in the order of Columns. Artists are joined by ListSeparator, null values are empty.
+type CSVWriter struct {
+	w *csv.Writer
+}
+
+// NewCSVWriter creates a CSVWriter and writes the header.
+func NewCSVWriter(out io.Writer) *CSVWriter {
+	w := csv.NewWriter(out)
+	_ = w.Write(Columns)
+	return &CSVWriter{w: w}
+}
+
+// Write writes play as one record.
+func (c *CSVWriter) Write(play models.ExportedPlay) error {
+	return c.w.Write([]string{
+		strconv.Itoa(play.ID),
+		play.PlayedAt.UTC().Format(time.RFC3339),
+		formatInt(play.MsPlayed),
+		play.DeviceID.String,
+		play.DeviceName.String,
+		play.DeviceType.String,
+		play.TrackID.String,
+		play.TrackName.String,
+		formatInt(play.TrackNumber),
+		formatInt(play.DiscNumber),
+		formatInt(play.DurationMs),
+		formatBool(play.Explicit),
+		play.AlbumID.String,
+		play.AlbumName.String,
+		play.AlbumType.String,
+		play.ReleaseDate.String,
+		strings.Join(play.ArtistIDs, ListSeparator),
+		strings.Join(play.ArtistNames, ListSeparator),
+		play.EpisodeID.String,
+		play.EpisodeName.String,
+		play.ShowID.String,
+		play.ShowName.String,
+		play.ContextURI.String,
+		play.Source.String,
+	})
+}
+
+// Flush writes all buffered records.
+func (c *CSVWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// JSONLWriter writes every play as JSON object in one line. Null values are written as null.
+type JSONLWriter struct {
+	enc *json.Encoder
+}
+
+// NewJSONLWriter creates a JSONLWriter.
+func NewJSONLWriter(out io.Writer) *JSONLWriter {
+	return &JSONLWriter{enc: json.NewEncoder(out)}
+}
+
+// Write writes play as one line.
+func (j *JSONLWriter) Write(play models.ExportedPlay) error {
+	play.PlayedAt = play.PlayedAt.UTC()
+	return j.enc.Encode(play)
+}
+
+// Flush does nothing as every line is written immediately.
+func (j *JSONLWriter) Flush() error {
+	return nil
+}
+
+func formatInt(i nulls.Int) string {
+	if !i.Valid {
+		return ""
+	}
+	return strconv.Itoa(i.Int)
+}
+
+func formatBool(b nulls.Bool) string {
+	if !b.Valid {
+		return ""
+	}
+	return strconv.FormatBool(b.Bool)
+}
@@ -0,0 +1,145 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/packr/v2"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testDB *pop.Connection
+
+var playedAt = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+	var err error
+
+	testDB, err = pop.Connect("test")
+	if err != nil {
+		fmt.Println("Could not connect to test database")
+		os.Exit(1)
+	}
+	_ = pop.CreateDB(testDB)
+	box, _ := pop.NewMigrationBox(packr.New("migrations", "../migrations"), testDB)
+	_ = box.Up()
+	_ = testDB.TruncateAll()
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+func testPlay() models.ExportedPlay {
+	return models.ExportedPlay{
+		ID:          1,
+		PlayedAt:    playedAt,
+		DeviceID:    nulls.NewString("d_id"),
+		TrackID:     nulls.NewString("t_id"),
+		TrackName:   nulls.NewString("t_name, with comma"),
+		TrackNumber: nulls.NewInt(2),
+		DiscNumber:  nulls.NewInt(1),
+		DurationMs:  nulls.NewInt(200000),
+		Explicit:    nulls.NewBool(true),
+		AlbumID:     nulls.NewString("al_id"),
+		AlbumName:   nulls.NewString("al_name"),
+		ArtistIDs:   []string{"a_id1", "a_id2"},
+		ArtistNames: []string{"a_name1", "a_name2"},
+		ContextURI:  nulls.NewString("spotify:album:al_id"),
+		Source:      nulls.NewString(models.SourceLastFM),
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	var out bytes.Buffer
+	w, err := NewWriter(FormatCSV, &out)
+	assert.NoError(t, err)
+	assert.IsType(t, &CSVWriter{}, w)
+
+	w, err = NewWriter(FormatJSONL, &out)
+	assert.NoError(t, err)
+	assert.IsType(t, &JSONLWriter{}, w)
+
+	_, err = NewWriter("xml", &out)
+	assert.EqualError(t, err, `unknown format "xml", expected csv, jsonl or endsong`)
+}
+
+func TestCSVWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewCSVWriter(&out)
+	assert.NoError(t, w.Write(testPlay()))
+	assert.NoError(t, w.Flush())
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Equal(t, strings.Join(Columns, ","), lines[0])
+	assert.Equal(t, `1,2021-03-01T12:00:00Z,,d_id,,,t_id,"t_name, with comma",2,1,200000,true,al_id,al_name,,,a_id1; a_id2,a_name1; a_name2,,,,,spotify:album:al_id,lastfm`, lines[1])
+}
+
+func TestJSONLWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewJSONLWriter(&out)
+	play := testPlay()
+	play.PlayedAt = playedAt.In(time.FixedZone("CET", 3600))
+	assert.NoError(t, w.Write(play))
+	assert.NoError(t, w.Write(testPlay()))
+	assert.NoError(t, w.Flush())
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	var columns []string
+	dec := json.NewDecoder(strings.NewReader(lines[0]))
+	_, _ = dec.Token()
+	for dec.More() {
+		key, _ := dec.Token()
+		columns = append(columns, key.(string))
+		var value interface{}
+		_ = dec.Decode(&value)
+	}
+	assert.Equal(t, Columns, columns)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &decoded))
+	assert.Equal(t, "2021-03-01T12:00:00Z", decoded["played_at"])
+	assert.Nil(t, decoded["ms_played"])
+	assert.Equal(t, []interface{}{"a_name1", "a_name2"}, decoded["artist_names"])
+	assert.Equal(t, "lastfm", decoded["source"])
+}
+
+func TestPlays(t *testing.T) {
+	_ = testDB.TruncateAll()
+	assert.NoError(t, testDB.Create(&models.Track{ID: "t_id", Name: "t_name"}))
+	for i := 0; i < 3; i++ {
+		entry := models.HistoryEntry{
+			TrackID:  nulls.NewString("t_id"),
+			PlayedAt: playedAt.Add(time.Duration(i) * time.Hour),
+		}
+		if i == 2 {
+			entry.Source = nulls.NewString(models.SourceListenBrainz)
+		}
+		assert.NoError(t, testDB.Create(&entry))
+	}
+
+	var out bytes.Buffer
+	count, err := Plays(testDB, models.Period{}, NewCSVWriter(&out))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 4, strings.Count(out.String(), "\n"))
+
+	out.Reset()
+	count, err = Plays(testDB, models.Period{Since: playedAt.Add(time.Minute)}, NewJSONLWriter(&out))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+	assert.Contains(t, out.String(), `"played_at":"2021-03-01T13:00:00Z"`)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Contains(t, lines[0], `"source":null`)
+	assert.Contains(t, lines[1], `"source":"listenbrainz"`)
+}
@@ -0,0 +1,454 @@
+package export
+
+import (
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/xitongsys/parquet-go/writer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FormatParquet writes plays partitioned by year and month and dimension files as Parquet
+	FormatParquet = "parquet"
+
+	// PlaysDirectory is the directory of the partitioned plays table
+	PlaysDirectory = "plays"
+	// PartitionFile is the file name of a partition of the plays table
+	PartitionFile = "plays.parquet"
+
+	// parallelism is the number of goroutines used to marshal rows
+	parallelism = 1
+)
+
+// ParquetPlay is a row of the denormalised plays table.
+type ParquetPlay struct {
+	ID          int64    `parquet:"name=id, type=INT64"`
+	PlayedAt    int64    `parquet:"name=played_at, type=TIMESTAMP_MILLIS"`
+	MsPlayed    *int32   `parquet:"name=ms_played, type=INT32"`
+	DeviceName  *string  `parquet:"name=device_name, type=UTF8"`
+	DeviceType  *string  `parquet:"name=device_type, type=UTF8"`
+	TrackID     *string  `parquet:"name=track_id, type=UTF8"`
+	TrackName   *string  `parquet:"name=track_name, type=UTF8"`
+	AlbumID     *string  `parquet:"name=album_id, type=UTF8"`
+	AlbumName   *string  `parquet:"name=album_name, type=UTF8"`
+	ArtistIDs   []string `parquet:"name=artist_ids, type=LIST, valuetype=UTF8"`
+	ArtistNames []string `parquet:"name=artist_names, type=LIST, valuetype=UTF8"`
+	DurationMs  *int32   `parquet:"name=duration_ms, type=INT32"`
+	EpisodeID   *string  `parquet:"name=episode_id, type=UTF8"`
+	EpisodeName *string  `parquet:"name=episode_name, type=UTF8"`
+	ShowName    *string  `parquet:"name=show_name, type=UTF8"`
+	ContextURI  *string  `parquet:"name=context_uri, type=UTF8"`
+	Source      *string  `parquet:"name=source, type=UTF8"`
+}
+
+// ParquetTrack is a row of the tracks dimension file.
+type ParquetTrack struct {
+	ID          string   `parquet:"name=id, type=UTF8"`
+	Name        string   `parquet:"name=name, type=UTF8"`
+	AlbumID     *string  `parquet:"name=album_id, type=UTF8"`
+	ArtistIDs   []string `parquet:"name=artist_ids, type=LIST, valuetype=UTF8"`
+	TrackNumber int32    `parquet:"name=track_number, type=INT32"`
+	DiscNumber  int32    `parquet:"name=disc_number, type=INT32"`
+	DurationMs  int32    `parquet:"name=duration_ms, type=INT32"`
+	Explicit    bool     `parquet:"name=explicit, type=BOOLEAN"`
+}
+
+// ParquetAlbum is a row of the albums dimension file.
+type ParquetAlbum struct {
+	ID          string  `parquet:"name=id, type=UTF8"`
+	Name        string  `parquet:"name=name, type=UTF8"`
+	AlbumType   string  `parquet:"name=album_type, type=UTF8"`
+	ReleaseDate string  `parquet:"name=release_date, type=UTF8"`
+	ImageURL    *string `parquet:"name=image_url, type=UTF8"`
+}
+
+// ParquetArtist is a row of the artists dimension file.
+type ParquetArtist struct {
+	ID       string   `parquet:"name=id, type=UTF8"`
+	Name     string   `parquet:"name=name, type=UTF8"`
+	Genres   []string `parquet:"name=genres, type=LIST, valuetype=UTF8"`
+	ImageURL *string  `parquet:"name=image_url, type=UTF8"`
+}
+
+// ParquetResult lists the files written by a Parquet export.
+type ParquetResult struct {
+	// Partitions are the written partitions of the plays table relative to the export directory
+	Partitions []string
+	Plays      int
+	Tracks     int
+	Albums     int
+	Artists    int
+}
+
+// Parquet writes the plays table partitioned like plays/year=2021/month=03/plays.parquet and the dimension files
+// tracks.parquet, albums.parquet and artists.parquet to dir.
+// Partitions before the latest existing one are kept, so repeated exports only rewrite the latest partition and
+// append new ones. With full all partitions are rewritten, e.g. after an import of older plays.
+// Files are written to temporary files first, so they are never left incomplete. A full export writes the plays
+// table to a temporary directory that replaces the existing one only if all partitions were written.
+func Parquet(db *pop.Connection, dir string, full bool) (ParquetResult, error) {
+	var result ParquetResult
+	var err error
+	if full {
+		err = writeFullParquetPlays(db, dir, &result)
+	} else {
+		err = writeNewParquetPlays(db, dir, &result)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.Tracks, err = writeParquetTracks(db, filepath.Join(dir, "tracks.parquet"))
+	if err != nil {
+		return result, err
+	}
+	result.Albums, err = writeParquetAlbums(db, filepath.Join(dir, "albums.parquet"))
+	if err != nil {
+		return result, err
+	}
+	result.Artists, err = writeParquetArtists(db, filepath.Join(dir, "artists.parquet"))
+	return result, err
+}
+
+// writeNewParquetPlays rewrites the latest partition in dir and writes the partitions of newer plays.
+func writeNewParquetPlays(db *pop.Connection, dir string, result *ParquetResult) error {
+	since, err := latestPartition(filepath.Join(dir, PlaysDirectory))
+	if err != nil {
+		return err
+	}
+	return writeParquetPlays(db, dir, since, result)
+}
+
+// writeFullParquetPlays writes all partitions to a temporary directory in dir and replaces the plays table with it.
+func writeFullParquetPlays(db *pop.Connection, dir string, result *ParquetResult) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("could not create directory: %v", err)
+	}
+	tmpDir, err := ioutil.TempDir(dir, ".plays-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = writeParquetPlays(db, tmpDir, time.Time{}, result)
+	if err != nil {
+		return err
+	}
+	playsDir := filepath.Join(dir, PlaysDirectory)
+	newPlaysDir := filepath.Join(tmpDir, PlaysDirectory)
+	err = os.MkdirAll(newPlaysDir, 0755)
+	if err != nil {
+		return fmt.Errorf("could not create directory: %v", err)
+	}
+	// The old plays are moved to the temporary directory, so they are removed with it
+	err = os.Rename(playsDir, filepath.Join(tmpDir, "old"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not replace plays: %v", err)
+	}
+	err = os.Rename(newPlaysDir, playsDir)
+	if err != nil {
+		return fmt.Errorf("could not replace plays: %v", err)
+	}
+	return nil
+}
+
+// partition returns the path of the partition of t relative to the export directory.
+func partition(t time.Time) string {
+	t = t.UTC()
+	return filepath.Join(PlaysDirectory, fmt.Sprintf("year=%04d", t.Year()), fmt.Sprintf("month=%02d", t.Month()), PartitionFile)
+}
+
+// latestPartition returns the start of the month of the latest partition in playsDir or zero time if there is none.
+func latestPartition(playsDir string) (time.Time, error) {
+	var latest time.Time
+	years, err := ioutil.ReadDir(playsDir)
+	if os.IsNotExist(err) {
+		return latest, nil
+	}
+	if err != nil {
+		return latest, fmt.Errorf("could not read plays: %v", err)
+	}
+	for _, year := range years {
+		y, ok := partitionValue(year.Name(), "year=")
+		if !ok {
+			continue
+		}
+		months, err := ioutil.ReadDir(filepath.Join(playsDir, year.Name()))
+		if err != nil {
+			return latest, fmt.Errorf("could not read plays: %v", err)
+		}
+		for _, month := range months {
+			m, ok := partitionValue(month.Name(), "month=")
+			if !ok {
+				continue
+			}
+			_, err = os.Stat(filepath.Join(playsDir, year.Name(), month.Name(), PartitionFile))
+			if err != nil {
+				continue
+			}
+			start := time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.UTC)
+			if start.After(latest) {
+				latest = start
+			}
+		}
+	}
+	return latest, nil
+}
+
+func partitionValue(name, prefix string) (int, bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	v, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+	return v, err == nil
+}
+
+// writeParquetPlays writes the partitions of all plays since the given time. Plays are streamed the oldest first,
+// so every partition is written at once.
+func writeParquetPlays(db *pop.Connection, dir string, since time.Time, result *ParquetResult) error {
+	var (
+		current string
+		file    *parquetFile
+	)
+	err := models.EachPlay(db, models.Period{Since: since}, func(play models.ExportedPlay) error {
+		p := partition(play.PlayedAt)
+		if p != current {
+			if file != nil {
+				err := file.close()
+				if err != nil {
+					return err
+				}
+			}
+			var err error
+			file, err = createParquetFile(filepath.Join(dir, p), new(ParquetPlay))
+			if err != nil {
+				return err
+			}
+			current = p
+			result.Partitions = append(result.Partitions, p)
+		}
+		result.Plays++
+		return file.write(toParquetPlay(play))
+	})
+	if file != nil {
+		if err != nil {
+			file.abort()
+			return fmt.Errorf("could not export plays: %v", err)
+		}
+		err = file.close()
+	}
+	if err != nil {
+		return fmt.Errorf("could not export plays: %v", err)
+	}
+	return nil
+}
+
+func writeParquetTracks(db *pop.Connection, path string) (int, error) {
+	var tracks models.Tracks
+	err := db.Order("id").All(&tracks)
+	if err != nil {
+		return 0, fmt.Errorf("could not get tracks: %v", err)
+	}
+	var connections models.ArtistsTracks
+	err = db.Order("id").All(&connections)
+	if err != nil {
+		return 0, fmt.Errorf("could not get artists of tracks: %v", err)
+	}
+	artists := make(map[string][]string)
+	for _, c := range connections {
+		artists[c.TrackID] = append(artists[c.TrackID], c.ArtistID)
+	}
+
+	rows := make([]interface{}, len(tracks))
+	for i, t := range tracks {
+		artistIDs := artists[t.ID]
+		if artistIDs == nil {
+			artistIDs = []string{}
+		}
+		rows[i] = ParquetTrack{
+			ID:          t.ID,
+			Name:        t.Name,
+			AlbumID:     stringPtr(t.AlbumID),
+			ArtistIDs:   artistIDs,
+			TrackNumber: int32(t.TrackNumber),
+			DiscNumber:  int32(t.DiscNumber),
+			DurationMs:  int32(t.DurationMs),
+			Explicit:    t.Explicit,
+		}
+	}
+	return len(rows), writeParquetRows(path, new(ParquetTrack), rows)
+}
+
+func writeParquetAlbums(db *pop.Connection, path string) (int, error) {
+	var albums models.Albums
+	err := db.Order("id").All(&albums)
+	if err != nil {
+		return 0, fmt.Errorf("could not get albums: %v", err)
+	}
+
+	rows := make([]interface{}, len(albums))
+	for i, a := range albums {
+		rows[i] = ParquetAlbum{
+			ID:          a.ID,
+			Name:        a.Name,
+			AlbumType:   a.AlbumType,
+			ReleaseDate: a.ReleaseDate,
+			ImageURL:    stringPtr(a.ImageURL),
+		}
+	}
+	return len(rows), writeParquetRows(path, new(ParquetAlbum), rows)
+}
+
+func writeParquetArtists(db *pop.Connection, path string) (int, error) {
+	var artists models.Artists
+	err := db.Order("id").All(&artists)
+	if err != nil {
+		return 0, fmt.Errorf("could not get artists: %v", err)
+	}
+	var genres models.Genres
+	err = db.All(&genres)
+	if err != nil {
+		return 0, fmt.Errorf("could not get genres: %v", err)
+	}
+	var connections models.ArtistsGenres
+	err = db.Order("id").All(&connections)
+	if err != nil {
+		return 0, fmt.Errorf("could not get genres of artists: %v", err)
+	}
+	names := make(map[int]string, len(genres))
+	for _, g := range genres {
+		names[g.ID] = g.Name
+	}
+	artistGenres := make(map[string][]string)
+	for _, c := range connections {
+		artistGenres[c.ArtistID] = append(artistGenres[c.ArtistID], names[c.GenreID])
+	}
+
+	rows := make([]interface{}, len(artists))
+	for i, a := range artists {
+		g := artistGenres[a.ID]
+		if g == nil {
+			g = []string{}
+		}
+		sort.Strings(g)
+		rows[i] = ParquetArtist{
+			ID:       a.ID,
+			Name:     a.Name,
+			Genres:   g,
+			ImageURL: stringPtr(a.ImageURL),
+		}
+	}
+	return len(rows), writeParquetRows(path, new(ParquetArtist), rows)
+}
+
+// writeParquetRows writes all rows to the Parquet file at path.
+func writeParquetRows(path string, schema interface{}, rows []interface{}) error {
+	file, err := createParquetFile(path, schema)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = file.write(row)
+		if err != nil {
+			file.abort()
+			return err
+		}
+	}
+	return file.close()
+}
+
+func toParquetPlay(play models.ExportedPlay) ParquetPlay {
+	return ParquetPlay{
+		ID:          int64(play.ID),
+		PlayedAt:    play.PlayedAt.UnixNano() / int64(time.Millisecond),
+		MsPlayed:    int32Ptr(play.MsPlayed),
+		DeviceName:  stringPtr(play.DeviceName),
+		DeviceType:  stringPtr(play.DeviceType),
+		TrackID:     stringPtr(play.TrackID),
+		TrackName:   stringPtr(play.TrackName),
+		AlbumID:     stringPtr(play.AlbumID),
+		AlbumName:   stringPtr(play.AlbumName),
+		ArtistIDs:   play.ArtistIDs,
+		ArtistNames: play.ArtistNames,
+		DurationMs:  int32Ptr(play.DurationMs),
+		EpisodeID:   stringPtr(play.EpisodeID),
+		EpisodeName: stringPtr(play.EpisodeName),
+		ShowName:    stringPtr(play.ShowName),
+		ContextURI:  stringPtr(play.ContextURI),
+		Source:      stringPtr(play.Source),
+	}
+}
+
+func stringPtr(s nulls.String) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func int32Ptr(i nulls.Int) *int32 {
+	if !i.Valid {
+		return nil
+	}
+	v := int32(i.Int)
+	return &v
+}
+
+// parquetFile is a Parquet file that is written to a temporary file and moved to its path when closed.
+type parquetFile struct {
+	path string
+	f    *os.File
+	w    *writer.ParquetWriter
+}
+
+func createParquetFile(path string, schema interface{}) (*parquetFile, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create directory: %v", err)
+	}
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("could not create file: %v", err)
+	}
+	w, err := writer.NewParquetWriterFromWriter(f, schema, parallelism)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, fmt.Errorf("could not create Parquet writer: %v", err)
+	}
+	return &parquetFile{path: path, f: f, w: w}, nil
+}
+
+func (p *parquetFile) write(row interface{}) error {
+	return p.w.Write(row)
+}
+
+// close writes the footer and moves the file to its path.
+func (p *parquetFile) close() error {
+	err := p.w.WriteStop()
+	if err != nil {
+		p.abort()
+		return fmt.Errorf("could not write %s: %v", p.path, err)
+	}
+	err = p.f.Close()
+	if err != nil {
+		_ = os.Remove(p.f.Name())
+		return fmt.Errorf("could not write %s: %v", p.path, err)
+	}
+	return os.Rename(p.f.Name(), p.path)
+}
+
+// abort removes the temporary file.
+func (p *parquetFile) abort() {
+	_ = p.f.Close()
+	_ = os.Remove(p.f.Name())
+}
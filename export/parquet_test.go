@@ -0,0 +1,159 @@
+package export
+
+import (
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readParquet reads the first rows of the Parquet file at path with the schema of row into rows.
+// It returns the number of rows in the file.
+func readParquet(t *testing.T, path string, row interface{}, rows interface{}) int {
+	f, err := local.NewLocalFileReader(path)
+	if !assert.NoError(t, err) {
+		return 0
+	}
+	defer f.Close()
+	r, err := reader.NewParquetReader(f, row, 1)
+	if !assert.NoError(t, err) {
+		return 0
+	}
+	defer r.ReadStop()
+	assert.NoError(t, r.Read(rows))
+	return int(r.GetNumRows())
+}
+
+func createParquetFixtures(t *testing.T) {
+	_ = testDB.TruncateAll()
+	assert.NoError(t, testDB.Create(&models.Album{ID: "al_id", Name: "al_name"}))
+	assert.NoError(t, testDB.Create(&models.Track{ID: "t_id", Name: "t_name", AlbumID: nulls.NewString("al_id"), DurationMs: 200000}))
+	assert.NoError(t, testDB.Create(&models.Artist{ID: "a_id", Name: "a_name"}))
+	assert.NoError(t, testDB.Create(&models.ArtistsTrack{ArtistID: "a_id", TrackID: "t_id"}))
+	genre := models.Genre{Name: "pop"}
+	assert.NoError(t, testDB.Create(&genre))
+	assert.NoError(t, testDB.Create(&models.ArtistsGenre{ArtistID: "a_id", GenreID: genre.ID}))
+	for i, at := range []time.Time{playedAt, playedAt.Add(time.Hour), playedAt.AddDate(0, 1, 0)} {
+		entry := models.HistoryEntry{
+			TrackID:    nulls.NewString("t_id"),
+			PlayedAt:   at,
+			MsPlayed:   nulls.NewInt(100000),
+			ContextURI: nulls.NewString("spotify:album:al_id"),
+		}
+		if i == 1 {
+			entry.Source = nulls.NewString(models.SourceLastFM)
+		}
+		assert.NoError(t, testDB.Create(&entry))
+	}
+}
+
+func TestParquet(t *testing.T) {
+	createParquetFixtures(t)
+	dir := t.TempDir()
+
+	result, err := Parquet(testDB, dir, false)
+	assert.NoError(t, err)
+	march := filepath.Join("plays", "year=2021", "month=03", "plays.parquet")
+	april := filepath.Join("plays", "year=2021", "month=04", "plays.parquet")
+	assert.Equal(t, []string{march, april}, result.Partitions)
+	assert.Equal(t, 3, result.Plays)
+	assert.Equal(t, 1, result.Tracks)
+	assert.Equal(t, 1, result.Albums)
+	assert.Equal(t, 1, result.Artists)
+
+	plays := make([]ParquetPlay, 2)
+	if assert.Equal(t, 2, readParquet(t, filepath.Join(dir, march), new(ParquetPlay), &plays)) {
+		assert.Equal(t, playedAt.UnixNano()/int64(time.Millisecond), plays[0].PlayedAt)
+		assert.Equal(t, "t_name", *plays[0].TrackName)
+		assert.Equal(t, "al_name", *plays[0].AlbumName)
+		assert.Equal(t, []string{"a_name"}, plays[0].ArtistNames)
+		assert.Equal(t, int32(100000), *plays[0].MsPlayed)
+		assert.Equal(t, int32(200000), *plays[0].DurationMs)
+		assert.Equal(t, "spotify:album:al_id", *plays[0].ContextURI)
+		assert.Nil(t, plays[0].EpisodeID)
+		assert.Nil(t, plays[0].Source)
+		assert.Equal(t, models.SourceLastFM, *plays[1].Source)
+	}
+
+	tracks := make([]ParquetTrack, 1)
+	if assert.Equal(t, 1, readParquet(t, filepath.Join(dir, "tracks.parquet"), new(ParquetTrack), &tracks)) {
+		assert.Equal(t, []string{"a_id"}, tracks[0].ArtistIDs)
+	}
+	artists := make([]ParquetArtist, 1)
+	if assert.Equal(t, 1, readParquet(t, filepath.Join(dir, "artists.parquet"), new(ParquetArtist), &artists)) {
+		assert.Equal(t, []string{"pop"}, artists[0].Genres)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*", "*", "*.tmp"))
+	assert.Empty(t, files)
+}
+
+func TestParquet_incremental(t *testing.T) {
+	createParquetFixtures(t)
+	dir := t.TempDir()
+
+	_, err := Parquet(testDB, dir, false)
+	assert.NoError(t, err)
+	march := filepath.Join(dir, "plays", "year=2021", "month=03", "plays.parquet")
+	assert.NoError(t, ioutil.WriteFile(march, []byte("kept"), 0644))
+
+	assert.NoError(t, testDB.Create(&models.HistoryEntry{TrackID: nulls.NewString("t_id"), PlayedAt: playedAt.AddDate(0, 2, 0)}))
+	result, err := Parquet(testDB, dir, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join("plays", "year=2021", "month=04", "plays.parquet"),
+		filepath.Join("plays", "year=2021", "month=05", "plays.parquet"),
+	}, result.Partitions)
+	assert.Equal(t, 2, result.Plays)
+	content, err := ioutil.ReadFile(march)
+	assert.NoError(t, err)
+	assert.Equal(t, "kept", string(content))
+
+	// A failed full export keeps the existing plays
+	assert.NoError(t, testDB.RawQuery("RENAME TABLE history_entries TO history_entries_renamed").Exec())
+	_, err = Parquet(testDB, dir, true)
+	assert.Error(t, err)
+	assert.NoError(t, testDB.RawQuery("RENAME TABLE history_entries_renamed TO history_entries").Exec())
+	content, err = ioutil.ReadFile(march)
+	assert.NoError(t, err)
+	assert.Equal(t, "kept", string(content))
+
+	result, err = Parquet(testDB, dir, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(result.Partitions))
+	assert.Equal(t, 4, result.Plays)
+	plays := make([]ParquetPlay, 2)
+	assert.Equal(t, 2, readParquet(t, march, new(ParquetPlay), &plays))
+	assert.Equal(t, "t_name", *plays[1].TrackName)
+
+	// No temporary directories are left
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.Equal(t, []string{"albums.parquet", "artists.parquet", "plays", "tracks.parquet"}, names)
+}
+
+func TestLatestPartition(t *testing.T) {
+	dir := t.TempDir()
+	latest, err := latestPartition(filepath.Join(dir, "plays"))
+	assert.NoError(t, err)
+	assert.True(t, latest.IsZero())
+
+	for _, p := range []string{"year=2020/month=12", "year=2021/month=02", "year=2021/month=11", "other"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "plays", p), 0755))
+	}
+	for _, p := range []string{"year=2020/month=12", "year=2021/month=02"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "plays", p, PartitionFile), nil, 0644))
+	}
+	latest, err = latestPartition(filepath.Join(dir, "plays"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), latest)
+}
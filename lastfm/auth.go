@@ -0,0 +1,101 @@
+package lastfm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+)
+
+// Session is the session of a Last.fm user. Its key does not expire until the user revokes it.
+type Session struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// GetToken requests a token the user has to authorize with AuthorizeURL.
+func (c *Client) GetToken() (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	err := c.call("auth.getToken", url.Values{}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Token == "" {
+		return "", errors.New("last.fm returned no token")
+	}
+	return resp.Token, nil
+}
+
+// AuthorizeURL returns the page the user authorizes token on.
+func (c *Client) AuthorizeURL(token string) string {
+	return c.authURL + "?" + url.Values{"api_key": {c.apiKey}, "token": {token}}.Encode()
+}
+
+// GetSession exchanges an authorized token for a session.
+// It returns an Error with code ErrorUnauthorizedToken as long as the user has not authorized it.
+func (c *Client) GetSession(token string) (Session, error) {
+	var resp struct {
+		Session Session `json:"session"`
+	}
+	err := c.call("auth.getSession", url.Values{"token": {token}}, &resp)
+	if err != nil {
+		return Session{}, err
+	}
+	if resp.Session.Key == "" {
+		return Session{}, errors.New("last.fm returned no session key")
+	}
+	return resp.Session, nil
+}
+
+// Login requests a token and passes the URL the user has to authorize it on to authorize.
+// It waits until the user authorized it, checking every interval, and fails after timeout.
+func (c *Client) Login(authorize func(authURL string), interval, timeout time.Duration) (Session, error) {
+	token, err := c.GetToken()
+	if err != nil {
+		return Session{}, fmt.Errorf("could not get token: %v", err)
+	}
+	authorize(c.AuthorizeURL(token))
+
+	deadline := time.Now().Add(timeout)
+	for {
+		session, err := c.GetSession(token)
+		var apiErr Error
+		if err == nil || !errors.As(err, &apiErr) || apiErr.Code != ErrorUnauthorizedToken {
+			return session, err
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return Session{}, errors.New("token was not authorized in time")
+		}
+		time.Sleep(interval)
+	}
+}
+
+// SaveSession writes session as JSON to file. Only the user may read it.
+func SaveSession(file string, session Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// LoadSession reads a session written by SaveSession.
+func LoadSession(file string) (Session, error) {
+	var session Session
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return session, err
+	}
+	err = json.Unmarshal(data, &session)
+	if err != nil {
+		return session, err
+	}
+	if session.Key == "" {
+		return session, fmt.Errorf("%s contains no session key", file)
+	}
+	return session, nil
+}
@@ -0,0 +1,207 @@
+package lastfm
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportDateLayouts are the layouts of dates in CSV exports of common Last.fm export tools, all in UTC.
+var exportDateLayouts = []string{
+	"02 Jan 2006 15:04",
+	"2 Jan 2006 15:04",
+	"02 Jan 2006, 15:04",
+	"2 Jan 2006, 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	time.RFC3339,
+}
+
+// ExportedScrobble is a scrobble of a Last.fm export. MBIDs are empty if Last.fm does not know them.
+type ExportedScrobble struct {
+	Artist     string
+	ArtistMBID string
+	Album      string
+	AlbumMBID  string
+	Track      string
+	TrackMBID  string
+	Timestamp  time.Time
+}
+
+// exportedTrack is a track of user.getRecentTracks. Artists have a name instead of text if extended data was requested.
+type exportedTrack struct {
+	Artist struct {
+		MBID string `json:"mbid"`
+		Text string `json:"#text"`
+		Name string `json:"name"`
+	} `json:"artist"`
+	Album struct {
+		MBID string `json:"mbid"`
+		Text string `json:"#text"`
+	} `json:"album"`
+	Name string `json:"name"`
+	MBID string `json:"mbid"`
+	Date *struct {
+		UTS string `json:"uts"`
+	} `json:"date"`
+}
+
+// exportedPage is a response of user.getRecentTracks.
+type exportedPage struct {
+	RecentTracks *struct {
+		Track []exportedTrack `json:"track"`
+	} `json:"recenttracks"`
+}
+
+// ReadJSONExport reads the scrobbles of a JSON export: a response page of user.getRecentTracks, an array of them
+// or an array of their tracks. The currently playing track of a page has no date and is skipped.
+func ReadJSONExport(r io.Reader) ([]ExportedScrobble, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+
+	var elements []json.RawMessage
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &elements)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		elements = []json.RawMessage{data}
+	}
+
+	var scrobbles []ExportedScrobble
+	for _, element := range elements {
+		var page exportedPage
+		err = json.Unmarshal(element, &page)
+		if err != nil {
+			return nil, err
+		}
+		var tracks []exportedTrack
+		if page.RecentTracks != nil {
+			tracks = page.RecentTracks.Track
+		} else {
+			var track exportedTrack
+			err = json.Unmarshal(element, &track)
+			if err != nil {
+				return nil, err
+			}
+			tracks = []exportedTrack{track}
+		}
+
+		for _, t := range tracks {
+			if t.Date == nil {
+				continue
+			}
+			uts, err := strconv.ParseInt(t.Date.UTS, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid date of %s: %q", t.Name, t.Date.UTS)
+			}
+			artist := t.Artist.Text
+			if artist == "" {
+				artist = t.Artist.Name
+			}
+			scrobbles = append(scrobbles, ExportedScrobble{
+				Artist:     artist,
+				ArtistMBID: t.Artist.MBID,
+				Album:      t.Album.Text,
+				AlbumMBID:  t.Album.MBID,
+				Track:      t.Name,
+				TrackMBID:  t.MBID,
+				Timestamp:  time.Unix(uts, 0).UTC(),
+			})
+		}
+	}
+	return scrobbles, nil
+}
+
+// ReadCSVExport reads the scrobbles of a CSV export. Files without header have the columns artist, album, track and
+// date. Files with header name their columns uts or utc_time, artist, artist_mbid, album, album_mbid, track and track_mbid.
+func ReadCSVExport(r io.Reader) ([]ExportedScrobble, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{"artist": 0, "album": 1, "track": 2, "date": 3}
+	if isCSVHeader(records[0]) {
+		columns = map[string]int{}
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		records = records[1:]
+	}
+	field := func(record []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+		}
+		return ""
+	}
+
+	scrobbles := make([]ExportedScrobble, 0, len(records))
+	for line, record := range records {
+		var timestamp time.Time
+		if uts := field(record, "uts", "timestamp"); uts != "" {
+			seconds, err := strconv.ParseInt(uts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp in record %d: %q", line+1, uts)
+			}
+			timestamp = time.Unix(seconds, 0).UTC()
+		} else {
+			timestamp, err = parseExportDate(field(record, "utc_time", "date"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid date in record %d: %v", line+1, err)
+			}
+		}
+		scrobbles = append(scrobbles, ExportedScrobble{
+			Artist:     field(record, "artist"),
+			ArtistMBID: field(record, "artist_mbid"),
+			Album:      field(record, "album"),
+			AlbumMBID:  field(record, "album_mbid"),
+			Track:      field(record, "track", "title", "name"),
+			TrackMBID:  field(record, "track_mbid", "mbid"),
+			Timestamp:  timestamp,
+		})
+	}
+	return scrobbles, nil
+}
+
+// isCSVHeader checks if record names the columns of a CSV export: the artist and the track or date.
+func isCSVHeader(record []string) bool {
+	artist, other := false, false
+	for _, name := range record {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "artist":
+			artist = true
+		case "track", "title", "uts", "utc_time":
+			other = true
+		}
+	}
+	return artist && other
+}
+
+// parseExportDate parses a date of a CSV export in one of exportDateLayouts.
+func parseExportDate(value string) (time.Time, error) {
+	for _, layout := range exportDateLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format %q", value)
+}
@@ -0,0 +1,81 @@
+package lastfm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadJSONExport(t *testing.T) {
+	playedAt := time.Unix(1614600000, 0).UTC()
+	expected := []ExportedScrobble{{
+		Artist:     "artist",
+		ArtistMBID: "a-mbid",
+		Album:      "album",
+		Track:      "track",
+		TrackMBID:  "t-mbid",
+		Timestamp:  playedAt,
+	}}
+	track := `{"artist": {"mbid": "a-mbid", "#text": "artist"}, "album": {"mbid": "", "#text": "album"},
+		"name": "track", "mbid": "t-mbid", "date": {"uts": "1614600000", "#text": "01 Mar 2021, 12:00"}}`
+
+	t.Run("Page", func(t *testing.T) {
+		scrobbles, err := ReadJSONExport(strings.NewReader(`{"recenttracks": {"track": [
+			{"artist": {"#text": "artist"}, "name": "playing", "@attr": {"nowplaying": "true"}}, ` + track + `]}}`))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, scrobbles)
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		scrobbles, err := ReadJSONExport(strings.NewReader(`[{"recenttracks": {"track": [` + track + `]}},
+			{"recenttracks": {"track": [` + track + `]}}]`))
+		assert.NoError(t, err)
+		assert.Equal(t, append(expected, expected...), scrobbles)
+	})
+
+	t.Run("Tracks", func(t *testing.T) {
+		scrobbles, err := ReadJSONExport(strings.NewReader(`[` + track + `,
+			{"artist": {"name": "extended"}, "name": "track", "date": {"uts": "1614600000"}}]`))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(scrobbles))
+		assert.Equal(t, expected[0], scrobbles[0])
+		assert.Equal(t, "extended", scrobbles[1].Artist)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := ReadJSONExport(strings.NewReader(`[{"name": "track", "date": {"uts": "yesterday"}}]`))
+		assert.EqualError(t, err, `invalid date of track: "yesterday"`)
+	})
+}
+
+func TestReadCSVExport(t *testing.T) {
+	t.Run("WithoutHeader", func(t *testing.T) {
+		scrobbles, err := ReadCSVExport(strings.NewReader("Radiohead,OK Computer,Airbag,01 Mar 2021 12:00\nMuse,,\"Hysteria, Live\",2 Mar 2021 08:05\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, []ExportedScrobble{
+			{Artist: "Radiohead", Album: "OK Computer", Track: "Airbag", Timestamp: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)},
+			{Artist: "Muse", Track: "Hysteria, Live", Timestamp: time.Date(2021, 3, 2, 8, 5, 0, 0, time.UTC)},
+		}, scrobbles)
+	})
+
+	t.Run("WithHeader", func(t *testing.T) {
+		scrobbles, err := ReadCSVExport(strings.NewReader("uts,utc_time,artist,artist_mbid,album,album_mbid,track,track_mbid\n" +
+			"1614600000,\"01 Mar 2021, 12:00\",artist,a-mbid,album,al-mbid,track,t-mbid\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, []ExportedScrobble{{
+			Artist:     "artist",
+			ArtistMBID: "a-mbid",
+			Album:      "album",
+			AlbumMBID:  "al-mbid",
+			Track:      "track",
+			TrackMBID:  "t-mbid",
+			Timestamp:  time.Unix(1614600000, 0).UTC(),
+		}}, scrobbles)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := ReadCSVExport(strings.NewReader("Radiohead,OK Computer,Airbag,yesterday\n"))
+		assert.EqualError(t, err, `invalid date in record 1: unknown date format "yesterday"`)
+	})
+}
@@ -0,0 +1,155 @@
+// Package lastfm is a small client of the Last.fm API to authenticate users and scrobble their plays.
+package lastfm
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// APIURL is the root URL of the Last.fm API
+	APIURL = "https://ws.audioscrobbler.com/2.0/"
+	// AuthURL is the page users authorize request tokens on
+	AuthURL = "https://www.last.fm/api/auth/"
+
+	// requestTimeout is the maximum time of a request to the Last.fm API
+	requestTimeout = 30 * time.Second
+)
+
+// Error codes of the Last.fm API.
+const (
+	// ErrorInvalidSession is returned for session keys that were revoked
+	ErrorInvalidSession = 9
+	// ErrorServiceOffline is returned if the service is offline
+	ErrorServiceOffline = 11
+	// ErrorUnauthorizedToken is returned for request tokens the user has not authorized yet
+	ErrorUnauthorizedToken = 14
+	// ErrorTemporarilyUnavailable is returned if the service could not process the request
+	ErrorTemporarilyUnavailable = 16
+	// ErrorRateLimitExceeded is returned if the API account made too many requests
+	ErrorRateLimitExceeded = 29
+)
+
+// Error is an error response of the Last.fm API.
+type Error struct {
+	Code    int    `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("last.fm error %d: %s", e.Code, e.Message)
+}
+
+// Temporary checks if the request may succeed when it is retried later.
+func (e Error) Temporary() bool {
+	switch e.Code {
+	case ErrorServiceOffline, ErrorTemporarilyUnavailable, ErrorRateLimitExceeded:
+		return true
+	}
+	return false
+}
+
+// Client calls the Last.fm API with the API key and shared secret of an API account.
+type Client struct {
+	apiKey  string
+	secret  string
+	apiURL  string
+	authURL string
+	http    *http.Client
+}
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithAPIURL replaces APIURL, e.g. for a local stand-in server in tests.
+func WithAPIURL(apiURL string) Option {
+	return func(c *Client) {
+		c.apiURL = apiURL
+	}
+}
+
+// WithAuthURL replaces AuthURL.
+func WithAuthURL(authURL string) Option {
+	return func(c *Client) {
+		c.authURL = authURL
+	}
+}
+
+// WithHTTPClient replaces the HTTP client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// NewClient creates a Client for the API account with apiKey and secret.
+func NewClient(apiKey, secret string, opts ...Option) *Client {
+	c := &Client{
+		apiKey:  apiKey,
+		secret:  secret,
+		apiURL:  APIURL,
+		authURL: AuthURL,
+		http:    &http.Client{Timeout: requestTimeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// call signs params for method, posts them and decodes the JSON response into v.
+func (c *Client) call(method string, params url.Values, v interface{}) error {
+	params.Set("method", method)
+	params.Set("api_key", c.apiKey)
+	params.Set("api_sig", c.sign(params))
+	params.Set("format", "json")
+
+	resp, err := c.http.PostForm(c.apiURL, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var apiErr Error
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Code != 0 {
+		return apiErr
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("last.fm responded with status %d", resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// sign returns the signature of params: the MD5 hash of all parameters sorted by name and concatenated
+// as <name><value> with the shared secret appended.
+func (c *Client) sign(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k == "format" || k == "callback" || k == "api_sig" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteString(params.Get(k))
+	}
+	b.WriteString(c.secret)
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,163 @@
+package lastfm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StandIn is a local stand-in for the Last.fm API for tests. It checks the signature of every request,
+// hands out tokens and sessions and records the accepted scrobbles.
+type StandIn struct {
+	*httptest.Server
+	apiKey string
+	client *Client
+
+	mu         sync.Mutex
+	tokens     int
+	authorized map[string]bool
+	scrobbles  []Scrobble
+	failCode   int
+	ignored    map[string]int
+}
+
+// NewStandIn starts a StandIn for the API account with apiKey and secret. Close it after use.
+func NewStandIn(apiKey, secret string) *StandIn {
+	s := &StandIn{
+		apiKey:     apiKey,
+		authorized: make(map[string]bool),
+		ignored:    make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.client = NewClient(apiKey, secret, WithAPIURL(s.URL), WithAuthURL(s.URL+"/auth"))
+	return s
+}
+
+// Client returns a Client calling the stand-in.
+func (s *StandIn) Client() *Client {
+	return s.client
+}
+
+// Authorize authorizes token like the user would do on AuthorizeURL.
+func (s *StandIn) Authorize(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorized[token] = true
+}
+
+// Fail makes all following requests fail with the API error code. 0 lets them succeed again.
+func (s *StandIn) Fail(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failCode = code
+}
+
+// Ignore makes the stand-in ignore all scrobbles of track with the ignored code, e.g. IgnoredTimestampTooOld.
+func (s *StandIn) Ignore(track string, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ignored[track] = code
+}
+
+// Scrobbles returns all accepted scrobbles.
+func (s *StandIn) Scrobbles() []Scrobble {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Scrobble(nil), s.scrobbles...)
+}
+
+func (s *StandIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	err := r.ParseForm()
+	if err != nil {
+		s.writeError(w, 6, "Invalid parameters")
+		return
+	}
+	if r.PostForm.Get("api_key") != s.apiKey {
+		s.writeError(w, 10, "Invalid API key")
+		return
+	}
+	if r.PostForm.Get("api_sig") != s.client.sign(r.PostForm) {
+		s.writeError(w, 13, "Invalid method signature supplied")
+		return
+	}
+	if s.failCode != 0 {
+		s.writeError(w, s.failCode, "Failed by stand-in")
+		return
+	}
+
+	switch r.PostForm.Get("method") {
+	case "auth.getToken":
+		s.tokens++
+		s.writeJSON(w, map[string]string{"token": "token" + strconv.Itoa(s.tokens)})
+	case "auth.getSession":
+		token := r.PostForm.Get("token")
+		if !s.authorized[token] {
+			s.writeError(w, ErrorUnauthorizedToken, "Unauthorized Token - This token has not been issued")
+			return
+		}
+		s.writeJSON(w, map[string]interface{}{"session": map[string]interface{}{"name": "user", "key": "sk_" + token, "subscriber": 0}})
+	case "track.scrobble":
+		if len(r.PostForm.Get("sk")) < 3 || r.PostForm.Get("sk")[:3] != "sk_" {
+			s.writeError(w, ErrorInvalidSession, "Invalid session key - Please re-authenticate")
+			return
+		}
+		s.scrobble(w, r)
+	default:
+		s.writeError(w, 3, "Invalid Method - No method with that name in this package")
+	}
+}
+
+func (s *StandIn) scrobble(w http.ResponseWriter, r *http.Request) {
+	var results []map[string]interface{}
+	accepted := 0
+	for i := 0; i < MaxBatchSize; i++ {
+		index := fmt.Sprintf("[%d]", i)
+		track := r.PostForm.Get("track" + index)
+		if track == "" {
+			break
+		}
+		code := s.ignored[track]
+		if code == 0 {
+			timestamp, _ := strconv.ParseInt(r.PostForm.Get("timestamp"+index), 10, 64)
+			duration, _ := strconv.Atoi(r.PostForm.Get("duration" + index))
+			s.scrobbles = append(s.scrobbles, Scrobble{
+				Artist:    r.PostForm.Get("artist" + index),
+				Track:     track,
+				Album:     r.PostForm.Get("album" + index),
+				Timestamp: time.Unix(timestamp, 0),
+				Duration:  time.Duration(duration) * time.Second,
+			})
+			accepted++
+		}
+		results = append(results, map[string]interface{}{
+			"track":          map[string]string{"corrected": "0", "#text": track},
+			"ignoredMessage": map[string]string{"code": strconv.Itoa(code), "#text": ""},
+		})
+	}
+
+	var scrobble interface{} = results
+	if len(results) == 1 {
+		scrobble = results[0]
+	}
+	s.writeJSON(w, map[string]interface{}{"scrobbles": map[string]interface{}{
+		"scrobble": scrobble,
+		"@attr":    map[string]int{"accepted": accepted, "ignored": len(results) - accepted},
+	}})
+}
+
+func (s *StandIn) writeJSON(w http.ResponseWriter, v interface{}) {
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *StandIn) writeError(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(http.StatusBadRequest)
+	s.writeJSON(w, Error{Code: code, Message: message})
+}
@@ -0,0 +1,122 @@
+package lastfm
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClient_sign(t *testing.T) {
+	c := NewClient("key", "secret")
+	params := url.Values{
+		"method":  {"auth.getSession"},
+		"api_key": {"key"},
+		"token":   {"token"},
+		"format":  {"json"},
+	}
+	// md5("api_keykeymethodauth.getSessiontokentokensecret")
+	assert.Equal(t, "9ac306496295a8866c4a8673395540eb", c.sign(params))
+
+	params.Set("callback", "cb")
+	params.Set("api_sig", "sig")
+	assert.Equal(t, "9ac306496295a8866c4a8673395540eb", c.sign(params))
+}
+
+func TestError(t *testing.T) {
+	err := Error{Code: ErrorInvalidSession, Message: "Invalid session key"}
+	assert.EqualError(t, err, "last.fm error 9: Invalid session key")
+	assert.False(t, err.Temporary())
+	assert.True(t, Error{Code: ErrorServiceOffline}.Temporary())
+	assert.True(t, Error{Code: ErrorRateLimitExceeded}.Temporary())
+}
+
+func TestClient_Login(t *testing.T) {
+	standIn := NewStandIn("key", "secret")
+	defer standIn.Close()
+	c := standIn.Client()
+
+	var authURL string
+	session, err := c.Login(func(u string) {
+		authURL = u
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			standIn.Authorize("token1")
+		}()
+	}, 10*time.Millisecond, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, Session{Name: "user", Key: "sk_token1"}, session)
+	assert.Equal(t, standIn.URL+"/auth?api_key=key&token=token1", authURL)
+
+	_, err = c.Login(func(string) {}, 10*time.Millisecond, 30*time.Millisecond)
+	assert.EqualError(t, err, "token was not authorized in time")
+
+	_, err = NewClient("key", "wrong_secret", WithAPIURL(standIn.URL)).Login(func(string) {}, time.Millisecond, time.Second)
+	assert.EqualError(t, err, "could not get token: last.fm error 13: Invalid method signature supplied")
+
+	standIn.Fail(ErrorServiceOffline)
+	_, err = c.GetSession("token1")
+	var apiErr Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.True(t, apiErr.Temporary())
+}
+
+func TestClient_Scrobble(t *testing.T) {
+	standIn := NewStandIn("key", "secret")
+	defer standIn.Close()
+	c := standIn.Client()
+	playedAt := time.Unix(1614600000, 0)
+
+	results, err := c.Scrobble("sk_token1", []Scrobble{
+		{Artist: "artist", Track: "track", Album: "album", Timestamp: playedAt, Duration: 200 * time.Second},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []ScrobbleResult{{Accepted: true}}, results)
+	assert.Equal(t, []Scrobble{
+		{Artist: "artist", Track: "track", Album: "album", Timestamp: playedAt, Duration: 200 * time.Second},
+	}, standIn.Scrobbles())
+
+	standIn.Ignore("old", IgnoredTimestampTooOld)
+	results, err = c.Scrobble("sk_token1", []Scrobble{
+		{Artist: "artist", Track: "old", Timestamp: playedAt},
+		{Artist: "artist", Track: "new", Timestamp: playedAt},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []ScrobbleResult{{IgnoredCode: IgnoredTimestampTooOld}, {Accepted: true}}, results)
+	assert.Equal(t, 2, len(standIn.Scrobbles()))
+	assert.False(t, results[0].Temporary())
+	assert.True(t, ScrobbleResult{IgnoredCode: IgnoredDailyLimitExceeded}.Temporary())
+
+	results, err = c.Scrobble("sk_token1", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, results)
+
+	_, err = c.Scrobble("sk_token1", make([]Scrobble, MaxBatchSize+1))
+	assert.EqualError(t, err, "at most 50 plays can be scrobbled at once, got 51")
+
+	_, err = c.Scrobble("invalid", []Scrobble{{Artist: "artist", Track: "track", Timestamp: playedAt}})
+	assert.EqualError(t, err, "last.fm error 9: Invalid session key - Please re-authenticate")
+}
+
+func TestSession(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lastfm_session.json")
+	_, err := LoadSession(file)
+	assert.Error(t, err)
+
+	assert.NoError(t, SaveSession(file, Session{Name: "user", Key: "sk"}))
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	session, err := LoadSession(file)
+	assert.NoError(t, err)
+	assert.Equal(t, Session{Name: "user", Key: "sk"}, session)
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"name":"user"}`), 0600))
+	_, err = LoadSession(file)
+	assert.Contains(t, err.Error(), "contains no session key")
+}
@@ -0,0 +1,120 @@
+package lastfm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MaxBatchSize is the maximum number of plays that can be scrobbled at once
+const MaxBatchSize = 50
+
+// Codes of scrobbles Last.fm ignored.
+const (
+	// IgnoredArtist is the code of scrobbles with an ignored artist
+	IgnoredArtist = 1
+	// IgnoredTrack is the code of scrobbles with an ignored track
+	IgnoredTrack = 2
+	// IgnoredTimestampTooOld is the code of scrobbles older than 14 days
+	IgnoredTimestampTooOld = 3
+	// IgnoredTimestampTooNew is the code of scrobbles in the future
+	IgnoredTimestampTooNew = 4
+	// IgnoredDailyLimitExceeded is the code of scrobbles beyond the daily limit of the user
+	IgnoredDailyLimitExceeded = 5
+)
+
+// Scrobble is a play of a track.
+type Scrobble struct {
+	Artist    string
+	Track     string
+	Album     string
+	Timestamp time.Time
+	Duration  time.Duration
+}
+
+// ScrobbleResult tells if Last.fm accepted a scrobble.
+type ScrobbleResult struct {
+	Accepted bool
+	// IgnoredCode is the reason Last.fm ignored the scrobble, e.g. IgnoredTimestampTooOld
+	IgnoredCode    int
+	IgnoredMessage string
+}
+
+// Temporary checks if an ignored scrobble may be accepted when it is retried later.
+func (r ScrobbleResult) Temporary() bool {
+	return !r.Accepted && r.IgnoredCode == IgnoredDailyLimitExceeded
+}
+
+// scrobbleResponse is the response of track.scrobble. Last.fm returns a single scrobble as object
+// instead of an array with one element.
+type scrobbleResponse struct {
+	Scrobbles struct {
+		Scrobble json.RawMessage `json:"scrobble"`
+	} `json:"scrobbles"`
+}
+
+type scrobbledTrack struct {
+	IgnoredMessage struct {
+		Code string `json:"code"`
+		Text string `json:"#text"`
+	} `json:"ignoredMessage"`
+}
+
+// Scrobble scrobbles up to MaxBatchSize plays for the user of sessionKey.
+// It returns one result per scrobble in the same order.
+func (c *Client) Scrobble(sessionKey string, scrobbles []Scrobble) ([]ScrobbleResult, error) {
+	if len(scrobbles) == 0 {
+		return nil, nil
+	}
+	if len(scrobbles) > MaxBatchSize {
+		return nil, fmt.Errorf("at most %d plays can be scrobbled at once, got %d", MaxBatchSize, len(scrobbles))
+	}
+
+	params := url.Values{"sk": {sessionKey}}
+	for i, s := range scrobbles {
+		index := "[" + strconv.Itoa(i) + "]"
+		params.Set("artist"+index, s.Artist)
+		params.Set("track"+index, s.Track)
+		params.Set("timestamp"+index, strconv.FormatInt(s.Timestamp.Unix(), 10))
+		if s.Album != "" {
+			params.Set("album"+index, s.Album)
+		}
+		if s.Duration > 0 {
+			params.Set("duration"+index, strconv.Itoa(int(s.Duration.Seconds())))
+		}
+	}
+
+	var resp scrobbleResponse
+	err := c.call("track.scrobble", params, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []scrobbledTrack
+	if len(scrobbles) == 1 {
+		var track scrobbledTrack
+		err = json.Unmarshal(resp.Scrobbles.Scrobble, &track)
+		tracks = append(tracks, track)
+	} else {
+		err = json.Unmarshal(resp.Scrobbles.Scrobble, &tracks)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode scrobbles: %v", err)
+	}
+	if len(tracks) != len(scrobbles) {
+		return nil, fmt.Errorf("last.fm returned a result for %d of %d scrobbles", len(tracks), len(scrobbles))
+	}
+
+	results := make([]ScrobbleResult, len(tracks))
+	for i, t := range tracks {
+		code, _ := strconv.Atoi(t.IgnoredMessage.Code)
+		results[i] = ScrobbleResult{
+			Accepted:       code == 0,
+			IgnoredCode:    code,
+			IgnoredMessage: t.IgnoredMessage.Text,
+		}
+	}
+	return results, nil
+}
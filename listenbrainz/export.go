@@ -0,0 +1,254 @@
+package listenbrainz
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadJSONExport reads the listens of a JSON export, either a JSON array or one listen per line.
+func ReadJSONExport(r io.Reader) ([]Listen, error) {
+	reader := bufio.NewReader(r)
+	first, err := firstByte(reader)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	var exported []exportedListen
+	if first == '[' {
+		err = decoder.Decode(&exported)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for {
+			var listen exportedListen
+			err = decoder.Decode(&listen)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid listen %d: %v", len(exported)+1, err)
+			}
+			exported = append(exported, listen)
+		}
+	}
+
+	listens := make([]Listen, 0, len(exported))
+	for i, e := range exported {
+		listen, err := e.listen()
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen %d: %v", i+1, err)
+		}
+		listens = append(listens, listen)
+	}
+	return listens, nil
+}
+
+// exportedListen is a listen of an export. Clients submit additional info of any type, so it is converted leniently.
+type exportedListen struct {
+	ListenedAt    interface{} `json:"listened_at"`
+	TrackMetadata struct {
+		ArtistName     string                 `json:"artist_name"`
+		TrackName      string                 `json:"track_name"`
+		ReleaseName    string                 `json:"release_name"`
+		AdditionalInfo map[string]interface{} `json:"additional_info"`
+		MBIDMapping    *MBIDMapping           `json:"mbid_mapping"`
+	} `json:"track_metadata"`
+}
+
+func (e exportedListen) listen() (Listen, error) {
+	listenedAt, err := parseListenedAt(infoString(e.ListenedAt))
+	if err != nil {
+		return Listen{}, err
+	}
+	info := e.TrackMetadata.AdditionalInfo
+	duration := infoInt(info["duration_ms"])
+	if duration == 0 {
+		duration = infoInt(info["duration"]) * 1000
+	}
+	return Listen{
+		ListenedAt: listenedAt,
+		TrackMetadata: TrackMetadata{
+			ArtistName:  e.TrackMetadata.ArtistName,
+			TrackName:   e.TrackMetadata.TrackName,
+			ReleaseName: e.TrackMetadata.ReleaseName,
+			AdditionalInfo: AdditionalInfo{
+				ISRC:             infoString(info["isrc"]),
+				RecordingMBID:    infoString(info["recording_mbid"]),
+				ReleaseMBID:      infoString(info["release_mbid"]),
+				ArtistMBIDs:      infoStrings(info["artist_mbids"]),
+				SpotifyID:        infoString(info["spotify_id"]),
+				SpotifyAlbumID:   infoString(info["spotify_album_id"]),
+				SpotifyArtistIDs: infoStrings(info["spotify_artist_ids"]),
+				ArtistNames:      infoStrings(info["artist_names"]),
+				TrackNumber:      infoInt(info["tracknumber"]),
+				DurationMs:       duration,
+				OriginURL:        infoString(info["origin_url"]),
+				MusicService:     infoString(info["music_service"]),
+				MediaPlayer:      infoString(info["media_player"]),
+				SubmissionClient: infoString(info["submission_client"]),
+			},
+			MBIDMapping: e.TrackMetadata.MBIDMapping,
+		},
+	}, nil
+}
+
+// infoString converts a string or number of additional info to a string.
+func infoString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+// infoInt converts a number or a numeric string of additional info to an int. It returns 0 for other values.
+func infoInt(v interface{}) int {
+	n, err := strconv.ParseFloat(infoString(v), 64)
+	if err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// infoStrings converts a list of strings or a comma separated string of additional info to strings.
+func infoStrings(v interface{}) []string {
+	var values []string
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			if s := infoString(e); s != "" {
+				values = append(values, s)
+			}
+		}
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// firstByte returns the first byte of r that is no white space without consuming it.
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, r.UnreadByte()
+		}
+	}
+}
+
+// ReadCSVExport reads the listens of a CSV export with header. Its columns are listened_at as Unix time or RFC 3339,
+// artist_name, track_name and optionally release_name, recording_mbid, release_mbid, artist_mbids separated by
+// commas, isrc, spotify_id and duration_ms.
+func ReadCSVExport(r io.Reader) ([]Listen, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"listened_at", "artist_name", "track_name"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing column %s", required)
+		}
+	}
+
+	var listens []Listen
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return listens, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		listenedAt, err := parseListenedAt(field("listened_at"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid listened_at in record %d: %v", line, err)
+		}
+		listens = append(listens, Listen{
+			ListenedAt: listenedAt,
+			TrackMetadata: TrackMetadata{
+				ArtistName:  field("artist_name"),
+				TrackName:   field("track_name"),
+				ReleaseName: field("release_name"),
+				AdditionalInfo: AdditionalInfo{
+					ISRC:          field("isrc"),
+					RecordingMBID: field("recording_mbid"),
+					ReleaseMBID:   field("release_mbid"),
+					ArtistMBIDs:   infoStrings(field("artist_mbids")),
+					SpotifyID:     field("spotify_id"),
+					DurationMs:    infoInt(field("duration_ms")),
+				},
+			},
+		})
+	}
+}
+
+// parseListenedAt parses a Unix time or a RFC 3339 date.
+func parseListenedAt(value string) (int64, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		return seconds, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("no Unix time or RFC 3339 date: %q", value)
+	}
+	return t.Unix(), nil
+}
+
+// MBIDs returns the recording, release and artist MBIDs of a listen, preferring the ones ListenBrainz linked.
+func (l Listen) MBIDs() MBIDMapping {
+	info := l.TrackMetadata.AdditionalInfo
+	mbids := MBIDMapping{
+		RecordingMBID: info.RecordingMBID,
+		ReleaseMBID:   info.ReleaseMBID,
+		ArtistMBIDs:   info.ArtistMBIDs,
+	}
+	if m := l.TrackMetadata.MBIDMapping; m != nil {
+		if m.RecordingMBID != "" {
+			mbids.RecordingMBID = m.RecordingMBID
+		}
+		if m.ReleaseMBID != "" {
+			mbids.ReleaseMBID = m.ReleaseMBID
+		}
+		if len(m.ArtistMBIDs) > 0 {
+			mbids.ArtistMBIDs = m.ArtistMBIDs
+		}
+	}
+	return mbids
+}
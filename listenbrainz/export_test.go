@@ -0,0 +1,94 @@
+package listenbrainz
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const exportedListenJSON = `{"listened_at": 1614600000, "recording_msid": "msid", "track_metadata": {
+	"artist_name": "artist", "track_name": "track", "release_name": "album",
+	"additional_info": {"isrc": "USRC17607839", "tracknumber": "3", "duration_ms": 200000,
+		"spotify_id": "https://open.spotify.com/track/t_id", "artist_mbids": ["a-mbid-submitted"]},
+	"mbid_mapping": {"recording_mbid": "r-mbid", "artist_mbids": ["a-mbid"]}}}`
+
+func TestReadJSONExport(t *testing.T) {
+	expected := Listen{
+		ListenedAt: 1614600000,
+		TrackMetadata: TrackMetadata{
+			ArtistName:  "artist",
+			TrackName:   "track",
+			ReleaseName: "album",
+			AdditionalInfo: AdditionalInfo{
+				ISRC:        "USRC17607839",
+				ArtistMBIDs: []string{"a-mbid-submitted"},
+				SpotifyID:   "https://open.spotify.com/track/t_id",
+				TrackNumber: 3,
+				DurationMs:  200000,
+			},
+			MBIDMapping: &MBIDMapping{RecordingMBID: "r-mbid", ArtistMBIDs: []string{"a-mbid"}},
+		},
+	}
+
+	t.Run("Array", func(t *testing.T) {
+		listens, err := ReadJSONExport(strings.NewReader(" [" + exportedListenJSON + "]"))
+		assert.NoError(t, err)
+		assert.Equal(t, []Listen{expected}, listens)
+	})
+
+	t.Run("Lines", func(t *testing.T) {
+		line := strings.Join(strings.Fields(exportedListenJSON), " ")
+		listens, err := ReadJSONExport(strings.NewReader(line + "\n" + line + "\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, []Listen{expected, expected}, listens)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		listens, err := ReadJSONExport(strings.NewReader("\n"))
+		assert.NoError(t, err)
+		assert.Empty(t, listens)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := ReadJSONExport(strings.NewReader(`{"listened_at": "yesterday"}`))
+		assert.EqualError(t, err, `invalid listen 1: no Unix time or RFC 3339 date: "yesterday"`)
+	})
+}
+
+func TestReadCSVExport(t *testing.T) {
+	listens, err := ReadCSVExport(strings.NewReader("listened_at,artist_name,track_name,release_name,recording_mbid,artist_mbids,isrc\n" +
+		"1614600000,artist,track,album,r-mbid,\"a-mbid, a-mbid2\",USRC17607839\n" +
+		"2021-03-01T12:00:00Z,artist,track,,,,\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Listen{
+		{
+			ListenedAt: 1614600000,
+			TrackMetadata: TrackMetadata{
+				ArtistName:  "artist",
+				TrackName:   "track",
+				ReleaseName: "album",
+				AdditionalInfo: AdditionalInfo{
+					ISRC:          "USRC17607839",
+					RecordingMBID: "r-mbid",
+					ArtistMBIDs:   []string{"a-mbid", "a-mbid2"},
+				},
+			},
+		},
+		{ListenedAt: 1614600000, TrackMetadata: TrackMetadata{ArtistName: "artist", TrackName: "track"}},
+	}, listens)
+
+	_, err = ReadCSVExport(strings.NewReader("artist_name,track_name\n"))
+	assert.EqualError(t, err, "missing column listened_at")
+}
+
+func TestListen_MBIDs(t *testing.T) {
+	listen := Listen{TrackMetadata: TrackMetadata{AdditionalInfo: AdditionalInfo{
+		RecordingMBID: "r-mbid-submitted",
+		ReleaseMBID:   "al-mbid",
+		ArtistMBIDs:   []string{"a-mbid-submitted"},
+	}}}
+	assert.Equal(t, MBIDMapping{RecordingMBID: "r-mbid-submitted", ReleaseMBID: "al-mbid", ArtistMBIDs: []string{"a-mbid-submitted"}}, listen.MBIDs())
+
+	listen.TrackMetadata.MBIDMapping = &MBIDMapping{RecordingMBID: "r-mbid", ArtistMBIDs: []string{"a-mbid"}}
+	assert.Equal(t, MBIDMapping{RecordingMBID: "r-mbid", ReleaseMBID: "al-mbid", ArtistMBIDs: []string{"a-mbid"}}, listen.MBIDs())
+}
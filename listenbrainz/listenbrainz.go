@@ -0,0 +1,131 @@
+// Package listenbrainz is a small client of the ListenBrainz API to submit listens.
+package listenbrainz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// APIURL is the root URL of the ListenBrainz API
+	APIURL = "https://api.listenbrainz.org"
+
+	// requestTimeout is the maximum time of a request to the ListenBrainz API
+	requestTimeout = 30 * time.Second
+)
+
+// Error is an error response of the ListenBrainz API. Code is the HTTP status code.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"error"`
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("listenbrainz error %d: %s", e.Code, e.Message)
+}
+
+// Temporary checks if the request may succeed when it is retried later.
+func (e Error) Temporary() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= http.StatusInternalServerError
+}
+
+// Client calls the ListenBrainz API with the token of a user.
+type Client struct {
+	token  string
+	apiURL string
+	http   *http.Client
+}
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithAPIURL replaces APIURL, e.g. for a self-hosted server or a local stand-in server in tests.
+func WithAPIURL(apiURL string) Option {
+	return func(c *Client) {
+		c.apiURL = apiURL
+	}
+}
+
+// WithHTTPClient replaces the HTTP client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// NewClient creates a Client for the user of token.
+func NewClient(token string, opts ...Option) *Client {
+	c := &Client{
+		token:  token,
+		apiURL: APIURL,
+		http:   &http.Client{Timeout: requestTimeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ValidateToken checks the token and returns the name of its user.
+func (c *Client) ValidateToken() (string, error) {
+	var resp struct {
+		Valid    bool   `json:"valid"`
+		Message  string `json:"message"`
+		UserName string `json:"user_name"`
+	}
+	err := c.call(http.MethodGet, "/1/validate-token", nil, &resp)
+	if err != nil {
+		return "", err
+	}
+	if !resp.Valid {
+		return "", fmt.Errorf("invalid token: %s", resp.Message)
+	}
+	return resp.UserName, nil
+}
+
+// call sends body as JSON to path with the token of the user and decodes the JSON response into v.
+func (c *Client) call(method, path string, body interface{}, v interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.apiURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := Error{Code: resp.StatusCode}
+		_ = json.Unmarshal(data, &apiErr)
+		apiErr.Code = resp.StatusCode
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+	return json.Unmarshal(data, v)
+}
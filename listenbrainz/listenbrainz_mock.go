@@ -0,0 +1,121 @@
+package listenbrainz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Submission is a submission received by a StandIn.
+type Submission struct {
+	ListenType string
+	Listens    []Listen
+}
+
+// StandIn is a local stand-in for the ListenBrainz API for tests. It checks the token of every request
+// and records the accepted submissions.
+type StandIn struct {
+	*httptest.Server
+	token string
+
+	mu          sync.Mutex
+	submissions []Submission
+	failCode    int
+	rejected    map[string]bool
+}
+
+// NewStandIn starts a StandIn accepting token. Close it after use.
+func NewStandIn(token string) *StandIn {
+	s := &StandIn{
+		token:    token,
+		rejected: make(map[string]bool),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Client returns a Client calling the stand-in with token.
+func (s *StandIn) Client(token string) *Client {
+	return NewClient(token, WithAPIURL(s.URL))
+}
+
+// Fail makes all following requests fail with the HTTP status code. 0 lets them succeed again.
+func (s *StandIn) Fail(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failCode = code
+}
+
+// Reject makes the stand-in reject all submissions containing a listen of track as invalid.
+func (s *StandIn) Reject(track string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected[track] = true
+}
+
+// Submissions returns all accepted submissions.
+func (s *StandIn) Submissions() []Submission {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Submission(nil), s.submissions...)
+}
+
+// Listens returns the listens of all accepted submissions.
+func (s *StandIn) Listens() []Listen {
+	var listens []Listen
+	for _, sub := range s.Submissions() {
+		listens = append(listens, sub.Listens...)
+	}
+	return listens
+}
+
+func (s *StandIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Header.Get("Authorization") != "Token "+s.token {
+		if r.URL.Path == "/1/validate-token" {
+			s.writeJSON(w, http.StatusOK, map[string]interface{}{"code": 200, "message": "Token invalid.", "valid": false})
+			return
+		}
+		s.writeError(w, http.StatusUnauthorized, "Invalid authorization token.")
+		return
+	}
+	if s.failCode != 0 {
+		s.writeError(w, s.failCode, "Failed by stand-in")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/1/validate-token":
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{"code": 200, "message": "Token valid.", "valid": true, "user_name": "user"})
+	case r.Method == http.MethodPost && r.URL.Path == "/1/submit-listens":
+		var sub submission
+		err := json.NewDecoder(r.Body).Decode(&sub)
+		if err != nil || len(sub.Payload) == 0 {
+			s.writeError(w, http.StatusBadRequest, "Invalid JSON document submitted.")
+			return
+		}
+		for _, l := range sub.Payload {
+			if s.rejected[l.TrackMetadata.TrackName] {
+				s.writeError(w, http.StatusBadRequest, "Invalid listen: "+l.TrackMetadata.TrackName)
+				return
+			}
+		}
+		s.submissions = append(s.submissions, Submission{ListenType: sub.ListenType, Listens: sub.Payload})
+		s.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		s.writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *StandIn) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *StandIn) writeError(w http.ResponseWriter, code int, message string) {
+	s.writeJSON(w, code, map[string]interface{}{"code": code, "error": message})
+}
@@ -0,0 +1,83 @@
+package listenbrainz
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	err := Error{Code: http.StatusUnauthorized, Message: "Invalid authorization token."}
+	assert.EqualError(t, err, "listenbrainz error 401: Invalid authorization token.")
+	assert.False(t, err.Temporary())
+	assert.True(t, Error{Code: http.StatusTooManyRequests}.Temporary())
+	assert.True(t, Error{Code: http.StatusServiceUnavailable}.Temporary())
+}
+
+func TestClient_ValidateToken(t *testing.T) {
+	standIn := NewStandIn("token")
+	defer standIn.Close()
+
+	user, err := standIn.Client("token").ValidateToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "user", user)
+
+	_, err = standIn.Client("wrong_token").ValidateToken()
+	assert.EqualError(t, err, "invalid token: Token invalid.")
+}
+
+func TestListen_json(t *testing.T) {
+	data, err := json.Marshal(Listen{
+		ListenedAt: 1614600000,
+		TrackMetadata: TrackMetadata{
+			ArtistName: "artist",
+			TrackName:  "track",
+			AdditionalInfo: AdditionalInfo{
+				ISRC:      "USRC17607839",
+				SpotifyID: "https://open.spotify.com/track/t_id",
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"listened_at": 1614600000, "track_metadata": {"artist_name": "artist", "track_name": "track",
+		"additional_info": {"isrc": "USRC17607839", "spotify_id": "https://open.spotify.com/track/t_id"}}}`, string(data))
+}
+
+func TestClient_SubmitListens(t *testing.T) {
+	standIn := NewStandIn("token")
+	defer standIn.Close()
+	c := standIn.Client("token")
+	listen := Listen{ListenedAt: 1614600000, TrackMetadata: TrackMetadata{ArtistName: "artist", TrackName: "track"}}
+
+	assert.NoError(t, c.SubmitListens(ListenTypeSingle, nil))
+	assert.NoError(t, c.SubmitListens(ListenTypeSingle, []Listen{listen}))
+	assert.NoError(t, c.SubmitListens(ListenTypeImport, []Listen{listen, listen}))
+	assert.Equal(t, []Submission{
+		{ListenType: ListenTypeSingle, Listens: []Listen{listen}},
+		{ListenType: ListenTypeImport, Listens: []Listen{listen, listen}},
+	}, standIn.Submissions())
+	assert.Equal(t, 3, len(standIn.Listens()))
+
+	err := c.SubmitListens(ListenTypeSingle, []Listen{listen, listen})
+	assert.EqualError(t, err, "single submissions take one listen, got 2")
+	err = c.SubmitListens(ListenTypeImport, make([]Listen, MaxListensPerRequest+1))
+	assert.EqualError(t, err, "at most 1000 listens can be submitted at once, got 1001")
+	err = c.SubmitListens("unknown", []Listen{listen})
+	assert.EqualError(t, err, `unknown listen type "unknown"`)
+
+	err = standIn.Client("wrong_token").SubmitListens(ListenTypeSingle, []Listen{listen})
+	assert.EqualError(t, err, "listenbrainz error 401: Invalid authorization token.")
+
+	standIn.Reject("track")
+	err = c.SubmitListens(ListenTypeSingle, []Listen{listen})
+	assert.EqualError(t, err, "listenbrainz error 400: Invalid listen: track")
+
+	standIn.Fail(http.StatusTooManyRequests)
+	err = c.SubmitListens(ListenTypeSingle, []Listen{listen})
+	var apiErr Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.True(t, apiErr.Temporary())
+	assert.Equal(t, 3, len(standIn.Listens()))
+}
@@ -0,0 +1,98 @@
+package listenbrainz
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// MaxListensPerRequest is the maximum number of listens that can be submitted at once
+const MaxListensPerRequest = 1000
+
+// Types of submissions.
+const (
+	// ListenTypeSingle submits one listen the user just finished
+	ListenTypeSingle = "single"
+	// ListenTypeImport submits listens of the past, e.g. when importing a history
+	ListenTypeImport = "import"
+	// ListenTypePlayingNow submits the track the user is listening to without listened_at
+	ListenTypePlayingNow = "playing_now"
+)
+
+// Listen is a listen of a track. ListenedAt is the Unix time the listen started.
+type Listen struct {
+	ListenedAt    int64         `json:"listened_at,omitempty"`
+	TrackMetadata TrackMetadata `json:"track_metadata"`
+}
+
+// TrackMetadata describes the listened track.
+type TrackMetadata struct {
+	ArtistName     string         `json:"artist_name"`
+	TrackName      string         `json:"track_name"`
+	ReleaseName    string         `json:"release_name,omitempty"`
+	AdditionalInfo AdditionalInfo `json:"additional_info"`
+	// MBIDMapping links the listen to MusicBrainz. It is set by ListenBrainz in exports only.
+	MBIDMapping *MBIDMapping `json:"mbid_mapping,omitempty"`
+}
+
+// MBIDMapping are the MusicBrainz IDs ListenBrainz linked to a listen.
+type MBIDMapping struct {
+	RecordingMBID string   `json:"recording_mbid,omitempty"`
+	ReleaseMBID   string   `json:"release_mbid,omitempty"`
+	ArtistMBIDs   []string `json:"artist_mbids,omitempty"`
+}
+
+// AdditionalInfo helps ListenBrainz to link a listen to MusicBrainz. The Spotify fields are URLs.
+type AdditionalInfo struct {
+	ISRC             string   `json:"isrc,omitempty"`
+	RecordingMBID    string   `json:"recording_mbid,omitempty"`
+	ReleaseMBID      string   `json:"release_mbid,omitempty"`
+	ArtistMBIDs      []string `json:"artist_mbids,omitempty"`
+	SpotifyID        string   `json:"spotify_id,omitempty"`
+	SpotifyAlbumID   string   `json:"spotify_album_id,omitempty"`
+	SpotifyArtistIDs []string `json:"spotify_artist_ids,omitempty"`
+	ArtistNames      []string `json:"artist_names,omitempty"`
+	TrackNumber      int      `json:"tracknumber,omitempty"`
+	DurationMs       int      `json:"duration_ms,omitempty"`
+	OriginURL        string   `json:"origin_url,omitempty"`
+	MusicService     string   `json:"music_service,omitempty"`
+	MediaPlayer      string   `json:"media_player,omitempty"`
+	SubmissionClient string   `json:"submission_client,omitempty"`
+}
+
+// submission is the body of submit-listens.
+type submission struct {
+	ListenType string   `json:"listen_type"`
+	Payload    []Listen `json:"payload"`
+}
+
+// SubmitListens submits up to MaxListensPerRequest listens of listenType.
+// Single and playing now submissions take exactly one listen.
+func (c *Client) SubmitListens(listenType string, listens []Listen) error {
+	if len(listens) == 0 {
+		return nil
+	}
+	switch listenType {
+	case ListenTypeSingle, ListenTypePlayingNow:
+		if len(listens) != 1 {
+			return fmt.Errorf("%s submissions take one listen, got %d", listenType, len(listens))
+		}
+	case ListenTypeImport:
+		if len(listens) > MaxListensPerRequest {
+			return fmt.Errorf("at most %d listens can be submitted at once, got %d", MaxListensPerRequest, len(listens))
+		}
+	default:
+		return fmt.Errorf("unknown listen type %q", listenType)
+	}
+
+	var resp struct {
+		Status string `json:"status"`
+	}
+	err := c.call(http.MethodPost, "/1/submit-listens", submission{ListenType: listenType, Payload: listens}, &resp)
+	if err != nil {
+		return err
+	}
+	if resp.Status != "ok" {
+		return fmt.Errorf("listenbrainz responded with status %q", resp.Status)
+	}
+	return nil
+}
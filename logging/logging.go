@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"fmt"
+	nested "github.com/antonfisher/nested-logrus-formatter"
+	"github.com/gobuffalo/pop/v5"
+	poplogging "github.com/gobuffalo/pop/v5/logging"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	// FormatText writes human readable lines
+	FormatText = "text"
+	// FormatJSON writes one JSON object per line
+	FormatJSON = "json"
+
+	// DefaultLevel is the log level used if none is configured
+	DefaultLevel = "info"
+	// DefaultFormat is the log format used if none is configured
+	DefaultFormat = FormatText
+)
+
+// Fields every package uses for the same information.
+const (
+	// FieldComponent is the package or app part writing the entry
+	FieldComponent = "component"
+	// FieldCategory is the worker or area inside a component
+	FieldCategory = "category"
+	// FieldPollID identifies all entries of one poll of the recently played history
+	FieldPollID = "poll_id"
+	// FieldUser is the Spotify user ID of the saved history
+	FieldUser = "user"
+	// FieldCount is the number of handled items
+	FieldCount = "count"
+	// FieldDuration is the time an operation took
+	FieldDuration = "duration"
+)
+
+// NewLogger creates a logger with level and format. Empty values fall back to the defaults.
+func NewLogger(level, format string) (*logrus.Logger, error) {
+	logger := logrus.New()
+	err := Configure(logger, level, format)
+	if err != nil {
+		return nil, err
+	}
+	return logger, nil
+}
+
+// Configure sets level and format of logger. Empty values fall back to the defaults.
+// The SQL queries of pop are logged at debug level.
+func Configure(logger *logrus.Logger, level, format string) error {
+	if level == "" {
+		level = DefaultLevel
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %s", level)
+	}
+
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		logger.SetFormatter(&nested.Formatter{
+			FieldsOrder: []string{FieldComponent, FieldCategory},
+			HideKeys:    true,
+		})
+	case FormatJSON:
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format: %s", format)
+	}
+	logger.SetLevel(lvl)
+
+	pop.Debug = logger.IsLevelEnabled(logrus.DebugLevel)
+	pop.SetLogger(popLogger(logger.WithField(FieldComponent, "pop")))
+	return nil
+}
+
+// popLogger writes the log of pop to log.
+func popLogger(log *logrus.Entry) func(lvl poplogging.Level, s string, args ...interface{}) {
+	return func(lvl poplogging.Level, s string, args ...interface{}) {
+		switch lvl {
+		case poplogging.SQL:
+			if !log.Logger.IsLevelEnabled(logrus.DebugLevel) {
+				return
+			}
+			entry := log
+			if len(args) > 0 {
+				entry = log.WithField("args", args)
+			}
+			entry.Debug(s)
+		case poplogging.Debug:
+			log.Debugf(s, args...)
+		case poplogging.Info:
+			log.Infof(s, args...)
+		case poplogging.Warn:
+			log.Warnf(s, args...)
+		default:
+			log.Errorf(s, args...)
+		}
+	}
+}
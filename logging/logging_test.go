@@ -0,0 +1,55 @@
+package logging
+
+import (
+	nested "github.com/antonfisher/nested-logrus-formatter"
+	"github.com/gobuffalo/pop/v5"
+	poplogging "github.com/gobuffalo/pop/v5/logging"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	logger, err := NewLogger("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, logrus.InfoLevel, logger.GetLevel())
+	assert.IsType(t, &nested.Formatter{}, logger.Formatter)
+	assert.False(t, pop.Debug)
+
+	logger, err = NewLogger("debug", "JSON")
+	assert.NoError(t, err)
+	assert.Equal(t, logrus.DebugLevel, logger.GetLevel())
+	assert.IsType(t, &logrus.JSONFormatter{}, logger.Formatter)
+	assert.True(t, pop.Debug)
+
+	_, err = NewLogger("verbose", "")
+	assert.EqualError(t, err, "invalid log level: verbose")
+
+	_, err = NewLogger("", "yaml")
+	assert.EqualError(t, err, "invalid log format: yaml")
+
+	pop.Debug = false
+}
+
+func TestPopLogger(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	log := popLogger(logger.WithField(FieldComponent, "pop"))
+
+	log(poplogging.SQL, "SELECT 1")
+	assert.Empty(t, hook.Entries)
+
+	logger.SetLevel(logrus.DebugLevel)
+	log(poplogging.SQL, "SELECT * FROM tracks WHERE id = ?", "t_id")
+	assert.Equal(t, logrus.DebugLevel, hook.LastEntry().Level)
+	assert.Equal(t, "SELECT * FROM tracks WHERE id = ?", hook.LastEntry().Message)
+	assert.Equal(t, []interface{}{"t_id"}, hook.LastEntry().Data["args"])
+	assert.Equal(t, "pop", hook.LastEntry().Data[FieldComponent])
+
+	log(poplogging.Warn, "%d migrations pending", 2)
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Equal(t, "2 migrations pending", hook.LastEntry().Message)
+
+	log(poplogging.Error, "failed")
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+}
@@ -5,12 +5,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	nested "github.com/antonfisher/nested-logrus-formatter"
+	"github.com/elivlo/SpotifyHistorySaver/logging"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -42,11 +44,11 @@ type Login struct {
 	codeChallenge string
 }
 
-// NewLogin creates a new Login with the given callbackURL to listen on.
+// NewLogin creates a new Login with the given callbackURL to listen on. It writes to log.
 // It will also create a code verifier for this login.
-func NewLogin(callbackURL, clientID, clientSecret string) Login {
+func NewLogin(callbackURL, clientID, clientSecret string, log *logrus.Entry) Login {
 	login := Login{
-		logger:       initLogger(logrus.New()),
+		logger:       log.WithField(logging.FieldComponent, "login"),
 		callbackURI:  callbackURL,
 		state:        createCodeVerifier(20),
 		codeVerifier: createCodeVerifier(96),
@@ -56,7 +58,7 @@ func NewLogin(callbackURL, clientID, clientSecret string) Login {
 
 	// creates new Authenticator
 	login.auth = spotifyauth.New(spotifyauth.WithRedirectURL(login.callbackURI),
-		spotifyauth.WithScopes(spotifyauth.ScopeUserReadRecentlyPlayed),
+		spotifyauth.WithScopes(spotifyauth.ScopeUserReadRecentlyPlayed, spotifyauth.ScopeUserReadPlaybackState),
 		spotifyauth.WithClientID(clientID),
 		spotifyauth.WithClientSecret(clientSecret))
 
@@ -69,7 +71,7 @@ func (l Login) Login() *oauth2.Token {
 	servMux := http.NewServeMux()
 	servMux.HandleFunc("/callback", l.authHandler)
 	server := http.Server{
-		Addr: ":8080",
+		Addr: l.listenAddr(),
 		Handler: servMux,
 	}
 
@@ -99,28 +101,60 @@ func (l Login) Login() *oauth2.Token {
 }
 
 // SaveToken will save access and refresh token to token.json file in exec directory.
+// The token is written atomically, so a crash while saving never leaves a partial token behind.
 func (l Login) SaveToken(file string, token *oauth2.Token) error {
 	fileString, err := json.Marshal(token)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0700)
+	err = writeFileAtomic(file, fileString, 0600)
 	if err != nil {
 		return err
 	}
 
-	_, err = f.Write(fileString)
+	dir, err := os.Getwd()
 	if err != nil {
 		return err
 	}
+	l.logger.Infof("Wrote access token to %s/%s", dir, file)
+	return nil
+}
 
-	dir, err := os.Getwd()
+// writeFileAtomic writes data to a temporary file next to file, syncs it to disk and renames it to file.
+// The file will always contain either the old or the new content.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	l.logger.Infof("Wrote access token to %s/%s", dir, file)
-	return nil
+	tmpName := f.Name()
+
+	err = writeAndSync(f, data, perm)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, file)
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+	}
+	return err
+}
+
+// writeAndSync sets the permissions of f, writes data to it and flushes it to disk.
+func writeAndSync(f *os.File, data []byte, perm os.FileMode) error {
+	err := f.Chmod(perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+	return f.Sync()
 }
 
 // authHandler will handle the incoming token from Spotify.
@@ -142,14 +176,13 @@ func (l Login) authHandler(w http.ResponseWriter, r *http.Request) {
 	l.ch <- token
 }
 
-// initLogger inits a logger with "ACCOUNT LOGIN" prefix.
-func initLogger(logger *logrus.Logger) *logrus.Entry {
-	logger.SetLevel(logrus.InfoLevel)
-	logger.SetFormatter(&nested.Formatter{
-		FieldsOrder: []string{"component", "category"},
-		HideKeys:    true,
-	})
-	return logger.WithField("component", "LOGIN")
+// listenAddr returns the address of the callback URI to listen on. It defaults to port 8080.
+func (l Login) listenAddr() string {
+	u, err := url.Parse(l.callbackURI)
+	if err != nil || u.Port() == "" {
+		return ":8080"
+	}
+	return ":" + u.Port()
 }
 
 // createCodeVerifier will create a random base64 encoded verifier.
@@ -6,9 +6,11 @@ import (
 	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/oauth2"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -20,14 +22,14 @@ func TestMain(m *testing.M) {
 	var logger *logrus.Logger
 	logger, hook = logtest.NewNullLogger()
 	logger.ExitFunc = func(i int) {}
-	log = initLogger(logger)
+	log = logger.WithField("component", "login")
 
 	code := m.Run()
 	os.Exit(code)
 }
 
 func TestNewLogin(t *testing.T) {
-	login := NewLogin("url.123", "cID", "cSec")
+	login := NewLogin("url.123", "cID", "cSec", log)
 
 	assert.Equal(t, login.callbackURI, "url.123")
 }
@@ -69,8 +71,14 @@ func TestLogin_Login(t *testing.T) {
 	})
 }
 
+func TestLogin_listenAddr(t *testing.T) {
+	assert.Equal(t, ":8080", Login{callbackURI: "http://localhost:8080/callback"}.listenAddr())
+	assert.Equal(t, ":9090", Login{callbackURI: "http://localhost:9090/callback"}.listenAddr())
+	assert.Equal(t, ":8080", Login{callbackURI: "url.123"}.listenAddr())
+}
+
 func TestLogin_SaveToken(t *testing.T) {
-	login := NewLogin("url.123", "", "")
+	login := NewLogin("url.123", "", "", log)
 	tokenName, err := uuid.NewV4()
 	assert.NoError(t, err)
 
@@ -85,9 +93,36 @@ func TestLogin_SaveToken(t *testing.T) {
 		})
 		assert.NoError(t, err)
 
+		info, err := os.Stat(tokenName.String())
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
 		err = os.Remove(tokenName.String())
 		assert.NoError(t, err)
 	})
+
+	t.Run("OverwriteToken", func(t *testing.T) {
+		err = login.SaveToken(tokenName.String(), &oauth2.Token{AccessToken: "old"})
+		assert.NoError(t, err)
+		err = login.SaveToken(tokenName.String(), &oauth2.Token{AccessToken: "new"})
+		assert.NoError(t, err)
+
+		content, err := ioutil.ReadFile(tokenName.String())
+		assert.NoError(t, err)
+		assert.Contains(t, string(content), `"access_token":"new"`)
+
+		tmpFiles, err := filepath.Glob(tokenName.String() + ".*.tmp")
+		assert.NoError(t, err)
+		assert.Empty(t, tmpFiles)
+
+		err = os.Remove(tokenName.String())
+		assert.NoError(t, err)
+	})
+
+	t.Run("InvalidDirectory", func(t *testing.T) {
+		err = login.SaveToken(filepath.Join(tokenName.String(), "token.json"), &oauth2.Token{})
+		assert.Error(t, err)
+	})
 }
 
 func TestLogin_authHandler(t *testing.T) {
@@ -1,69 +1,66 @@
 package main
 
 import (
-	"flag"
+	"bufio"
+	"encoding/json"
 	"fmt"
-	nested "github.com/antonfisher/nested-logrus-formatter"
+	"github.com/elivlo/SpotifyHistorySaver/config"
+	"github.com/elivlo/SpotifyHistorySaver/export"
+	"github.com/elivlo/SpotifyHistorySaver/lastfm"
+	"github.com/elivlo/SpotifyHistorySaver/listenbrainz"
+	"github.com/elivlo/SpotifyHistorySaver/logging"
 	"github.com/elivlo/SpotifyHistorySaver/login"
 	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/elivlo/SpotifyHistorySaver/mqtt"
+	"github.com/elivlo/SpotifyHistorySaver/server"
 	"github.com/elivlo/SpotifyHistorySaver/spotifySaver"
-	"github.com/gobuffalo/envy"
-	"github.com/gobuffalo/packr/v2"
+	"github.com/elivlo/SpotifyHistorySaver/webhook"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
-)
-
-const (
-	// EnvClientID is the env variable name for spotify client id
-	EnvClientID = "CLIENT_ID"
-	// EnvClientSecret is the env variable name for spotify client secret
-	EnvClientSecret = "CLIENT_SECRET"
-	// GoEnv is the env variable that defines in which stage the app is running
-	// (development/production/test)
-	GoEnv = "GO_ENV"
-
-	// CallbackURI is the URL used to log in to the spotify account
-	CallbackURI = "http://localhost:8080/callback"
+	"text/tabwriter"
+	"time"
 )
 
 var (
-	log          *logrus.Entry
-	env          string
-	clientID     string
-	clientSecret string
-	createDb     = flag.Bool("create_db", false, "create_db: will create the database")
-	migrate      = flag.Bool("migrate", false, "migrate: will migrate the current schema into db")
-	loginFlag    = flag.Bool("login", false, "login: will get you an OAuth2 token for further usage")
+	log *logrus.Entry
+	cfg = config.Default()
+
+	// lastfmLoginInterval is the time between checks if the user authorized the Last.fm login
+	lastfmLoginInterval = 3 * time.Second
+	// lastfmLoginTimeout is the time the user has to authorize the Last.fm login
+	lastfmLoginTimeout = 5 * time.Minute
 )
 
-// init logging
+// init logging with the default level and format until the configured ones are known
 func initLogger(logger *logrus.Logger) {
-	logger.SetLevel(logrus.InfoLevel)
-	logger.SetFormatter(&nested.Formatter{
-		FieldsOrder: []string{"component", "category"},
-		HideKeys:    true,
-	})
-	log = logger.WithField("component", "SpotifyPlaybackSaver")
+	_ = logging.Configure(logger, logging.DefaultLevel, logging.DefaultFormat)
+	log = logger.WithField(logging.FieldComponent, "main")
 	log.Info("Setup SpotifyPlaybackSaver...")
 }
 
-// load env variables
-func initEnvVariables() (string, string, error) {
-	cID, err := envy.MustGet(EnvClientID)
-	if err != nil || cID == "" {
-		return cID, "", fmt.Errorf("env key: %s not set", EnvClientID)
+// configureLogger sets the configured log level and format. It will throw an error if one of them is invalid.
+func configureLogger(c config.Log) error {
+	return logging.Configure(log.Logger, c.Level, c.Format)
+}
+
+// printConfig writes the effective config with masked secrets to out. Validation errors are only logged.
+func printConfig(out io.Writer) error {
+	err := cfg.Print(out)
+	if err != nil {
+		return fmt.Errorf("could not print config: %v", err)
 	}
-	cSec, err := envy.MustGet(EnvClientSecret)
-	if err != nil || cSec == "" {
-		return cID, cSec, fmt.Errorf("env key: %s not set", EnvClientSecret)
+	err = cfg.Validate()
+	if err != nil {
+		log.Warn(err)
 	}
-	env = envy.Get(GoEnv, "development")
-
-	return cID, cSec, nil
+	return nil
 }
 
 func createDB(c *pop.Connection) error {
@@ -75,9 +72,9 @@ func createDB(c *pop.Connection) error {
 }
 
 func migrateDB(c *pop.Connection) error {
-	box, err := pop.NewMigrationBox(packr.New("migrations", "./migrations"), c)
+	box, err := models.Migrations(c)
 	if err != nil {
-		return fmt.Errorf("could not load migrations: %s", err)
+		return err
 	}
 	err = box.Up()
 	if err != nil {
@@ -86,6 +83,60 @@ func migrateDB(c *pop.Connection) error {
 	return nil
 }
 
+// rollbackDB reverts the last n applied migrations. Unless yes is set, migrations whose rollback deletes saved plays
+// are listed on out and have to be confirmed on in.
+func rollbackDB(c *pop.Connection, n int, yes bool, in io.Reader, out io.Writer) error {
+	if !yes {
+		destructive, err := models.DestructiveRollbacks(c, n)
+		if err != nil {
+			return err
+		}
+		if len(destructive) > 0 && !confirmRollback(destructive, in, out) {
+			return fmt.Errorf("rollback aborted, nothing was reverted")
+		}
+	}
+
+	box, err := models.Migrations(c)
+	if err != nil {
+		return err
+	}
+	err = box.Down(n)
+	if err != nil {
+		return fmt.Errorf("could not rollback: %s", err)
+	}
+	return nil
+}
+
+// confirmRollback warns on out about the data the rollback of destructive deletes and asks for confirmation on in.
+func confirmRollback(destructive []models.DestructiveMigration, in io.Reader, out io.Writer) bool {
+	_, _ = fmt.Fprintln(out, "WARNING: The rollback deletes saved data that migrating again does not restore:")
+	for _, m := range destructive {
+		_, _ = fmt.Fprintf(out, "  %s %s %s\n", m.Version, m.Name, m.Warning)
+	}
+	_, _ = fmt.Fprint(out, "Continue? [y/N] ")
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// printMigrationStatus writes all migrations and whether they are applied to out.
+func printMigrationStatus(c *pop.Connection, out io.Writer) error {
+	statuses, err := models.MigrationStatuses(c)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "Version\tName\tStatus")
+	for _, s := range statuses {
+		state := "pending"
+		if s.Applied {
+			state = "applied"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", s.Version, s.Name, state)
+	}
+	return w.Flush()
+}
+
 func loginAccount(auth login.Auth) error {
 	log.Info("Start login to your account...")
 	token := auth.Login()
@@ -94,52 +145,317 @@ func loginAccount(auth login.Auth) error {
 		return fmt.Errorf("could not get valid token: %v", token)
 	}
 
-	err := auth.SaveToken(login.TokenFileName, token)
+	err := auth.SaveToken(cfg.Spotify.TokenFile, token)
 	if err != nil {
 		return fmt.Errorf("could not save token to file: %v", err)
 	}
 	return nil
 }
 
-func startSubCommands(db *pop.Connection, auth login.Auth) (bool, error) {
-	flag.Parse()
+// newLastFMClient creates a Last.fm client with the API account of the config.
+func newLastFMClient() *lastfm.Client {
+	return lastfm.NewClient(cfg.LastFM.APIKey, cfg.LastFM.APISecret)
+}
+
+// loginLastFM waits until the user authorized the app on Last.fm and saves the session to the session file.
+func loginLastFM(client *lastfm.Client) error {
+	if cfg.LastFM.APIKey == "" || cfg.LastFM.APISecret == "" {
+		return fmt.Errorf("lastfm.api_key and lastfm.api_secret are required to log in (env %s, %s)",
+			config.EnvLastFMAPIKey, config.EnvLastFMAPISecret)
+	}
+	log.Info("Start login to your Last.fm account...")
+	session, err := client.Login(func(authURL string) {
+		log.Info("Please allow access to your Last.fm account by visiting the following page in your browser: ", authURL)
+	}, lastfmLoginInterval, lastfmLoginTimeout)
+	if err != nil {
+		return fmt.Errorf("could not get Last.fm session: %v", err)
+	}
+
+	err = lastfm.SaveSession(cfg.LastFM.SessionFile, session)
+	if err != nil {
+		return fmt.Errorf("could not save Last.fm session to file: %v", err)
+	}
+	log.Infof("Logged in to Last.fm as %s, wrote session to %s", session.Name, cfg.LastFM.SessionFile)
+	return nil
+}
+
+// newListenBrainzClient creates a ListenBrainz client with the token of the config.
+func newListenBrainzClient() *listenbrainz.Client {
+	return listenbrainz.NewClient(cfg.ListenBrainz.Token, listenbrainz.WithAPIURL(cfg.ListenBrainz.APIURL))
+}
 
-	if *createDb {
-		return false, createDB(db)
+// backfillListenBrainz submits all plays that were not submitted yet to ListenBrainz.
+func backfillListenBrainz(s spotifySaver.InterfaceSpotifySaver, client *listenbrainz.Client) error {
+	if cfg.ListenBrainz.Token == "" {
+		return fmt.Errorf("listenbrainz.token is required to backfill (env %s)", config.EnvListenBrainzToken)
 	}
+	user, err := client.ValidateToken()
+	if err != nil {
+		return fmt.Errorf("could not validate ListenBrainz token: %v", err)
+	}
+	log.Infof("Backfill listens of ListenBrainz user %s...", user)
 
-	if *migrate {
-		return false, migrateDB(db)
+	s.SetListenBrainz(client)
+	submitted, err := s.BackfillListenBrainz()
+	log.WithField(logging.FieldCount, submitted).Info("Submitted listens to ListenBrainz")
+	if err != nil {
+		return fmt.Errorf("could not backfill listens, run it again to resume: %v", err)
 	}
+	return nil
+}
 
-	if *loginFlag {
-		return false, loginAccount(auth)
+// webhookTargets returns the webhook targets of the config.
+func webhookTargets() []webhook.Target {
+	targets := make([]webhook.Target, len(cfg.Webhooks.Targets))
+	for i, t := range cfg.Webhooks.Targets {
+		targets[i] = webhook.Target{Name: t.Name, URL: t.URL, Secret: t.Secret}
 	}
+	return targets
+}
 
-	return true, nil
+// testWebhooks posts a test payload with a sample play to all targets and writes the result of each to out.
+// The deliveries are not saved to the outbox, so they are not retried.
+func testWebhooks(client *webhook.Client, targets []webhook.Target, out io.Writer) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("no webhook targets configured")
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	body, err := json.Marshal(webhook.Payload{
+		Event:     webhook.EventTest,
+		CreatedAt: now,
+		Plays: []webhook.Play{{
+			PlayedAt: now,
+			Track: webhook.Track{
+				ID:         "4uLU6hMCjMI75M1A2tKUQC",
+				Name:       "Never Gonna Give You Up",
+				URL:        "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC",
+				DurationMs: 213573,
+				Album:      &webhook.Album{ID: "6XhjNHCyCDyyGJRM5mg40G", Name: "Whenever You Need Somebody"},
+				Artists:    []webhook.Artist{{ID: "0gxyHStUsqpMadRV0Di1Qt", Name: "Rick Astley"}},
+			},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode test payload: %v", err)
+	}
+
+	failed := 0
+	for i, target := range targets {
+		err = client.Deliver(target, webhook.EventTest, "test-"+strconv.Itoa(i+1), body)
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "%s: failed: %v\n", target.Name, err)
+			continue
+		}
+		fmt.Fprintf(out, "%s: ok\n", target.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d webhook targets failed", failed, len(targets))
+	}
+	return nil
+}
+
+// connectMQTT connects to the MQTT broker of the config and publishes the Home Assistant discovery payloads
+// if a discovery prefix is set. Close the publisher after use.
+func connectMQTT() (*mqtt.Publisher, error) {
+	publisher, err := mqtt.Connect(mqtt.Options{
+		Broker:   cfg.MQTT.Broker,
+		ClientID: cfg.MQTT.ClientID,
+		Username: cfg.MQTT.Username,
+		Password: cfg.MQTT.Password,
+		QoS:      byte(cfg.MQTT.QoS),
+		Topics: mqtt.Topics{
+			Plays:        cfg.MQTT.Topics.Plays,
+			LastPlayed:   cfg.MQTT.Topics.LastPlayed,
+			NowPlaying:   cfg.MQTT.Topics.NowPlaying,
+			Availability: cfg.MQTT.Topics.Availability,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to MQTT broker %s: %v", cfg.MQTT.Broker, err)
+	}
+	if cfg.MQTT.DiscoveryPrefix != "" {
+		err = publisher.PublishDiscovery(cfg.MQTT.DiscoveryPrefix, cfg.Polling.Playback)
+		if err != nil {
+			publisher.Close()
+			return nil, fmt.Errorf("could not publish Home Assistant discovery: %v", err)
+		}
+	}
+	return publisher, nil
+}
+
+func importHistory(s spotifySaver.InterfaceSpotifySaver, file string) error {
+	log.Infof("Import extended streaming history from %s...", file)
+
+	err := s.LoadToken(cfg.Spotify.TokenFile)
+	if err != nil {
+		return fmt.Errorf("could not load token: %v", err)
+	}
+	s.Authenticate(cfg.Spotify.CallbackURI, cfg.Spotify.ClientID, cfg.Spotify.ClientSecret)
+
+	err = s.ImportExtendedHistory(file)
+	if err != nil {
+		return fmt.Errorf("could not import history: %v", err)
+	}
+	return nil
 }
 
-func startApp(s spotifySaver.InterfaceSpotifySaver) error {
+// importScrobbles imports a Last.fm or ListenBrainz export, which needs no Spotify token.
+func importScrobbles(s spotifySaver.InterfaceSpotifySaver, source, file string) error {
+	log.Infof("Import %s scrobbles from %s...", source, file)
+
+	err := s.ImportScrobbles(source, file)
+	if err != nil {
+		return fmt.Errorf("could not import scrobbles: %v", err)
+	}
+	return nil
+}
+
+// exportHistory writes all plays in period as format to output.
+// Without output the file is written to the export directory, "-" writes to stdout.
+// Files are written to a temporary file first, so they are never left incomplete.
+func exportHistory(db *pop.Connection, format string, period models.Period, output string, stdout io.Writer) error {
+	if output == "-" {
+		w, err := export.NewWriter(format, stdout)
+		if err != nil {
+			return err
+		}
+		_, err = export.Plays(db, period, w)
+		return err
+	}
+
+	if output == "" {
+		output = filepath.Join(cfg.Export.Directory, export.FileName(format))
+	}
+	err := os.MkdirAll(filepath.Dir(output), 0755)
+	if err != nil {
+		return fmt.Errorf("could not create export directory: %v", err)
+	}
+	tmp := output + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("could not create export file: %v", err)
+	}
+	defer os.Remove(tmp)
+
+	w, err := export.NewWriter(format, f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	count, err := export.Plays(db, period, w)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not export history: %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("could not write export file: %v", err)
+	}
+	err = os.Rename(tmp, output)
+	if err != nil {
+		return fmt.Errorf("could not write export file: %v", err)
+	}
+	log.WithField(logging.FieldCount, count).Infof("Exported %d plays to %s", count, output)
+	return nil
+}
+
+// exportParquet writes the plays table and the dimension files as Parquet to dir.
+// Without dir they are written to "parquet" in the export directory.
+func exportParquet(db *pop.Connection, dir string, full bool) error {
+	if dir == "" {
+		dir = filepath.Join(cfg.Export.Directory, export.FormatParquet)
+	}
+	result, err := export.Parquet(db, dir, full)
+	if err != nil {
+		return fmt.Errorf("could not export history: %v", err)
+	}
+	log.WithField(logging.FieldCount, result.Plays).Infof("Exported %d plays in %d partitions, %d tracks, %d albums and %d artists to %s",
+		result.Plays, len(result.Partitions), result.Tracks, result.Albums, result.Artists, dir)
+	return nil
+}
+
+// newServer creates the HTTP API server reporting the health of s.
+func newServer(db *pop.Connection, s server.Worker) *server.Server {
+	srv := server.NewServer(db, cfg.Server.Address, cfg.Server.APIKey, log)
+	srv.SetWorker(s, server.HealthConfig{
+		MaxPollAge:     cfg.Server.Health.MaxPollAge,
+		MaxFailedPolls: cfg.Server.Health.MaxFailedPolls,
+	})
+	return srv
+}
+
+// startApp starts all workers and the HTTP API server if srv is not nil. It blocks until all of them are stopped.
+func startApp(s spotifySaver.InterfaceSpotifySaver, srv *server.Server) error {
 	log.Info("Start listening to your spotify history...")
 	var wg sync.WaitGroup
 
-	err := s.LoadToken(spotifySaver.TokenFileName)
+	err := s.LoadToken(cfg.Spotify.TokenFile)
 	if err != nil {
 		return fmt.Errorf("could not load token: %v", err)
 	}
-	s.Authenticate(CallbackURI, clientID, clientSecret)
+	s.Authenticate(cfg.Spotify.CallbackURI, cfg.Spotify.ClientID, cfg.Spotify.ClientSecret)
+	s.SetIntervals(spotifySaver.Intervals{
+		History:    cfg.Polling.HistoryInterval,
+		Playback:   cfg.Polling.PlaybackInterval,
+		Enrichment: cfg.Polling.EnrichmentInterval,
+	})
 
-	stop := make(chan bool, 1)
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		for range c {
-			stop <- true
+	if cfg.LastFM.Enabled {
+		session, err := lastfm.LoadSession(cfg.LastFM.SessionFile)
+		if err != nil {
+			return fmt.Errorf("could not load Last.fm session, run login lastfm first: %v", err)
 		}
-	}()
+		log.Infof("Scrobble new plays to Last.fm as %s...", session.Name)
+		s.SetLastFM(newLastFMClient(), session)
+	}
+	if cfg.ListenBrainz.Enabled {
+		log.Info("Submit new plays to ListenBrainz...")
+		s.SetListenBrainz(newListenBrainzClient())
+	}
+	webhooks := len(cfg.Webhooks.Targets) > 0
+	if webhooks {
+		log.Infof("Post new plays to %d webhook targets...", len(cfg.Webhooks.Targets))
+		s.SetWebhooks(webhook.NewClient(), webhookTargets(), cfg.Webhooks.MaxAttempts)
+	}
+	if cfg.MQTT.Enabled {
+		publisher, err := connectMQTT()
+		if err != nil {
+			return err
+		}
+		defer publisher.Close()
+		log.Infof("Publish new plays to MQTT broker %s...", cfg.MQTT.Broker)
+		s.SetMQTT(publisher)
+	}
 
-	wg.Add(1)
+	if srv != nil {
+		err = srv.Listen()
+		if err != nil {
+			return err
+		}
+	}
+
+	stop := stopOnInterrupt()
+
+	wg.Add(2)
 	go s.StartLastSongsWorker(&wg, stop)
+	go s.StartEnrichmentWorker(&wg, stop)
+
+	if cfg.Polling.Playback {
+		log.Info("Start tracking your currently playing songs...")
+		wg.Add(1)
+		go s.StartPlaybackWorker(&wg, stop)
+	}
+
+	if webhooks {
+		wg.Add(1)
+		go s.StartWebhookWorker(&wg, stop)
+	}
+
+	if srv != nil {
+		wg.Add(1)
+		go srv.StartServer(&wg, stop)
+	}
 
 	wg.Wait()
 	log.Info("Shutting down...")
@@ -147,32 +463,41 @@ func startApp(s spotifySaver.InterfaceSpotifySaver) error {
 	return nil
 }
 
+// stopOnInterrupt returns a channel that is closed when the app is interrupted.
+func stopOnInterrupt() chan bool {
+	stop := make(chan bool, 1)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		close(stop)
+	}()
+	return stop
+}
+
 func init() {
 	initLogger(logrus.New())
 }
 
-func main() {
-	var err error
-
-	clientID, clientSecret, err = initEnvVariables()
+// setupApp validates the config, configures the logger and connects to the database.
+func setupApp() error {
+	err := cfg.Validate()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	ready, err := startSubCommands(models.DB, login.NewLogin(CallbackURI, clientID, clientSecret))
+	err = configureLogger(cfg.Log)
 	if err != nil {
-		log.Error(err)
+		return err
 	}
+	models.DB, err = cfg.Database.Connect()
+	return err
+}
 
-	if !ready {
+func main() {
+	err := newCLI().execute(filepath.Base(os.Args[0]), os.Args[1:], os.Stdout)
+	if isHelp(err) {
 		return
 	}
-
-	s, err := spotifySaver.NewSpotifySaver(log, env)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = startApp(s)
 	if err != nil {
 		log.Fatal(err)
 	}
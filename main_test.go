@@ -1,16 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/config"
+	"github.com/elivlo/SpotifyHistorySaver/export"
+	"github.com/elivlo/SpotifyHistorySaver/lastfm"
+	"github.com/elivlo/SpotifyHistorySaver/listenbrainz"
 	"github.com/elivlo/SpotifyHistorySaver/login"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/elivlo/SpotifyHistorySaver/mqtt"
+	"github.com/elivlo/SpotifyHistorySaver/server"
 	"github.com/elivlo/SpotifyHistorySaver/spotifySaver"
+	"github.com/elivlo/SpotifyHistorySaver/webhook"
 	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/nulls"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/sirupsen/logrus"
 	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 var hook *logtest.Hook
@@ -23,7 +40,7 @@ func TestMain(m *testing.M) {
 	)
 	logger, hook = logtest.NewNullLogger()
 	initLogger(logger)
-	envy.Set(GoEnv, "test")
+	envy.Set(config.GoEnv, "test")
 	DB, err = pop.Connect("test")
 	if err != nil {
 		fmt.Println("Could not connect to test database")
@@ -40,25 +57,107 @@ func TestInitLogger(t *testing.T) {
 	hook.Reset()
 }
 
-func TestInitEnvVariables(t *testing.T) {
-	envy.Set(EnvClientID, "")
-	envy.Set(EnvClientSecret, "")
+func TestConfigureLogger(t *testing.T) {
+	defer func() {
+		_ = configureLogger(config.Default().Log)
+	}()
 
-	id, sec, err := initEnvVariables()
-	assert.Equal(t, "", id)
-	assert.Equal(t, "", sec)
-	assert.Equal(t, fmt.Sprintf("env key: %s not set", EnvClientID), err.Error())
+	assert.NoError(t, configureLogger(config.Log{Level: "debug", Format: "json"}))
+	assert.Equal(t, logrus.DebugLevel, log.Logger.GetLevel())
+	assert.IsType(t, &logrus.JSONFormatter{}, log.Logger.Formatter)
 
-	envy.Set(EnvClientID, "client_id123")
-	id, sec, err = initEnvVariables()
-	assert.Equal(t, "client_id123", id)
-	assert.Equal(t, "", sec)
-	assert.Equal(t, fmt.Sprintf("env key: %s not set", EnvClientSecret), err.Error())
+	assert.EqualError(t, configureLogger(config.Log{Level: "loud"}), "invalid log level: loud")
+	assert.EqualError(t, configureLogger(config.Log{Format: "xml"}), "invalid log format: xml")
+}
+
+func TestSetupApp(t *testing.T) {
+	defer func() {
+		cfg = config.Default()
+		models.DB = nil
+	}()
+	cfg.Spotify.ClientID = "client_id"
+	cfg.Spotify.ClientSecret = "client_secret"
+
+	cfg.Database.Env = "staging"
+	assert.EqualError(t, setupApp(), "could not find connection staging in database.yml")
+	assert.Nil(t, models.DB)
+
+	cfg.Database.Env = "test"
+	assert.NoError(t, setupApp())
+	assert.NoError(t, models.DB.RawQuery("SELECT 1").Exec())
+}
+
+func TestNewCLI_config(t *testing.T) {
+	envy.Set(config.EnvClientID, "client_id123")
+	envy.Set(config.EnvLogLevel, "warn")
+	defer envy.Set(config.EnvClientID, "")
+	defer envy.Set(config.EnvLogLevel, "")
+	defer func() {
+		cfg = config.Default()
+	}()
+
+	var out bytes.Buffer
+	err := newCLI().execute("SpotifyPlaybackSaver", []string{"config", "print"}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "client_id123", cfg.Spotify.ClientID)
+	assert.Equal(t, "warn", cfg.Log.Level)
+
+	err = newCLI().execute("SpotifyPlaybackSaver", []string{"-log_level", "debug", "config", "print"}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "debug", cfg.Log.Level)
+
+	err = newCLI().execute("SpotifyPlaybackSaver", []string{"-config", "not_existing.yml", "config", "print"}, &out)
+	assert.Contains(t, err.Error(), "could not read config file:")
+
+	err = newCLI().execute("SpotifyPlaybackSaver", []string{"config"}, &out)
+	assert.EqualError(t, err, "missing command, see SpotifyPlaybackSaver config -h")
+}
+
+func TestNewCLI_unexpectedArguments(t *testing.T) {
+	defer func() {
+		cfg = config.Default()
+	}()
+
+	var out bytes.Buffer
+	for _, args := range [][]string{{"run", "migrate"}, {"serve", "now"}, {"login", "spotify"}, {"db", "migrate", "up"}, {"config", "print", "all"}} {
+		err := newCLI().execute("SpotifyPlaybackSaver", args, &out)
+		assert.EqualError(t, err, fmt.Sprintf("unexpected argument %q, see SpotifyPlaybackSaver %s -h",
+			args[len(args)-1], strings.Join(args[:len(args)-1], " ")), args)
+	}
+}
+
+func TestNewCLI_deprecatedFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"run"}},
+		{[]string{"-create_db"}, []string{"db", "create"}},
+		{[]string{"-migrate"}, []string{"db", "migrate"}},
+		{[]string{"-login"}, []string{"login"}},
+		{[]string{"-import", "endsong_0.json"}, []string{"import", "endsong_0.json"}},
+		{[]string{"-playback", "-serve"}, []string{"run", "-playback=true", "-serve=true"}},
+	}
+	for _, test := range tests {
+		root := newCLI()
+		fs := flag.NewFlagSet("SpotifyPlaybackSaver", flag.ContinueOnError)
+		root.flags(fs)
+		assert.NoError(t, fs.Parse(test.args))
+		assert.Equal(t, test.want, root.defaultArgs(fs), test.args)
+	}
+}
 
-	envy.Set(EnvClientSecret, "client_secret123")
-	id, sec, err = initEnvVariables()
-	assert.Equal(t, "client_id123", id)
-	assert.Equal(t, "client_secret123", sec)
+func TestPrintConfig(t *testing.T) {
+	cfg.Server.APIKey = "secret_key"
+	defer func() {
+		cfg = config.Default()
+	}()
+
+	var out bytes.Buffer
+	err := printConfig(&out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "api_key: '********'")
+	assert.NotContains(t, out.String(), "secret_key")
 }
 
 func TestCreateDB(t *testing.T) {
@@ -73,6 +172,50 @@ func TestMigrateDB(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRollbackDB(t *testing.T) {
+	var out bytes.Buffer
+	err := printMigrationStatus(DB, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "20210320140422   create_add_history_tables")
+	assert.NotContains(t, out.String(), "pending")
+	migrations := strings.Count(out.String(), "applied")
+
+	// The latest migration deletes no plays, so it is reverted without asking
+	var prompt bytes.Buffer
+	err = rollbackDB(DB, 1, false, strings.NewReader(""), &prompt)
+	assert.NoError(t, err)
+	assert.Empty(t, prompt.String())
+	out.Reset()
+	assert.NoError(t, printMigrationStatus(DB, &out))
+	assert.Equal(t, 1, strings.Count(out.String(), "pending"))
+
+	err = rollbackDB(DB, migrations, false, strings.NewReader("n\n"), &prompt)
+	assert.EqualError(t, err, "rollback aborted, nothing was reverted")
+	assert.Contains(t, prompt.String(), "create_shows_and_episodes deletes all plays and playback sessions of podcast episodes")
+	assert.Contains(t, prompt.String(), "Continue? [y/N]")
+	out.Reset()
+	assert.NoError(t, printMigrationStatus(DB, &out))
+	assert.Equal(t, 1, strings.Count(out.String(), "pending"))
+
+	err = rollbackDB(DB, 1, true, strings.NewReader(""), &prompt)
+	assert.NoError(t, err)
+	out.Reset()
+	assert.NoError(t, printMigrationStatus(DB, &out))
+	assert.Equal(t, 2, strings.Count(out.String(), "pending"))
+
+	err = rollbackDB(DB, migrations, false, strings.NewReader("y\n"), &prompt)
+	assert.NoError(t, err)
+	out.Reset()
+	assert.NoError(t, printMigrationStatus(DB, &out))
+	assert.Equal(t, migrations, strings.Count(out.String(), "pending"))
+
+	err = migrateDB(DB)
+	assert.NoError(t, err)
+	out.Reset()
+	assert.NoError(t, printMigrationStatus(DB, &out))
+	assert.Equal(t, migrations, strings.Count(out.String(), "applied"))
+}
+
 func TestLogin(t *testing.T) {
 	mock := login.MockedAuth{
 		SError: false,
@@ -90,42 +233,301 @@ func TestLogin(t *testing.T) {
 	assert.Contains(t, err.Error(), "could not get valid token:")
 }
 
+func TestLoginLastFM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lastfm")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func() {
+		cfg = config.Default()
+	}()
+	interval, timeout := lastfmLoginInterval, lastfmLoginTimeout
+	defer func() {
+		lastfmLoginInterval, lastfmLoginTimeout = interval, timeout
+	}()
+	lastfmLoginInterval = 10 * time.Millisecond
+	lastfmLoginTimeout = 50 * time.Millisecond
+
+	standIn := lastfm.NewStandIn("key", "secret")
+	defer standIn.Close()
+
+	err = loginLastFM(standIn.Client())
+	assert.Contains(t, err.Error(), "lastfm.api_key and lastfm.api_secret are required")
+
+	cfg.LastFM.APIKey = "key"
+	cfg.LastFM.APISecret = "secret"
+	cfg.LastFM.SessionFile = filepath.Join(dir, "lastfm_session.json")
+	err = loginLastFM(standIn.Client())
+	assert.Contains(t, err.Error(), "token was not authorized in time")
+
+	standIn.Authorize("token2")
+	err = loginLastFM(standIn.Client())
+	assert.NoError(t, err)
+	session, err := lastfm.LoadSession(cfg.LastFM.SessionFile)
+	assert.NoError(t, err)
+	assert.Equal(t, lastfm.Session{Name: "user", Key: "sk_token2"}, session)
+
+	standIn.Authorize("token3")
+	cfg.LastFM.SessionFile = filepath.Join(dir, "missing", "lastfm_session.json")
+	err = loginLastFM(standIn.Client())
+	assert.Contains(t, err.Error(), "could not save Last.fm session to file:")
+}
+
+func TestBackfillListenBrainz(t *testing.T) {
+	defer func() {
+		cfg = config.Default()
+	}()
+	standIn := listenbrainz.NewStandIn("token")
+	defer standIn.Close()
+	mock := spotifySaver.MockedSpotifySaver{}
+
+	err := backfillListenBrainz(&mock, standIn.Client("token"))
+	assert.Contains(t, err.Error(), "listenbrainz.token is required to backfill")
+
+	cfg.ListenBrainz.Token = "token"
+	err = backfillListenBrainz(&mock, standIn.Client("token"))
+	assert.NoError(t, err)
+
+	err = backfillListenBrainz(&mock, standIn.Client("wrong_token"))
+	assert.Contains(t, err.Error(), "could not validate ListenBrainz token:")
+
+	mock.IError = true
+	err = backfillListenBrainz(&mock, standIn.Client("token"))
+	assert.Contains(t, err.Error(), "could not backfill listens, run it again to resume:")
+}
+
 func TestStartApp(t *testing.T) {
 	mock := spotifySaver.MockedSpotifySaver{LError: false}
 
-	err := startApp(&mock)
+	err := startApp(&mock, nil)
 	assert.NoError(t, err)
 
+	cfg.Polling.Playback = true
+	err = startApp(&mock, nil)
+	assert.NoError(t, err)
+	cfg.Polling.Playback = false
+
+	err = startApp(&mock, server.NewServer(DB, "localhost:0", "key", log))
+	assert.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	err = startApp(&mock, server.NewServer(DB, listener.Addr().String(), "key", log))
+	assert.Contains(t, err.Error(), "could not listen on "+listener.Addr().String()+":")
+	_ = listener.Close()
+
+	cfg.LastFM.Enabled = true
+	cfg.LastFM.SessionFile = "missing_lastfm_session.json"
+	err = startApp(&mock, nil)
+	assert.Contains(t, err.Error(), "could not load Last.fm session, run login lastfm first:")
+	cfg = config.Default()
+
+	cfg.ListenBrainz.Enabled = true
+	err = startApp(&mock, nil)
+	assert.NoError(t, err)
+	cfg = config.Default()
+
+	cfg.Webhooks.Targets = []config.WebhookTarget{{Name: "home", URL: "https://example.com"}}
+	err = startApp(&mock, nil)
+	assert.NoError(t, err)
+	cfg = config.Default()
+
+	standIn := mqtt.NewStandIn("", "")
+	defer standIn.Close()
+	cfg.MQTT.Enabled = true
+	cfg.MQTT.Broker = standIn.Broker()
+	err = startApp(&mock, nil)
+	assert.NoError(t, err)
+	availability, _ := standIn.Retained(cfg.MQTT.Topics.Availability)
+	assert.Equal(t, mqtt.Offline, string(availability.Payload))
+
+	cfg.MQTT.Broker = "tcp://127.0.0.1:1"
+	err = startApp(&mock, nil)
+	assert.Contains(t, err.Error(), "could not connect to MQTT broker tcp://127.0.0.1:1:")
+	cfg = config.Default()
+
 	mock.LError = true
-	err = startApp(&mock)
+	err = startApp(&mock, nil)
 	assert.Contains(t, err.Error(), "could not load token:")
 }
 
-func TestStartSubCommands(t *testing.T) {
-	mock := login.MockedAuth{
-		SError: false,
-	}
+func TestNewServer(t *testing.T) {
+	cfg.Server.Health.MaxFailedPolls = 5
+	defer func() {
+		cfg = config.Default()
+	}()
 
-	err := pop.DropDB(DB)
+	srv := newServer(DB, &spotifySaver.MockedSpotifySaver{})
+	assert.NotNil(t, srv)
+}
+
+func TestWebhookTargets(t *testing.T) {
+	cfg.Webhooks.Targets = []config.WebhookTarget{{Name: "home", URL: "https://example.com", Secret: "secret"}}
+	defer func() {
+		cfg = config.Default()
+	}()
+
+	assert.Equal(t, []webhook.Target{{Name: "home", URL: "https://example.com", Secret: "secret"}}, webhookTargets())
+}
+
+func TestTestWebhooks(t *testing.T) {
+	standIn := webhook.NewStandIn("secret")
+	defer standIn.Close()
+	client := webhook.NewClient()
+	var out bytes.Buffer
+
+	err := testWebhooks(client, nil, &out)
+	assert.EqualError(t, err, "no webhook targets configured")
+
+	err = testWebhooks(client, []webhook.Target{standIn.Target("home", "secret"), standIn.Target("chat", "")}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "home: ok\nchat: ok\n", out.String())
+	requests := standIn.Requests()
+	assert.Equal(t, 2, len(requests))
+	assert.Equal(t, webhook.EventTest, requests[0].Event)
+	assert.True(t, requests[0].Signed)
+	assert.False(t, requests[1].Signed)
+	assert.Contains(t, string(requests[0].Body), "Never Gonna Give You Up")
+
+	out.Reset()
+	standIn.Fail(http.StatusUnauthorized)
+	err = testWebhooks(client, []webhook.Target{standIn.Target("home", "secret"), standIn.Target("chat", "")}, &out)
+	assert.EqualError(t, err, "2 of 2 webhook targets failed")
+	assert.Contains(t, out.String(), "home: failed: webhook target responded with status 401")
+}
+
+func TestConnectMQTT(t *testing.T) {
+	standIn := mqtt.NewStandIn("user", "password")
+	defer standIn.Close()
+	cfg.MQTT.Broker = standIn.Broker()
+	cfg.MQTT.Username = "user"
+	cfg.MQTT.Password = "password"
+	cfg.Polling.Playback = true
+	defer func() {
+		cfg = config.Default()
+	}()
+
+	publisher, err := connectMQTT()
+	assert.NoError(t, err)
+	publisher.Close()
+	_, ok := standIn.Retained("homeassistant/sensor/spotify_history_saver/last_played/config")
+	assert.True(t, ok)
+	_, ok = standIn.Retained("homeassistant/sensor/spotify_history_saver/now_playing/config")
+	assert.True(t, ok)
+
+	cfg.MQTT.Password = "wrong"
+	_, err = connectMQTT()
+	assert.Contains(t, err.Error(), "could not connect to MQTT broker")
+}
+
+func TestImportHistory(t *testing.T) {
+	mock := spotifySaver.MockedSpotifySaver{}
+
+	err := importHistory(&mock, "endsong_0.json")
+	assert.NoError(t, err)
+
+	mock.IError = true
+	err = importHistory(&mock, "endsong_0.json")
+	assert.Contains(t, err.Error(), "could not import history:")
+
+	mock.LError = true
+	err = importHistory(&mock, "endsong_0.json")
+	assert.Contains(t, err.Error(), "could not load token:")
+}
+
+func TestImportScrobbles(t *testing.T) {
+	mock := spotifySaver.MockedSpotifySaver{}
+
+	err := importScrobbles(&mock, models.SourceLastFM, "scrobbles.csv")
 	assert.NoError(t, err)
 
-	ready, err := startSubCommands(nil, nil)
+	// No token is needed
+	mock.LError = true
+	err = importScrobbles(&mock, models.SourceLastFM, "scrobbles.csv")
 	assert.NoError(t, err)
-	assert.True(t, ready)
 
-	*loginFlag = true
-	ready, err = startSubCommands(nil, mock)
+	mock.IError = true
+	err = importScrobbles(&mock, models.SourceListenBrainz, "listens.jsonl")
+	assert.Contains(t, err.Error(), "could not import scrobbles:")
+}
+
+func TestExportHistory(t *testing.T) {
+	cfg.Export.Directory = t.TempDir()
+	defer func() {
+		cfg = config.Default()
+	}()
+	_ = DB.TruncateAll()
+	assert.NoError(t, DB.Create(&models.Track{ID: "t_id", Name: "t_name"}))
+	assert.NoError(t, DB.Create(&models.HistoryEntry{TrackID: nulls.NewString("t_id"), PlayedAt: time.Now()}))
+
+	err := exportHistory(DB, export.FormatCSV, models.Period{}, "", nil)
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(filepath.Join(cfg.Export.Directory, "history.csv"))
 	assert.NoError(t, err)
-	assert.False(t, ready)
+	assert.Contains(t, string(content), "t_name")
+	_, err = os.Stat(filepath.Join(cfg.Export.Directory, "history.csv.tmp"))
+	assert.True(t, os.IsNotExist(err))
 
-	*createDb = true
-	ready, err = startSubCommands(DB, mock)
+	var out bytes.Buffer
+	err = exportHistory(DB, export.FormatJSONL, models.Period{}, "-", &out)
 	assert.NoError(t, err)
-	assert.False(t, ready)
+	assert.Contains(t, out.String(), `"track_name":"t_name"`)
+
+	err = exportHistory(DB, "xml", models.Period{}, filepath.Join(cfg.Export.Directory, "history.xml"), nil)
+	assert.EqualError(t, err, `unknown format "xml", expected csv, jsonl or endsong`)
+	_, err = os.Stat(filepath.Join(cfg.Export.Directory, "history.xml"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExportParquet(t *testing.T) {
+	cfg.Export.Directory = t.TempDir()
+	defer func() {
+		cfg = config.Default()
+	}()
+	_ = DB.TruncateAll()
+	assert.NoError(t, DB.Create(&models.Track{ID: "t_id", Name: "t_name"}))
+	playedAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, DB.Create(&models.HistoryEntry{TrackID: nulls.NewString("t_id"), PlayedAt: playedAt}))
 
-	*createDb = false
-	*migrate = true
-	ready, err = startSubCommands(DB, mock)
+	err := exportParquet(DB, "", false)
 	assert.NoError(t, err)
-	assert.False(t, ready)
+	for _, file := range []string{"plays/year=2021/month=03/plays.parquet", "tracks.parquet", "albums.parquet", "artists.parquet"} {
+		_, err = os.Stat(filepath.Join(cfg.Export.Directory, "parquet", file))
+		assert.NoError(t, err, file)
+	}
+
+	var out bytes.Buffer
+	err = newCLI().execute("SpotifyPlaybackSaver", []string{"export", "-format", "parquet", "-since", "2021-01-01"}, &out)
+	assert.Contains(t, err.Error(), "since, until and - are not supported")
+}
+
+func TestNewCLI_db(t *testing.T) {
+	envy.Set(config.EnvClientID, "client_id123")
+	envy.Set(config.EnvClientSecret, "client_secret123")
+	defer envy.Set(config.EnvClientID, "")
+	defer envy.Set(config.EnvClientSecret, "")
+	defer func() {
+		cfg = config.Default()
+	}()
+
+	err := pop.DropDB(DB)
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	err = newCLI().execute("SpotifyPlaybackSaver", []string{"db", "create"}, &out)
+	assert.NoError(t, err)
+
+	err = newCLI().execute("SpotifyPlaybackSaver", []string{"-migrate"}, &out)
+	assert.NoError(t, err)
+
+	err = newCLI().execute("SpotifyPlaybackSaver", []string{"db", "rollback", "0"}, &out)
+	assert.EqualError(t, err, `number of migrations must be positive: "0"`)
+
+	err = newCLI().execute("SpotifyPlaybackSaver", []string{"import"}, &out)
+	assert.EqualError(t, err, "expected one file to import, got 0")
+	err = newCLI().execute("SpotifyPlaybackSaver", []string{"import", "-source", "deezer", "history.csv"}, &out)
+	assert.EqualError(t, err, `unknown source "deezer", expected spotify, lastfm or listenbrainz`)
+
+	envy.Set(config.EnvClientSecret, "")
+	err = newCLI().execute("SpotifyPlaybackSaver", []string{"db", "migrate"}, &out)
+	assert.Contains(t, err.Error(), "spotify.client_secret is required")
 }
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zmb3/spotify/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Namespace is the prefix of all metric names
+	Namespace = "spotify_history"
+
+	// WorkerRecentlyPlayed labels metrics of the recently played history worker
+	WorkerRecentlyPlayed = "recently_played"
+	// WorkerPlayback labels metrics of the player state worker
+	WorkerPlayback = "playback"
+	// WorkerEnrichment labels metrics of the enrichment worker
+	WorkerEnrichment = "enrichment"
+	// WorkerWebhooks labels metrics of the webhook worker
+	WorkerWebhooks = "webhooks"
+
+	// ServiceLastFM labels metrics of scrobbling to Last.fm
+	ServiceLastFM = "lastfm"
+	// ServiceListenBrainz labels metrics of submitting listens to ListenBrainz
+	ServiceListenBrainz = "listenbrainz"
+)
+
+var (
+	// Registry holds all metrics of the app together with Go runtime and process metrics.
+	Registry = prometheus.NewRegistry()
+
+	factory = promauto.With(Registry)
+
+	// Polls counts the runs of a worker.
+	Polls = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "polls_total",
+		Help:      "Number of runs of a worker.",
+	}, []string{"worker"})
+	// PollDuration observes how long the runs of a worker take.
+	PollDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "poll_duration_seconds",
+		Help:      "Duration of the runs of a worker.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"worker"})
+	// FetchedItems counts the items fetched from Spotify by a worker.
+	FetchedItems = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "fetched_items_total",
+		Help:      "Number of items fetched from Spotify by a worker.",
+	}, []string{"worker"})
+	// Inserted counts the rows inserted into database by table.
+	Inserted = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "inserted_rows_total",
+		Help:      "Number of rows inserted into database by table.",
+	}, []string{"table"})
+	// APIErrors counts the failed Spotify API requests by HTTP status code. Errors without status are labeled "0".
+	APIErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "api_errors_total",
+		Help:      "Number of failed Spotify API requests by HTTP status code.",
+	}, []string{"status"})
+	// DBErrors counts the failed database operations.
+	DBErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "db_errors_total",
+		Help:      "Number of failed database operations.",
+	}, []string{"operation"})
+	// TokenRefreshes counts the refreshed OAuth tokens that were saved.
+	TokenRefreshes = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Number of refreshed OAuth tokens that were saved.",
+	})
+	// Scrobbles counts the plays forwarded to a scrobbling service by result.
+	Scrobbles = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "scrobbles_total",
+		Help:      "Number of plays forwarded to a scrobbling service by result.",
+	}, []string{"service", "status"})
+	// WebhookDeliveries counts the attempts to deliver a payload to a webhook target by result.
+	WebhookDeliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Number of attempts to deliver a payload to a webhook target by result.",
+	}, []string{"target", "status"})
+	// MQTTMessages counts the messages published to the MQTT broker by payload type and result.
+	MQTTMessages = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "mqtt_messages_total",
+		Help:      "Number of messages published to the MQTT broker by payload type and result.",
+	}, []string{"type", "status"})
+	// LatestPlay is the time of the latest stored play.
+	LatestPlay = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "latest_play_timestamp_seconds",
+		Help:      "Unix time of the latest stored play.",
+	})
+)
+
+var (
+	latestPlay   time.Time
+	latestPlayMu sync.Mutex
+)
+
+func init() {
+	Registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler returns the HTTP handler serving all metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObservePoll counts a run of worker that started at start.
+func ObservePoll(worker string, start time.Time) {
+	Polls.WithLabelValues(worker).Inc()
+	PollDuration.WithLabelValues(worker).Observe(time.Since(start).Seconds())
+}
+
+// ObserveAPIError counts a failed Spotify API request. It does nothing if err is nil.
+func ObserveAPIError(err error) {
+	if err == nil {
+		return
+	}
+	status := 0
+	if e, ok := err.(spotify.Error); ok {
+		status = e.Status
+	}
+	APIErrors.WithLabelValues(strconv.Itoa(status)).Inc()
+}
+
+// ObserveLatestPlay sets the time of the latest stored play if playedAt is later than the current one.
+func ObserveLatestPlay(playedAt time.Time) {
+	latestPlayMu.Lock()
+	defer latestPlayMu.Unlock()
+
+	if playedAt.After(latestPlay) {
+		latestPlay = playedAt
+		LatestPlay.Set(float64(playedAt.Unix()))
+	}
+}
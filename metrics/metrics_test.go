@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+	"testing"
+	"time"
+)
+
+func TestObservePoll(t *testing.T) {
+	before := testutil.ToFloat64(Polls.WithLabelValues(WorkerPlayback))
+	ObservePoll(WorkerPlayback, time.Now().Add(-time.Second))
+	assert.Equal(t, before+1, testutil.ToFloat64(Polls.WithLabelValues(WorkerPlayback)))
+	assert.Equal(t, 1, testutil.CollectAndCount(PollDuration, Namespace+"_poll_duration_seconds"))
+}
+
+func TestObserveAPIError(t *testing.T) {
+	ObserveAPIError(nil)
+	assert.Equal(t, 0, testutil.CollectAndCount(APIErrors))
+
+	ObserveAPIError(spotify.Error{Message: "Too many requests", Status: 429})
+	ObserveAPIError(spotify.Error{Message: "Too many requests", Status: 429})
+	ObserveAPIError(errors.New("connection refused"))
+	assert.Equal(t, float64(2), testutil.ToFloat64(APIErrors.WithLabelValues("429")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(APIErrors.WithLabelValues("0")))
+}
+
+func TestObserveLatestPlay(t *testing.T) {
+	now := time.Now()
+	ObserveLatestPlay(now)
+	ObserveLatestPlay(now.Add(-time.Hour))
+	assert.Equal(t, float64(now.Unix()), testutil.ToFloat64(LatestPlay))
+
+	ObserveLatestPlay(now.Add(time.Hour))
+	assert.Equal(t, float64(now.Add(time.Hour).Unix()), testutil.ToFloat64(LatestPlay))
+}
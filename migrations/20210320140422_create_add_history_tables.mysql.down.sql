@@ -0,0 +1,9 @@
+-- destructive: drops the whole history with all tracks and artists
+
+DROP TABLE `artists_tracks`;
+
+DROP TABLE `history_entries`;
+
+DROP TABLE `artists`;
+
+DROP TABLE `tracks`;
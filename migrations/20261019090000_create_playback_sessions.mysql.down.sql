@@ -0,0 +1,5 @@
+-- destructive: drops all playback sessions
+
+DROP TABLE `playback_sessions`;
+
+ALTER TABLE `tracks` DROP COLUMN `duration_ms`;
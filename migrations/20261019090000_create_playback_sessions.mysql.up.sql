@@ -0,0 +1,22 @@
+ALTER TABLE `tracks` ADD COLUMN `duration_ms` int NOT NULL DEFAULT 0;
+
+CREATE TABLE `playback_sessions` (
+  `id` int PRIMARY KEY AUTO_INCREMENT,
+  `track_id` varchar(255),
+  `history_entry_id` int,
+  `started_at` datetime,
+  `ended_at` datetime,
+  `progress_ms` int,
+  `duration_ms` int,
+  `device_id` varchar(255),
+  `device_name` varchar(255),
+  `device_type` varchar(255),
+  `shuffle` boolean,
+  `repeat_state` varchar(255),
+  `context_uri` varchar(255),
+  `skipped` boolean
+);
+
+ALTER TABLE `playback_sessions` ADD FOREIGN KEY (`track_id`) REFERENCES `tracks` (`id`);
+
+ALTER TABLE `playback_sessions` ADD FOREIGN KEY (`history_entry_id`) REFERENCES `history_entries` (`id`);
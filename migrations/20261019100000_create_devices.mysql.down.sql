@@ -0,0 +1,17 @@
+ALTER TABLE `history_entries` DROP FOREIGN KEY `history_entries_device_id_fk`;
+
+ALTER TABLE `history_entries` DROP COLUMN `ms_played`;
+
+ALTER TABLE `history_entries` DROP COLUMN `device_id`;
+
+ALTER TABLE `playback_sessions` DROP FOREIGN KEY `playback_sessions_device_id_fk`;
+
+ALTER TABLE `playback_sessions` ADD COLUMN `device_name` varchar(255);
+
+ALTER TABLE `playback_sessions` ADD COLUMN `device_type` varchar(255);
+
+UPDATE `playback_sessions` SET
+  `device_name` = (SELECT `name` FROM `devices` WHERE `devices`.`id` = `playback_sessions`.`device_id`),
+  `device_type` = (SELECT `type` FROM `devices` WHERE `devices`.`id` = `playback_sessions`.`device_id`);
+
+DROP TABLE `devices`;
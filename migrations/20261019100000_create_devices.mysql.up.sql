@@ -0,0 +1,24 @@
+CREATE TABLE `devices` (
+  `id` varchar(255) PRIMARY KEY,
+  `name` varchar(255),
+  `type` varchar(255)
+);
+
+INSERT INTO `devices` (`id`, `name`, `type`)
+SELECT `device_id`, MAX(`device_name`), MAX(`device_type`) FROM `playback_sessions`
+WHERE `device_id` IS NOT NULL AND `device_id` <> ''
+GROUP BY `device_id`;
+
+UPDATE `playback_sessions` SET `device_id` = NULL WHERE `device_id` = '';
+
+ALTER TABLE `playback_sessions` DROP COLUMN `device_name`;
+
+ALTER TABLE `playback_sessions` DROP COLUMN `device_type`;
+
+ALTER TABLE `playback_sessions` ADD CONSTRAINT `playback_sessions_device_id_fk` FOREIGN KEY (`device_id`) REFERENCES `devices` (`id`);
+
+ALTER TABLE `history_entries` ADD COLUMN `device_id` varchar(255);
+
+ALTER TABLE `history_entries` ADD COLUMN `ms_played` int;
+
+ALTER TABLE `history_entries` ADD CONSTRAINT `history_entries_device_id_fk` FOREIGN KEY (`device_id`) REFERENCES `devices` (`id`);
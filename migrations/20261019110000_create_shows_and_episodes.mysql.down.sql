@@ -0,0 +1,17 @@
+-- destructive: deletes all plays and playback sessions of podcast episodes
+
+DELETE FROM `playback_sessions` WHERE `episode_id` IS NOT NULL;
+
+ALTER TABLE `playback_sessions` DROP FOREIGN KEY `playback_sessions_episode_id_fk`;
+
+ALTER TABLE `playback_sessions` DROP COLUMN `episode_id`;
+
+DELETE FROM `history_entries` WHERE `episode_id` IS NOT NULL;
+
+ALTER TABLE `history_entries` DROP FOREIGN KEY `history_entries_episode_id_fk`;
+
+ALTER TABLE `history_entries` DROP COLUMN `episode_id`;
+
+DROP TABLE `episodes`;
+
+DROP TABLE `shows`;
@@ -0,0 +1,23 @@
+CREATE TABLE `shows` (
+  `id` varchar(255) PRIMARY KEY,
+  `name` varchar(255),
+  `publisher` varchar(255)
+);
+
+CREATE TABLE `episodes` (
+  `id` varchar(255) PRIMARY KEY,
+  `show_id` varchar(255),
+  `name` varchar(255),
+  `duration_ms` int NOT NULL DEFAULT 0,
+  `release_date` varchar(255)
+);
+
+ALTER TABLE `episodes` ADD CONSTRAINT `episodes_show_id_fk` FOREIGN KEY (`show_id`) REFERENCES `shows` (`id`);
+
+ALTER TABLE `history_entries` ADD COLUMN `episode_id` varchar(255);
+
+ALTER TABLE `history_entries` ADD CONSTRAINT `history_entries_episode_id_fk` FOREIGN KEY (`episode_id`) REFERENCES `episodes` (`id`);
+
+ALTER TABLE `playback_sessions` ADD COLUMN `episode_id` varchar(255);
+
+ALTER TABLE `playback_sessions` ADD CONSTRAINT `playback_sessions_episode_id_fk` FOREIGN KEY (`episode_id`) REFERENCES `episodes` (`id`);
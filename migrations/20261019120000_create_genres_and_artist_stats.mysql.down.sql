@@ -0,0 +1,7 @@
+DROP TABLE `artist_stats`;
+
+DROP TABLE `artists_genres`;
+
+DROP TABLE `genres`;
+
+ALTER TABLE `artists` DROP COLUMN `enriched_at`;
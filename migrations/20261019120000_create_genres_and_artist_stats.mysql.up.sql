@@ -0,0 +1,26 @@
+ALTER TABLE `artists` ADD COLUMN `enriched_at` datetime;
+
+CREATE TABLE `genres` (
+  `id` int PRIMARY KEY AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL UNIQUE
+);
+
+CREATE TABLE `artists_genres` (
+  `id` int PRIMARY KEY AUTO_INCREMENT,
+  `artist_id` varchar(255),
+  `genre_id` int
+);
+
+ALTER TABLE `artists_genres` ADD CONSTRAINT `artists_genres_artist_id_fk` FOREIGN KEY (`artist_id`) REFERENCES `artists` (`id`);
+
+ALTER TABLE `artists_genres` ADD CONSTRAINT `artists_genres_genre_id_fk` FOREIGN KEY (`genre_id`) REFERENCES `genres` (`id`);
+
+CREATE TABLE `artist_stats` (
+  `id` int PRIMARY KEY AUTO_INCREMENT,
+  `artist_id` varchar(255),
+  `popularity` int NOT NULL DEFAULT 0,
+  `followers` int NOT NULL DEFAULT 0,
+  `recorded_at` datetime
+);
+
+ALTER TABLE `artist_stats` ADD CONSTRAINT `artist_stats_artist_id_fk` FOREIGN KEY (`artist_id`) REFERENCES `artists` (`id`);
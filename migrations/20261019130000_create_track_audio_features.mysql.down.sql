@@ -0,0 +1,5 @@
+DROP TABLE `enrichment_states`;
+
+DROP TABLE `track_audio_features`;
+
+ALTER TABLE `tracks` DROP COLUMN `audio_features_checked_at`;
@@ -0,0 +1,27 @@
+ALTER TABLE `tracks` ADD COLUMN `audio_features_checked_at` datetime;
+
+CREATE TABLE `track_audio_features` (
+  `track_id` varchar(255) PRIMARY KEY,
+  `acousticness` float,
+  `danceability` float,
+  `energy` float,
+  `instrumentalness` float,
+  `pitch_class` int,
+  `liveness` float,
+  `loudness` float,
+  `mode` int,
+  `speechiness` float,
+  `tempo` float,
+  `time_signature` int,
+  `valence` float
+);
+
+ALTER TABLE `track_audio_features` ADD CONSTRAINT `track_audio_features_track_id_fk` FOREIGN KEY (`track_id`) REFERENCES `tracks` (`id`);
+
+CREATE TABLE `enrichment_states` (
+  `id` varchar(255) PRIMARY KEY,
+  `unavailable` boolean NOT NULL DEFAULT FALSE,
+  `status` int NOT NULL DEFAULT 0,
+  `message` varchar(255),
+  `checked_at` datetime
+);
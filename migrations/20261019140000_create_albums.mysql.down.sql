@@ -0,0 +1,7 @@
+ALTER TABLE `tracks` DROP FOREIGN KEY `tracks_album_id_fk`;
+
+ALTER TABLE `tracks` DROP COLUMN `enriched_at`;
+
+ALTER TABLE `tracks` DROP COLUMN `album_id`;
+
+DROP TABLE `albums`;
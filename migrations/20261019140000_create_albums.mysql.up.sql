@@ -0,0 +1,12 @@
+CREATE TABLE `albums` (
+  `id` varchar(255) PRIMARY KEY,
+  `name` varchar(255),
+  `album_type` varchar(255),
+  `release_date` varchar(255)
+);
+
+ALTER TABLE `tracks` ADD COLUMN `album_id` varchar(255);
+
+ALTER TABLE `tracks` ADD COLUMN `enriched_at` datetime;
+
+ALTER TABLE `tracks` ADD CONSTRAINT `tracks_album_id_fk` FOREIGN KEY (`album_id`) REFERENCES `albums` (`id`);
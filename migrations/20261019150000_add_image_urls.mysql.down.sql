@@ -0,0 +1,3 @@
+ALTER TABLE `artists` DROP COLUMN `image_url`;
+
+ALTER TABLE `albums` DROP COLUMN `image_url`;
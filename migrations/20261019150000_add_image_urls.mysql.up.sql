@@ -0,0 +1,3 @@
+ALTER TABLE `albums` ADD COLUMN `image_url` varchar(255);
+
+ALTER TABLE `artists` ADD COLUMN `image_url` varchar(255);
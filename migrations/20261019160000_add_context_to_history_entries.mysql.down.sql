@@ -0,0 +1 @@
+ALTER TABLE `history_entries` DROP COLUMN `context_uri`;
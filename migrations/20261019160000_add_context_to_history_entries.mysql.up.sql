@@ -0,0 +1 @@
+ALTER TABLE `history_entries` ADD COLUMN `context_uri` varchar(255);
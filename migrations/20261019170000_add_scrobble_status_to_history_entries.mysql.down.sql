@@ -0,0 +1,3 @@
+-- destructive: forgets which plays were scrobbled to Last.fm, migrating again scrobbles the last 14 days twice
+
+ALTER TABLE `history_entries` DROP COLUMN `scrobble_status`;
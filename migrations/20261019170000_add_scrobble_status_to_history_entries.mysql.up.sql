@@ -0,0 +1 @@
+ALTER TABLE `history_entries` ADD COLUMN `scrobble_status` varchar(255);
@@ -0,0 +1,5 @@
+-- destructive: forgets which plays were submitted to ListenBrainz, migrating again submits them twice
+
+ALTER TABLE `tracks` DROP COLUMN `isrc`;
+
+ALTER TABLE `history_entries` DROP COLUMN `listenbrainz_status`;
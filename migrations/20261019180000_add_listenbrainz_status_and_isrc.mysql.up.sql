@@ -0,0 +1,3 @@
+ALTER TABLE `history_entries` ADD COLUMN `listenbrainz_status` varchar(255);
+
+ALTER TABLE `tracks` ADD COLUMN `isrc` varchar(255);
@@ -0,0 +1,7 @@
+-- destructive: imported scrobbles look like Spotify plays afterwards and can hide Spotify plays from the next poll
+
+ALTER TABLE `artists` DROP COLUMN `mbid`;
+
+ALTER TABLE `tracks` DROP COLUMN `mbid`;
+
+ALTER TABLE `history_entries` DROP COLUMN `source`;
@@ -0,0 +1,5 @@
+ALTER TABLE `history_entries` ADD COLUMN `source` varchar(255);
+
+ALTER TABLE `tracks` ADD COLUMN `mbid` varchar(255);
+
+ALTER TABLE `artists` ADD COLUMN `mbid` varchar(255);
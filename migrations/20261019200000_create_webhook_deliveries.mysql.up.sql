@@ -0,0 +1,12 @@
+CREATE TABLE `webhook_deliveries` (
+  `id` int PRIMARY KEY AUTO_INCREMENT,
+  `target` varchar(255) NOT NULL,
+  `event` varchar(255) NOT NULL,
+  `payload` mediumtext NOT NULL,
+  `attempts` int NOT NULL DEFAULT 0,
+  `next_attempt_at` datetime NOT NULL,
+  `last_error` text,
+  `delivered_at` datetime,
+  `failed_at` datetime,
+  `created_at` datetime NOT NULL
+);
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+)
+
+// Album is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// ImageURL is empty if Spotify has no image of the album. It is null if it was never fetched.
+type Album struct {
+	ID          string       `json:"id" db:"id"`
+	Name        string       `json:"name" db:"name"`
+	AlbumType   string       `json:"album_type" db:"album_type"`
+	ReleaseDate string       `json:"release_date" db:"release_date"`
+	ImageURL    nulls.String `json:"image_url" db:"image_url"`
+}
+
+// Albums is not required by pop and may be deleted
+type Albums []Album
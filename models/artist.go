@@ -1,9 +1,19 @@
 package models
 
+import (
+	"github.com/gobuffalo/nulls"
+)
+
 // Artist is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// EnrichedAt is the time genres and statistics were last fetched from Spotify. It is null if they were never fetched.
+// ImageURL is empty if Spotify has no image of the artist. It is null if it was never fetched.
+// MBID is the MusicBrainz artist ID, known from imported scrobbles.
 type Artist struct {
-	ID   string `json:"id" db:"id"`
-	Name string `json:"name" db:"name"`
+	ID         string       `json:"id" db:"id"`
+	Name       string       `json:"name" db:"name"`
+	ImageURL   nulls.String `json:"image_url" db:"image_url"`
+	EnrichedAt nulls.Time   `json:"enriched_at" db:"enriched_at"`
+	MBID       nulls.String `json:"mbid" db:"mbid"`
 }
 
 // Artists is not required by pop and may be deleted
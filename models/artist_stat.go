@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// ArtistStat is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// It is the popularity and follower count of an artist at the time it was recorded.
+type ArtistStat struct {
+	ID         int       `json:"id" db:"id"`
+	ArtistID   string    `json:"artist_id" db:"artist_id"`
+	Popularity int       `json:"popularity" db:"popularity"`
+	Followers  int       `json:"followers" db:"followers"`
+	RecordedAt time.Time `json:"recorded_at" db:"recorded_at"`
+}
+
+// ArtistStats is not required by pop and may be deleted
+type ArtistStats []ArtistStat
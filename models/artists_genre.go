@@ -0,0 +1,11 @@
+package models
+
+// ArtistsGenre is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+type ArtistsGenre struct {
+	ID       int    `json:"id" db:"id"`
+	ArtistID string `json:"artist_id" db:"artist_id"`
+	GenreID  int    `json:"genre_id" db:"genre_id"`
+}
+
+// ArtistsGenres is not required by pop and may be deleted
+type ArtistsGenres []ArtistsGenre
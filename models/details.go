@@ -0,0 +1,121 @@
+package models
+
+import (
+	"github.com/gobuffalo/pop/v5"
+)
+
+// TrackDetails is a track with its album, artists, audio features and number of plays.
+type TrackDetails struct {
+	Track
+	// Album is nil if the album of the track is not known yet
+	Album   *Album  `json:"album"`
+	Artists Artists `json:"artists"`
+	// AudioFeatures is nil if Spotify has no audio features for the track
+	AudioFeatures *TrackAudioFeature `json:"audio_features"`
+	Plays         int                `json:"plays"`
+}
+
+// ArtistDetails is an artist with its genres, latest statistics, number of plays and most played tracks.
+type ArtistDetails struct {
+	Artist
+	Genres []string `json:"genres"`
+	// Stats are the latest popularity and followers. It is nil if they were not fetched yet.
+	Stats     *ArtistStat `json:"stats"`
+	Plays     int         `json:"plays"`
+	TopTracks TopEntries  `json:"top_tracks"`
+}
+
+// FindTrackDetails returns the details of the track with id.
+// The error can be checked with IsNotFound if the track does not exist.
+func FindTrackDetails(db *pop.Connection, id string) (*TrackDetails, error) {
+	details := &TrackDetails{}
+	err := db.Find(&details.Track, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.AlbumID.Valid {
+		album := &Album{}
+		err = db.Find(album, details.AlbumID.String)
+		if err != nil && !IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			details.Album = album
+		}
+	}
+
+	details.Artists = Artists{}
+	err = db.RawQuery(`SELECT a.* FROM artists a JOIN artists_tracks at ON at.artist_id = a.id
+		WHERE at.track_id = ? ORDER BY at.id ASC`, id).All(&details.Artists)
+	if err != nil {
+		return nil, err
+	}
+
+	features := &TrackAudioFeature{}
+	err = db.Find(features, id)
+	if err != nil && !IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		details.AudioFeatures = features
+	}
+
+	details.Plays, err = db.Where("track_id = ?", id).Count(&HistoryEntry{})
+	if err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// FindArtistDetails returns the details of the artist with id including its limit most played tracks.
+// The error can be checked with IsNotFound if the artist does not exist.
+func FindArtistDetails(db *pop.Connection, id string, limit int) (*ArtistDetails, error) {
+	details := &ArtistDetails{}
+	err := db.Find(&details.Artist, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var genres Genres
+	err = db.RawQuery(`SELECT g.* FROM genres g JOIN artists_genres ag ON ag.genre_id = g.id
+		WHERE ag.artist_id = ? ORDER BY g.name ASC`, id).All(&genres)
+	if err != nil {
+		return nil, err
+	}
+	details.Genres = make([]string, len(genres))
+	for i, g := range genres {
+		details.Genres[i] = g.Name
+	}
+
+	stats := &ArtistStat{}
+	err = db.Where("artist_id = ?", id).Order("recorded_at DESC").First(stats)
+	if err != nil && !IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		details.Stats = stats
+	}
+
+	var plays struct {
+		Count int `db:"count"`
+	}
+	err = db.RawQuery(`SELECT COUNT(*) AS count FROM history_entries h
+		JOIN artists_tracks at ON at.track_id = h.track_id WHERE at.artist_id = ?`, id).First(&plays)
+	if err != nil {
+		return nil, err
+	}
+	details.Plays = plays.Count
+
+	details.TopTracks = TopEntries{}
+	err = db.RawQuery(`SELECT t.id AS id, t.name AS name, COUNT(*) AS plays
+		FROM history_entries h JOIN tracks t ON t.id = h.track_id JOIN artists_tracks at ON at.track_id = t.id
+		WHERE at.artist_id = ?
+		GROUP BY t.id, t.name
+		ORDER BY plays DESC, name ASC
+		LIMIT ?`, id, limit).All(&details.TopTracks)
+	if err != nil {
+		return nil, err
+	}
+	return details, nil
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFindTrackDetails(t *testing.T) {
+	createReportFixtures(t)
+	err := testDB.Create(&TrackAudioFeature{ID: "t_id2", Energy: 0.5})
+	assert.NoError(t, err)
+
+	details, err := FindTrackDetails(testDB, "t_id2")
+	assert.NoError(t, err)
+	assert.Equal(t, "t_name2", details.Name)
+	assert.Equal(t, "al_name1", details.Album.Name)
+	assert.Equal(t, 2, len(details.Artists))
+	assert.Equal(t, float32(0.5), details.AudioFeatures.Energy)
+	assert.Equal(t, 1, details.Plays)
+
+	details, err = FindTrackDetails(testDB, "t_id1")
+	assert.NoError(t, err)
+	assert.Nil(t, details.AudioFeatures)
+	assert.Equal(t, 3, details.Plays)
+
+	_, err = FindTrackDetails(testDB, "t_id_not_found")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestFindArtistDetails(t *testing.T) {
+	createReportFixtures(t)
+	genres := Genres{{Name: "rock"}, {Name: "indie"}}
+	err := testDB.Create(&genres)
+	assert.NoError(t, err)
+	connections := ArtistsGenres{{ArtistID: "a_id2", GenreID: genres[0].ID}, {ArtistID: "a_id2", GenreID: genres[1].ID}}
+	err = testDB.Create(&connections)
+	assert.NoError(t, err)
+	stats := ArtistStats{
+		{ArtistID: "a_id2", Popularity: 40, RecordedAt: reportStart},
+		{ArtistID: "a_id2", Popularity: 50, RecordedAt: reportStart.Add(time.Hour)},
+	}
+	err = testDB.Create(&stats)
+	assert.NoError(t, err)
+
+	details, err := FindArtistDetails(testDB, "a_id2", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "a_name2", details.Name)
+	assert.Equal(t, []string{"indie", "rock"}, details.Genres)
+	assert.Equal(t, 50, details.Stats.Popularity)
+	assert.Equal(t, 3, details.Plays)
+	assert.Equal(t, TopEntries{{ID: "t_id3", Name: "t_name3", Plays: 2}}, details.TopTracks)
+
+	details, err = FindArtistDetails(testDB, "a_id1", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, details.Genres)
+	assert.Nil(t, details.Stats)
+
+	_, err = FindArtistDetails(testDB, "a_id_not_found", 10)
+	assert.True(t, IsNotFound(err))
+}
@@ -0,0 +1,12 @@
+package models
+
+// Device is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// It is a device or platform Spotify was listened on, e.g. a smartphone, a computer or a smart speaker.
+type Device struct {
+	ID   string `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+	Type string `json:"type" db:"type"`
+}
+
+// Devices is not required by pop and may be deleted
+type Devices []Device
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// EnrichmentState is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// It records whether the Spotify endpoint of an enrichment job is available, e.g. "audio_features".
+type EnrichmentState struct {
+	ID          string    `json:"id" db:"id"`
+	Unavailable bool      `json:"unavailable" db:"unavailable"`
+	Status      int       `json:"status" db:"status"`
+	Message     string    `json:"message" db:"message"`
+	CheckedAt   time.Time `json:"checked_at" db:"checked_at"`
+}
+
+// EnrichmentStates is not required by pop and may be deleted
+type EnrichmentStates []EnrichmentState
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+)
+
+// Episode is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// It is a podcast episode of a show.
+type Episode struct {
+	ID          string       `json:"id" db:"id"`
+	ShowID      nulls.String `json:"show_id" db:"show_id"`
+	Name        string       `json:"name" db:"name"`
+	DurationMs  int          `json:"duration_ms" db:"duration_ms"`
+	ReleaseDate string       `json:"release_date" db:"release_date"`
+}
+
+// Episodes is not required by pop and may be deleted
+type Episodes []Episode
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// ExportedPlay is a history entry joined with its device, track, album and artists or episode and show.
+type ExportedPlay struct {
+	ID          int          `json:"id" db:"id"`
+	PlayedAt    time.Time    `json:"played_at" db:"played_at"`
+	MsPlayed    nulls.Int    `json:"ms_played" db:"ms_played"`
+	DeviceID    nulls.String `json:"device_id" db:"device_id"`
+	DeviceName  nulls.String `json:"device_name" db:"device_name"`
+	DeviceType  nulls.String `json:"device_type" db:"device_type"`
+	TrackID     nulls.String `json:"track_id" db:"track_id"`
+	TrackName   nulls.String `json:"track_name" db:"track_name"`
+	TrackNumber nulls.Int    `json:"track_number" db:"track_number"`
+	DiscNumber  nulls.Int    `json:"disc_number" db:"disc_number"`
+	DurationMs  nulls.Int    `json:"duration_ms" db:"duration_ms"`
+	Explicit    nulls.Bool   `json:"explicit" db:"explicit"`
+	AlbumID     nulls.String `json:"album_id" db:"album_id"`
+	AlbumName   nulls.String `json:"album_name" db:"album_name"`
+	AlbumType   nulls.String `json:"album_type" db:"album_type"`
+	ReleaseDate nulls.String `json:"release_date" db:"release_date"`
+	ArtistIDs   []string     `json:"artist_ids" db:"-"`
+	ArtistNames []string     `json:"artist_names" db:"-"`
+	EpisodeID   nulls.String `json:"episode_id" db:"episode_id"`
+	EpisodeName nulls.String `json:"episode_name" db:"episode_name"`
+	ShowID      nulls.String `json:"show_id" db:"show_id"`
+	ShowName    nulls.String `json:"show_name" db:"show_name"`
+	ContextURI  nulls.String `json:"context_uri" db:"context_uri"`
+	Source      nulls.String `json:"source" db:"source"`
+}
+
+// exportRow is an exported play with one of its artists.
+type exportRow struct {
+	ExportedPlay
+	ArtistID   nulls.String `db:"artist_id"`
+	ArtistName nulls.String `db:"artist_name"`
+}
+
+// queryer is implemented by the database and transaction stores of pop.
+type queryer interface {
+	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
+}
+
+// EachPlay calls fn with every play in period, the oldest first. The rows are streamed from the database
+// instead of loading all of them. It stops at the first error returned by fn.
+func EachPlay(db *pop.Connection, period Period, fn func(play ExportedPlay) error) error {
+	q, ok := db.Store.(queryer)
+	if !ok {
+		return fmt.Errorf("connection does not support streaming queries")
+	}
+	where, args := period.where()
+	query := fmt.Sprintf(`SELECT h.id AS id, h.played_at AS played_at, h.ms_played AS ms_played,
+			h.device_id AS device_id, d.name AS device_name, d.type AS device_type,
+			h.track_id AS track_id, t.name AS track_name, t.track_number AS track_number, t.disc_number AS disc_number,
+			COALESCE(t.duration_ms, e.duration_ms) AS duration_ms, t.explicit AS explicit,
+			t.album_id AS album_id, al.name AS album_name, al.album_type AS album_type, al.release_date AS release_date,
+			a.id AS artist_id, a.name AS artist_name,
+			h.episode_id AS episode_id, e.name AS episode_name, e.show_id AS show_id, s.name AS show_name,
+			h.context_uri AS context_uri, h.source AS source
+		FROM history_entries h
+		LEFT JOIN devices d ON d.id = h.device_id
+		LEFT JOIN tracks t ON t.id = h.track_id
+		LEFT JOIN albums al ON al.id = t.album_id
+		LEFT JOIN artists_tracks at ON at.track_id = t.id
+		LEFT JOIN artists a ON a.id = at.artist_id
+		LEFT JOIN episodes e ON e.id = h.episode_id
+		LEFT JOIN shows s ON s.id = e.show_id
+		WHERE %s
+		ORDER BY h.played_at ASC, h.id ASC, at.id ASC`, where)
+
+	rows, err := q.Queryx(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var current *ExportedPlay
+	for rows.Next() {
+		var row exportRow
+		err = rows.StructScan(&row)
+		if err != nil {
+			return err
+		}
+		if current != nil && current.ID != row.ID {
+			err = fn(*current)
+			if err != nil {
+				return err
+			}
+			current = nil
+		}
+		if current == nil {
+			play := row.ExportedPlay
+			play.ArtistIDs = []string{}
+			play.ArtistNames = []string{}
+			current = &play
+		}
+		if row.ArtistID.Valid {
+			current.ArtistIDs = append(current.ArtistIDs, row.ArtistID.String)
+			current.ArtistNames = append(current.ArtistNames, row.ArtistName.String)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		return fn(*current)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEachPlay(t *testing.T) {
+	createReportFixtures(t)
+
+	var plays []ExportedPlay
+	err := EachPlay(testDB, Period{}, func(play ExportedPlay) error {
+		plays = append(plays, play)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(plays))
+	assert.Equal(t, reportStart, plays[0].PlayedAt.UTC())
+	assert.Equal(t, "t_name1", plays[0].TrackName.String)
+	assert.Equal(t, "al_name1", plays[0].AlbumName.String)
+	assert.Equal(t, 200000, plays[0].DurationMs.Int)
+	assert.Equal(t, []string{"a_id1"}, plays[0].ArtistIDs)
+	assert.Equal(t, "t_id2", plays[3].TrackID.String)
+	assert.Equal(t, []string{"a_id1", "a_id2"}, plays[3].ArtistIDs)
+	assert.Equal(t, []string{"a_name1", "a_name2"}, plays[3].ArtistNames)
+	assert.Equal(t, 50000, plays[2].MsPlayed.Int)
+	assert.False(t, plays[0].MsPlayed.Valid)
+	assert.False(t, plays[0].EpisodeID.Valid)
+
+	plays = nil
+	period := Period{Since: reportStart.Add(time.Minute), Until: reportStart.Add(30 * 24 * time.Hour)}
+	err = EachPlay(testDB, period, func(play ExportedPlay) error {
+		plays = append(plays, play)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(plays))
+
+	calls := 0
+	err = EachPlay(testDB, Period{}, func(play ExportedPlay) error {
+		calls++
+		return errors.New("write error")
+	})
+	assert.EqualError(t, err, "write error")
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,10 @@
+package models
+
+// Genre is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+type Genre struct {
+	ID   int    `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+}
+
+// Genres is not required by pop and may be deleted
+type Genres []Genre
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"fmt"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"strings"
+	"time"
+)
+
+// Play is a history entry together with the names of the played track and album or episode and show.
+type Play struct {
+	ID          int          `json:"id" db:"id"`
+	PlayedAt    time.Time    `json:"played_at" db:"played_at"`
+	MsPlayed    nulls.Int    `json:"ms_played" db:"ms_played"`
+	DeviceID    nulls.String `json:"device_id" db:"device_id"`
+	TrackID     nulls.String `json:"track_id" db:"track_id"`
+	TrackName   nulls.String `json:"track_name" db:"track_name"`
+	AlbumID     nulls.String `json:"album_id" db:"album_id"`
+	AlbumName   nulls.String `json:"album_name" db:"album_name"`
+	ImageURL    nulls.String `json:"image_url" db:"image_url"`
+	EpisodeID   nulls.String `json:"episode_id" db:"episode_id"`
+	EpisodeName nulls.String `json:"episode_name" db:"episode_name"`
+	ShowName    nulls.String `json:"show_name" db:"show_name"`
+}
+
+// Plays returns limit plays in period starting at offset, the most recent first.
+func Plays(db *pop.Connection, period Period, limit, offset int) ([]Play, error) {
+	where, args := period.where()
+	query := fmt.Sprintf(`SELECT h.id AS id, h.played_at AS played_at, h.ms_played AS ms_played, h.device_id AS device_id,
+			h.track_id AS track_id, t.name AS track_name, t.album_id AS album_id, al.name AS album_name, al.image_url AS image_url,
+			h.episode_id AS episode_id, e.name AS episode_name, s.name AS show_name
+		FROM history_entries h
+		LEFT JOIN tracks t ON t.id = h.track_id
+		LEFT JOIN albums al ON al.id = t.album_id
+		LEFT JOIN episodes e ON e.id = h.episode_id
+		LEFT JOIN shows s ON s.id = e.show_id
+		WHERE %s
+		ORDER BY h.played_at DESC, h.id DESC
+		LIMIT ? OFFSET ?`, where)
+
+	plays := []Play{}
+	err := db.RawQuery(query, append(args, limit, offset)...).All(&plays)
+	return plays, err
+}
+
+// CountPlays returns the number of plays in period.
+func CountPlays(db *pop.Connection, period Period) (int, error) {
+	where, args := period.where()
+	var count struct {
+		Count int `db:"count"`
+	}
+	err := db.RawQuery("SELECT COUNT(*) AS count FROM history_entries h WHERE "+where, args...).First(&count)
+	return count.Count, err
+}
+
+// IsNotFound checks if err was returned because a query found no rows.
+func IsNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "sql: no rows in result set")
+}
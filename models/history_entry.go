@@ -1,15 +1,36 @@
 package models
 
 import (
+	"github.com/gobuffalo/nulls"
 	"sort"
 	"time"
 )
 
+// Sources of history entries that were not saved from Spotify.
+const (
+	// SourceLastFM is the source of plays imported from a Last.fm export
+	SourceLastFM = "lastfm"
+	// SourceListenBrainz is the source of plays imported from a ListenBrainz export
+	SourceListenBrainz = "listenbrainz"
+)
+
 // HistoryEntry is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// It references either a played track or a played podcast episode.
 type HistoryEntry struct {
-	ID       int       `json:"id" db:"id"`
-	TrackID  string    `json:"track_id" db:"track_id"`
-	PlayedAt time.Time `json:"played_at" db:"played_at"`
+	ID        int          `json:"id" db:"id"`
+	TrackID   nulls.String `json:"track_id" db:"track_id"`
+	EpisodeID nulls.String `json:"episode_id" db:"episode_id"`
+	PlayedAt  time.Time    `json:"played_at" db:"played_at"`
+	DeviceID  nulls.String `json:"device_id" db:"device_id"`
+	MsPlayed  nulls.Int    `json:"ms_played" db:"ms_played"`
+	// ContextURI is the album, artist, playlist or show the play was started from
+	ContextURI nulls.String `json:"context_uri" db:"context_uri"`
+	// ScrobbleStatus is the result of scrobbling the play to Last.fm. It is null if it was not scrobbled yet.
+	ScrobbleStatus nulls.String `json:"scrobble_status" db:"scrobble_status"`
+	// ListenBrainzStatus is the result of submitting the play to ListenBrainz. It is null if it was not submitted yet.
+	ListenBrainzStatus nulls.String `json:"listenbrainz_status" db:"listenbrainz_status"`
+	// Source is the service the play was imported from, e.g. SourceLastFM. It is null for plays saved from Spotify.
+	Source nulls.String `json:"source" db:"source"`
 }
 
 // HistoryEntries is not required by pop and may be deleted
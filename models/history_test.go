@@ -0,0 +1,46 @@
+package models
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPlays(t *testing.T) {
+	createReportFixtures(t)
+
+	plays, err := Plays(testDB, Period{}, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(plays))
+	assert.Equal(t, "t_id3", plays[0].TrackID.String)
+	assert.Equal(t, "t_name3", plays[0].TrackName.String)
+	assert.Equal(t, "al_name2", plays[0].AlbumName.String)
+	assert.False(t, plays[0].EpisodeID.Valid)
+
+	plays, err = Plays(testDB, Period{}, 10, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(plays))
+	assert.Equal(t, reportStart.Add(time.Hour), plays[0].PlayedAt.UTC())
+
+	period := Period{Until: reportStart.Add(time.Minute)}
+	plays, err = Plays(testDB, period, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(plays))
+
+	count, err := CountPlays(testDB, period)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	count, err = CountPlays(testDB, Period{})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, count)
+}
+
+func TestIsNotFound(t *testing.T) {
+	assert.False(t, IsNotFound(nil))
+	assert.False(t, IsNotFound(errors.New("connection refused")))
+
+	err := testDB.Find(&Track{}, "t_id_not_found")
+	assert.True(t, IsNotFound(err))
+}
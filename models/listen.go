@@ -0,0 +1,119 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"time"
+)
+
+// Results of submitting a history entry to ListenBrainz.
+const (
+	// ListenStatusSubmitted is the status of plays that were accepted
+	ListenStatusSubmitted = "submitted"
+	// ListenStatusRejected is the status of invalid plays, e.g. without artist, that are not submitted again
+	ListenStatusRejected = "rejected"
+	// ListenStatusFailed is the status of plays that could not be submitted and are retried
+	ListenStatusFailed = "failed"
+)
+
+// ListenPlay is a played track with the names and IDs needed to submit it as listen.
+type ListenPlay struct {
+	ID          int          `db:"id"`
+	PlayedAt    time.Time    `db:"played_at"`
+	TrackID     string       `db:"track_id"`
+	TrackName   string       `db:"track_name"`
+	TrackNumber int          `db:"track_number"`
+	DurationMs  int          `db:"duration_ms"`
+	ISRC        nulls.String `db:"isrc"`
+	AlbumID     nulls.String `db:"album_id"`
+	AlbumName   nulls.String `db:"album_name"`
+	// Artists are the artists of the track in the order Spotify lists them
+	Artists []Artist `db:"-"`
+}
+
+// trackArtist is an artist of a track.
+type trackArtist struct {
+	TrackID string `db:"track_id"`
+	Artist
+}
+
+// PendingListens returns up to limit played tracks since the given time that were not submitted yet or failed,
+// the oldest first.
+func PendingListens(db *pop.Connection, since time.Time, limit int) ([]ListenPlay, error) {
+	plays := []ListenPlay{}
+	err := db.RawQuery(`SELECT h.id AS id, h.played_at AS played_at, t.id AS track_id, t.name AS track_name,
+			t.track_number AS track_number, t.duration_ms AS duration_ms, t.isrc AS isrc,
+			al.id AS album_id, al.name AS album_name
+		FROM history_entries h
+		JOIN tracks t ON t.id = h.track_id
+		LEFT JOIN albums al ON al.id = t.album_id
+		WHERE h.played_at >= ? AND (h.listenbrainz_status IS NULL OR h.listenbrainz_status = ?)
+		ORDER BY h.played_at ASC, h.id ASC
+		LIMIT ?`, since, ListenStatusFailed, limit).All(&plays)
+	if err != nil {
+		return plays, err
+	}
+	return plays, addArtists(db, plays)
+}
+
+// TrackPlays returns the played tracks of the history entries with ids, the oldest first.
+// History entries of episodes are left out.
+func TrackPlays(db *pop.Connection, ids ...int) ([]ListenPlay, error) {
+	plays := []ListenPlay{}
+	if len(ids) == 0 {
+		return plays, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	err := db.RawQuery(`SELECT h.id AS id, h.played_at AS played_at, t.id AS track_id, t.name AS track_name,
+			t.track_number AS track_number, t.duration_ms AS duration_ms, t.isrc AS isrc,
+			al.id AS album_id, al.name AS album_name
+		FROM history_entries h
+		JOIN tracks t ON t.id = h.track_id
+		LEFT JOIN albums al ON al.id = t.album_id
+		WHERE h.id IN (`+placeholders(len(ids))+`)
+		ORDER BY h.played_at ASC, h.id ASC`, args...).All(&plays)
+	if err != nil {
+		return plays, err
+	}
+	return plays, addArtists(db, plays)
+}
+
+// addArtists sets the artists of the tracks of plays.
+func addArtists(db *pop.Connection, plays []ListenPlay) error {
+	if len(plays) == 0 {
+		return nil
+	}
+	trackIDs := make([]interface{}, 0, len(plays))
+	seen := map[string]bool{}
+	for _, p := range plays {
+		if !seen[p.TrackID] {
+			seen[p.TrackID] = true
+			trackIDs = append(trackIDs, p.TrackID)
+		}
+	}
+	artists := []trackArtist{}
+	err := db.RawQuery(`SELECT at.track_id AS track_id, a.id AS id, a.name AS name
+		FROM artists_tracks at
+		JOIN artists a ON a.id = at.artist_id
+		WHERE at.track_id IN (`+placeholders(len(trackIDs))+`)
+		ORDER BY at.id`, trackIDs...).All(&artists)
+	if err != nil {
+		return err
+	}
+	byTrack := map[string][]Artist{}
+	for _, a := range artists {
+		byTrack[a.TrackID] = append(byTrack[a.TrackID], a.Artist)
+	}
+	for i := range plays {
+		plays[i].Artists = byTrack[plays[i].TrackID]
+	}
+	return nil
+}
+
+// SetListenStatus sets the ListenBrainz status of the history entries with ids.
+func SetListenStatus(db *pop.Connection, status string, ids ...int) error {
+	return setHistoryStatus(db, "listenbrainz_status", status, ids)
+}
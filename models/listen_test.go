@@ -0,0 +1,65 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPendingListens(t *testing.T) {
+	createReportFixtures(t)
+	assert.NoError(t, testDB.RawQuery("UPDATE tracks SET isrc = ? WHERE id = ?", "USRC17607839", "t_id2").Exec())
+
+	plays, err := PendingListens(testDB, reportStart, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(plays))
+	assert.Equal(t, reportStart, plays[0].PlayedAt.UTC())
+	assert.Equal(t, "t_id1", plays[0].TrackID)
+	assert.Equal(t, "t_name1", plays[0].TrackName)
+	assert.Equal(t, "al_id1", plays[0].AlbumID.String)
+	assert.Equal(t, "al_name1", plays[0].AlbumName.String)
+	assert.Equal(t, 200000, plays[0].DurationMs)
+	assert.False(t, plays[0].ISRC.Valid)
+	assert.Equal(t, []Artist{{ID: "a_id1", Name: "a_name1"}}, plays[0].Artists)
+	assert.Equal(t, "USRC17607839", plays[3].ISRC.String)
+	assert.Equal(t, []Artist{{ID: "a_id1", Name: "a_name1"}, {ID: "a_id2", Name: "a_name2"}}, plays[3].Artists)
+
+	assert.NoError(t, SetListenStatus(testDB, ListenStatusSubmitted, plays[0].ID, plays[1].ID))
+	assert.NoError(t, SetListenStatus(testDB, ListenStatusRejected, plays[2].ID))
+	assert.NoError(t, SetListenStatus(testDB, ListenStatusFailed, plays[3].ID))
+
+	pending, err := PendingListens(testDB, reportStart, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{plays[3].ID, plays[4].ID}, []int{pending[0].ID, pending[1].ID})
+
+	pending, err = PendingListens(testDB, reportStart.Add(20*24*time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pending))
+
+	var entry HistoryEntry
+	assert.NoError(t, testDB.Find(&entry, plays[0].ID))
+	assert.Equal(t, nulls.NewString(ListenStatusSubmitted), entry.ListenBrainzStatus)
+	assert.False(t, entry.ScrobbleStatus.Valid)
+
+	pending, err = PendingListens(testDB, reportStart.Add(100*24*time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestTrackPlays(t *testing.T) {
+	createReportFixtures(t)
+
+	pending, err := PendingListens(testDB, reportStart, 10)
+	assert.NoError(t, err)
+
+	plays, err := TrackPlays(testDB, pending[3].ID, pending[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{pending[0].ID, pending[3].ID}, []int{plays[0].ID, plays[1].ID})
+	assert.Equal(t, pending[3].Artists, plays[1].Artists)
+	assert.Equal(t, "al_name1", plays[0].AlbumName.String)
+
+	plays, err = TrackPlays(testDB)
+	assert.NoError(t, err)
+	assert.Empty(t, plays)
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// UnitDay groups listening time by day
+	UnitDay = "day"
+	// UnitWeek groups listening time by ISO week starting on Monday
+	UnitWeek = "week"
+	// UnitMonth groups listening time by month
+	UnitMonth = "month"
+	// UnitYear groups listening time by year
+	UnitYear = "year"
+
+	// playTimeColumn is the play time of a history entry with alias h. Plays without a measured play time
+	// count with the duration of their track or episode.
+	playTimeColumn = "COALESCE(h.ms_played, NULLIF(t.duration_ms, 0), NULLIF(e.duration_ms, 0), 0)"
+)
+
+// ListeningTime is the number of plays and the total play time in milliseconds of a group of history entries.
+type ListeningTime struct {
+	Plays    int   `json:"plays" db:"plays"`
+	MsPlayed int64 `json:"ms_played" db:"ms_played"`
+}
+
+// Duration returns the total play time.
+func (l ListeningTime) Duration() time.Duration {
+	return time.Duration(l.MsPlayed) * time.Millisecond
+}
+
+func (l *ListeningTime) add(msPlayed int64) {
+	l.Plays++
+	l.MsPlayed += msPlayed
+}
+
+// PeriodListeningTime is the listening time of a day, week, month or year.
+type PeriodListeningTime struct {
+	ListeningTime
+	// Label is the period formatted like "2021-03-01", "2021-W09", "2021-03" or "2021"
+	Label string    `json:"period"`
+	Start time.Time `json:"start"`
+}
+
+// ArtistListeningTime is the listening time of an artist.
+type ArtistListeningTime struct {
+	ListeningTime
+	ID   string `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+}
+
+// HourListeningTime is the listening time of an hour of the day.
+type HourListeningTime struct {
+	ListeningTime
+	Hour int `json:"hour"`
+}
+
+// playTime is the time and play time of a history entry.
+type playTime struct {
+	PlayedAt time.Time `db:"played_at"`
+	MsPlayed int64     `db:"ms_played"`
+}
+
+// ListeningTimeByPeriod returns the listening time per day, week, month or year in period depending on unit.
+// Periods are computed in loc and sorted ascending. Periods without plays are left out.
+func ListeningTimeByPeriod(db *pop.Connection, unit string, period Period, loc *time.Location) ([]PeriodListeningTime, error) {
+	switch unit {
+	case UnitDay, UnitWeek, UnitMonth, UnitYear:
+	default:
+		return nil, fmt.Errorf("unknown unit %q, expected %s, %s, %s or %s", unit, UnitDay, UnitWeek, UnitMonth, UnitYear)
+	}
+	plays, err := playTimes(db, period)
+	if err != nil {
+		return nil, err
+	}
+
+	periods := map[time.Time]*PeriodListeningTime{}
+	for _, p := range plays {
+		start, label := periodStart(p.PlayedAt.In(loc), unit)
+		if _, ok := periods[start]; !ok {
+			periods[start] = &PeriodListeningTime{Label: label, Start: start}
+		}
+		periods[start].add(p.MsPlayed)
+	}
+
+	result := make([]PeriodListeningTime, 0, len(periods))
+	for _, p := range periods {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+	return result, nil
+}
+
+// ListeningTimeByHour returns the listening time per hour of the day in period for all 24 hours.
+// Hours are computed in loc.
+func ListeningTimeByHour(db *pop.Connection, period Period, loc *time.Location) ([]HourListeningTime, error) {
+	plays, err := playTimes(db, period)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]HourListeningTime, 24)
+	for hour := range result {
+		result[hour].Hour = hour
+	}
+	for _, p := range plays {
+		result[p.PlayedAt.In(loc).Hour()].add(p.MsPlayed)
+	}
+	return result, nil
+}
+
+// ListeningTimeByArtist returns the limit artists listened to the longest in period.
+// The play time of a track with several artists counts for each of them.
+func ListeningTimeByArtist(db *pop.Connection, period Period, limit int) ([]ArtistListeningTime, error) {
+	where, args := period.where()
+	query := fmt.Sprintf(`SELECT a.id AS id, a.name AS name, COUNT(*) AS plays, SUM(%s) AS ms_played
+		FROM history_entries h JOIN tracks t ON t.id = h.track_id
+		LEFT JOIN episodes e ON e.id = h.episode_id
+		JOIN artists_tracks at ON at.track_id = t.id JOIN artists a ON a.id = at.artist_id
+		WHERE %s
+		GROUP BY a.id, a.name
+		ORDER BY ms_played DESC, name ASC
+		LIMIT ?`, playTimeColumn, where)
+
+	result := []ArtistListeningTime{}
+	err := db.RawQuery(query, append(args, limit)...).All(&result)
+	return result, err
+}
+
+// playTimes returns the play time of all history entries in period.
+func playTimes(db *pop.Connection, period Period) ([]playTime, error) {
+	where, args := period.where()
+	query := fmt.Sprintf(`SELECT h.played_at AS played_at, %s AS ms_played
+		FROM history_entries h
+		LEFT JOIN tracks t ON t.id = h.track_id
+		LEFT JOIN episodes e ON e.id = h.episode_id
+		WHERE %s`, playTimeColumn, where)
+
+	var plays []playTime
+	err := db.RawQuery(query, args...).All(&plays)
+	return plays, err
+}
+
+// periodStart returns the start of the day, week, month or year containing t and its label.
+func periodStart(t time.Time, unit string) (time.Time, string) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch unit {
+	case UnitWeek:
+		start := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		year, week := start.ISOWeek()
+		return start, fmt.Sprintf("%d-W%02d", year, week)
+	case UnitMonth:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		return start, start.Format("2006-01")
+	case UnitYear:
+		start := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+		return start, strconv.Itoa(t.Year())
+	}
+	return day, day.Format("2006-01-02")
+}
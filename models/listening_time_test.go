@@ -0,0 +1,85 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestListeningTimeByPeriod(t *testing.T) {
+	createReportFixtures(t)
+
+	tests := []struct {
+		unit   string
+		labels []string
+		times  []ListeningTime
+	}{
+		{UnitDay, []string{"2021-03-01", "2021-03-02", "2021-03-11", "2021-04-10"},
+			[]ListeningTime{{2, 400000}, {2, 150000}, {1, 300000}, {1, 300000}}},
+		{UnitWeek, []string{"2021-W09", "2021-W10", "2021-W14"},
+			[]ListeningTime{{4, 550000}, {1, 300000}, {1, 300000}}},
+		{UnitMonth, []string{"2021-03", "2021-04"},
+			[]ListeningTime{{5, 850000}, {1, 300000}}},
+		{UnitYear, []string{"2021"},
+			[]ListeningTime{{6, 1150000}}},
+	}
+	for _, test := range tests {
+		t.Run(test.unit, func(t *testing.T) {
+			periods, err := ListeningTimeByPeriod(testDB, test.unit, Period{}, time.UTC)
+			assert.NoError(t, err)
+			var labels []string
+			var times []ListeningTime
+			for _, p := range periods {
+				labels = append(labels, p.Label)
+				times = append(times, p.ListeningTime)
+			}
+			assert.Equal(t, test.labels, labels)
+			assert.Equal(t, test.times, times)
+		})
+	}
+
+	t.Run("Period", func(t *testing.T) {
+		periods, err := ListeningTimeByPeriod(testDB, UnitMonth, Period{Since: reportStart.AddDate(0, 1, 0)}, time.UTC)
+		assert.NoError(t, err)
+		assert.Equal(t, []PeriodListeningTime{{
+			ListeningTime: ListeningTime{1, 300000},
+			Label:         "2021-04",
+			Start:         time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+		}}, periods)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := ListeningTimeByPeriod(testDB, "decade", Period{}, time.UTC)
+		assert.Error(t, err)
+	})
+}
+
+func TestListeningTimeByHour(t *testing.T) {
+	createReportFixtures(t)
+
+	hours, err := ListeningTimeByHour(testDB, Period{}, time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, 24, len(hours))
+	assert.Equal(t, HourListeningTime{ListeningTime{5, 950000}, 12}, hours[12])
+	assert.Equal(t, HourListeningTime{ListeningTime{1, 200000}, 13}, hours[13])
+	assert.Equal(t, HourListeningTime{ListeningTime{}, 0}, hours[0])
+
+	hours, err = ListeningTimeByHour(testDB, Period{}, time.FixedZone("UTC+2", 2*60*60))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, hours[14].Plays)
+}
+
+func TestListeningTimeByArtist(t *testing.T) {
+	createReportFixtures(t)
+
+	artists, err := ListeningTimeByArtist(testDB, Period{}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []ArtistListeningTime{
+		{ListeningTime{3, 700000}, "a_id2", "a_name2"},
+		{ListeningTime{4, 550000}, "a_id1", "a_name1"},
+	}, artists)
+}
+
+func TestListeningTime_Duration(t *testing.T) {
+	assert.Equal(t, 90*time.Second, ListeningTime{MsPlayed: 90000}.Duration())
+}
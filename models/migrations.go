@@ -0,0 +1,119 @@
+package models
+
+import (
+	"fmt"
+	"github.com/gobuffalo/packr/v2"
+	"github.com/gobuffalo/pop/v5"
+	"sort"
+	"strings"
+)
+
+// destructiveMarker starts the first line of down migrations that delete saved plays or state that migrating
+// again does not restore. It is followed by a description of the lost data.
+const destructiveMarker = "-- destructive:"
+
+// migrationFiles holds the migrations of the app
+var migrationFiles = packr.New("migrations", "../migrations")
+
+// MigrationStatus is the state of a migration in the schema_migration table.
+type MigrationStatus struct {
+	Version string `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+// DestructiveMigration is an applied migration whose rollback loses data that migrating again does not restore.
+type DestructiveMigration struct {
+	MigrationStatus
+	// Warning describes the lost data
+	Warning string
+}
+
+// Migrations loads the migrations of the app for c.
+func Migrations(c *pop.Connection) (pop.MigrationBox, error) {
+	box, err := pop.NewMigrationBox(migrationFiles, c)
+	if err != nil {
+		return box, fmt.Errorf("could not load migrations: %v", err)
+	}
+	return box, nil
+}
+
+// MigrationStatuses lists all migrations ordered by version and whether they are applied to c.
+func MigrationStatuses(c *pop.Connection) ([]MigrationStatus, error) {
+	box, err := Migrations(c)
+	if err != nil {
+		return nil, err
+	}
+	err = box.CreateSchemaMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not create schema_migration table: %v", err)
+	}
+
+	migrations := box.Migrations["up"]
+	sort.Sort(migrations)
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		applied, err := c.Where("version = ?", m.Version).Exists(c.MigrationTableName())
+		if err != nil {
+			return nil, fmt.Errorf("could not check migration %s: %v", m.Version, err)
+		}
+		statuses = append(statuses, MigrationStatus{Version: m.Version, Name: m.Name, Applied: applied})
+	}
+	return statuses, nil
+}
+
+// PendingMigrations lists the migrations that are not applied to c yet.
+func PendingMigrations(c *pop.Connection) ([]MigrationStatus, error) {
+	statuses, err := MigrationStatuses(c)
+	if err != nil {
+		return nil, err
+	}
+	var pending []MigrationStatus
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s)
+		}
+	}
+	return pending, nil
+}
+
+// DestructiveRollbacks lists the migrations reverted by rolling back the last n applied migrations of c
+// whose down migrations delete saved plays or playback sessions, the latest first.
+func DestructiveRollbacks(c *pop.Connection, n int) ([]DestructiveMigration, error) {
+	box, err := Migrations(c)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := MigrationStatuses(c)
+	if err != nil {
+		return nil, err
+	}
+	downs := map[string]pop.Migration{}
+	for _, m := range box.Migrations["down"] {
+		downs[m.Version] = m
+	}
+
+	var destructive []DestructiveMigration
+	for i := len(statuses) - 1; i >= 0 && n > 0; i-- {
+		if !statuses[i].Applied {
+			continue
+		}
+		n--
+		down, ok := downs[statuses[i].Version]
+		if !ok {
+			continue
+		}
+		content, err := migrationFiles.FindString(down.Path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration %s: %v", down.Path, err)
+		}
+		firstLine := strings.SplitN(content, "\n", 2)[0]
+		if strings.HasPrefix(firstLine, destructiveMarker) {
+			destructive = append(destructive, DestructiveMigration{
+				MigrationStatus: statuses[i],
+				Warning:         strings.TrimSpace(strings.TrimPrefix(firstLine, destructiveMarker)),
+			})
+		}
+	}
+	return destructive, nil
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMigrationStatuses(t *testing.T) {
+	statuses, err := MigrationStatuses(testDB)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, statuses)
+	assert.Equal(t, "20210320140422", statuses[0].Version)
+	assert.Equal(t, "create_add_history_tables", statuses[0].Name)
+	for i, s := range statuses {
+		assert.True(t, s.Applied, s.Version)
+		if i > 0 {
+			assert.Less(t, statuses[i-1].Version, s.Version)
+		}
+	}
+
+	pending, err := PendingMigrations(testDB)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestPendingMigrations(t *testing.T) {
+	box, err := Migrations(testDB)
+	assert.NoError(t, err)
+	assert.NoError(t, box.Down(1))
+	defer func() {
+		assert.NoError(t, box.Up())
+	}()
+
+	statuses, err := MigrationStatuses(testDB)
+	assert.NoError(t, err)
+	pending, err := PendingMigrations(testDB)
+	assert.NoError(t, err)
+	assert.Equal(t, []MigrationStatus{statuses[len(statuses)-1]}, pending)
+	assert.False(t, pending[0].Applied)
+}
+
+func TestDestructiveRollbacks(t *testing.T) {
+	destructive, err := DestructiveRollbacks(testDB, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, destructive)
+
+	statuses, err := MigrationStatuses(testDB)
+	assert.NoError(t, err)
+	destructive, err = DestructiveRollbacks(testDB, len(statuses))
+	assert.NoError(t, err)
+	var names []string
+	for _, m := range destructive {
+		names = append(names, m.Name)
+	}
+	assert.Equal(t, []string{"add_source_and_mbids", "add_listenbrainz_status_and_isrc", "add_scrobble_status_to_history_entries", "create_shows_and_episodes", "create_playback_sessions", "create_add_history_tables"}, names)
+	assert.Equal(t, "imported scrobbles look like Spotify plays afterwards and can hide Spotify plays from the next poll", destructive[0].Warning)
+	assert.Equal(t, "forgets which plays were submitted to ListenBrainz, migrating again submits them twice", destructive[1].Warning)
+	assert.Equal(t, "forgets which plays were scrobbled to Last.fm, migrating again scrobbles the last 14 days twice", destructive[2].Warning)
+	assert.Equal(t, "deletes all plays and playback sessions of podcast episodes", destructive[3].Warning)
+}
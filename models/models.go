@@ -1,21 +1,10 @@
 package models
 
 import (
-	"github.com/gobuffalo/envy"
 	"github.com/gobuffalo/pop/v5"
-	"github.com/sirupsen/logrus"
 )
 
 // DB is a connection to your database to be used
-// throughout your application.
+// throughout your application. It is connected by the app
+// to the database selected by the config.
 var DB *pop.Connection
-
-func init() {
-	var err error
-	env := envy.Get("GO_ENV", "development")
-	DB, err = pop.Connect(env)
-	if err != nil {
-		logrus.Fatal(err)
-	}
-	pop.Debug = env == "development"
-}
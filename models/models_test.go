@@ -0,0 +1,28 @@
+package models
+
+import (
+	"fmt"
+	"github.com/gobuffalo/packr/v2"
+	"github.com/gobuffalo/pop/v5"
+	"os"
+	"testing"
+)
+
+var testDB *pop.Connection
+
+func TestMain(m *testing.M) {
+	var err error
+
+	testDB, err = pop.Connect("test")
+	if err != nil {
+		fmt.Println("Could not connect to test database")
+		os.Exit(1)
+	}
+	_ = pop.CreateDB(testDB)
+	box, _ := pop.NewMigrationBox(packr.New("migrations", "../migrations"), testDB)
+	_ = box.Up()
+	_ = testDB.TruncateAll()
+
+	code := m.Run()
+	os.Exit(code)
+}
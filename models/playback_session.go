@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"time"
+)
+
+// PlaybackSession is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// It is a segment of continuous playback of one track or episode observed by the player state worker.
+type PlaybackSession struct {
+	ID             int          `json:"id" db:"id"`
+	TrackID        nulls.String `json:"track_id" db:"track_id"`
+	EpisodeID      nulls.String `json:"episode_id" db:"episode_id"`
+	HistoryEntryID nulls.Int    `json:"history_entry_id" db:"history_entry_id"`
+	StartedAt      time.Time    `json:"started_at" db:"started_at"`
+	EndedAt        time.Time    `json:"ended_at" db:"ended_at"`
+	ProgressMs     int          `json:"progress_ms" db:"progress_ms"`
+	DurationMs     int          `json:"duration_ms" db:"duration_ms"`
+	DeviceID       nulls.String `json:"device_id" db:"device_id"`
+	Shuffle        bool         `json:"shuffle" db:"shuffle"`
+	RepeatState    string       `json:"repeat_state" db:"repeat_state"`
+	ContextURI     string       `json:"context_uri" db:"context_uri"`
+	Skipped        bool         `json:"skipped" db:"skipped"`
+}
+
+// PlaybackSessions is not required by pop and may be deleted
+type PlaybackSessions []PlaybackSession
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"strings"
+	"time"
+)
+
+const (
+	// TopByTrack groups a top list by track
+	TopByTrack = "track"
+	// TopByArtist groups a top list by artist
+	TopByArtist = "artist"
+	// TopByAlbum groups a top list by album
+	TopByAlbum = "album"
+)
+
+// Period limits reports to history entries played at or after Since and before Until.
+// A zero time leaves that side of the period open.
+type Period struct {
+	Since time.Time
+	Until time.Time
+}
+
+// where returns the SQL condition and its arguments to filter the played_at column of history entries with alias h.
+func (p Period) where() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if !p.Since.IsZero() {
+		conditions = append(conditions, "h.played_at >= ?")
+		args = append(args, p.Since)
+	}
+	if !p.Until.IsZero() {
+		conditions = append(conditions, "h.played_at < ?")
+		args = append(args, p.Until)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// TopEntry is a track, artist or album with the number of times it was played.
+type TopEntry struct {
+	ID    string `json:"id" db:"id"`
+	Name  string `json:"name" db:"name"`
+	Plays int    `json:"plays" db:"plays"`
+}
+
+// TopEntries is a list of TopEntry sorted by plays descending.
+type TopEntries []TopEntry
+
+// Top returns the limit most played tracks, artists or albums in period depending on by, skipping the first offset.
+// It returns an error if by is not one of TopByTrack, TopByArtist or TopByAlbum.
+func Top(db *pop.Connection, by string, period Period, limit, offset int) (TopEntries, error) {
+	switch by {
+	case TopByTrack:
+		return TopTracks(db, period, limit, offset)
+	case TopByArtist:
+		return TopArtists(db, period, limit, offset)
+	case TopByAlbum:
+		return TopAlbums(db, period, limit, offset)
+	}
+	return nil, fmt.Errorf("unknown top list %q, expected %s, %s or %s", by, TopByTrack, TopByArtist, TopByAlbum)
+}
+
+// TopTracks returns the limit most played tracks in period, skipping the first offset.
+func TopTracks(db *pop.Connection, period Period, limit, offset int) (TopEntries, error) {
+	return top(db, "tracks t ON t.id = h.track_id", "t", period, limit, offset)
+}
+
+// TopArtists returns the limit most played artists in period, skipping the first offset.
+// A play of a track with several artists counts for each of them.
+func TopArtists(db *pop.Connection, period Period, limit, offset int) (TopEntries, error) {
+	return top(db, "artists_tracks at ON at.track_id = h.track_id JOIN artists a ON a.id = at.artist_id", "a", period, limit, offset)
+}
+
+// TopAlbums returns the limit most played albums in period, skipping the first offset.
+// Tracks without a known album are ignored.
+func TopAlbums(db *pop.Connection, period Period, limit, offset int) (TopEntries, error) {
+	return top(db, "tracks t ON t.id = h.track_id JOIN albums al ON al.id = t.album_id", "al", period, limit, offset)
+}
+
+// top counts the history entries joined with join per row of the table with alias table.
+func top(db *pop.Connection, join, table string, period Period, limit, offset int) (TopEntries, error) {
+	where, args := period.where()
+	query := fmt.Sprintf(`SELECT %[1]s.id AS id, %[1]s.name AS name, COUNT(*) AS plays
+		FROM history_entries h JOIN %[2]s
+		WHERE %[3]s
+		GROUP BY %[1]s.id, %[1]s.name
+		ORDER BY plays DESC, name ASC, %[1]s.id ASC
+		LIMIT ? OFFSET ?`, table, join, where)
+
+	entries := TopEntries{}
+	err := db.RawQuery(query, append(args, limit, offset)...).All(&entries)
+	return entries, err
+}
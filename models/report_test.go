@@ -0,0 +1,100 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var reportStart = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// createReportFixtures inserts two albums with three tracks by two artists and their plays in March 2021.
+func createReportFixtures(t *testing.T) {
+	_ = testDB.TruncateAll()
+
+	albums := Albums{{ID: "al_id1", Name: "al_name1"}, {ID: "al_id2", Name: "al_name2"}}
+	tracks := Tracks{
+		{ID: "t_id1", Name: "t_name1", AlbumID: nulls.NewString("al_id1"), DurationMs: 200000},
+		{ID: "t_id2", Name: "t_name2", AlbumID: nulls.NewString("al_id1"), DurationMs: 100000},
+		{ID: "t_id3", Name: "t_name3", AlbumID: nulls.NewString("al_id2"), DurationMs: 300000},
+	}
+	artists := Artists{{ID: "a_id1", Name: "a_name1"}, {ID: "a_id2", Name: "a_name2"}}
+	connections := ArtistsTracks{
+		{ArtistID: "a_id1", TrackID: "t_id1"},
+		{ArtistID: "a_id1", TrackID: "t_id2"},
+		{ArtistID: "a_id2", TrackID: "t_id2"},
+		{ArtistID: "a_id2", TrackID: "t_id3"},
+	}
+	history := HistoryEntries{
+		{TrackID: nulls.NewString("t_id1"), PlayedAt: reportStart},
+		{TrackID: nulls.NewString("t_id1"), PlayedAt: reportStart.Add(time.Hour)},
+		{TrackID: nulls.NewString("t_id1"), PlayedAt: reportStart.Add(24 * time.Hour), MsPlayed: nulls.NewInt(50000)},
+		{TrackID: nulls.NewString("t_id2"), PlayedAt: reportStart.Add(24 * time.Hour)},
+		{TrackID: nulls.NewString("t_id3"), PlayedAt: reportStart.Add(10 * 24 * time.Hour)},
+		{TrackID: nulls.NewString("t_id3"), PlayedAt: reportStart.Add(40 * 24 * time.Hour)},
+	}
+	for _, models := range []interface{}{&albums, &tracks, &artists, &connections, &history} {
+		err := testDB.Create(models)
+		assert.NoError(t, err)
+	}
+}
+
+func TestTop(t *testing.T) {
+	createReportFixtures(t)
+
+	t.Run("Tracks", func(t *testing.T) {
+		entries, err := Top(testDB, TopByTrack, Period{}, 10, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, TopEntries{
+			{ID: "t_id1", Name: "t_name1", Plays: 3},
+			{ID: "t_id3", Name: "t_name3", Plays: 2},
+			{ID: "t_id2", Name: "t_name2", Plays: 1},
+		}, entries)
+	})
+
+	t.Run("Artists", func(t *testing.T) {
+		entries, err := Top(testDB, TopByArtist, Period{}, 10, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, TopEntries{
+			{ID: "a_id1", Name: "a_name1", Plays: 4},
+			{ID: "a_id2", Name: "a_name2", Plays: 3},
+		}, entries)
+	})
+
+	t.Run("Albums", func(t *testing.T) {
+		entries, err := Top(testDB, TopByAlbum, Period{}, 1, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, TopEntries{{ID: "al_id1", Name: "al_name1", Plays: 4}}, entries)
+	})
+
+	t.Run("Period", func(t *testing.T) {
+		entries, err := Top(testDB, TopByTrack, Period{
+			Since: reportStart.Add(time.Minute),
+			Until: reportStart.Add(30 * 24 * time.Hour),
+		}, 10, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, TopEntries{
+			{ID: "t_id1", Name: "t_name1", Plays: 2},
+			{ID: "t_id2", Name: "t_name2", Plays: 1},
+			{ID: "t_id3", Name: "t_name3", Plays: 1},
+		}, entries)
+	})
+
+	t.Run("Offset", func(t *testing.T) {
+		entries, err := Top(testDB, TopByTrack, Period{}, 1, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, TopEntries{{ID: "t_id3", Name: "t_name3", Plays: 2}}, entries)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		entries, err := Top(testDB, TopByTrack, Period{Since: reportStart.AddDate(1, 0, 0)}, 10, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, TopEntries{}, entries)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := Top(testDB, "genre", Period{}, 10, 0)
+		assert.Error(t, err)
+	})
+}
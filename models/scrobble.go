@@ -0,0 +1,68 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"strings"
+	"time"
+)
+
+// Results of scrobbling a history entry.
+const (
+	// ScrobbleStatusScrobbled is the status of plays that were accepted
+	ScrobbleStatusScrobbled = "scrobbled"
+	// ScrobbleStatusIgnored is the status of plays that were ignored, e.g. because they are too old
+	ScrobbleStatusIgnored = "ignored"
+	// ScrobbleStatusFailed is the status of plays that could not be scrobbled and are retried
+	ScrobbleStatusFailed = "failed"
+)
+
+// ScrobblePlay is a played track with the names needed to scrobble it.
+type ScrobblePlay struct {
+	ID         int          `db:"id"`
+	PlayedAt   time.Time    `db:"played_at"`
+	TrackName  string       `db:"track_name"`
+	AlbumName  nulls.String `db:"album_name"`
+	ArtistName nulls.String `db:"artist_name"`
+	DurationMs int          `db:"duration_ms"`
+}
+
+// PendingScrobbles returns up to limit played tracks since the given time that were not scrobbled yet or failed,
+// the oldest first. The first artist of a track is returned as its artist.
+func PendingScrobbles(db *pop.Connection, since time.Time, limit int) ([]ScrobblePlay, error) {
+	plays := []ScrobblePlay{}
+	err := db.RawQuery(`SELECT h.id AS id, h.played_at AS played_at, t.name AS track_name, al.name AS album_name,
+			(SELECT a.name FROM artists_tracks at JOIN artists a ON a.id = at.artist_id
+				WHERE at.track_id = t.id ORDER BY at.id LIMIT 1) AS artist_name,
+			t.duration_ms AS duration_ms
+		FROM history_entries h
+		JOIN tracks t ON t.id = h.track_id
+		LEFT JOIN albums al ON al.id = t.album_id
+		WHERE h.played_at >= ? AND (h.scrobble_status IS NULL OR h.scrobble_status = ?)
+		ORDER BY h.played_at ASC, h.id ASC
+		LIMIT ?`, since, ScrobbleStatusFailed, limit).All(&plays)
+	return plays, err
+}
+
+// SetScrobbleStatus sets the scrobble status of the history entries with ids.
+func SetScrobbleStatus(db *pop.Connection, status string, ids ...int) error {
+	return setHistoryStatus(db, "scrobble_status", status, ids)
+}
+
+// setHistoryStatus sets column of the history entries with ids to status.
+func setHistoryStatus(db *pop.Connection, column, status string, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, status)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return db.RawQuery("UPDATE history_entries SET "+column+" = ? WHERE id IN ("+placeholders(len(ids))+")", args...).Exec()
+}
+
+// placeholders returns n comma separated bind variables for an IN clause.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPendingScrobbles(t *testing.T) {
+	createReportFixtures(t)
+
+	plays, err := PendingScrobbles(testDB, reportStart, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(plays))
+	assert.Equal(t, reportStart, plays[0].PlayedAt.UTC())
+	assert.Equal(t, "t_name1", plays[0].TrackName)
+	assert.Equal(t, "al_name1", plays[0].AlbumName.String)
+	assert.Equal(t, "a_name1", plays[0].ArtistName.String)
+	assert.Equal(t, 200000, plays[0].DurationMs)
+	assert.Equal(t, "a_name1", plays[3].ArtistName.String)
+
+	assert.NoError(t, SetScrobbleStatus(testDB, ScrobbleStatusScrobbled, plays[0].ID, plays[1].ID))
+	assert.NoError(t, SetScrobbleStatus(testDB, ScrobbleStatusIgnored, plays[2].ID))
+	assert.NoError(t, SetScrobbleStatus(testDB, ScrobbleStatusFailed, plays[3].ID))
+	assert.NoError(t, SetScrobbleStatus(testDB, ScrobbleStatusFailed))
+
+	pending, err := PendingScrobbles(testDB, reportStart, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{plays[3].ID, plays[4].ID}, []int{pending[0].ID, pending[1].ID})
+
+	pending, err = PendingScrobbles(testDB, reportStart.Add(20*24*time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pending))
+
+	var entry HistoryEntry
+	assert.NoError(t, testDB.Find(&entry, plays[0].ID))
+	assert.Equal(t, nulls.NewString(ScrobbleStatusScrobbled), entry.ScrobbleStatus)
+
+	assert.NoError(t, testDB.Create(&Episode{ID: "e_id", Name: "e_name"}))
+	assert.NoError(t, testDB.Create(&HistoryEntry{EpisodeID: nulls.NewString("e_id"), PlayedAt: reportStart}))
+	pending, err = PendingScrobbles(testDB, reportStart, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(pending))
+}
@@ -0,0 +1,12 @@
+package models
+
+// Show is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// It is a podcast show that has episodes.
+type Show struct {
+	ID        string `json:"id" db:"id"`
+	Name      string `json:"name" db:"name"`
+	Publisher string `json:"publisher" db:"publisher"`
+}
+
+// Shows is not required by pop and may be deleted
+type Shows []Show
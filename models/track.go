@@ -1,12 +1,27 @@
 package models
 
+import (
+	"github.com/gobuffalo/nulls"
+)
+
 // Track is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// EnrichedAt is the time the full track including its album was fetched from Spotify. It is null if it was never fetched.
+// ISRC is the International Standard Recording Code of the track, saved with the album. It is empty if Spotify has none
+// and null if it was never fetched.
+// MBID is the MusicBrainz recording ID of the track, known from imported scrobbles.
+// AudioFeaturesCheckedAt is the time audio features were requested from Spotify. It is null if they were never requested.
 type Track struct {
-	ID          string `json:"id" db:"id"`
-	Name        string `json:"name" db:"name"`
-	TrackNumber int    `json:"track_number" db:"track_number"`
-	DiscNumber  int    `json:"disc_number" db:"disc_number"`
-	Explicit    bool   `json:"explicit" db:"explicit"`
+	ID                     string       `json:"id" db:"id"`
+	Name                   string       `json:"name" db:"name"`
+	AlbumID                nulls.String `json:"album_id" db:"album_id"`
+	TrackNumber            int          `json:"track_number" db:"track_number"`
+	DiscNumber             int          `json:"disc_number" db:"disc_number"`
+	Explicit               bool         `json:"explicit" db:"explicit"`
+	DurationMs             int          `json:"duration_ms" db:"duration_ms"`
+	ISRC                   nulls.String `json:"isrc" db:"isrc"`
+	MBID                   nulls.String `json:"mbid" db:"mbid"`
+	EnrichedAt             nulls.Time   `json:"enriched_at" db:"enriched_at"`
+	AudioFeaturesCheckedAt nulls.Time   `json:"audio_features_checked_at" db:"audio_features_checked_at"`
 }
 
 // Tracks is not required by pop and may be deleted
@@ -0,0 +1,22 @@
+package models
+
+// TrackAudioFeature is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// It holds the acoustic attributes Spotify computed for a track. ID is the ID of the track.
+type TrackAudioFeature struct {
+	ID               string  `json:"track_id" db:"track_id"`
+	Acousticness     float32 `json:"acousticness" db:"acousticness"`
+	Danceability     float32 `json:"danceability" db:"danceability"`
+	Energy           float32 `json:"energy" db:"energy"`
+	Instrumentalness float32 `json:"instrumentalness" db:"instrumentalness"`
+	PitchClass       int     `json:"pitch_class" db:"pitch_class"`
+	Liveness         float32 `json:"liveness" db:"liveness"`
+	Loudness         float32 `json:"loudness" db:"loudness"`
+	Mode             int     `json:"mode" db:"mode"`
+	Speechiness      float32 `json:"speechiness" db:"speechiness"`
+	Tempo            float32 `json:"tempo" db:"tempo"`
+	TimeSignature    int     `json:"time_signature" db:"time_signature"`
+	Valence          float32 `json:"valence" db:"valence"`
+}
+
+// TrackAudioFeatures is not required by pop and may be deleted
+type TrackAudioFeatures []TrackAudioFeature
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/gobuffalo/nulls"
+	"time"
+)
+
+// WebhookDelivery is used by pop to map your .model.Name.Proper.Pluralize.Underscore database table to your go code.
+// It is a payload in the outbox of a webhook target. Deliveries are retried at NextAttemptAt until the target accepts
+// them at DeliveredAt or they are given up at FailedAt.
+type WebhookDelivery struct {
+	ID            int          `json:"id" db:"id"`
+	Target        string       `json:"target" db:"target"`
+	Event         string       `json:"event" db:"event"`
+	Payload       string       `json:"payload" db:"payload"`
+	Attempts      int          `json:"attempts" db:"attempts"`
+	NextAttemptAt time.Time    `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError     nulls.String `json:"last_error" db:"last_error"`
+	DeliveredAt   nulls.Time   `json:"delivered_at" db:"delivered_at"`
+	FailedAt      nulls.Time   `json:"failed_at" db:"failed_at"`
+	CreatedAt     time.Time    `json:"created_at" db:"created_at"`
+}
+
+// WebhookDeliveries is not required by pop and may be deleted
+type WebhookDeliveries []WebhookDelivery
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// wrappedTopLimit is the number of top tracks, artists and albums of a Wrapped summary
+	wrappedTopLimit = 10
+	// wrappedStreakLimit is the number of streaks of a Wrapped summary
+	wrappedStreakLimit = 3
+)
+
+// FirstPlay is an artist with the time it was played for the first time.
+type FirstPlay struct {
+	ID            string    `json:"id" db:"id"`
+	Name          string    `json:"name" db:"name"`
+	FirstPlayedAt time.Time `json:"first_played_at" db:"first_played_at"`
+}
+
+// Streak is a number of consecutive days with at least one play.
+type Streak struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Days  int       `json:"days"`
+}
+
+// Wrapped is a summary of the listening history of a year.
+type Wrapped struct {
+	Year       int                   `json:"year"`
+	Total      ListeningTime         `json:"total"`
+	TopTracks  TopEntries            `json:"top_tracks"`
+	TopArtists TopEntries            `json:"top_artists"`
+	TopAlbums  TopEntries            `json:"top_albums"`
+	Days       []PeriodListeningTime `json:"days"`
+	// MostPlayedDay is the day with the longest listening time. It is nil if nothing was played in the year.
+	MostPlayedDay *PeriodListeningTime `json:"most_played_day"`
+	// NewArtists are the artists first played in the year sorted by their first play
+	NewArtists []FirstPlay `json:"new_artists"`
+	Streaks    []Streak    `json:"streaks"`
+}
+
+// NewWrapped computes the Wrapped summary of year. Days are computed in loc.
+func NewWrapped(db *pop.Connection, year int, loc *time.Location) (*Wrapped, error) {
+	period := Period{
+		Since: time.Date(year, 1, 1, 0, 0, 0, 0, loc),
+		Until: time.Date(year+1, 1, 1, 0, 0, 0, 0, loc),
+	}
+	w := &Wrapped{Year: year}
+
+	var err error
+	w.TopTracks, err = TopTracks(db, period, wrappedTopLimit, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not get top tracks: %v", err)
+	}
+	w.TopArtists, err = TopArtists(db, period, wrappedTopLimit, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not get top artists: %v", err)
+	}
+	w.TopAlbums, err = TopAlbums(db, period, wrappedTopLimit, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not get top albums: %v", err)
+	}
+	w.Days, err = ListeningTimeByPeriod(db, UnitDay, period, loc)
+	if err != nil {
+		return nil, fmt.Errorf("could not get listening time: %v", err)
+	}
+	w.NewArtists, err = NewArtists(db, period)
+	if err != nil {
+		return nil, fmt.Errorf("could not get new artists: %v", err)
+	}
+
+	for i, day := range w.Days {
+		w.Total.Plays += day.Plays
+		w.Total.MsPlayed += day.MsPlayed
+		if w.MostPlayedDay == nil || day.MsPlayed > w.MostPlayedDay.MsPlayed {
+			w.MostPlayedDay = &w.Days[i]
+		}
+	}
+	w.Streaks = LongestStreaks(w.Days, wrappedStreakLimit)
+	return w, nil
+}
+
+// NewArtists returns the artists that were played for the first time in period sorted by their first play.
+func NewArtists(db *pop.Connection, period Period) ([]FirstPlay, error) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if !period.Since.IsZero() {
+		conditions = append(conditions, "MIN(h.played_at) >= ?")
+		args = append(args, period.Since)
+	}
+	if !period.Until.IsZero() {
+		conditions = append(conditions, "MIN(h.played_at) < ?")
+		args = append(args, period.Until)
+	}
+	query := fmt.Sprintf(`SELECT a.id AS id, a.name AS name, MIN(h.played_at) AS first_played_at
+		FROM history_entries h JOIN artists_tracks at ON at.track_id = h.track_id JOIN artists a ON a.id = at.artist_id
+		GROUP BY a.id, a.name
+		HAVING %s
+		ORDER BY first_played_at ASC, name ASC`, strings.Join(conditions, " AND "))
+
+	artists := []FirstPlay{}
+	err := db.RawQuery(query, args...).All(&artists)
+	return artists, err
+}
+
+// LongestStreaks returns the limit longest streaks of consecutive days in days sorted by length descending.
+// days must be sorted ascending like the result of ListeningTimeByPeriod with UnitDay.
+func LongestStreaks(days []PeriodListeningTime, limit int) []Streak {
+	streaks := []Streak{}
+	for _, day := range days {
+		last := len(streaks) - 1
+		if last >= 0 && streaks[last].End.AddDate(0, 0, 1).Equal(day.Start) {
+			streaks[last].End = day.Start
+			streaks[last].Days++
+			continue
+		}
+		streaks = append(streaks, Streak{Start: day.Start, End: day.Start, Days: 1})
+	}
+
+	sort.SliceStable(streaks, func(i, j int) bool {
+		return streaks[i].Days > streaks[j].Days
+	})
+	if len(streaks) > limit {
+		streaks = streaks[:limit]
+	}
+	return streaks
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewWrapped(t *testing.T) {
+	createReportFixtures(t)
+
+	w, err := NewWrapped(testDB, 2021, time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, 2021, w.Year)
+	assert.Equal(t, ListeningTime{Plays: 6, MsPlayed: 1150000}, w.Total)
+	assert.Equal(t, "t_id1", w.TopTracks[0].ID)
+	assert.Equal(t, "a_id1", w.TopArtists[0].ID)
+	assert.Equal(t, "al_id1", w.TopAlbums[0].ID)
+	assert.Equal(t, 4, len(w.Days))
+	assert.Equal(t, "2021-03-01", w.MostPlayedDay.Label)
+	assert.Equal(t, 2, len(w.NewArtists))
+	assert.Equal(t, Streak{
+		Start: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC),
+		Days:  2,
+	}, w.Streaks[0])
+
+	w, err = NewWrapped(testDB, 2020, time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, ListeningTime{}, w.Total)
+	assert.Nil(t, w.MostPlayedDay)
+	assert.Empty(t, w.Streaks)
+}
+
+func TestNewArtists(t *testing.T) {
+	createReportFixtures(t)
+
+	artists, err := NewArtists(testDB, Period{Since: reportStart.Add(time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, []FirstPlay{{ID: "a_id2", Name: "a_name2", FirstPlayedAt: reportStart.Add(24 * time.Hour)}}, artists)
+
+	artists, err = NewArtists(testDB, Period{Until: reportStart.Add(time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(artists))
+	assert.Equal(t, "a_id1", artists[0].ID)
+}
+
+func TestLongestStreaks(t *testing.T) {
+	day := func(d int) PeriodListeningTime {
+		return PeriodListeningTime{Start: time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC)}
+	}
+	days := []PeriodListeningTime{day(1), day(3), day(4), day(5), day(7), day(8), day(20)}
+
+	streaks := LongestStreaks(days, 2)
+	assert.Equal(t, []Streak{
+		{Start: day(3).Start, End: day(5).Start, Days: 3},
+		{Start: day(7).Start, End: day(8).Start, Days: 2},
+	}, streaks)
+
+	assert.Empty(t, LongestStreaks(nil, 3))
+}
@@ -0,0 +1,260 @@
+// Package mqtt publishes new plays and the currently playing track to an MQTT broker, with Home Assistant discovery.
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// Payloads of the availability topic.
+const (
+	// Online is published when the publisher connected
+	Online = "online"
+	// Offline is published when the publisher is closed and by the broker when the connection is lost
+	Offline = "offline"
+)
+
+// States of NowPlaying.
+const (
+	// StatePlaying is the state of a playing track or episode
+	StatePlaying = "playing"
+	// StatePaused is the state of a paused track or episode
+	StatePaused = "paused"
+	// StateIdle is the state if nothing is played
+	StateIdle = "idle"
+)
+
+const (
+	// connectTimeout is the maximum time of connecting to the broker
+	connectTimeout = 10 * time.Second
+	// publishTimeout is the maximum time of publishing a message
+	publishTimeout = 10 * time.Second
+	// disconnectQuiesce is the time in milliseconds pending messages may take to be sent when the publisher is closed
+	disconnectQuiesce = 250
+	// deviceName is the name of the Home Assistant device all sensors belong to
+	deviceName = "Spotify History Saver"
+)
+
+// nodeIDPattern matches the characters Home Assistant does not allow in node IDs
+var nodeIDPattern = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// Topics are the topics messages are published to. An empty topic disables its messages.
+type Topics struct {
+	// Plays receives every new play
+	Plays string
+	// LastPlayed holds the latest play as retained message
+	LastPlayed string
+	// NowPlaying holds the current player state as retained message
+	NowPlaying string
+	// Availability holds Online or Offline as retained message
+	Availability string
+}
+
+// Options configure the connection of a Publisher.
+type Options struct {
+	// Broker is the URL of the broker, e.g. tcp://localhost:1883 or ssl://broker:8883
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	QoS      byte
+	Topics   Topics
+}
+
+// Play is the payload of a saved play of a track.
+type Play struct {
+	ID       int       `json:"id"`
+	PlayedAt time.Time `json:"played_at"`
+	TrackID  string    `json:"track_id"`
+	Track    string    `json:"track"`
+	// Artists are the names of all artists separated by commas
+	Artists    string `json:"artists"`
+	Album      string `json:"album,omitempty"`
+	URL        string `json:"url,omitempty"`
+	DurationMs int    `json:"duration_ms"`
+}
+
+// NowPlaying is the payload of the player state. All fields but State and UpdatedAt are empty if it is StateIdle.
+// Episodes have the name of their show as Album.
+type NowPlaying struct {
+	State string `json:"state"`
+	// ItemID is the ID of the track or episode
+	ItemID     string    `json:"item_id,omitempty"`
+	Track      string    `json:"track,omitempty"`
+	Artists    string    `json:"artists,omitempty"`
+	Album      string    `json:"album,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	DurationMs int       `json:"duration_ms,omitempty"`
+	ProgressMs int       `json:"progress_ms,omitempty"`
+	Device     string    `json:"device,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Discovery is the Home Assistant discovery payload of a sensor.
+type Discovery struct {
+	Name                string          `json:"name"`
+	UniqueID            string          `json:"unique_id"`
+	StateTopic          string          `json:"state_topic"`
+	ValueTemplate       string          `json:"value_template"`
+	JSONAttributesTopic string          `json:"json_attributes_topic,omitempty"`
+	AvailabilityTopic   string          `json:"availability_topic,omitempty"`
+	Icon                string          `json:"icon,omitempty"`
+	Device              DiscoveryDevice `json:"device"`
+}
+
+// DiscoveryDevice is the Home Assistant device of a sensor.
+type DiscoveryDevice struct {
+	Identifiers []string `json:"identifiers"`
+	Name        string   `json:"name"`
+}
+
+// Publisher publishes plays and player states to a broker. It reconnects if the connection is lost.
+type Publisher struct {
+	client paho.Client
+	opts   Options
+	// connects counts the connections to the broker including reconnects
+	connects int32
+}
+
+// Connect connects a Publisher to the broker of opts. The broker publishes Offline to the availability topic
+// if the connection is lost.
+func Connect(opts Options) (*Publisher, error) {
+	p := &Publisher{opts: opts}
+	clientOpts := paho.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetProtocolVersion(4).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectTimeout(connectTimeout).
+		SetOnConnectHandler(func(_ paho.Client) {
+			// The first connection publishes it in Connect. Errors of reconnects are not reported,
+			// the next reconnect publishes it again.
+			if atomic.AddInt32(&p.connects, 1) > 1 {
+				_ = p.publishRaw(opts.Topics.Availability, true, []byte(Online))
+			}
+		})
+	if opts.Topics.Availability != "" {
+		clientOpts.SetWill(opts.Topics.Availability, Offline, opts.QoS, true)
+	}
+
+	p.client = paho.NewClient(clientOpts)
+	token := p.client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		return nil, fmt.Errorf("timed out connecting to %s", opts.Broker)
+	}
+	if token.Error() != nil {
+		return nil, token.Error()
+	}
+	err := p.publishRaw(opts.Topics.Availability, true, []byte(Online))
+	if err != nil {
+		p.client.Disconnect(disconnectQuiesce)
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close publishes Offline to the availability topic and disconnects.
+func (p *Publisher) Close() {
+	_ = p.publishRaw(p.opts.Topics.Availability, true, []byte(Offline))
+	p.client.Disconnect(disconnectQuiesce)
+}
+
+// PublishPlays publishes every play to the plays topic and the last one to the last played topic.
+// plays have to be ordered by PlayedAt.
+func (p *Publisher) PublishPlays(plays []Play) error {
+	if len(plays) == 0 {
+		return nil
+	}
+	for _, play := range plays {
+		err := p.publish(p.opts.Topics.Plays, false, play)
+		if err != nil {
+			return err
+		}
+	}
+	return p.publish(p.opts.Topics.LastPlayed, true, plays[len(plays)-1])
+}
+
+// PublishNowPlaying publishes the player state to the now playing topic.
+func (p *Publisher) PublishNowPlaying(nowPlaying NowPlaying) error {
+	return p.publish(p.opts.Topics.NowPlaying, true, nowPlaying)
+}
+
+// PublishDiscovery publishes the Home Assistant discovery payloads of the sensors below prefix,
+// e.g. homeassistant. The now playing sensors are removed if nowPlaying is false.
+func (p *Publisher) PublishDiscovery(prefix string, nowPlaying bool) error {
+	nodeID := nodeIDPattern.ReplaceAllString(p.opts.ClientID, "_")
+	device := DiscoveryDevice{Identifiers: []string{nodeID}, Name: deviceName}
+	sensor := func(objectID, name, topic, valueTemplate, icon string) Discovery {
+		return Discovery{
+			Name:                name,
+			UniqueID:            nodeID + "_" + objectID,
+			StateTopic:          topic,
+			ValueTemplate:       valueTemplate,
+			JSONAttributesTopic: topic,
+			AvailabilityTopic:   p.opts.Topics.Availability,
+			Icon:                icon,
+			Device:              device,
+		}
+	}
+
+	sensors := []struct {
+		objectID string
+		enabled  bool
+		config   Discovery
+	}{
+		{"last_played", p.opts.Topics.LastPlayed != "",
+			sensor("last_played", "Last played", p.opts.Topics.LastPlayed, "{{ value_json.track }}", "mdi:history")},
+		{"now_playing", nowPlaying && p.opts.Topics.NowPlaying != "",
+			sensor("now_playing", "Now playing", p.opts.Topics.NowPlaying, "{{ value_json.track | default('') }}", "mdi:music")},
+		{"playback_state", nowPlaying && p.opts.Topics.NowPlaying != "",
+			sensor("playback_state", "Playback state", p.opts.Topics.NowPlaying, "{{ value_json.state }}", "mdi:play-pause")},
+	}
+	for _, s := range sensors {
+		topic := fmt.Sprintf("%s/sensor/%s/%s/config", prefix, nodeID, s.objectID)
+		var err error
+		if s.enabled {
+			err = p.publish(topic, true, s.config)
+		} else {
+			// An empty retained message removes the sensor
+			err = p.publishRaw(topic, true, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publish publishes v as JSON to topic. It does nothing if topic is empty.
+func (p *Publisher) publish(topic string, retained bool, v interface{}) error {
+	if topic == "" {
+		return nil
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return p.publishRaw(topic, retained, payload)
+}
+
+// publishRaw publishes payload to topic and waits until the broker received it. It does nothing if topic is empty.
+func (p *Publisher) publishRaw(topic string, retained bool, payload []byte) error {
+	if topic == "" {
+		return nil
+	}
+	token := p.client.Publish(topic, p.opts.QoS, retained, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("could not publish to %s: %v", topic, token.Error())
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package mqtt
+
+import (
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"net"
+	"sync"
+)
+
+// Message is a message received by a StandIn.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+	QoS      byte
+}
+
+// StandIn is an embedded MQTT 3.1.1 broker for tests. It records all published messages and keeps the retained
+// ones, including the will of clients that lost their connection. It does not deliver messages to subscribers.
+type StandIn struct {
+	listener net.Listener
+	username string
+	password string
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	messages []Message
+	retained map[string]Message
+}
+
+// NewStandIn starts a StandIn on a free local port. Clients have to log in with username and password if
+// username is set. Close it after use.
+func NewStandIn(username, password string) *StandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := &StandIn{
+		listener: listener,
+		username: username,
+		password: password,
+		conns:    map[net.Conn]bool{},
+		retained: map[string]Message{},
+	}
+	go s.serve()
+	return s
+}
+
+// Broker returns the URL of the stand-in.
+func (s *StandIn) Broker() string {
+	return "tcp://" + s.listener.Addr().String()
+}
+
+// Messages returns all published messages including the wills of lost connections.
+func (s *StandIn) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Retained returns the retained message of topic.
+func (s *StandIn) Retained(topic string) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.retained[topic]
+	return m, ok
+}
+
+// Drop closes all client connections as if the network failed.
+func (s *StandIn) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+// Close stops the stand-in and closes all client connections.
+func (s *StandIn) Close() {
+	_ = s.listener.Close()
+	s.Drop()
+}
+
+func (s *StandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// handle answers the packets of a client until it disconnects. The will is published if the connection is lost.
+func (s *StandIn) handle(conn net.Conn) {
+	var will *Message
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		if will != nil {
+			s.publish(*will)
+		}
+		_ = conn.Close()
+	}()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var reply packets.ControlPacket
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			if s.username != "" && (p.Username != s.username || string(p.Password) != s.password) {
+				connack.ReturnCode = packets.ErrRefusedBadUsernameOrPassword
+				_ = connack.Write(conn)
+				return
+			}
+			if p.WillFlag && p.WillRetain {
+				will = &Message{Topic: p.WillTopic, Payload: p.WillMessage, Retained: true, QoS: p.WillQos}
+			}
+			reply = connack
+		case *packets.PublishPacket:
+			s.publish(Message{Topic: p.TopicName, Payload: p.Payload, Retained: p.Retain, QoS: p.Qos})
+			switch p.Qos {
+			case 1:
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				reply = puback
+			case 2:
+				pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				pubrec.MessageID = p.MessageID
+				reply = pubrec
+			}
+		case *packets.PubrelPacket:
+			pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			pubcomp.MessageID = p.MessageID
+			reply = pubcomp
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			will = nil
+			return
+		}
+		if reply != nil {
+			err = reply.Write(conn)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *StandIn) publish(m Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, m)
+	if m.Retained {
+		s.retain(m)
+	}
+}
+
+// retain keeps m as retained message of its topic, an empty payload removes it. s.mu has to be locked.
+func (s *StandIn) retain(m Message) {
+	if len(m.Payload) == 0 {
+		delete(s.retained, m.Topic)
+		return
+	}
+	s.retained[m.Topic] = m
+}
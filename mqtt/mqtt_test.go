@@ -0,0 +1,158 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var testTopics = Topics{
+	Plays:        "spotify/plays",
+	LastPlayed:   "spotify/last_played",
+	NowPlaying:   "spotify/now_playing",
+	Availability: "spotify/availability",
+}
+
+func connect(t *testing.T, standIn *StandIn, clientID string) *Publisher {
+	p, err := Connect(Options{
+		Broker:   standIn.Broker(),
+		ClientID: clientID,
+		Username: "user",
+		Password: "password",
+		QoS:      1,
+		Topics:   testTopics,
+	})
+	assert.NoError(t, err)
+	return p
+}
+
+func TestConnect(t *testing.T) {
+	standIn := NewStandIn("user", "password")
+	defer standIn.Close()
+
+	p := connect(t, standIn, "test")
+	availability, ok := standIn.Retained(testTopics.Availability)
+	assert.True(t, ok)
+	assert.Equal(t, Online, string(availability.Payload))
+
+	p.Close()
+	availability, _ = standIn.Retained(testTopics.Availability)
+	assert.Equal(t, Offline, string(availability.Payload))
+
+	_, err := Connect(Options{Broker: standIn.Broker(), ClientID: "test", Username: "user", Password: "wrong", Topics: testTopics})
+	assert.EqualError(t, err, "bad user name or password")
+}
+
+func TestConnect_will(t *testing.T) {
+	standIn := NewStandIn("user", "password")
+	defer standIn.Close()
+
+	p := connect(t, standIn, "test")
+	defer p.Close()
+	standIn.Drop()
+
+	assert.Eventually(t, func() bool {
+		for _, m := range standIn.Messages() {
+			if m.Topic == testTopics.Availability && string(m.Payload) == Offline && m.Retained {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+	// The publisher reconnects and is online again
+	assert.Eventually(t, func() bool {
+		availability, _ := standIn.Retained(testTopics.Availability)
+		return string(availability.Payload) == Online
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPublisher_PublishPlays(t *testing.T) {
+	standIn := NewStandIn("user", "password")
+	defer standIn.Close()
+	p := connect(t, standIn, "test")
+	defer p.Close()
+
+	playedAt := time.Date(2021, 3, 20, 9, 58, 0, 0, time.UTC)
+	plays := []Play{
+		{ID: 1, PlayedAt: playedAt, TrackID: "t_id_1", Track: "t_name_1", Artists: "a_name_1, a_name_2", DurationMs: 120000},
+		{ID: 2, PlayedAt: playedAt.Add(2 * time.Minute), TrackID: "t_id_2", Track: "t_name_2", Artists: "a_name_1",
+			Album: "al_name", URL: "https://open.spotify.com/track/t_id_2", DurationMs: 180000},
+	}
+	assert.NoError(t, p.PublishPlays(plays))
+	assert.NoError(t, p.PublishPlays(nil))
+
+	var published []Play
+	for _, m := range standIn.Messages() {
+		if m.Topic == testTopics.Plays {
+			var play Play
+			assert.NoError(t, json.Unmarshal(m.Payload, &play))
+			assert.False(t, m.Retained)
+			assert.Equal(t, byte(1), m.QoS)
+			published = append(published, play)
+		}
+	}
+	assert.Equal(t, plays, published)
+
+	lastPlayed, ok := standIn.Retained(testTopics.LastPlayed)
+	assert.True(t, ok)
+	assert.JSONEq(t, `{"id": 2, "played_at": "2021-03-20T10:00:00Z", "track_id": "t_id_2", "track": "t_name_2",
+		"artists": "a_name_1", "album": "al_name", "url": "https://open.spotify.com/track/t_id_2", "duration_ms": 180000}`,
+		string(lastPlayed.Payload))
+}
+
+func TestPublisher_PublishNowPlaying(t *testing.T) {
+	standIn := NewStandIn("user", "password")
+	defer standIn.Close()
+	p := connect(t, standIn, "test")
+	defer p.Close()
+
+	updatedAt := time.Date(2021, 3, 20, 10, 0, 0, 0, time.UTC)
+	assert.NoError(t, p.PublishNowPlaying(NowPlaying{State: StateIdle, UpdatedAt: updatedAt}))
+	nowPlaying, ok := standIn.Retained(testTopics.NowPlaying)
+	assert.True(t, ok)
+	assert.JSONEq(t, `{"state": "idle", "updated_at": "2021-03-20T10:00:00Z"}`, string(nowPlaying.Payload))
+
+	// Empty topics disable their messages
+	p.opts.Topics.NowPlaying = ""
+	assert.NoError(t, p.PublishNowPlaying(NowPlaying{State: StatePlaying, UpdatedAt: updatedAt}))
+	nowPlaying, _ = standIn.Retained(testTopics.NowPlaying)
+	assert.Contains(t, string(nowPlaying.Payload), StateIdle)
+}
+
+func TestPublisher_PublishDiscovery(t *testing.T) {
+	standIn := NewStandIn("user", "password")
+	defer standIn.Close()
+	p := connect(t, standIn, "spotify.history saver")
+	defer p.Close()
+
+	assert.NoError(t, p.PublishDiscovery("homeassistant", true))
+	m, ok := standIn.Retained("homeassistant/sensor/spotify_history_saver/last_played/config")
+	assert.True(t, ok)
+	var discovery Discovery
+	assert.NoError(t, json.Unmarshal(m.Payload, &discovery))
+	assert.Equal(t, Discovery{
+		Name:                "Last played",
+		UniqueID:            "spotify_history_saver_last_played",
+		StateTopic:          testTopics.LastPlayed,
+		ValueTemplate:       "{{ value_json.track }}",
+		JSONAttributesTopic: testTopics.LastPlayed,
+		AvailabilityTopic:   testTopics.Availability,
+		Icon:                "mdi:history",
+		Device:              DiscoveryDevice{Identifiers: []string{"spotify_history_saver"}, Name: "Spotify History Saver"},
+	}, discovery)
+	_, ok = standIn.Retained("homeassistant/sensor/spotify_history_saver/now_playing/config")
+	assert.True(t, ok)
+	m, ok = standIn.Retained("homeassistant/sensor/spotify_history_saver/playback_state/config")
+	assert.True(t, ok)
+	assert.Contains(t, string(m.Payload), `"value_template":"{{ value_json.state }}"`)
+
+	// Without now playing its sensors are removed
+	assert.NoError(t, p.PublishDiscovery("homeassistant", false))
+	_, ok = standIn.Retained("homeassistant/sensor/spotify_history_saver/last_played/config")
+	assert.True(t, ok)
+	_, ok = standIn.Retained("homeassistant/sensor/spotify_history_saver/now_playing/config")
+	assert.False(t, ok)
+	_, ok = standIn.Retained("homeassistant/sensor/spotify_history_saver/playback_state/config")
+	assert.False(t, ok)
+}
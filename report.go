@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/pop/v5"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// FormatTable writes reports as aligned text table
+	FormatTable = "table"
+	// FormatJSON writes reports as JSON
+	FormatJSON = "json"
+	// FormatCSV writes reports as CSV with header
+	FormatCSV = "csv"
+
+	// DateLayout is the layout of dates accepted by report flags
+	DateLayout = "2006-01-02"
+)
+
+// runReport runs the report given by args, e.g. "top --by artist", and writes it to out.
+func runReport(db *pop.Connection, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing report, expected top, time or wrapped")
+	}
+	switch args[0] {
+	case "top":
+		return reportTop(db, args[1:], out)
+	case "time":
+		return reportTime(db, args[1:], out)
+	case "wrapped":
+		return reportWrapped(db, args[1:], out)
+	}
+	return fmt.Errorf("unknown report %q, expected top, time or wrapped", args[0])
+}
+
+// reportTop writes the most played tracks, artists or albums.
+func reportTop(db *pop.Connection, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("report top", flag.ContinueOnError)
+	by := fs.String("by", models.TopByTrack, "by: list the most played track, artist or album")
+	limit := fs.Int("limit", 10, "limit: maximum number of entries")
+	since, until, format := reportFlags(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	period, err := parsePeriod(*since, *until)
+	if err != nil {
+		return err
+	}
+	entries, err := models.Top(db, *by, period, *limit, 0)
+	if err != nil {
+		return fmt.Errorf("could not get top list: %v", err)
+	}
+
+	rows := make([][]string, len(entries))
+	for i, e := range entries {
+		rows[i] = []string{strconv.Itoa(i + 1), e.ID, e.Name, strconv.Itoa(e.Plays)}
+	}
+	return writeReport(out, *format, []string{"rank", "id", *by, "plays"}, rows, entries)
+}
+
+// reportTime writes the listening time per day, week, month, year, artist or hour of the day.
+func reportTime(db *pop.Connection, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("report time", flag.ContinueOnError)
+	by := fs.String("by", models.UnitMonth, "by: sum listening time per day, week, month, year, artist or hour")
+	limit := fs.Int("limit", 10, "limit: maximum number of artists")
+	since, until, format := reportFlags(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	period, err := parsePeriod(*since, *until)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	var result interface{}
+	switch *by {
+	case "artist":
+		artists, err := models.ListeningTimeByArtist(db, period, *limit)
+		if err != nil {
+			return fmt.Errorf("could not get listening time: %v", err)
+		}
+		for _, a := range artists {
+			rows = append(rows, listeningTimeRow(a.Name, a.ListeningTime))
+		}
+		result = artists
+	case "hour":
+		hours, err := models.ListeningTimeByHour(db, period, time.Local)
+		if err != nil {
+			return fmt.Errorf("could not get listening time: %v", err)
+		}
+		for _, h := range hours {
+			rows = append(rows, listeningTimeRow(fmt.Sprintf("%02d:00", h.Hour), h.ListeningTime))
+		}
+		result = hours
+	default:
+		periods, err := models.ListeningTimeByPeriod(db, *by, period, time.Local)
+		if err != nil {
+			return fmt.Errorf("could not get listening time: %v", err)
+		}
+		for _, p := range periods {
+			rows = append(rows, listeningTimeRow(p.Label, p.ListeningTime))
+		}
+		result = periods
+	}
+	return writeReport(out, *format, []string{*by, "plays", "ms_played", "time"}, rows, result)
+}
+
+func listeningTimeRow(label string, l models.ListeningTime) []string {
+	return []string{label, strconv.Itoa(l.Plays), strconv.FormatInt(l.MsPlayed, 10), formatDuration(l.Duration())}
+}
+
+// formatDuration formats d in hours and minutes like "12h05m".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// reportFlags adds the flags shared by all reports to fs.
+func reportFlags(fs *flag.FlagSet) (since, until, format *string) {
+	since = fs.String("since", "", "since: only count plays on or after this date (YYYY-MM-DD)")
+	until = fs.String("until", "", "until: only count plays on or before this date (YYYY-MM-DD)")
+	format = fs.String("format", FormatTable, "format: output as table, json or csv")
+	return
+}
+
+// parsePeriod parses the dates of the since and until flags in local time. Both dates are included in the period.
+func parsePeriod(since, until string) (models.Period, error) {
+	var period models.Period
+	var err error
+	if since != "" {
+		period.Since, err = time.ParseInLocation(DateLayout, since, time.Local)
+		if err != nil {
+			return period, fmt.Errorf("invalid since date: %v", err)
+		}
+	}
+	if until != "" {
+		period.Until, err = time.ParseInLocation(DateLayout, until, time.Local)
+		if err != nil {
+			return period, fmt.Errorf("invalid until date: %v", err)
+		}
+		period.Until = period.Until.AddDate(0, 0, 1)
+	}
+	return period, nil
+}
+
+// writeReport writes header and rows as table or CSV, or v as JSON.
+func writeReport(out io.Writer, format string, header []string, rows [][]string, v interface{}) error {
+	switch format {
+	case FormatTable:
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		upper := make([]string, len(header))
+		for i, h := range header {
+			upper[i] = strings.ToUpper(h)
+		}
+		_, _ = fmt.Fprintln(w, strings.Join(upper, "\t"))
+		for _, row := range rows {
+			_, _ = fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	case FormatJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case FormatCSV:
+		w := csv.NewWriter(out)
+		_ = w.Write(header)
+		_ = w.WriteAll(rows)
+		return w.Error()
+	}
+	return fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatTable, FormatJSON, FormatCSV)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRunReport(t *testing.T) {
+	var out bytes.Buffer
+
+	err := runReport(DB, nil, &out)
+	assert.Error(t, err)
+
+	err = runReport(DB, []string{"unknown"}, &out)
+	assert.Contains(t, err.Error(), "unknown report")
+
+	err = runReport(DB, []string{"top", "--by", "genre"}, &out)
+	assert.Contains(t, err.Error(), "could not get top list:")
+
+	err = runReport(DB, []string{"top", "--since", "yesterday"}, &out)
+	assert.Contains(t, err.Error(), "invalid since date:")
+
+	err = runReport(DB, []string{"top", "--by", "artist", "--since", "2021-03-01", "--until", "2021-03-31", "--format", "csv"}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "rank,id,artist,plays\n", out.String())
+}
+
+func TestReportTime(t *testing.T) {
+	var out bytes.Buffer
+
+	err := runReport(DB, []string{"time", "--by", "decade"}, &out)
+	assert.Contains(t, err.Error(), "could not get listening time:")
+
+	err = runReport(DB, []string{"time", "--until", "tomorrow"}, &out)
+	assert.Contains(t, err.Error(), "invalid until date:")
+
+	for _, by := range []string{"day", "week", "month", "year", "artist"} {
+		out.Reset()
+		err = runReport(DB, []string{"time", "--by", by, "--since", "2021-03-01", "--format", "csv"}, &out)
+		assert.NoError(t, err)
+		assert.Equal(t, by+",plays,ms_played,time\n", out.String())
+	}
+
+	out.Reset()
+	err = runReport(DB, []string{"time", "--by", "hour", "--since", "2040-01-01", "--format", "csv"}, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "hour,plays,ms_played,time\n00:00,0,0,0h00m\n")
+}
+
+func TestFormatDuration(t *testing.T) {
+	assert.Equal(t, "0h00m", formatDuration(0))
+	assert.Equal(t, "1h05m", formatDuration(65*time.Minute+20*time.Second))
+	assert.Equal(t, "26h00m", formatDuration(26*time.Hour))
+}
+
+func TestParsePeriod(t *testing.T) {
+	period, err := parsePeriod("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Period{}, period)
+
+	period, err = parsePeriod("2021-03-01", "2021-03-31")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local), period.Since)
+	assert.Equal(t, time.Date(2021, 4, 1, 0, 0, 0, 0, time.Local), period.Until)
+
+	_, err = parsePeriod("", "31.03.2021")
+	assert.Contains(t, err.Error(), "invalid until date:")
+}
+
+func TestWriteReport(t *testing.T) {
+	header := []string{"rank", "id", "track", "plays"}
+	rows := [][]string{{"1", "t_id1", "t_name1", "12"}, {"2", "t_id2", "t_name, 2", "3"}}
+	entries := models.TopEntries{{ID: "t_id1", Name: "t_name1", Plays: 12}}
+
+	t.Run("Table", func(t *testing.T) {
+		var out bytes.Buffer
+		err := writeReport(&out, FormatTable, header, rows, entries)
+		assert.NoError(t, err)
+		assert.Equal(t, "RANK  ID     TRACK      PLAYS\n1     t_id1  t_name1    12\n2     t_id2  t_name, 2  3\n", out.String())
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var out bytes.Buffer
+		err := writeReport(&out, FormatJSON, header, rows, entries)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[{"id": "t_id1", "name": "t_name1", "plays": 12}]`, out.String())
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		var out bytes.Buffer
+		err := writeReport(&out, FormatCSV, header, rows, entries)
+		assert.NoError(t, err)
+		assert.Equal(t, "rank,id,track,plays\n1,t_id1,t_name1,12\n2,t_id2,\"t_name, 2\",3\n", out.String())
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		var out bytes.Buffer
+		err := writeReport(&out, "xml", header, rows, entries)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLimit is the page size used if a request has no limit
+	DefaultLimit = 50
+	// MaxLimit is the maximum page size
+	MaxLimit = 500
+)
+
+// Pagination describes the returned page of a list.
+type Pagination struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	Total  int `json:"total"`
+}
+
+// listResponse is the body of list requests.
+type listResponse struct {
+	Data       interface{} `json:"data"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// history returns the plays in the period given by since and until, the most recent first.
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	period, err := parsePeriod(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := parsePagination(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	plays, err := models.Plays(s.db, period, page.Limit, page.Offset)
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	page.Total, err = models.CountPlays(s.db, period)
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, listResponse{Data: plays, Pagination: &page})
+}
+
+// track returns the details of the track with the ID in the path.
+func (s *Server) track(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "tracks/")
+	if !ok {
+		return
+	}
+	details, err := models.FindTrackDetails(s.db, id)
+	if models.IsNotFound(err) {
+		writeError(w, http.StatusNotFound, "track not found")
+		return
+	}
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+// artist returns the details of the artist with the ID in the path.
+func (s *Server) artist(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "artists/")
+	if !ok {
+		return
+	}
+	details, err := models.FindArtistDetails(s.db, id, 10)
+	if models.IsNotFound(err) {
+		writeError(w, http.StatusNotFound, "artist not found")
+		return
+	}
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+// top returns the most played tracks, artists or albums in the period given by since and until.
+func (s *Server) top(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	period, err := parsePeriod(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := parsePagination(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	by := query.Get("by")
+	if by == "" {
+		by = models.TopByTrack
+	}
+	if by != models.TopByTrack && by != models.TopByArtist && by != models.TopByAlbum {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid by %q, expected track, artist or album", by))
+		return
+	}
+
+	entries, err := models.Top(s.db, by, period, page.Limit, page.Offset)
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, listResponse{Data: entries})
+}
+
+// listeningTime returns the listening time per day, week, month, year, hour of the day or artist
+// in the period given by since and until.
+func (s *Server) listeningTime(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	period, err := parsePeriod(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := parsePagination(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var result interface{}
+	switch by := query.Get("by"); by {
+	case "artist":
+		result, err = models.ListeningTimeByArtist(s.db, period, page.Limit)
+	case "hour":
+		result, err = models.ListeningTimeByHour(s.db, period, time.Local)
+	case "", models.UnitDay, models.UnitWeek, models.UnitMonth, models.UnitYear:
+		if by == "" {
+			by = models.UnitDay
+		}
+		result, err = models.ListeningTimeByPeriod(s.db, by, period, time.Local)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid by %q, expected day, week, month, year, hour or artist", by))
+		return
+	}
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, listResponse{Data: result})
+}
+
+// pathID returns the ID following prefix in the request path. It responds with an error if the ID is missing.
+func pathID(w http.ResponseWriter, r *http.Request, prefix string) (string, bool) {
+	id := strings.TrimPrefix(r.URL.Path, APIPrefix+prefix)
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return "", false
+	}
+	return id, true
+}
+
+// parsePeriod parses the since and until parameters. They may be RFC 3339 timestamps or dates like 2021-03-01.
+// A date in until includes the whole day.
+func parsePeriod(query url.Values) (models.Period, error) {
+	var period models.Period
+	var err error
+	if since := query.Get("since"); since != "" {
+		period.Since, _, err = parseTime(since)
+		if err != nil {
+			return period, fmt.Errorf("invalid since: %v", err)
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		var date bool
+		period.Until, date, err = parseTime(until)
+		if err != nil {
+			return period, fmt.Errorf("invalid until: %v", err)
+		}
+		if date {
+			period.Until = period.Until.AddDate(0, 0, 1)
+		}
+	}
+	return period, nil
+}
+
+// parseTime parses an RFC 3339 timestamp or a date in UTC. It returns true if value is a date.
+func parseTime(value string) (time.Time, bool, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, false, fmt.Errorf("%q is neither RFC 3339 timestamp nor date", value)
+	}
+	return t, true, nil
+}
+
+// parsePagination parses the limit and offset parameters.
+func parsePagination(query url.Values) (Pagination, error) {
+	page := Pagination{Limit: DefaultLimit}
+	var err error
+	if limit := query.Get("limit"); limit != "" {
+		page.Limit, err = strconv.Atoi(limit)
+		if err != nil || page.Limit < 1 || page.Limit > MaxLimit {
+			return page, fmt.Errorf("invalid limit %q, expected 1 to %d", limit, MaxLimit)
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		page.Offset, err = strconv.Atoi(offset)
+		if err != nil || page.Offset < 0 {
+			return page, fmt.Errorf("invalid offset %q", offset)
+		}
+	}
+	return page, nil
+}
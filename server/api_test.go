@@ -0,0 +1,156 @@
+package server
+
+import (
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestServer_history(t *testing.T) {
+	s := newTestServer(t)
+
+	var response struct {
+		Data       []models.Play `json:"data"`
+		Pagination Pagination    `json:"pagination"`
+	}
+	status := get(t, s, "/api/v1/history?limit=1", &response)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, Pagination{Limit: 1, Offset: 0, Total: 2}, response.Pagination)
+	assert.Equal(t, 1, len(response.Data))
+	assert.Equal(t, "t_name", response.Data[0].TrackName.String)
+	assert.Equal(t, time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC), response.Data[0].PlayedAt.UTC())
+
+	status = get(t, s, "/api/v1/history?until=2021-03-01", &response)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, response.Pagination.Total)
+	assert.Equal(t, time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), response.Data[0].PlayedAt.UTC())
+
+	status = get(t, s, "/api/v1/history?since=2021-03-02T00:00:00Z&offset=1", &response)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, response.Pagination.Total)
+	assert.Empty(t, response.Data)
+
+	var e errorResponse
+	status = get(t, s, "/api/v1/history?since=yesterday", &e)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, e.Error, "invalid since")
+
+	status = get(t, s, "/api/v1/history?limit=1000", &e)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestServer_track(t *testing.T) {
+	s := newTestServer(t)
+
+	var details models.TrackDetails
+	status := get(t, s, "/api/v1/tracks/t_id", &details)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "t_name", details.Name)
+	assert.Equal(t, "a_name", details.Artists[0].Name)
+	assert.Equal(t, 2, details.Plays)
+
+	var e errorResponse
+	status = get(t, s, "/api/v1/tracks/t_id_not_found", &e)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "track not found", e.Error)
+
+	status = get(t, s, "/api/v1/tracks/", &e)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestServer_artist(t *testing.T) {
+	s := newTestServer(t)
+
+	var details models.ArtistDetails
+	status := get(t, s, "/api/v1/artists/a_id", &details)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "a_name", details.Name)
+	assert.Equal(t, 2, details.Plays)
+	assert.Equal(t, "t_id", details.TopTracks[0].ID)
+
+	var e errorResponse
+	status = get(t, s, "/api/v1/artists/a_id/albums", &e)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestServer_top(t *testing.T) {
+	s := newTestServer(t)
+
+	var response struct {
+		Data models.TopEntries `json:"data"`
+	}
+	status := get(t, s, "/api/v1/stats/top?by=artist&since=2021-03-02", &response)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, models.TopEntries{{ID: "a_id", Name: "a_name", Plays: 1}}, response.Data)
+
+	status = get(t, s, "/api/v1/stats/top", &response)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, models.TopEntries{{ID: "t_id", Name: "t_name", Plays: 2}}, response.Data)
+
+	status = get(t, s, "/api/v1/stats/top?offset=1", &response)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, models.TopEntries{}, response.Data)
+
+	var e errorResponse
+	status = get(t, s, "/api/v1/stats/top?by=genre", &e)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestServer_listeningTime(t *testing.T) {
+	s := newTestServer(t)
+
+	var days struct {
+		Data []models.PeriodListeningTime `json:"data"`
+	}
+	status := get(t, s, "/api/v1/stats/time?since=2021-03-02", &days)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, len(days.Data))
+	assert.Equal(t, 1, days.Data[0].Plays)
+
+	var hours struct {
+		Data []models.HourListeningTime `json:"data"`
+	}
+	status = get(t, s, "/api/v1/stats/time?by=hour", &hours)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 24, len(hours.Data))
+
+	var artists struct {
+		Data []models.ArtistListeningTime `json:"data"`
+	}
+	status = get(t, s, "/api/v1/stats/time?by=artist", &artists)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "a_id", artists.Data[0].ID)
+	assert.Equal(t, 2, artists.Data[0].Plays)
+
+	var e errorResponse
+	status = get(t, s, "/api/v1/stats/time?by=decade", &e)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestParsePeriod(t *testing.T) {
+	period, err := parsePeriod(url.Values{"since": {"2021-03-01T10:00:00+01:00"}, "until": {"2021-03-31"}})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC), period.Since.UTC())
+	assert.Equal(t, time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), period.Until)
+
+	_, err = parsePeriod(url.Values{"until": {"03/31/2021"}})
+	assert.Contains(t, err.Error(), "invalid until")
+}
+
+func TestParsePagination(t *testing.T) {
+	page, err := parsePagination(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, Pagination{Limit: DefaultLimit}, page)
+
+	page, err = parsePagination(url.Values{"limit": {"10"}, "offset": {"20"}})
+	assert.NoError(t, err)
+	assert.Equal(t, Pagination{Limit: 10, Offset: 20}, page)
+
+	_, err = parsePagination(url.Values{"limit": {"0"}})
+	assert.Error(t, err)
+	_, err = parsePagination(url.Values{"offset": {"-1"}})
+	assert.Error(t, err)
+}
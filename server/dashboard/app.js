@@ -0,0 +1,193 @@
+'use strict';
+
+const app = document.getElementById('app');
+const keyStorage = 'spotifyHistoryApiKey';
+
+const ranges = [
+  {label: 'Last 4 weeks', days: 28},
+  {label: 'Last 6 months', days: 182},
+  {label: 'Last year', days: 365},
+  {label: 'All time', days: 0},
+];
+
+// esc escapes text for use in HTML.
+function esc(text) {
+  const div = document.createElement('div');
+  div.textContent = text == null ? '' : String(text);
+  return div.innerHTML;
+}
+
+// daysAgo returns the start of the local day days ago.
+function daysAgo(days) {
+  const d = new Date();
+  d.setDate(d.getDate() - days);
+  d.setHours(0, 0, 0, 0);
+  return d;
+}
+
+// timestamp formats d as RFC 3339 timestamp for the since and until parameters, so the API does not read
+// local dates as UTC.
+function timestamp(d) {
+  return encodeURIComponent(d.toISOString());
+}
+
+function localDate(d) {
+  const pad = (n) => String(n).padStart(2, '0');
+  return `${d.getFullYear()}-${pad(d.getMonth() + 1)}-${pad(d.getDate())}`;
+}
+
+// api requests an API endpoint with the stored key. It shows the login form if the key is missing or invalid.
+async function api(path) {
+  const key = localStorage.getItem(keyStorage);
+  if (!key) {
+    showLogin(false);
+    throw new Error('missing API key');
+  }
+  const response = await fetch('/api/v1/' + path, {headers: {'X-API-Key': key}});
+  if (response.status === 401) {
+    localStorage.removeItem(keyStorage);
+    showLogin(true);
+    throw new Error('invalid API key');
+  }
+  const body = await response.json();
+  if (!response.ok) {
+    throw new Error(body.error || response.statusText);
+  }
+  return body;
+}
+
+function showLogin(rejected) {
+  app.innerHTML = document.getElementById('login').innerHTML;
+  const form = app.querySelector('form');
+  form.querySelector('.error').hidden = !rejected;
+  form.addEventListener('submit', (event) => {
+    event.preventDefault();
+    localStorage.setItem(keyStorage, form.key.value);
+    route();
+  });
+}
+
+function cover(url) {
+  return url ? `<img class="cover" src="${esc(url)}" alt="" loading="lazy">` : '<div class="cover"></div>';
+}
+
+// renderRecent shows the listening heatmap of the last year and the most recent plays.
+async function renderRecent() {
+  app.innerHTML = `<h1>Recent plays</h1><div id="heatmap" class="heatmap"></div>
+    <ul id="plays" class="list"></ul><p><button id="more">Load more</button></p>`;
+  let offset = 0;
+  const more = document.getElementById('more');
+  const load = async () => {
+    const page = await api(`history?limit=50&offset=${offset}`);
+    offset += page.data.length;
+    more.hidden = offset >= page.pagination.total;
+    document.getElementById('plays').insertAdjacentHTML('beforeend', page.data.map((p) => `<li>
+      ${cover(p.image_url)}
+      <div class="title">
+        <div>${esc(p.track_name || p.episode_name || p.track_id || p.episode_id)}</div>
+        <div class="muted">${esc(p.album_name || p.show_name || '')}</div>
+      </div>
+      <span class="muted">${esc(new Date(p.played_at).toLocaleString())}</span>
+    </li>`).join(''));
+  };
+  more.addEventListener('click', load);
+  await Promise.all([renderHeatmap(), load()]);
+}
+
+async function renderHeatmap() {
+  const now = new Date();
+  const since = daysAgo(364 + (now.getDay() + 6) % 7);
+  const days = (await api(`stats/time?by=day&since=${timestamp(since)}`)).data;
+  const minutes = {};
+  let max = 0;
+  for (const d of days) {
+    minutes[d.period] = Math.floor(d.ms_played / 60000);
+    max = Math.max(max, minutes[d.period]);
+  }
+
+  let html = '';
+  const today = localDate(new Date());
+  for (const day = new Date(since); localDate(day) <= today;) {
+    html += '<div class="week">';
+    for (let i = 0; i < 7; i++) {
+      const date = localDate(day);
+      const m = minutes[date] || 0;
+      const level = m > 0 ? Math.min(4, 1 + Math.floor(3 * m / max)) : 0;
+      const cls = date > today ? 'day empty' : `day${level ? ' l' + level : ''}`;
+      html += `<div class="${cls}" title="${date}: ${m} minutes"></div>`;
+      day.setDate(day.getDate() + 1);
+    }
+    html += '</div>';
+  }
+  document.getElementById('heatmap').innerHTML = html;
+}
+
+// renderTop shows the most played tracks, artists or albums in the selected range.
+async function renderTop(params) {
+  const by = params.get('by') || 'track';
+  const days = Number(params.get('days') || 28);
+  const buttons = (items, key, selected) => items.map((item) =>
+    `<button class="${item.value === selected ? 'active' : ''}" data-${key}="${item.value}">${item.label}</button>`).join('');
+
+  app.innerHTML = `<h1>Top charts</h1>
+    <div class="controls">${buttons([
+      {label: 'Tracks', value: 'track'}, {label: 'Artists', value: 'artist'}, {label: 'Albums', value: 'album'},
+    ], 'by', by)}</div>
+    <div class="controls">${buttons(ranges.map((r) => ({label: r.label, value: r.days})), 'days', days)}</div>
+    <ol id="top" class="list"></ol>`;
+  app.querySelectorAll('button').forEach((button) => button.addEventListener('click', () => {
+    const next = new URLSearchParams({by, days});
+    Object.entries(button.dataset).forEach(([key, value]) => next.set(key, value));
+    location.hash = '#/top?' + next;
+  }));
+
+  const since = days ? `&since=${timestamp(daysAgo(days))}` : '';
+  const entries = (await api(`stats/top?by=${by}&limit=25${since}`)).data;
+  document.getElementById('top').innerHTML = entries.map((e, i) => {
+    const name = by === 'artist' ? `<a href="#/artist/${encodeURIComponent(e.id)}">${esc(e.name)}</a>` : esc(e.name);
+    return `<li><span class="rank">${i + 1}</span><div class="title"><div>${name}</div></div>
+      <span class="muted">${e.plays} plays</span></li>`;
+  }).join('') || '<p class="muted">Nothing played in this range.</p>';
+}
+
+// renderArtist shows an artist with genres, statistics and most played tracks.
+async function renderArtist(id) {
+  const a = await api('artists/' + encodeURIComponent(id));
+  const image = a.image_url ? `<img src="${esc(a.image_url)}" alt="">` : '<img alt="">';
+  app.innerHTML = `<div class="artist">${image}<div>
+      <h1>${esc(a.name)}</h1>
+      <div class="tags">${a.genres.map((g) => `<span>${esc(g)}</span>`).join('')}</div>
+      <div class="stats">
+        <div><b>${a.plays}</b>your plays</div>
+        ${a.stats ? `<div><b>${a.stats.popularity}</b>popularity</div>
+        <div><b>${a.stats.followers.toLocaleString()}</b>followers</div>` : ''}
+      </div>
+    </div></div>
+    <h2>Your most played tracks</h2>
+    <ol class="list">${a.top_tracks.map((t, i) => `<li><span class="rank">${i + 1}</span>
+      <div class="title"><div>${esc(t.name)}</div></div><span class="muted">${t.plays} plays</span></li>`).join('')}</ol>`;
+}
+
+async function route() {
+  const [path, query] = (location.hash.slice(1) || '/').split('?');
+  const params = new URLSearchParams(query);
+  try {
+    if (path === '/logout') {
+      localStorage.removeItem(keyStorage);
+      location.hash = '#/';
+    } else if (path === '/top') {
+      await renderTop(params);
+    } else if (path.startsWith('/artist/')) {
+      await renderArtist(decodeURIComponent(path.slice('/artist/'.length)));
+    } else {
+      await renderRecent();
+    }
+  } catch (e) {
+    if (!app.querySelector('form')) {
+      app.insertAdjacentHTML('beforeend', `<p class="error">${esc(e.message)}</p>`);
+    }
+  }
+}
+
+window.addEventListener('hashchange', route);
+route();
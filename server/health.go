@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/spotifySaver"
+	"net/http"
+	"time"
+)
+
+const (
+	// HealthPath is the liveness endpoint. It fails if the history worker is stuck.
+	HealthPath = "/healthz"
+	// ReadyPath is the readiness endpoint. It fails if the database, the token or the history worker is not usable.
+	ReadyPath = "/readyz"
+
+	// DefaultMaxPollAge is the default time without successful poll before the worker counts as stuck.
+	// The history is polled every 45 minutes.
+	DefaultMaxPollAge = 2 * time.Hour
+	// DefaultMaxFailedPolls is the default number of polls that may fail in a row.
+	DefaultMaxFailedPolls = 3
+)
+
+// Worker is the saver whose state the health endpoints report.
+type Worker interface {
+	Health() spotifySaver.Health
+	CheckToken() (time.Time, error)
+}
+
+// HealthConfig holds the thresholds of the health endpoints.
+type HealthConfig struct {
+	MaxPollAge     time.Duration
+	MaxFailedPolls int
+}
+
+// DefaultHealthConfig returns the default thresholds.
+func DefaultHealthConfig() HealthConfig {
+	return HealthConfig{
+		MaxPollAge:     DefaultMaxPollAge,
+		MaxFailedPolls: DefaultMaxFailedPolls,
+	}
+}
+
+// healthCheck is the result of a single check.
+type healthCheck struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// workerState is the reported state of the worker.
+type workerState struct {
+	StartedAt           time.Time  `json:"started_at"`
+	LastPollAt          *time.Time `json:"last_poll_at"`
+	LastSuccessAt       *time.Time `json:"last_success_at"`
+	SecondsSinceSuccess *int64     `json:"seconds_since_success"`
+	FailedPolls         int        `json:"failed_polls"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	TokenExpiry         *time.Time `json:"token_expiry,omitempty"`
+}
+
+// healthResponse is the body of the health endpoints.
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks"`
+	Worker *workerState           `json:"worker,omitempty"`
+}
+
+// SetWorker lets the health endpoints report the state of worker with the thresholds of config.
+// Without worker only the database is checked.
+func (s *Server) SetWorker(worker Worker, config HealthConfig) {
+	s.worker = worker
+	s.healthConfig = config
+}
+
+// health is the liveness handler. Orchestration should restart the app if it fails.
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	res := healthResponse{Checks: map[string]healthCheck{}}
+	if s.worker != nil {
+		state := s.worker.Health()
+		res.Worker = newWorkerState(state, time.Now())
+		res.Checks["poll"] = s.checkPoll(state, time.Now())
+	}
+	s.writeHealth(w, res)
+}
+
+// ready is the readiness handler. It additionally checks the database and the token.
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	res := healthResponse{Checks: map[string]healthCheck{
+		"database": s.checkDatabase(),
+	}}
+	if s.worker != nil {
+		state := s.worker.Health()
+		res.Worker = newWorkerState(state, time.Now())
+		res.Checks["poll"] = s.checkPoll(state, time.Now())
+
+		expiry, err := s.worker.CheckToken()
+		if err != nil {
+			res.Checks["token"] = healthCheck{Message: err.Error()}
+		} else {
+			res.Checks["token"] = healthCheck{OK: true}
+			res.Worker.TokenExpiry = &expiry
+		}
+	}
+	s.writeHealth(w, res)
+}
+
+// writeHealth responds with 200 if all checks of res are ok and 503 otherwise.
+func (s *Server) writeHealth(w http.ResponseWriter, res healthResponse) {
+	res.Status = "ok"
+	status := http.StatusOK
+	for _, check := range res.Checks {
+		if !check.OK {
+			res.Status = "failing"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, res)
+}
+
+func (s *Server) checkDatabase() healthCheck {
+	err := s.db.RawQuery("SELECT 1").Exec()
+	if err != nil {
+		s.log.Error("Health check could not reach database: ", err)
+		return healthCheck{Message: "database unreachable"}
+	}
+	return healthCheck{OK: true}
+}
+
+// checkPoll fails if the worker did not poll successfully for longer than the allowed poll age or
+// if too many polls failed in a row. A worker that never polled is measured from its start.
+func (s *Server) checkPoll(state spotifySaver.Health, now time.Time) healthCheck {
+	last := state.LastSuccessAt
+	if last.IsZero() {
+		last = state.StartedAt
+	}
+	if age := now.Sub(last); age > s.healthConfig.MaxPollAge {
+		return healthCheck{Message: fmt.Sprintf("no successful poll for %v", age.Round(time.Second))}
+	}
+	if state.FailedPolls >= s.healthConfig.MaxFailedPolls {
+		return healthCheck{Message: fmt.Sprintf("%d polls failed in a row", state.FailedPolls)}
+	}
+	return healthCheck{OK: true}
+}
+
+func newWorkerState(h spotifySaver.Health, now time.Time) *workerState {
+	state := &workerState{
+		StartedAt:   h.StartedAt,
+		LastPollAt:  timePtr(h.LastPollAt),
+		FailedPolls: h.FailedPolls,
+		LastError:   h.LastError,
+		LastErrorAt: timePtr(h.LastErrorAt),
+	}
+	if !h.LastSuccessAt.IsZero() {
+		state.LastSuccessAt = &h.LastSuccessAt
+		seconds := int64(now.Sub(h.LastSuccessAt).Seconds())
+		state.SecondsSinceSuccess = &seconds
+	}
+	return state
+}
+
+// timePtr returns nil for the zero time.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/elivlo/SpotifyHistorySaver/spotifySaver"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testWorker is a worker with fixed state.
+type testWorker struct {
+	health   spotifySaver.Health
+	expiry   time.Time
+	tokenErr error
+}
+
+func (w testWorker) Health() spotifySaver.Health {
+	return w.health
+}
+
+func (w testWorker) CheckToken() (time.Time, error) {
+	return w.expiry, w.tokenErr
+}
+
+func getHealth(t *testing.T, s *Server, path string) (int, healthResponse) {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	var res healthResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	return w.Code, res
+}
+
+func TestServer_health(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+
+	status, res := getHealth(t, s, HealthPath)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", res.Status)
+	assert.Nil(t, res.Worker)
+
+	t.Run("Healthy", func(t *testing.T) {
+		s.SetWorker(testWorker{health: spotifySaver.Health{StartedAt: now.Add(-time.Hour), LastSuccessAt: now}}, DefaultHealthConfig())
+		status, res := getHealth(t, s, HealthPath)
+		assert.Equal(t, http.StatusOK, status)
+		assert.True(t, res.Checks["poll"].OK)
+		assert.Equal(t, int64(0), *res.Worker.SecondsSinceSuccess)
+	})
+
+	t.Run("NeverPolled", func(t *testing.T) {
+		s.SetWorker(testWorker{health: spotifySaver.Health{StartedAt: now.Add(-time.Minute)}}, DefaultHealthConfig())
+		status, res := getHealth(t, s, HealthPath)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Nil(t, res.Worker.LastSuccessAt)
+	})
+
+	t.Run("Stuck", func(t *testing.T) {
+		s.SetWorker(testWorker{health: spotifySaver.Health{StartedAt: now.Add(-5 * time.Hour), LastSuccessAt: now.Add(-3 * time.Hour)}}, DefaultHealthConfig())
+		status, res := getHealth(t, s, HealthPath)
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "failing", res.Status)
+		assert.Contains(t, res.Checks["poll"].Message, "no successful poll for 3h0m")
+
+		s.SetWorker(s.worker, HealthConfig{MaxPollAge: 4 * time.Hour, MaxFailedPolls: 3})
+		status, _ = getHealth(t, s, HealthPath)
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("FailedPolls", func(t *testing.T) {
+		s.SetWorker(testWorker{health: spotifySaver.Health{
+			StartedAt:     now.Add(-time.Hour),
+			LastSuccessAt: now.Add(-time.Minute),
+			FailedPolls:   3,
+			LastError:     "rate limited",
+			LastErrorAt:   now,
+		}}, DefaultHealthConfig())
+		status, res := getHealth(t, s, HealthPath)
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, "3 polls failed in a row", res.Checks["poll"].Message)
+		assert.Equal(t, "rate limited", res.Worker.LastError)
+	})
+}
+
+func TestServer_ready(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+
+	status, res := getHealth(t, s, ReadyPath)
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, res.Checks["database"].OK)
+
+	s.SetWorker(testWorker{health: spotifySaver.Health{StartedAt: now, LastSuccessAt: now}, expiry: now.Add(time.Hour)}, DefaultHealthConfig())
+	status, res = getHealth(t, s, ReadyPath)
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, res.Checks["token"].OK)
+	assert.True(t, now.Add(time.Hour).Equal(*res.Worker.TokenExpiry))
+
+	s.SetWorker(testWorker{health: spotifySaver.Health{StartedAt: now, LastSuccessAt: now}, tokenErr: errors.New("invalid_grant")}, DefaultHealthConfig())
+	status, res = getHealth(t, s, ReadyPath)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "invalid_grant", res.Checks["token"].Message)
+	assert.True(t, res.Checks["database"].OK)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/logging"
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
+	"github.com/gobuffalo/packr/v2"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// APIPrefix is the path prefix of the current API version
+	APIPrefix = "/api/v1/"
+	// APIKeyHeader is the header the API key may be sent in. It may also be sent as bearer token.
+	APIKeyHeader = "X-API-Key"
+	// MetricsPath is the path Prometheus metrics are served on. It does not need an API key.
+	MetricsPath = "/metrics"
+
+	// shutdownTimeout is the time running requests get to finish when the server is stopped
+	shutdownTimeout = 5 * time.Second
+)
+
+// dashboard holds the static files of the web dashboard
+var dashboard = packr.New("dashboard", "./dashboard")
+
+// Server serves the read-only HTTP JSON API over the history database, the web dashboard using it,
+// Prometheus metrics and health endpoints.
+type Server struct {
+	db     *pop.Connection
+	addr   string
+	apiKey string
+	log    *logrus.Entry
+	mux    *http.ServeMux
+	// listener is opened by Listen
+	listener net.Listener
+
+	worker       Worker
+	healthConfig HealthConfig
+}
+
+// NewServer creates a server listening on addr. Every API request has to be authenticated with apiKey.
+func NewServer(db *pop.Connection, addr, apiKey string, log *logrus.Entry) *Server {
+	s := &Server{
+		db:     db,
+		addr:   addr,
+		apiKey: apiKey,
+		log:    log.WithField(logging.FieldComponent, "server"),
+		mux:    http.NewServeMux(),
+
+		healthConfig: DefaultHealthConfig(),
+	}
+	s.handleAPI("history", s.history)
+	s.handleAPI("tracks/", s.track)
+	s.handleAPI("artists/", s.artist)
+	s.handleAPI("stats/top", s.top)
+	s.handleAPI("stats/time", s.listeningTime)
+	s.handleAPI("", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not found")
+	})
+	s.mux.Handle(MetricsPath, metrics.Handler())
+	s.mux.HandleFunc(HealthPath, s.health)
+	s.mux.HandleFunc(ReadyPath, s.ready)
+	s.mux.Handle("/", http.FileServer(dashboard))
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Listen listens on the address of the server, so an address that is in use fails before any worker is started.
+// It has to be called before StartServer.
+func (s *Server) Listen() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %v", s.addr, err)
+	}
+	s.listener = listener
+	s.log.Infof("Listening on %s", listener.Addr())
+	return nil
+}
+
+// StartServer is a worker that will serve HTTP requests on the listener of Listen until it is stopped.
+// It is not async. It accepts a wait group and will send Done when stopped. It may be stopped with stop chan value.
+func (s *Server) StartServer(wg *sync.WaitGroup, stop chan bool) {
+	srv := &http.Server{
+		Addr:    s.addr,
+		Handler: s,
+	}
+	go func() {
+		err := srv.Serve(s.listener)
+		if err != nil && err != http.ErrServerClosed {
+			s.log.Error("Could not serve HTTP: ", err)
+		}
+	}()
+
+	<-stop
+	s.log.Info("Shutting down StartServer")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		s.log.Error("Could not shut down HTTP server: ", err)
+	}
+	wg.Done()
+}
+
+// handleAPI registers an API handler for path below APIPrefix that only accepts authenticated GET requests.
+func (s *Server) handleAPI(path string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(APIPrefix+path, func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// authorized checks the API key of r.
+func (s *Server) authorized(r *http.Request) bool {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	return s.apiKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.apiKey)) == 1
+}
+
+// errorResponse is the body of all failed requests.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
+
+// internalError logs err and responds with a generic error.
+func (s *Server) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	s.log.Errorf("Could not handle %s: %v", r.URL.Path, err)
+	writeError(w, http.StatusInternalServerError, "internal server error")
+}
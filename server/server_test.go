@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/packr/v2"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAPIKey = "test_key"
+
+var DB *pop.Connection
+
+func getTestLogger() (*logtest.Hook, *logrus.Entry) {
+	logger, hook := logtest.NewNullLogger()
+	logger.Level = logrus.DebugLevel
+	log := logger.WithField("test", "test")
+
+	return hook, log
+}
+
+func TestMain(m *testing.M) {
+	var err error
+
+	DB, err = pop.Connect("test")
+	if err != nil {
+		fmt.Println("Could not connect to test database")
+		os.Exit(1)
+	}
+	_ = pop.CreateDB(DB)
+	box, _ := pop.NewMigrationBox(packr.New("migrations", "../migrations"), DB)
+	_ = box.Up()
+	_ = DB.TruncateAll()
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+// newTestServer creates a server with a track played twice by an artist.
+func newTestServer(t *testing.T) *Server {
+	_, log := getTestLogger()
+	_ = DB.TruncateAll()
+
+	playedAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, m := range []interface{}{
+		&models.Track{ID: "t_id", Name: "t_name"},
+		&models.Artist{ID: "a_id", Name: "a_name"},
+		&models.ArtistsTrack{ArtistID: "a_id", TrackID: "t_id"},
+		&models.HistoryEntry{TrackID: nulls.NewString("t_id"), PlayedAt: playedAt},
+		&models.HistoryEntry{TrackID: nulls.NewString("t_id"), PlayedAt: playedAt.Add(24 * time.Hour)},
+	} {
+		err := DB.Create(m)
+		assert.NoError(t, err)
+	}
+	return NewServer(DB, "localhost:0", testAPIKey, log)
+}
+
+// get requests path with the test API key and decodes the JSON response into v.
+func get(t *testing.T, s *Server, path string, v interface{}) int {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set(APIKeyHeader, testAPIKey)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	assert.NoError(t, err)
+	return w.Code
+}
+
+func TestServer_authorized(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"NoKey", "", "", http.StatusUnauthorized},
+		{"WrongKey", APIKeyHeader, "wrong", http.StatusUnauthorized},
+		{"Header", APIKeyHeader, testAPIKey, http.StatusOK},
+		{"Bearer", "Authorization", "Bearer " + testAPIKey, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/history", nil)
+			if test.header != "" {
+				r.Header.Set(test.header, test.value)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			assert.Equal(t, test.status, w.Code)
+		})
+	}
+
+	t.Run("NoAPIKeyConfigured", func(t *testing.T) {
+		s.apiKey = ""
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/history", nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestServer_MethodNotAllowed(t *testing.T) {
+	s := newTestServer(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/history", nil)
+	r.Header.Set(APIKeyHeader, testAPIKey)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, http.MethodGet, w.Header().Get("Allow"))
+}
+
+func TestServer_NotFound(t *testing.T) {
+	s := newTestServer(t)
+
+	var e errorResponse
+	status := get(t, s, "/api/v1/playlists", &e)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "not found", e.Error)
+}
+
+func TestServer_dashboard(t *testing.T) {
+	s := newTestServer(t)
+
+	for path, content := range map[string]string{
+		"/":          "<title>Spotify History</title>",
+		"/app.js":    "function route()",
+		"/style.css": ".heatmap",
+	} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Contains(t, w.Body.String(), content, path)
+	}
+}
+
+func TestServer_metrics(t *testing.T) {
+	s := newTestServer(t)
+
+	r := httptest.NewRequest(http.MethodGet, MetricsPath, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "spotify_history_token_refreshes_total")
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
+
+func TestServer_StartServer(t *testing.T) {
+	_, log := getTestLogger()
+	s := NewServer(DB, "localhost:0", testAPIKey, log)
+	assert.NoError(t, s.Listen())
+
+	// The address is in use
+	err := NewServer(DB, s.listener.Addr().String(), testAPIKey, log).Listen()
+	assert.Contains(t, err.Error(), "could not listen on "+s.listener.Addr().String()+":")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	stop := make(chan bool)
+	close(stop)
+	s.StartServer(&wg, stop)
+
+	wg.Wait()
+}
@@ -4,8 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/lastfm"
+	"github.com/elivlo/SpotifyHistorySaver/listenbrainz"
+	"github.com/elivlo/SpotifyHistorySaver/logging"
 	"github.com/elivlo/SpotifyHistorySaver/login"
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
 	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/elivlo/SpotifyHistorySaver/mqtt"
+	"github.com/elivlo/SpotifyHistorySaver/webhook"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/sirupsen/logrus"
 	"github.com/zmb3/spotify/v2"
@@ -19,15 +25,43 @@ import (
 const (
 	// TokenFileName is the standard file name to save the OAuth token to
 	TokenFileName = "token.json"
+
+	// MinMsPlayed is the minimum play time of a play to be saved as history entry.
+	// Spotify itself only counts plays of at least 30 seconds.
+	MinMsPlayed = 30000
+
+	// HistoryInterval is the default interval in which the recently played history is requested
+	HistoryInterval = 45 * time.Minute
+
+	// identifyTimeout is the time the current user may take to load
+	identifyTimeout = 10 * time.Second
 )
 
 // InterfaceSpotifySaver is the interface SpotifySaver implements.
 // It supports loading a token and authenticating with it.
 // The main purpose is to start the StartLastSongsWorker to periodically save the history.
+// StartPlaybackWorker may be started additionally to capture skips and partial plays.
+// StartEnrichmentWorker completes the saved artists and tracks with genres, statistics, albums and audio features.
+// SetLastFM and SetListenBrainz forward newly saved plays to Last.fm and ListenBrainz.
+// SetWebhooks posts newly saved plays to webhook targets, delivered by StartWebhookWorker.
+// SetMQTT publishes newly saved plays and, with StartPlaybackWorker, the currently playing track to an MQTT broker.
 type InterfaceSpotifySaver interface {
 	LoadToken(file string) error
 	Authenticate(callbackURI, clientID, clientSecret string)
 	StartLastSongsWorker(wg *sync.WaitGroup, stop chan bool)
+	StartPlaybackWorker(wg *sync.WaitGroup, stop chan bool)
+	StartEnrichmentWorker(wg *sync.WaitGroup, stop chan bool)
+	StartWebhookWorker(wg *sync.WaitGroup, stop chan bool)
+	ImportExtendedHistory(file string) error
+	ImportScrobbles(source, file string) error
+	SetIntervals(intervals Intervals)
+	SetLastFM(client *lastfm.Client, session lastfm.Session)
+	SetListenBrainz(client *listenbrainz.Client)
+	BackfillListenBrainz() (int, error)
+	SetWebhooks(client *webhook.Client, targets []webhook.Target, maxAttempts int)
+	SetMQTT(publisher *mqtt.Publisher)
+	Health() Health
+	CheckToken() (time.Time, error)
 }
 
 // SpotifySaver will handle all the saving logic.
@@ -38,31 +72,114 @@ type SpotifySaver struct {
 	token        *oauth2.Token
 	auth         *spotifyauth.Authenticator
 	client       *spotify.Client
+	api          *apiClient
 	log          *logrus.Entry
 	env          string
+	tokenFile    string
+	intervals    Intervals
+	health       healthRecorder
+
+	lastfm        *lastfm.Client
+	lastfmSession lastfm.Session
+	listenbrainz  *listenbrainz.Client
+	webhooks      *webhookDispatcher
+	mqtt          *mqttPublisher
+}
+
+// Intervals are the times between the runs of the workers.
+type Intervals struct {
+	History    time.Duration
+	Playback   time.Duration
+	Enrichment time.Duration
+}
+
+// DefaultIntervals returns the intervals used if none are set.
+func DefaultIntervals() Intervals {
+	return Intervals{
+		History:    HistoryInterval,
+		Playback:   PlaybackPollInterval,
+		Enrichment: EnrichmentInterval,
+	}
 }
 
 // NewSpotifySaver will create a new SpotifySaver instance with database connection.
-// It will throw an error when database connection fails.
+// It will throw an error when database connection fails or the database has pending migrations.
 func NewSpotifySaver(log *logrus.Entry, env string) (*SpotifySaver, error) {
 	tx, err := pop.Connect(env)
 	if err != nil {
 		return nil, fmt.Errorf("Could not connect to database: %v", err)
 	}
-	return &SpotifySaver{
+	pending, err := models.PendingMigrations(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Could not check migrations: %v", err)
+	}
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("Database schema is outdated, %d pending migrations starting with %s_%s; run db migrate first",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+	saver := &SpotifySaver{
 		dbConnection: tx,
-		log:          log,
+		log:          log.WithField(logging.FieldComponent, "saver"),
 		env:          env,
-	}, nil
+		tokenFile:    TokenFileName,
+		intervals:    DefaultIntervals(),
+	}
+	saver.health.health.StartedAt = time.Now()
+	return saver, nil
+}
+
+// SetIntervals sets the times between the runs of the workers. It has to be called before they are started.
+func (s *SpotifySaver) SetIntervals(intervals Intervals) {
+	s.intervals = intervals
+}
+
+// SetLastFM scrobbles the newly saved plays after every history poll with client as the user of session.
+// It has to be called before the workers are started.
+func (s *SpotifySaver) SetLastFM(client *lastfm.Client, session lastfm.Session) {
+	s.lastfm = client
+	s.lastfmSession = session
+}
+
+// SetListenBrainz submits the newly saved plays after every history poll with client.
+// It has to be called before the workers are started.
+func (s *SpotifySaver) SetListenBrainz(client *listenbrainz.Client) {
+	s.listenbrainz = client
 }
 
-// LoadToken will load the token from file "token.json" in exec directory.
+// BackfillListenBrainz submits all plays that were not submitted yet to the ListenBrainz client set with SetListenBrainz.
+// It resumes where a previous backfill or submission stopped and returns the number of submitted plays.
+func (s *SpotifySaver) BackfillListenBrainz() (int, error) {
+	if s.listenbrainz == nil {
+		return 0, fmt.Errorf("ListenBrainz is not set up")
+	}
+	log := s.log.WithField(logging.FieldCategory, "listenbrainz")
+	return newListenSubmitter(s.dbConnection, s.listenbrainz, log).backfill()
+}
+
+// SetWebhooks saves a payload of the newly saved plays of every history poll to the outbox of every target.
+// StartWebhookWorker delivers them with client and gives a delivery up after maxAttempts.
+// It has to be called before the workers are started.
+func (s *SpotifySaver) SetWebhooks(client *webhook.Client, targets []webhook.Target, maxAttempts int) {
+	dispatcher := newWebhookDispatcher(s.dbConnection, client, targets, maxAttempts, s.log.WithField(logging.FieldCategory, metrics.WorkerWebhooks))
+	s.webhooks = &dispatcher
+}
+
+// SetMQTT publishes the newly saved plays of every history poll and the changes of the player state with publisher.
+// It has to be called before the workers are started.
+func (s *SpotifySaver) SetMQTT(publisher *mqtt.Publisher) {
+	p := newMQTTPublisher(s.dbConnection, publisher)
+	s.mqtt = &p
+}
+
+// LoadToken will load the token from file, e.g. "token.json" in exec directory.
+// Refreshed tokens are saved to the same file.
 // It will throw an error when the token is expired.
 func (s *SpotifySaver) LoadToken(file string) error {
 	fileBytes, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
+	s.tokenFile = file
 
 	err = json.Unmarshal(fileBytes, &s.token)
 	if err != nil {
@@ -78,38 +195,105 @@ func (s *SpotifySaver) LoadToken(file string) error {
 // Authenticate will create a new client from token.
 func (s *SpotifySaver) Authenticate(callbackURI, clientID, clientSecret string) {
 	s.auth = spotifyauth.New(spotifyauth.WithRedirectURL(callbackURI),
-		spotifyauth.WithScopes(spotifyauth.ScopeUserReadRecentlyPlayed),
+		spotifyauth.WithScopes(spotifyauth.ScopeUserReadRecentlyPlayed, spotifyauth.ScopeUserReadPlaybackState),
 		spotifyauth.WithClientID(clientID),
 		spotifyauth.WithClientSecret(clientSecret))
-	s.client = spotify.New(s.auth.Client(context.Background(), s.token))
+	httpClient := s.auth.Client(context.Background(), s.token)
+	s.client = spotify.New(httpClient)
+	s.api = newAPIClient(httpClient, APIBaseURL)
+	s.identifyUser()
+}
+
+// identifyUser adds the Spotify user ID of the loaded token to all log entries. It does nothing without token.
+func (s *SpotifySaver) identifyUser() {
+	if s.token == nil || (!s.token.Valid() && s.token.RefreshToken == "") {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), identifyTimeout)
+	defer cancel()
+	user, err := s.client.CurrentUser(ctx)
+	if err != nil {
+		s.log.Warn("Could not get current user: ", err)
+		return
+	}
+	s.log = s.log.WithField(logging.FieldUser, user.ID)
 }
 
-// StartLastSongsWorker is a worker that will send history requests every 45 minutes.
+// StartLastSongsWorker is a worker that will send history requests every history interval (default 45 minutes).
 // It is not async. It accepts a wait group and will send Done when stopped. It may be stopped with stop chan value.
 func (s *SpotifySaver) StartLastSongsWorker(wg *sync.WaitGroup, stop chan bool) {
+	var pollID uint64
 	first := true
 	ticker := time.NewTicker(time.Second * 5)
 	for {
 		select {
 		case <-ticker.C:
-			s.log.Info("Fetch newly listened songs")
+			pollID++
+			s.pollHistory(pollID)
 
-			last := s.getLastEntry()
+			if first {
+				first = false
+				ticker.Reset(s.intervals.History)
+			}
+		case <-stop:
+			s.log.Info("Shutting down StartLastSongsWorker")
+			ticker.Stop()
+			wg.Done()
+			return
+		}
+	}
+}
+
+// StartPlaybackWorker is a worker that will request the player state every playback interval (default 10 seconds).
+// Every observed playback segment is saved as playback session, including skipped and partially played tracks.
+// Podcast episodes are saved as history entries as well, because they are missing in the recently played history.
+// It is not async. It accepts a wait group and will send Done when stopped. It may be stopped with stop chan value.
+func (s *SpotifySaver) StartPlaybackWorker(wg *sync.WaitGroup, stop chan bool) {
+	log := s.log.WithField(logging.FieldCategory, metrics.WorkerPlayback)
+	tracker := playbackTracker{}
+	ticker := time.NewTicker(s.intervals.Playback)
+	for {
+		select {
+		case <-ticker.C:
+			s.pollPlayerState(log, &tracker)
+		case <-stop:
+			log.Info("Shutting down StartPlaybackWorker")
+			ticker.Stop()
+			s.savePlaybackSession(log, tracker.finish())
+			wg.Done()
+			return
+		}
+	}
+}
+
+// StartEnrichmentWorker is a worker that will fetch artist and track metadata every enrichment interval (default 1 hour).
+// Artists are fetched once they were saved and again every 7 days to record popularity and followers over time.
+// Albums and audio features are fetched once for every saved track.
+// It is not async. It accepts a wait group and will send Done when stopped. It may be stopped with stop chan value.
+func (s *SpotifySaver) StartEnrichmentWorker(wg *sync.WaitGroup, stop chan bool) {
+	log := s.log.WithField(logging.FieldCategory, metrics.WorkerEnrichment)
+	first := true
+	ticker := time.NewTicker(time.Minute)
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
 
-			songs := s.fetchNewSongs(last)
+			s.enrichArtists(log)
 
-			s.insertNewSongs(songs)
+			s.enrichTracks(log)
 
-			s.log.Info("Finished fetching newly listened songs")
+			s.enrichAudioFeatures(log)
 
-			s.saveNewToken(login.TokenFileName)
+			metrics.ObservePoll(metrics.WorkerEnrichment, start)
+			log.WithField(logging.FieldDuration, time.Since(start).Seconds()).Debug("Finished enrichment")
 
 			if first {
 				first = false
-				ticker.Reset(time.Minute * 45)
+				ticker.Reset(s.intervals.Enrichment)
 			}
 		case <-stop:
-			s.log.Info("Shutting down StartLastSongsWorker")
+			log.Info("Shutting down StartEnrichmentWorker")
 			ticker.Stop()
 			wg.Done()
 			return
@@ -117,43 +301,304 @@ func (s *SpotifySaver) StartLastSongsWorker(wg *sync.WaitGroup, stop chan bool)
 	}
 }
 
-func (s *SpotifySaver) getLastEntry() models.HistoryEntry {
+// StartWebhookWorker is a worker that will deliver the webhook outbox every 30 seconds. It does nothing without SetWebhooks.
+// Deliveries that were pending when the app stopped are delivered after the next start.
+// It is not async. It accepts a wait group and will send Done when stopped. It may be stopped with stop chan value.
+func (s *SpotifySaver) StartWebhookWorker(wg *sync.WaitGroup, stop chan bool) {
+	ticker := time.NewTicker(WebhookInterval)
+	for {
+		select {
+		case <-ticker.C:
+			s.deliverWebhooks()
+		case <-stop:
+			s.log.Info("Shutting down StartWebhookWorker")
+			ticker.Stop()
+			wg.Done()
+			return
+		}
+	}
+}
+
+// pollHistory fetches and saves the newly listened songs. All entries of the poll are logged with pollID.
+func (s *SpotifySaver) pollHistory(pollID uint64) {
+	log := s.log.WithFields(logrus.Fields{
+		logging.FieldCategory: metrics.WorkerRecentlyPlayed,
+		logging.FieldPollID:   pollID,
+	})
+	log.Info("Fetch newly listened songs")
+	start := time.Now()
+
+	last := s.getLastEntry(log)
+
+	songs, err := s.fetchNewSongs(log, last)
+	if err == nil {
+		err = s.insertNewSongs(log, songs)
+	}
+	s.health.recordPoll(time.Now(), err)
+
+	s.reconcileSessions(log)
+
+	s.scrobblePlays(log)
+
+	s.submitListens(log)
+
+	metrics.ObservePoll(metrics.WorkerRecentlyPlayed, start)
+	log.WithFields(logrus.Fields{
+		logging.FieldCount:    len(songs),
+		logging.FieldDuration: time.Since(start).Seconds(),
+	}).Info("Finished fetching newly listened songs")
+
+	s.saveNewToken(log, s.tokenFile)
+}
+
+func (s *SpotifySaver) enrichArtists(log *logrus.Entry) {
+	enriched, err := newArtistEnricher(s.dbConnection, s.client, log).enrichArtists(time.Now())
+	if err != nil {
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not enrich artists: ", err)
+	}
+	if enriched > 0 {
+		log.WithField(logging.FieldCount, enriched).Info("Enriched artists with genres, popularity and followers")
+	}
+}
+
+func (s *SpotifySaver) enrichTracks(log *logrus.Entry) {
+	enriched, err := newTrackEnricher(s.dbConnection, s.client, log).enrichTracks(time.Now())
+	if err != nil {
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not enrich tracks with albums: ", err)
+	}
+	if enriched > 0 {
+		log.WithField(logging.FieldCount, enriched).Info("Saved albums of tracks")
+	}
+}
+
+func (s *SpotifySaver) enrichAudioFeatures(log *logrus.Entry) {
+	enriched, err := newAudioFeatureEnricher(s.dbConnection, s.client, log).enrichTracks(time.Now())
+	if err != nil {
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not enrich tracks with audio features: ", err)
+	}
+	if enriched > 0 {
+		log.WithField(logging.FieldCount, enriched).Info("Saved audio features of tracks")
+	}
+}
+
+func (s *SpotifySaver) scrobblePlays(log *logrus.Entry) {
+	if s.lastfm == nil {
+		return
+	}
+	scrobbled, err := newScrobbler(s.dbConnection, s.lastfm, s.lastfmSession, log).scrobble(time.Now())
+	if err != nil {
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not scrobble plays to Last.fm: ", err)
+	}
+	if scrobbled > 0 {
+		log.WithField(logging.FieldCount, scrobbled).Info("Scrobbled plays to Last.fm")
+	}
+}
+
+func (s *SpotifySaver) submitListens(log *logrus.Entry) {
+	if s.listenbrainz == nil {
+		return
+	}
+	submitted, err := newListenSubmitter(s.dbConnection, s.listenbrainz, log).submitNew(time.Now())
+	if err != nil {
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not submit listens to ListenBrainz: ", err)
+	}
+	if submitted > 0 {
+		log.WithField(logging.FieldCount, submitted).Info("Submitted listens to ListenBrainz")
+	}
+}
+
+func (s *SpotifySaver) pollPlayerState(log *logrus.Entry, tracker *playbackTracker) {
+	start := time.Now()
+	defer metrics.ObservePoll(metrics.WorkerPlayback, start)
+
+	state, err := s.api.playerState(context.Background())
+	if err != nil {
+		metrics.ObserveAPIError(err)
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not get player state: ", err)
+		return
+	}
+	if state != nil && state.Item != nil {
+		metrics.FetchedItems.WithLabelValues(metrics.WorkerPlayback).Inc()
+	}
+	now := time.Now()
+	s.savePlaybackSession(log, tracker.observe(state, now))
+	s.publishNowPlaying(log, state, now)
+}
+
+func (s *SpotifySaver) savePlaybackSession(log *logrus.Entry, observed *observedSession) {
+	if observed == nil {
+		return
+	}
+	err := insertPlaybackSession(s.dbConnection, log, observed)
+	if err != nil {
+		metrics.DBErrors.WithLabelValues("save_playback_session").Inc()
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not save playback session: ", err)
+	}
+}
+
+func (s *SpotifySaver) reconcileSessions(log *logrus.Entry) {
+	linked, err := reconcilePlaybackSessions(s.dbConnection, time.Now().Add(-reconcileLookback))
+	if err != nil {
+		metrics.DBErrors.WithLabelValues("reconcile_sessions").Inc()
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not reconcile playback sessions: ", err)
+		return
+	}
+	if linked > 0 {
+		log.WithField(logging.FieldCount, linked).Info("Linked playback sessions to history entries")
+	}
+}
+
+func (s *SpotifySaver) getLastEntry(log *logrus.Entry) models.HistoryEntry {
 	last, err := getLastHistoryEntry(s.dbConnection)
 	if err != nil {
-		s.log.Warnf("Could not get last played song: %v", err)
+		if !models.IsNotFound(err) {
+			metrics.DBErrors.WithLabelValues("get_last_entry").Inc()
+		}
+		log.Warnf("Could not get last played song: %v", err)
 		last.PlayedAt = time.Unix(0, 0)
+		return last
 	}
+	metrics.ObserveLatestPlay(last.PlayedAt)
 	return last
 }
 
-func (s *SpotifySaver) fetchNewSongs(last models.HistoryEntry) []spotify.RecentlyPlayedItem {
+func (s *SpotifySaver) fetchNewSongs(log *logrus.Entry, last models.HistoryEntry) ([]spotify.RecentlyPlayedItem, error) {
 	songs, err := s.client.PlayerRecentlyPlayedOpt(context.Background(), &spotify.RecentlyPlayedOptions{
 		Limit:        50,
 		AfterEpochMs: last.PlayedAt.Unix()*1000 + 1000,
 	})
 	if err != nil {
-		s.log.Error("Could not get recently played songs: ", err)
+		metrics.ObserveAPIError(err)
+		log.Error("Could not get recently played songs: ", err)
+	}
+	metrics.FetchedItems.WithLabelValues(metrics.WorkerRecentlyPlayed).Add(float64(len(songs)))
+
+	log.WithField(logging.FieldCount, len(songs)).Info("Fetched new RecentlyPlayedItems")
+	return songs, err
+}
+
+func (s *SpotifySaver) insertNewSongs(log *logrus.Entry, songs []spotify.RecentlyPlayedItem) error {
+	var fetched FetchedSongs
+	// The webhook deliveries are saved with the history, so no play is inserted without them
+	err := s.dbConnection.Transaction(func(tx *pop.Connection) error {
+		fetched = NewFetchedSongs(tx, songs)
+		err := fetched.TransformAndInsertIntoDatabase(log)
+		if err != nil {
+			return err
+		}
+		return s.enqueueWebhooks(log, tx, fetched.history)
+	})
+	if err != nil {
+		metrics.DBErrors.WithLabelValues("insert_history").Inc()
+		log.Error("Could not save recently played songs: ", err)
+		return err
+	}
+	s.publishPlays(log, fetched.history)
+	return nil
+}
+
+// enqueueWebhooks saves a payload of the inserted entries to the webhook outbox with tx, the transaction that
+// inserted them. It does nothing without SetWebhooks.
+func (s *SpotifySaver) enqueueWebhooks(log *logrus.Entry, tx *pop.Connection, entries models.HistoryEntries) error {
+	if s.webhooks == nil {
+		return nil
+	}
+	n, err := s.webhooks.enqueue(tx, time.Now(), entries)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.WithField(logging.FieldCount, n).Info("Saved webhook deliveries")
+	}
+	return nil
+}
+
+// publishPlays publishes the inserted entries to the MQTT broker. It does nothing without SetMQTT.
+func (s *SpotifySaver) publishPlays(log *logrus.Entry, entries models.HistoryEntries) {
+	if s.mqtt == nil {
+		return
+	}
+	published, err := s.mqtt.publishPlays(entries)
+	if err != nil {
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not publish plays to MQTT: ", err)
+		return
 	}
+	if published > 0 {
+		log.WithField(logging.FieldCount, published).Info("Published plays to MQTT")
+	}
+}
 
-	s.log.Infof("Fetched %d new RecentlyPlayedItems", len(songs))
-	return songs
+// publishNowPlaying publishes the player state to the MQTT broker if it changed. It does nothing without SetMQTT.
+func (s *SpotifySaver) publishNowPlaying(log *logrus.Entry, state *playerState, now time.Time) {
+	if s.mqtt == nil {
+		return
+	}
+	published, err := s.mqtt.publishNowPlaying(state, now)
+	if err != nil {
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not publish player state to MQTT: ", err)
+		return
+	}
+	if published {
+		log.Debug("Published player state to MQTT")
+	}
 }
 
-func (s *SpotifySaver) insertNewSongs(songs []spotify.RecentlyPlayedItem) {
-	fetched := NewFetchedSongs(s.dbConnection, songs)
-	err := fetched.TransformAndInsertIntoDatabase(s.log)
+// deliverWebhooks delivers the due payloads of the webhook outbox. It does nothing without SetWebhooks.
+func (s *SpotifySaver) deliverWebhooks() {
+	if s.webhooks == nil {
+		return
+	}
+	start := time.Now()
+	delivered, err := s.webhooks.deliver(start)
+	metrics.ObservePoll(metrics.WorkerWebhooks, start)
 	if err != nil {
-		s.log.Error("Could not save recently played songs: ", err)
+		s.health.recordError(time.Now(), err)
+		s.webhooks.log.Error("Could not deliver webhooks: ", err)
+		return
+	}
+	if delivered > 0 {
+		s.webhooks.log.WithField(logging.FieldCount, delivered).Info("Delivered webhooks")
 	}
 }
 
-func (s *SpotifySaver) saveNewToken(fileName string) {
+// saveNewToken will save the current client token to fileName if it changed since it was loaded or last saved.
+func (s *SpotifySaver) saveNewToken(log *logrus.Entry, fileName string) {
 	token, err := s.client.Token()
 	if err != nil {
-		s.log.Error("Could not get current client token: ", err)
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not get current client token: ", err)
+		return
+	}
+	if tokenEqual(s.token, token) {
+		return
 	}
-	err = login.NewLogin("", "", "").SaveToken(fileName, token)
+	err = login.NewLogin("", "", "", log).SaveToken(fileName, token)
 	if err != nil {
-		s.log.Error("Could not save current client token ", err)
+		s.health.recordError(time.Now(), err)
+		log.Error("Could not save current client token ", err)
+		return
+	}
+	metrics.TokenRefreshes.Inc()
+	s.token = token
+}
+
+// tokenEqual checks if two tokens hold the same credentials.
+func tokenEqual(a, b *oauth2.Token) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
+	return a.AccessToken == b.AccessToken &&
+		a.RefreshToken == b.RefreshToken &&
+		a.TokenType == b.TokenType &&
+		a.Expiry.Equal(b.Expiry)
 }
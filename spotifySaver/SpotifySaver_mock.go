@@ -2,6 +2,10 @@ package spotifySaver
 
 import (
 	"errors"
+	"github.com/elivlo/SpotifyHistorySaver/lastfm"
+	"github.com/elivlo/SpotifyHistorySaver/listenbrainz"
+	"github.com/elivlo/SpotifyHistorySaver/mqtt"
+	"github.com/elivlo/SpotifyHistorySaver/webhook"
 	"sync"
 	"time"
 )
@@ -9,6 +13,7 @@ import (
 // MockedSpotifySaver implements the InterfaceSpotifySaver interface for tests.
 type MockedSpotifySaver struct {
 	LError bool
+	IError bool
 }
 
 // LoadToken will load the token from file "token.json" in exec directory.
@@ -38,3 +43,77 @@ func (s *MockedSpotifySaver) StartLastSongsWorker(wg *sync.WaitGroup, stop chan
 		}
 	}
 }
+
+// StartPlaybackWorker is a worker that will request the player state every 10 seconds.
+// It is not async. It accepts a wait group and will send Done when stopped. It may be stopped with stop chan value.
+func (s *MockedSpotifySaver) StartPlaybackWorker(wg *sync.WaitGroup, stop chan bool) {
+	<-stop
+	wg.Done()
+}
+
+// StartEnrichmentWorker is a worker that will fetch artist and track metadata every hour.
+// It is not async. It accepts a wait group and will send Done when stopped. It may be stopped with stop chan value.
+func (s *MockedSpotifySaver) StartEnrichmentWorker(wg *sync.WaitGroup, stop chan bool) {
+	<-stop
+	wg.Done()
+}
+
+// StartWebhookWorker is a worker that will deliver the webhook outbox every 30 seconds.
+// It is not async. It accepts a wait group and will send Done when stopped. It may be stopped with stop chan value.
+func (s *MockedSpotifySaver) StartWebhookWorker(wg *sync.WaitGroup, stop chan bool) {
+	<-stop
+	wg.Done()
+}
+
+// ImportExtendedHistory mocks importing Spotify's extended streaming history.
+func (s *MockedSpotifySaver) ImportExtendedHistory(_ string) error {
+	if s.IError {
+		return errors.New("import error")
+	}
+	return nil
+}
+
+// ImportScrobbles mocks importing a Last.fm or ListenBrainz export.
+func (s *MockedSpotifySaver) ImportScrobbles(_, _ string) error {
+	if s.IError {
+		return errors.New("import error")
+	}
+	return nil
+}
+
+// SetIntervals mocks setting the intervals of the workers.
+func (s *MockedSpotifySaver) SetIntervals(_ Intervals) {}
+
+// SetLastFM mocks scrobbling to Last.fm.
+func (s *MockedSpotifySaver) SetLastFM(_ *lastfm.Client, _ lastfm.Session) {}
+
+// SetListenBrainz mocks submitting listens to ListenBrainz.
+func (s *MockedSpotifySaver) SetListenBrainz(_ *listenbrainz.Client) {}
+
+// BackfillListenBrainz mocks submitting all plays to ListenBrainz.
+func (s *MockedSpotifySaver) BackfillListenBrainz() (int, error) {
+	if s.IError {
+		return 0, errors.New("backfill error")
+	}
+	return 42, nil
+}
+
+// SetWebhooks mocks posting new plays to webhook targets.
+func (s *MockedSpotifySaver) SetWebhooks(_ *webhook.Client, _ []webhook.Target, _ int) {}
+
+// SetMQTT mocks publishing to an MQTT broker.
+func (s *MockedSpotifySaver) SetMQTT(_ *mqtt.Publisher) {}
+
+// Health returns a healthy state started a minute ago.
+func (s *MockedSpotifySaver) Health() Health {
+	now := time.Now()
+	return Health{StartedAt: now.Add(-time.Minute), LastPollAt: now, LastSuccessAt: now}
+}
+
+// CheckToken mocks a token that is valid for another hour.
+func (s *MockedSpotifySaver) CheckToken() (time.Time, error) {
+	if s.LError {
+		return time.Time{}, errors.New("token error")
+	}
+	return time.Now().Add(time.Hour), nil
+}
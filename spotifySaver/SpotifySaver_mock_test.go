@@ -32,3 +32,61 @@ func TestMockedSpotifySaver_StartLastSongsWorker(_ *testing.T) {
 
 	wg.Wait()
 }
+
+func TestMockedSpotifySaver_StartPlaybackWorker(_ *testing.T) {
+	mock := MockedSpotifySaver{}
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	stop := make(chan bool, 1)
+	stop <- true
+	mock.StartPlaybackWorker(&wg, stop)
+
+	wg.Wait()
+}
+
+func TestMockedSpotifySaver_StartEnrichmentWorker(_ *testing.T) {
+	mock := MockedSpotifySaver{}
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	stop := make(chan bool, 1)
+	stop <- true
+	mock.StartEnrichmentWorker(&wg, stop)
+
+	wg.Wait()
+}
+
+func TestMockedSpotifySaver_StartWebhookWorker(_ *testing.T) {
+	mock := MockedSpotifySaver{}
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	stop := make(chan bool, 1)
+	stop <- true
+	mock.StartWebhookWorker(&wg, stop)
+
+	wg.Wait()
+}
+
+func TestMockedSpotifySaver_ImportExtendedHistory(t *testing.T) {
+	mock := MockedSpotifySaver{}
+
+	err := mock.ImportExtendedHistory("")
+	assert.NoError(t, err)
+
+	mock.IError = true
+	err = mock.ImportExtendedHistory("")
+	assert.Error(t, err)
+}
+
+func TestMockedSpotifySaver_ImportScrobbles(t *testing.T) {
+	mock := MockedSpotifySaver{}
+
+	err := mock.ImportScrobbles("lastfm", "")
+	assert.NoError(t, err)
+
+	mock.IError = true
+	err = mock.ImportScrobbles("lastfm", "")
+	assert.Error(t, err)
+}
@@ -4,17 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/logging"
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
 	"github.com/elivlo/SpotifyHistorySaver/models"
 	"github.com/gobuffalo/packr/v2"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/gofrs/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 	"golang.org/x/oauth2"
+	"net/http"
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -58,6 +63,21 @@ func TestNewSpotifySaver(t *testing.T) {
 	assert.Nil(t, saver)
 }
 
+func TestNewSpotifySaver_pendingMigrations(t *testing.T) {
+	_, log := getTestLogger()
+
+	box, err := models.Migrations(DB)
+	assert.NoError(t, err)
+	assert.NoError(t, box.Down(1))
+	defer func() {
+		assert.NoError(t, box.Up())
+	}()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.Contains(t, err.Error(), "Database schema is outdated, 1 pending migrations")
+	assert.Nil(t, saver)
+}
+
 func TestSpotifySaver_LoadToken(t *testing.T) {
 	_, log := getTestLogger()
 
@@ -130,7 +150,7 @@ func TestSpotifySaver_getLastEntry(t *testing.T) {
 	saver, err := NewSpotifySaver(log, "test")
 	assert.NoError(t, err)
 
-	entry := saver.getLastEntry()
+	entry := saver.getLastEntry(log)
 	assert.Equal(t, time.Unix(0, 0), entry.PlayedAt)
 }
 
@@ -143,19 +163,111 @@ func TestSpotifySaver_fetchNewSongs(t *testing.T) {
 	saver.auth = spotifyauth.New()
 	saver.client = spotify.New(saver.auth.Client(context.Background(), &oauth2.Token{}))
 
-	items := saver.fetchNewSongs(models.HistoryEntry{
+	items, err := saver.fetchNewSongs(log, models.HistoryEntry{
 		PlayedAt: time.Unix(0, 0),
 	})
+	assert.Error(t, err)
 	assert.Equal(t, 0, len(items))
 }
 
+func TestSpotifySaver_pollPlayerState(t *testing.T) {
+	hook, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	saver.auth = spotifyauth.New()
+	httpClient := saver.auth.Client(context.Background(), &oauth2.Token{})
+	saver.client = spotify.New(httpClient)
+	saver.api = newAPIClient(httpClient, APIBaseURL)
+
+	polls := testutil.ToFloat64(metrics.Polls.WithLabelValues(metrics.WorkerPlayback))
+	tracker := playbackTracker{}
+	saver.pollPlayerState(log, &tracker)
+	assert.Nil(t, tracker.current)
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+	assert.Equal(t, polls+1, testutil.ToFloat64(metrics.Polls.WithLabelValues(metrics.WorkerPlayback)))
+}
+
+func TestSpotifySaver_pollHistory(t *testing.T) {
+	hook, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	server := setTestServer(saver, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"items": []}`)
+	})
+	defer server.Close()
+
+	saver.pollHistory(7)
+	assert.False(t, saver.Health().LastSuccessAt.IsZero())
+
+	finished := false
+	for _, entry := range hook.AllEntries() {
+		assert.Equal(t, uint64(7), entry.Data[logging.FieldPollID], entry.Message)
+		assert.Equal(t, "saver", entry.Data[logging.FieldComponent], entry.Message)
+		if entry.Message == "Finished fetching newly listened songs" {
+			finished = true
+			assert.Equal(t, 0, entry.Data[logging.FieldCount])
+			assert.Contains(t, entry.Data, logging.FieldDuration)
+		}
+	}
+	assert.True(t, finished)
+}
+
+func TestSpotifySaver_StartPlaybackWorker(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	stop := make(chan bool)
+	close(stop)
+	saver.StartPlaybackWorker(&wg, stop)
+
+	wg.Wait()
+}
+
+func TestSpotifySaver_StartEnrichmentWorker(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	stop := make(chan bool)
+	close(stop)
+	saver.StartEnrichmentWorker(&wg, stop)
+
+	wg.Wait()
+}
+
+func TestSpotifySaver_StartWebhookWorker(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	stop := make(chan bool)
+	close(stop)
+	saver.StartWebhookWorker(&wg, stop)
+
+	wg.Wait()
+}
+
 func TestSpotifySaver_InsertNewSongs(t *testing.T) {
 	_, log := getTestLogger()
 
 	saver, err := NewSpotifySaver(log, "test")
 	assert.NoError(t, err)
 
-	saver.insertNewSongs([]spotify.RecentlyPlayedItem{{
+	_ = saver.insertNewSongs(log, []spotify.RecentlyPlayedItem{{
 		Track:           spotify.SimpleTrack{},
 		PlayedAt:        time.Now(),
 		PlaybackContext: spotify.PlaybackContext{},
@@ -168,14 +280,58 @@ func TestSpotifySaver_SaveNewToken(t *testing.T) {
 	saver, err := NewSpotifySaver(log, "test")
 	assert.NoError(t, err)
 
+	token := &oauth2.Token{
+		AccessToken:  "aaaa",
+		TokenType:    "Bearer",
+		RefreshToken: "rrrr",
+		Expiry:       time.Now().Add(time.Hour),
+	}
 	saver.auth = spotifyauth.New()
-	saver.client = spotify.New(saver.auth.Client(context.Background(), &oauth2.Token{}))
+	saver.client = spotify.New(saver.auth.Client(context.Background(), token))
 
 	tokenName, err := uuid.NewV4()
 	assert.NoError(t, err)
 
-	saver.saveNewToken(tokenName.String())
+	t.Run("Changed", func(t *testing.T) {
+		refreshes := testutil.ToFloat64(metrics.TokenRefreshes)
+		saver.saveNewToken(log, tokenName.String())
+		assert.Equal(t, refreshes+1, testutil.ToFloat64(metrics.TokenRefreshes))
 
-	err = os.Remove(tokenName.String())
-	assert.NoError(t, err)
+		_, err = os.Stat(tokenName.String())
+		assert.NoError(t, err)
+		assert.Equal(t, token, saver.token)
+
+		err = os.Remove(tokenName.String())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Unchanged", func(t *testing.T) {
+		saver.saveNewToken(log, tokenName.String())
+
+		_, err = os.Stat(tokenName.String())
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("NoToken", func(t *testing.T) {
+		hook, log := getTestLogger()
+		saver.log = log
+		saver.client = spotify.New(saver.auth.Client(context.Background(), &oauth2.Token{}))
+
+		saver.saveNewToken(log, tokenName.String())
+
+		_, err = os.Stat(tokenName.String())
+		assert.True(t, os.IsNotExist(err))
+		assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+	})
+}
+
+func TestTokenEqual(t *testing.T) {
+	expiry := time.Now()
+	a := &oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: expiry}
+
+	assert.True(t, tokenEqual(nil, nil))
+	assert.False(t, tokenEqual(a, nil))
+	assert.True(t, tokenEqual(a, &oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: expiry}))
+	assert.False(t, tokenEqual(a, &oauth2.Token{AccessToken: "b", RefreshToken: "r", Expiry: expiry}))
+	assert.False(t, tokenEqual(a, &oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: expiry.Add(time.Second)}))
 }
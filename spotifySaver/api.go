@@ -0,0 +1,130 @@
+package spotifySaver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/zmb3/spotify/v2"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// APIBaseURL is the base URL of the Spotify Web API
+	APIBaseURL = "https://api.spotify.com/v1/"
+
+	// episodeBatchSize is the maximum number of episodes that can be requested at once
+	episodeBatchSize = 50
+)
+
+// apiClient requests Spotify Web API endpoints and options that are not supported by the spotify library.
+type apiClient struct {
+	http    *http.Client
+	baseURL string
+}
+
+func newAPIClient(httpClient *http.Client, baseURL string) *apiClient {
+	return &apiClient{
+		http:    httpClient,
+		baseURL: baseURL,
+	}
+}
+
+// get requests path with params and decodes the JSON response into result.
+// It returns the HTTP status code. The result is left untouched if the response has no content.
+func (a *apiClient) get(ctx context.Context, path string, params url.Values, result interface{}) (int, error) {
+	u := a.baseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, decodeAPIError(resp)
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(result)
+}
+
+// decodeAPIError converts an error response of the Spotify Web API into a spotify.Error.
+func decodeAPIError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	var e struct {
+		Error spotify.Error `json:"error"`
+	}
+	if json.Unmarshal(body, &e) != nil || e.Error.Message == "" {
+		e.Error.Message = fmt.Sprintf("spotify: unexpected HTTP %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	e.Error.Status = resp.StatusCode
+	return e.Error
+}
+
+// playerState is the player state including a currently playing podcast episode.
+type playerState struct {
+	spotify.PlayerState
+	// Episode is the currently playing episode. It is nil if no episode is playing.
+	Episode *spotify.EpisodePage
+}
+
+// playerState requests the current player state including podcast episodes.
+// It returns an empty state if nothing is playing.
+func (a *apiClient) playerState(ctx context.Context) (*playerState, error) {
+	var raw json.RawMessage
+	status, err := a.get(ctx, "me/player", url.Values{"additional_types": {"track,episode"}}, &raw)
+	if err != nil {
+		return nil, err
+	}
+	state := &playerState{}
+	if status == http.StatusNoContent {
+		return state, nil
+	}
+
+	err = json.Unmarshal(raw, &state.PlayerState)
+	if err != nil {
+		return nil, err
+	}
+	var typed struct {
+		Type string               `json:"currently_playing_type"`
+		Item *spotify.EpisodePage `json:"item"`
+	}
+	err = json.Unmarshal(raw, &typed)
+	if err != nil {
+		return nil, err
+	}
+	if typed.Type == "episode" {
+		state.Episode = typed.Item
+	}
+	return state, nil
+}
+
+// episodes requests up to 50 podcast episodes. Episodes that are not found are missing in the result.
+func (a *apiClient) episodes(ctx context.Context, ids []string) ([]spotify.EpisodePage, error) {
+	var result struct {
+		Episodes []*spotify.EpisodePage `json:"episodes"`
+	}
+	_, err := a.get(ctx, "episodes", url.Values{"ids": {strings.Join(ids, ",")}}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	var episodes []spotify.EpisodePage
+	for _, e := range result.Episodes {
+		if e != nil {
+			episodes = append(episodes, *e)
+		}
+	}
+	return episodes, nil
+}
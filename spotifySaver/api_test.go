@@ -0,0 +1,103 @@
+package spotifySaver
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestAPIClient(handler http.HandlerFunc) (*apiClient, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	return newAPIClient(server.Client(), server.URL+"/"), server
+}
+
+func TestAPIClient_get(t *testing.T) {
+	api, server := newTestAPIClient(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "/error":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprint(w, `{"error": {"status": 403, "message": "forbidden"}}`)
+		case "/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			_, _ = fmt.Fprintf(w, `{"query": "%s"}`, r.URL.RawQuery)
+		}
+	})
+	defer server.Close()
+
+	var result struct {
+		Query string `json:"query"`
+	}
+	status, err := api.get(context.Background(), "ok", map[string][]string{"a": {"b"}}, &result)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "a=b", result.Query)
+
+	status, err = api.get(context.Background(), "empty", nil, &result)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	_, err = api.get(context.Background(), "error", nil, &result)
+	assert.Equal(t, spotify.Error{Message: "forbidden", Status: http.StatusForbidden}, err)
+
+	_, err = api.get(context.Background(), "broken", nil, &result)
+	assert.Equal(t, http.StatusBadGateway, err.(spotify.Error).Status)
+}
+
+func TestAPIClient_playerState(t *testing.T) {
+	response := ""
+	api, server := newTestAPIClient(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/me/player", r.URL.Path)
+		assert.Equal(t, "track,episode", r.URL.Query().Get("additional_types"))
+		if response == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = fmt.Fprint(w, response)
+	})
+	defer server.Close()
+
+	state, err := api.playerState(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, state.Item)
+
+	response = `{"is_playing": true, "progress_ms": 1000, "currently_playing_type": "track",
+		"device": {"id": "d_id", "name": "d_name", "type": "Computer"},
+		"item": {"id": "t_id", "name": "t_name", "duration_ms": 180000}}`
+	state, err = api.playerState(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "t_id", state.Item.ID.String())
+	assert.Equal(t, "d_name", state.Device.Name)
+	assert.Nil(t, state.Episode)
+
+	response = `{"is_playing": true, "progress_ms": 1000, "currently_playing_type": "episode",
+		"item": {"id": "e_id", "name": "e_name", "duration_ms": 3600000, "show": {"id": "s_id", "name": "s_name"}}}`
+	state, err = api.playerState(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "e_id", state.Item.ID.String())
+	assert.Equal(t, 3600000, state.Item.Duration)
+	assert.Equal(t, "s_id", state.Episode.Show.ID.String())
+
+	response = `{`
+	_, err = api.playerState(context.Background())
+	assert.Error(t, err)
+}
+
+func TestAPIClient_episodes(t *testing.T) {
+	api, server := newTestAPIClient(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "e_id,e_id2", r.URL.Query().Get("ids"))
+		_, _ = fmt.Fprint(w, `{"episodes": [{"id": "e_id", "name": "e_name"}, null]}`)
+	})
+	defer server.Close()
+
+	episodes, err := api.episodes(context.Background(), []string{"e_id", "e_id2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(episodes))
+	assert.Equal(t, "e_name", episodes[0].Name)
+}
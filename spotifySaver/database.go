@@ -1,12 +1,16 @@
 package spotifySaver
 
 import (
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
 	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/zmb3/spotify/v2"
 	"strings"
+	"time"
 )
 
 // FetchedSongs type will be used for inserting newly pulled Spotify history entries to the database.
@@ -15,6 +19,7 @@ type FetchedSongs struct {
 	fetched []spotify.RecentlyPlayedItem
 
 	history     models.HistoryEntries
+	albums      models.Albums
 	tracks      models.Tracks
 	artists     models.Artists
 	connections models.ArtistsTracks
@@ -32,7 +37,30 @@ func NewFetchedSongs(d *pop.Connection, songs []spotify.RecentlyPlayedItem) Fetc
 // TransformAndInsertIntoDatabase will convert and insert recently played songs into database.
 func (s *FetchedSongs) TransformAndInsertIntoDatabase(log *logrus.Entry) error {
 	s.convertRecentlyToDBTables(log)
-	err := s.db.Create(&s.tracks)
+	err := s.insertTracksAndArtists()
+	if err != nil {
+		return err
+	}
+	err = s.db.Create(&s.history)
+	if err != nil {
+		return errors.Errorf("Could not insert history: %v", err)
+	}
+	observeInsertedHistory(s.history...)
+	log.WithFields(logrus.Fields{
+		"tracks":          len(s.tracks),
+		"artists":         len(s.artists),
+		"history_entries": len(s.history),
+	}).Info("Added new tracks, artists and history entries")
+	return nil
+}
+
+// insertTracksAndArtists will insert the collected albums, tracks, artists and their connections into database.
+func (s *FetchedSongs) insertTracksAndArtists() error {
+	err := s.db.Create(&s.albums)
+	if err != nil {
+		return errors.Errorf("Could not insert albums: %v", err)
+	}
+	err = s.db.Create(&s.tracks)
 	if err != nil {
 		return errors.Errorf("Could not insert tracks: %v", err)
 	}
@@ -40,48 +68,89 @@ func (s *FetchedSongs) TransformAndInsertIntoDatabase(log *logrus.Entry) error {
 	if err != nil {
 		return errors.Errorf("Could not insert artists: %v", err)
 	}
-	err = s.db.Create(&s.history)
-	if err != nil {
-		return errors.Errorf("Could not insert history: %v", err)
-	}
 	err = s.db.Create(&s.connections)
 	if err != nil {
 		return errors.Errorf("Could not insert artist track connections: %v", err)
 	}
-	log.Infof("Added %d new tracks, %d new artists and %d history tracks", len(s.tracks), len(s.artists), len(s.history))
+	metrics.Inserted.WithLabelValues("albums").Add(float64(len(s.albums)))
+	metrics.Inserted.WithLabelValues("tracks").Add(float64(len(s.tracks)))
+	metrics.Inserted.WithLabelValues("artists").Add(float64(len(s.artists)))
 	return nil
 }
 
+// observeInsertedHistory counts the inserted history entries and updates the time of the latest stored play.
+func observeInsertedHistory(entries ...models.HistoryEntry) {
+	metrics.Inserted.WithLabelValues("history_entries").Add(float64(len(entries)))
+	for _, entry := range entries {
+		metrics.ObserveLatestPlay(entry.PlayedAt)
+	}
+}
+
 // convertRecentlyToDBTables will convert API json to database models.
 // It will also exclude Tracks and Artists that already exists in database.
 func (s *FetchedSongs) convertRecentlyToDBTables(log *logrus.Entry) {
 	for _, song := range s.fetched {
+		if song.Track.Type == "episode" {
+			log.Warnf("Skip episode %s in recently played history", song.Track.ID)
+			continue
+		}
 		s.history = append(s.history, convertToHistoryEntry(song))
+		s.addTrack(song.Track, log)
+	}
+	s.history.SortByDate()
+}
 
-		track := convertToTrackEntry(song)
-		trackInserted, err := s.trackAlreadyInserted(track.ID)
+// addTrack will add the track and its artists if they do not exist in database yet.
+func (s *FetchedSongs) addTrack(t spotify.SimpleTrack, log *logrus.Entry) {
+	track := convertToTrackEntry(t)
+	trackInserted, err := s.trackAlreadyInserted(track.ID)
+	if err != nil {
+		log.Errorf("Song %v could not be added: %v\n", t, err)
+		return
+	}
+	if trackInserted {
+		return
+	}
+	s.tracks = append(s.tracks, track)
+	arts, conn := convertToArtistEntries(t)
+	s.connections = append(s.connections, conn...)
+
+	for _, art := range arts {
+		artInserted, err := s.artistAlreadyInserted(art.ID)
 		if err != nil {
-			log.Errorf("Song %v could not be added: %v\n", song, err)
+			log.Errorf("Artist %v could not be added: %v\n", art, err)
 			continue
 		}
-		if !trackInserted {
-			s.tracks = append(s.tracks, track)
-			arts, conn := convertToArtistEntries(song)
-			s.connections = append(s.connections, conn...)
-
-			for _, art := range arts {
-				artInserted, err := s.artistAlreadyInserted(art.ID)
-				if err != nil {
-					log.Errorf("Artist %v could not be added: %v\n", art, err)
-					continue
-				}
-				if !artInserted {
-					s.artists = append(s.artists, art)
-				}
-			}
+		if !artInserted {
+			s.artists = append(s.artists, art)
 		}
 	}
-	s.history.SortByDate()
+}
+
+// addFullTrack will add the track with its album and artists if they do not exist in database yet.
+// The track is marked as enriched, because its album is already known.
+func (s *FetchedSongs) addFullTrack(t spotify.FullTrack, now time.Time, log *logrus.Entry) {
+	added := len(s.tracks)
+	s.addTrack(t.SimpleTrack, log)
+	if len(s.tracks) == added {
+		return
+	}
+	track := &s.tracks[added]
+	track.EnrichedAt = nulls.NewTime(now)
+	if t.Album.ID == "" {
+		return
+	}
+	track.AlbumID = nulls.NewString(t.Album.ID.String())
+
+	albumInserted, err := s.albumAlreadyInserted(t.Album.ID.String())
+	if err != nil {
+		log.Errorf("Album %v could not be added: %v\n", t.Album, err)
+		track.AlbumID = nulls.String{}
+		return
+	}
+	if !albumInserted {
+		s.albums = append(s.albums, convertToAlbum(t.Album))
+	}
 }
 
 // trackAlreadyInserted check if database contains track.
@@ -122,31 +191,63 @@ func (s *FetchedSongs) artistAlreadyInserted(id string) (bool, error) {
 	return false, err
 }
 
+// albumAlreadyInserted check if database contains album.
+func (s *FetchedSongs) albumAlreadyInserted(id string) (bool, error) {
+	for _, a := range s.albums {
+		if a.ID == id {
+			return true, nil
+		}
+	}
+	return s.db.Where("id = ?", id).Exists(&models.Album{})
+}
+
 func convertToHistoryEntry(song spotify.RecentlyPlayedItem) models.HistoryEntry {
-	return models.HistoryEntry{
-		TrackID:  song.Track.ID.String(),
+	entry := models.HistoryEntry{
+		TrackID:  nulls.NewString(song.Track.ID.String()),
 		PlayedAt: song.PlayedAt,
 	}
+	if song.PlaybackContext.URI != "" {
+		entry.ContextURI = nulls.NewString(string(song.PlaybackContext.URI))
+	}
+	return entry
 }
 
-func convertToTrackEntry(song spotify.RecentlyPlayedItem) models.Track {
-	track := song.Track
+func convertToTrackEntry(track spotify.SimpleTrack) models.Track {
 	return models.Track{
 		ID:          track.ID.String(),
 		Name:        track.Name,
 		TrackNumber: track.TrackNumber,
 		DiscNumber:  track.DiscNumber,
 		Explicit:    track.Explicit,
+		DurationMs:  track.Duration,
+	}
+}
+
+func convertToAlbum(album spotify.SimpleAlbum) models.Album {
+	return models.Album{
+		ID:          album.ID.String(),
+		Name:        album.Name,
+		AlbumType:   album.AlbumType,
+		ReleaseDate: album.ReleaseDate,
+		ImageURL:    imageURL(album.Images),
 	}
 }
 
+// imageURL returns the URL of the widest image. It is empty if there are no images, null image URLs are
+// fetched by the enrichers.
+func imageURL(images []spotify.Image) nulls.String {
+	if len(images) == 0 {
+		return nulls.NewString("")
+	}
+	return nulls.NewString(images[0].URL)
+}
+
 // convertToArtistEntries created artists and the connection to a track.
-func convertToArtistEntries(song spotify.RecentlyPlayedItem) (models.Artists, models.ArtistsTracks) {
-	songID := song.Track.ID.String()
+func convertToArtistEntries(track spotify.SimpleTrack) (models.Artists, models.ArtistsTracks) {
+	songID := track.ID.String()
 	var artists models.Artists
 	var connection models.ArtistsTracks
-	as := song.Track.Artists
-	for _, a := range as {
+	for _, a := range track.Artists {
 		artists = append(artists, models.Artist{
 			ID:   a.ID.String(),
 			Name: a.Name,
@@ -159,8 +260,205 @@ func convertToArtistEntries(song spotify.RecentlyPlayedItem) (models.Artists, mo
 	return artists, connection
 }
 
+// getLastHistoryEntry returns the last played track. Episodes are ignored, because they are not part
+// of the recently played history.
 func getLastHistoryEntry(db *pop.Connection) (models.HistoryEntry, error) {
 	var last models.HistoryEntry
-	err := db.Order("played_at DESC").First(&last)
+	err := db.Where("track_id IS NOT NULL AND source IS NULL").Order("played_at DESC").First(&last)
 	return last, err
 }
+
+// insertPlaybackSession will insert a playback session, the played track or episode and the device it was played on.
+// The track or episode is only inserted if it does not exist in database yet.
+// Episodes played for at least MinMsPlayed are inserted as history entry, because they are missing in the recently played history.
+func insertPlaybackSession(db *pop.Connection, log *logrus.Entry, observed *observedSession) error {
+	var err error
+	if observed.episode != nil {
+		err = saveEpisode(db, convertToEpisode(*observed.episode), convertToShow(observed.episode.Show))
+	} else {
+		fetched := NewFetchedSongs(db, nil)
+		fetched.addFullTrack(observed.track, time.Now(), log)
+		err = fetched.insertTracksAndArtists()
+	}
+	if err != nil {
+		return err
+	}
+	if observed.device != nil {
+		err = saveDevice(db, *observed.device)
+		if err != nil {
+			return err
+		}
+	}
+
+	session := &observed.session
+	if observed.episode != nil && observed.listenedMs >= MinMsPlayed {
+		entry := models.HistoryEntry{
+			EpisodeID: session.EpisodeID,
+			PlayedAt:  session.EndedAt,
+			DeviceID:  session.DeviceID,
+			MsPlayed:  nulls.NewInt(observed.listenedMs),
+		}
+		if session.ContextURI != "" {
+			entry.ContextURI = nulls.NewString(session.ContextURI)
+		}
+		err = db.Create(&entry)
+		if err != nil {
+			return errors.Errorf("Could not insert episode history entry: %v", err)
+		}
+		observeInsertedHistory(entry)
+		session.HistoryEntryID = nulls.NewInt(entry.ID)
+	}
+
+	err = db.Create(session)
+	if err != nil {
+		return errors.Errorf("Could not insert playback session: %v", err)
+	}
+	metrics.Inserted.WithLabelValues("playback_sessions").Inc()
+	return nil
+}
+
+// saveEpisode inserts an episode and its show if they do not exist in database yet.
+func saveEpisode(db *pop.Connection, episode models.Episode, show *models.Show) error {
+	if show != nil {
+		exists, err := db.Where("id = ?", show.ID).Exists(&models.Show{})
+		if err != nil {
+			return errors.Errorf("Could not check show %s: %v", show.ID, err)
+		}
+		if !exists {
+			err = db.Create(show)
+			if err != nil {
+				return errors.Errorf("Could not insert show %s: %v", show.ID, err)
+			}
+		}
+		episode.ShowID = nulls.NewString(show.ID)
+	}
+
+	exists, err := db.Where("id = ?", episode.ID).Exists(&models.Episode{})
+	if err != nil {
+		return errors.Errorf("Could not check episode %s: %v", episode.ID, err)
+	}
+	if exists {
+		return nil
+	}
+	err = db.Create(&episode)
+	if err != nil {
+		return errors.Errorf("Could not insert episode %s: %v", episode.ID, err)
+	}
+	return nil
+}
+
+// convertToEpisode creates an episode without its show.
+func convertToEpisode(e spotify.EpisodePage) models.Episode {
+	return models.Episode{
+		ID:          e.ID.String(),
+		Name:        e.Name,
+		DurationMs:  e.Duration_ms,
+		ReleaseDate: e.ReleaseDate,
+	}
+}
+
+// convertToShow creates a show. It returns nil if the show is unknown.
+func convertToShow(s spotify.SimpleShow) *models.Show {
+	if s.ID == "" {
+		return nil
+	}
+	return &models.Show{
+		ID:        s.ID.String(),
+		Name:      s.Name,
+		Publisher: s.Publisher,
+	}
+}
+
+// saveAlbum inserts album or updates it if it already exists.
+func saveAlbum(db *pop.Connection, album models.Album) error {
+	exists, err := db.Where("id = ?", album.ID).Exists(&models.Album{})
+	if err != nil {
+		return errors.Errorf("Could not check album %s: %v", album.ID, err)
+	}
+	if exists {
+		err = db.Update(&album)
+	} else {
+		err = db.Create(&album)
+	}
+	if err != nil {
+		return errors.Errorf("Could not save album %s: %v", album.ID, err)
+	}
+	return nil
+}
+
+// saveDevice inserts device or updates its name and type if it already exists.
+func saveDevice(db *pop.Connection, device models.Device) error {
+	exists, err := db.Where("id = ?", device.ID).Exists(&models.Device{})
+	if err != nil {
+		return errors.Errorf("Could not check device %s: %v", device.ID, err)
+	}
+	if exists {
+		err = db.Update(&device)
+	} else {
+		err = db.Create(&device)
+	}
+	if err != nil {
+		return errors.Errorf("Could not save device %s: %v", device.ID, err)
+	}
+	return nil
+}
+
+// convertToDevice creates a device from the player device. Devices without an ID are identified by type and name.
+// It returns false if the player device is unknown.
+func convertToDevice(d spotify.PlayerDevice) (models.Device, bool) {
+	if d.ID == "" && d.Name == "" {
+		return models.Device{}, false
+	}
+	id := d.ID.String()
+	if id == "" {
+		id = fmt.Sprintf("player:%s:%s", d.Type, d.Name)
+	}
+	return models.Device{
+		ID:   id,
+		Name: d.Name,
+		Type: d.Type,
+	}, true
+}
+
+// reconcilePlaybackSessions links playback sessions ended after since to the history entries Spotify reported for them.
+// The history entries get the device of the linked session. It returns the number of linked sessions.
+func reconcilePlaybackSessions(db *pop.Connection, since time.Time) (int, error) {
+	var sessions models.PlaybackSessions
+	err := db.Where("history_entry_id IS NULL AND track_id IS NOT NULL AND ended_at >= ?", since).
+		Order("started_at ASC").
+		All(&sessions)
+	if err != nil {
+		return 0, errors.Errorf("Could not get unlinked playback sessions: %v", err)
+	}
+
+	linked := 0
+	for _, session := range sessions {
+		var entry models.HistoryEntry
+		err = db.Where("track_id = ? AND played_at BETWEEN ? AND ?", session.TrackID,
+			session.StartedAt.Add(-reconcileWindow), session.EndedAt.Add(reconcileWindow)).
+			Where("id NOT IN (SELECT history_entry_id FROM playback_sessions WHERE history_entry_id IS NOT NULL)").
+			Order("played_at ASC").
+			First(&entry)
+		if err != nil && strings.Contains(err.Error(), "sql: no rows in result set") {
+			continue
+		}
+		if err != nil {
+			return linked, errors.Errorf("Could not find history entry for playback session %d: %v", session.ID, err)
+		}
+
+		session.HistoryEntryID = nulls.NewInt(entry.ID)
+		err = db.UpdateColumns(&session, "history_entry_id")
+		if err != nil {
+			return linked, errors.Errorf("Could not link playback session %d: %v", session.ID, err)
+		}
+		if session.DeviceID.Valid && !entry.DeviceID.Valid {
+			entry.DeviceID = session.DeviceID
+			err = db.UpdateColumns(&entry, "device_id")
+			if err != nil {
+				return linked, errors.Errorf("Could not set device of history entry %d: %v", entry.ID, err)
+			}
+		}
+		linked++
+	}
+	return linked, nil
+}
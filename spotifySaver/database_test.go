@@ -2,6 +2,7 @@ package spotifySaver
 
 import (
 	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
 	"github.com/stretchr/testify/assert"
 	"github.com/zmb3/spotify/v2"
 	"testing"
@@ -49,6 +50,17 @@ func TestFetchedSongs_convertRecentlyToDBTables(t *testing.T) {
 
 	songs.convertRecentlyToDBTables(log)
 	assert.Equal(t, 0, len(hook.AllEntries()))
+	assert.Equal(t, 1, len(songs.history))
+
+	songs = NewFetchedSongs(DB, []spotify.RecentlyPlayedItem{{
+		Track: spotify.SimpleTrack{
+			ID:   "e_id",
+			Type: "episode",
+		},
+	}})
+	songs.convertRecentlyToDBTables(log)
+	assert.Equal(t, 0, len(songs.history))
+	assert.Equal(t, 0, len(songs.tracks))
 }
 
 func TestFetchedSongs_trackAlreadyInserted(t *testing.T) {
@@ -112,7 +124,12 @@ func TestConvertToHistoryEntry(t *testing.T) {
 
 	entry := convertToHistoryEntry(song)
 	assert.Equal(t, now, entry.PlayedAt)
-	assert.Equal(t, "t_id", entry.TrackID)
+	assert.Equal(t, "t_id", entry.TrackID.String)
+	assert.False(t, entry.ContextURI.Valid)
+
+	song.PlaybackContext.URI = "spotify:playlist:p_id"
+	entry = convertToHistoryEntry(song)
+	assert.Equal(t, "spotify:playlist:p_id", entry.ContextURI.String)
 }
 
 func TestConvertToTrackEntry(t *testing.T) {
@@ -123,15 +140,50 @@ func TestConvertToTrackEntry(t *testing.T) {
 			ID:          "t_id",
 			Name:        "t_name",
 			TrackNumber: 1,
+			Duration:    180000,
 		},
 	}
 
-	entry := convertToTrackEntry(song)
+	entry := convertToTrackEntry(song.Track)
 	assert.Equal(t, "t_id", entry.ID)
 	assert.Equal(t, "t_name", entry.Name)
 	assert.Equal(t, 1, entry.TrackNumber)
 	assert.Equal(t, 1, entry.DiscNumber)
 	assert.True(t, entry.Explicit)
+	assert.Equal(t, 180000, entry.DurationMs)
+}
+
+func TestConvertToAlbum(t *testing.T) {
+	album := convertToAlbum(spotify.SimpleAlbum{
+		ID:          "al_id",
+		Name:        "al_name",
+		AlbumType:   "single",
+		ReleaseDate: "2021-03-01",
+		Images:      []spotify.Image{{URL: "https://i.scdn.co/image/640"}, {URL: "https://i.scdn.co/image/300"}},
+	})
+	assert.Equal(t, models.Album{
+		ID:          "al_id",
+		Name:        "al_name",
+		AlbumType:   "single",
+		ReleaseDate: "2021-03-01",
+		ImageURL:    nulls.NewString("https://i.scdn.co/image/640"),
+	}, album)
+
+	album = convertToAlbum(spotify.SimpleAlbum{ID: "al_id"})
+	assert.Equal(t, nulls.NewString(""), album.ImageURL)
+}
+
+func TestSaveAlbum(t *testing.T) {
+	err := saveAlbum(DB, models.Album{ID: "al_id_save", Name: "al_name"})
+	assert.NoError(t, err)
+
+	err = saveAlbum(DB, models.Album{ID: "al_id_save", Name: "al_name", ImageURL: nulls.NewString("https://i.scdn.co/image/640")})
+	assert.NoError(t, err)
+
+	var album models.Album
+	err = DB.Find(&album, "al_id_save")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://i.scdn.co/image/640", album.ImageURL.String)
 }
 
 func TestConvertToArtistEntries(t *testing.T) {
@@ -145,7 +197,7 @@ func TestConvertToArtistEntries(t *testing.T) {
 		},
 	}
 
-	artists, tracks := convertToArtistEntries(song)
+	artists, tracks := convertToArtistEntries(song.Track)
 	assert.Equal(t, 1, len(artists))
 	assert.Equal(t, 1, len(tracks))
 
@@ -159,7 +211,7 @@ func TestConvertToArtistEntries(t *testing.T) {
 func TestGetLastHistoryEntry(t *testing.T) {
 	now := time.Now()
 	entry := models.HistoryEntry{
-		TrackID:  "t_id",
+		TrackID:  nulls.NewString("t_id"),
 		PlayedAt: now,
 	}
 	err := DB.Create(&entry)
@@ -168,6 +220,193 @@ func TestGetLastHistoryEntry(t *testing.T) {
 	e, err := getLastHistoryEntry(DB)
 	assert.NoError(t, err)
 
-	assert.Equal(t, "t_id", e.TrackID)
+	assert.Equal(t, "t_id", e.TrackID.String)
 	assert.Equal(t, 1, e.ID)
 }
+
+func TestInsertPlaybackSession(t *testing.T) {
+	_, log := getTestLogger()
+
+	observed := &observedSession{
+		track: spotify.FullTrack{
+			SimpleTrack: spotify.SimpleTrack{
+				ID:       "t_id_session",
+				Name:     "t_name",
+				Duration: 180000,
+				Artists: []spotify.SimpleArtist{{
+					ID:   "a_id_session",
+					Name: "a_name",
+				}},
+			},
+			Album: spotify.SimpleAlbum{
+				ID:   "al_id_session",
+				Name: "al_name",
+			},
+		},
+		device: &models.Device{
+			ID:   "d_id_session",
+			Name: "d_name",
+			Type: "Computer",
+		},
+		session: models.PlaybackSession{
+			TrackID:   nulls.NewString("t_id_session"),
+			DeviceID:  nulls.NewString("d_id_session"),
+			StartedAt: time.Now(),
+			EndedAt:   time.Now(),
+		},
+	}
+
+	err := insertPlaybackSession(DB, log, observed)
+	assert.NoError(t, err)
+	assert.NotZero(t, observed.session.ID)
+
+	var inserted models.Track
+	err = DB.Find(&inserted, "t_id_session")
+	assert.NoError(t, err)
+	assert.Equal(t, 180000, inserted.DurationMs)
+	assert.Equal(t, "al_id_session", inserted.AlbumID.String)
+	assert.True(t, inserted.EnrichedAt.Valid)
+
+	var device models.Device
+	err = DB.Find(&device, "d_id_session")
+	assert.NoError(t, err)
+	assert.Equal(t, "d_name", device.Name)
+}
+
+func TestInsertPlaybackSession_Episode(t *testing.T) {
+	_, log := getTestLogger()
+
+	observed := &observedSession{
+		episode: &spotify.EpisodePage{
+			ID:          "e_id_session",
+			Name:        "e_name",
+			Duration_ms: 3600000,
+			Show: spotify.SimpleShow{
+				ID:   "s_id_session",
+				Name: "s_name",
+			},
+		},
+		session: models.PlaybackSession{
+			EpisodeID: nulls.NewString("e_id_session"),
+			StartedAt: time.Now().Add(-time.Minute),
+			EndedAt:   time.Now(),
+		},
+		listenedMs: 60000,
+	}
+
+	err := insertPlaybackSession(DB, log, observed)
+	assert.NoError(t, err)
+	assert.True(t, observed.session.HistoryEntryID.Valid)
+
+	var episode models.Episode
+	err = DB.Find(&episode, "e_id_session")
+	assert.NoError(t, err)
+	assert.Equal(t, "s_id_session", episode.ShowID.String)
+
+	var entry models.HistoryEntry
+	err = DB.Find(&entry, observed.session.HistoryEntryID.Int)
+	assert.NoError(t, err)
+	assert.Equal(t, "e_id_session", entry.EpisodeID.String)
+	assert.False(t, entry.TrackID.Valid)
+	assert.Equal(t, 60000, entry.MsPlayed.Int)
+}
+
+func TestSaveEpisode(t *testing.T) {
+	err := saveEpisode(DB, models.Episode{ID: "e_id_save"}, nil)
+	assert.NoError(t, err)
+	err = saveEpisode(DB, models.Episode{ID: "e_id_save"}, nil)
+	assert.NoError(t, err)
+
+	err = saveEpisode(DB, models.Episode{ID: "e_id_save_show"}, &models.Show{ID: "s_id_save"})
+	assert.NoError(t, err)
+
+	var episode models.Episode
+	err = DB.Find(&episode, "e_id_save_show")
+	assert.NoError(t, err)
+	assert.Equal(t, "s_id_save", episode.ShowID.String)
+}
+
+func TestConvertToShow(t *testing.T) {
+	assert.Nil(t, convertToShow(spotify.SimpleShow{}))
+
+	show := convertToShow(spotify.SimpleShow{ID: "s_id", Name: "s_name", Publisher: "s_publisher"})
+	assert.Equal(t, &models.Show{ID: "s_id", Name: "s_name", Publisher: "s_publisher"}, show)
+}
+
+func TestSaveDevice(t *testing.T) {
+	err := saveDevice(DB, models.Device{ID: "d_id_save", Name: "old", Type: "Computer"})
+	assert.NoError(t, err)
+
+	err = saveDevice(DB, models.Device{ID: "d_id_save", Name: "new", Type: "Computer"})
+	assert.NoError(t, err)
+
+	var device models.Device
+	err = DB.Find(&device, "d_id_save")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", device.Name)
+}
+
+func TestConvertToDevice(t *testing.T) {
+	_, ok := convertToDevice(spotify.PlayerDevice{})
+	assert.False(t, ok)
+
+	device, ok := convertToDevice(spotify.PlayerDevice{ID: "d_id", Name: "d_name", Type: "Speaker"})
+	assert.True(t, ok)
+	assert.Equal(t, models.Device{ID: "d_id", Name: "d_name", Type: "Speaker"}, device)
+
+	device, ok = convertToDevice(spotify.PlayerDevice{Name: "d_name", Type: "Speaker"})
+	assert.True(t, ok)
+	assert.Equal(t, "player:Speaker:d_name", device.ID)
+}
+
+func TestReconcilePlaybackSessions(t *testing.T) {
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err := DB.Create(&models.Track{ID: "t_id_reconcile"})
+	assert.NoError(t, err)
+
+	entry := models.HistoryEntry{
+		TrackID:  nulls.NewString("t_id_reconcile"),
+		PlayedAt: start.Add(3 * time.Minute),
+	}
+	err = DB.Create(&entry)
+	assert.NoError(t, err)
+
+	err = DB.Create(&models.Device{ID: "d_id_reconcile"})
+	assert.NoError(t, err)
+	played := models.PlaybackSession{
+		TrackID:   nulls.NewString("t_id_reconcile"),
+		DeviceID:  nulls.NewString("d_id_reconcile"),
+		StartedAt: start,
+		EndedAt:   start.Add(3 * time.Minute),
+	}
+	skipped := models.PlaybackSession{
+		TrackID:   nulls.NewString("t_id_reconcile"),
+		StartedAt: start.Add(10 * time.Minute),
+		EndedAt:   start.Add(11 * time.Minute),
+		Skipped:   true,
+	}
+	err = DB.Create(&played)
+	assert.NoError(t, err)
+	err = DB.Create(&skipped)
+	assert.NoError(t, err)
+
+	linked, err := reconcilePlaybackSessions(DB, start.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, linked)
+
+	err = DB.Reload(&played)
+	assert.NoError(t, err)
+	assert.Equal(t, entry.ID, played.HistoryEntryID.Int)
+
+	err = DB.Reload(&entry)
+	assert.NoError(t, err)
+	assert.Equal(t, "d_id_reconcile", entry.DeviceID.String)
+
+	err = DB.Reload(&skipped)
+	assert.NoError(t, err)
+	assert.False(t, skipped.HistoryEntryID.Valid)
+
+	linked, err = reconcilePlaybackSessions(DB, start.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, linked)
+}
@@ -0,0 +1,429 @@
+package spotifySaver
+
+import (
+	"context"
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// EnrichmentInterval is the interval in which the enrichment worker fetches missing artist metadata
+	EnrichmentInterval = time.Hour
+	// ArtistRefreshInterval is the age after which genres, popularity and followers of an artist are fetched again
+	ArtistRefreshInterval = 7 * 24 * time.Hour
+	// UnavailableRetryInterval is the time after which an endpoint that was unavailable for the app is requested again
+	UnavailableRetryInterval = 30 * 24 * time.Hour
+
+	// artistBatchSize is the maximum number of artists that can be requested at once
+	artistBatchSize = 50
+	// artistsPerRun is the maximum number of artists enriched in one run to spread requests over time
+	artistsPerRun = 1000
+	// audioFeaturesBatchSize is the maximum number of audio features that can be requested at once
+	audioFeaturesBatchSize = 100
+	// tracksPerRun is the maximum number of tracks enriched in one run to spread requests over time
+	tracksPerRun = 1000
+
+	// audioFeaturesState is the enrichment state ID of the audio features endpoint
+	audioFeaturesState = "audio_features"
+
+	// spotifyIDCondition excludes tracks and artists of imported scrobbles, their IDs like "import:<hash>" are no Spotify IDs
+	spotifyIDCondition = "id NOT LIKE '%:%'"
+)
+
+// artistEnricher fetches full artist objects from Spotify and saves their genres and statistics.
+type artistEnricher struct {
+	db     *pop.Connection
+	client *spotify.Client
+	log    *logrus.Entry
+
+	// genres caches the IDs of known genres by name
+	genres map[string]int
+}
+
+func newArtistEnricher(db *pop.Connection, client *spotify.Client, log *logrus.Entry) artistEnricher {
+	return artistEnricher{
+		db:     db,
+		client: client,
+		log:    log,
+		genres: map[string]int{},
+	}
+}
+
+// enrichArtists fetches all artists that were never enriched, not refreshed since ArtistRefreshInterval or whose
+// image was never fetched. Their genres are replaced and their popularity and follower count are recorded at now.
+// It returns the number of enriched artists.
+func (e artistEnricher) enrichArtists(now time.Time) (int, error) {
+	var artists models.Artists
+	err := e.db.Where("(enriched_at IS NULL OR enriched_at < ? OR image_url IS NULL) AND "+spotifyIDCondition,
+		now.Add(-ArtistRefreshInterval)).
+		Order("enriched_at ASC").
+		Limit(artistsPerRun).
+		All(&artists)
+	if err != nil {
+		return 0, errors.Errorf("Could not get artists to enrich: %v", err)
+	}
+
+	enriched := 0
+	for start := 0; start < len(artists); start += artistBatchSize {
+		end := start + artistBatchSize
+		if end > len(artists) {
+			end = len(artists)
+		}
+		batch := artists[start:end]
+
+		ids := make([]spotify.ID, len(batch))
+		for i, a := range batch {
+			ids[i] = spotify.ID(a.ID)
+		}
+		full, err := e.client.GetArtists(context.Background(), ids...)
+		metrics.ObserveAPIError(err)
+		if permanentClientError(err) {
+			// Requesting the batch again fails again, its artists are handled like unknown ones
+			e.log.Warnf("Spotify refused artists %v: %v", ids, err)
+			full, err = nil, nil
+		}
+		if err != nil {
+			return enriched, errors.Errorf("Could not get artists: %v", err)
+		}
+		found := map[string]*spotify.FullArtist{}
+		for _, a := range full {
+			if a != nil {
+				found[a.ID.String()] = a
+			}
+		}
+
+		for _, artist := range batch {
+			if a, ok := found[artist.ID]; ok {
+				err = e.saveArtistDetails(artist, a, now)
+				if err != nil {
+					return enriched, err
+				}
+				enriched++
+				continue
+			}
+			// Do not request unknown artists again before the next refresh
+			e.log.Warnf("Artist %s not found", artist.ID)
+			artist.ImageURL = imageURL(nil)
+			artist.EnrichedAt = nulls.NewTime(now)
+			err = e.db.UpdateColumns(&artist, "image_url", "enriched_at")
+			if err != nil {
+				return enriched, errors.Errorf("Could not update artist %s: %v", artist.ID, err)
+			}
+		}
+	}
+	return enriched, nil
+}
+
+// saveArtistDetails replaces the genres of artist and records its popularity and follower count.
+func (e artistEnricher) saveArtistDetails(artist models.Artist, full *spotify.FullArtist, now time.Time) error {
+	err := e.db.RawQuery("DELETE FROM artists_genres WHERE artist_id = ?", artist.ID).Exec()
+	if err != nil {
+		return errors.Errorf("Could not delete genres of artist %s: %v", artist.ID, err)
+	}
+	var connections models.ArtistsGenres
+	for _, name := range full.Genres {
+		id, err := e.genreID(name)
+		if err != nil {
+			return err
+		}
+		connections = append(connections, models.ArtistsGenre{
+			ArtistID: artist.ID,
+			GenreID:  id,
+		})
+	}
+	err = e.db.Create(&connections)
+	if err != nil {
+		return errors.Errorf("Could not insert genres of artist %s: %v", artist.ID, err)
+	}
+
+	err = e.db.Create(&models.ArtistStat{
+		ArtistID:   artist.ID,
+		Popularity: full.Popularity,
+		Followers:  int(full.Followers.Count),
+		RecordedAt: now,
+	})
+	if err != nil {
+		return errors.Errorf("Could not insert statistics of artist %s: %v", artist.ID, err)
+	}
+
+	artist.Name = full.Name
+	artist.ImageURL = imageURL(full.Images)
+	artist.EnrichedAt = nulls.NewTime(now)
+	err = e.db.UpdateColumns(&artist, "name", "image_url", "enriched_at")
+	if err != nil {
+		return errors.Errorf("Could not update artist %s: %v", artist.ID, err)
+	}
+	return nil
+}
+
+// genreID returns the ID of the genre with name. The genre is inserted if it does not exist in database yet.
+func (e artistEnricher) genreID(name string) (int, error) {
+	if id, ok := e.genres[name]; ok {
+		return id, nil
+	}
+
+	genre := models.Genre{}
+	err := e.db.Where("name = ?", name).First(&genre)
+	if err != nil && strings.Contains(err.Error(), "sql: no rows in result set") {
+		genre.Name = name
+		err = e.db.Create(&genre)
+	}
+	if err != nil {
+		return 0, errors.Errorf("Could not save genre %s: %v", name, err)
+	}
+	e.genres[name] = genre.ID
+	return genre.ID, nil
+}
+
+// trackEnricher fetches full track objects from Spotify to save the albums missing in the recently played history.
+type trackEnricher struct {
+	db     *pop.Connection
+	client *spotify.Client
+	log    *logrus.Entry
+}
+
+func newTrackEnricher(db *pop.Connection, client *spotify.Client, log *logrus.Entry) trackEnricher {
+	return trackEnricher{
+		db:     db,
+		client: client,
+		log:    log,
+	}
+}
+
+// enrichTracks fetches all tracks that were never enriched or whose ISRC or album image was never fetched and saves
+// their albums, ISRCs, durations and positions on their albums.
+// It returns the number of enriched tracks.
+func (e trackEnricher) enrichTracks(now time.Time) (int, error) {
+	var tracks models.Tracks
+	err := e.db.Where("(enriched_at IS NULL OR isrc IS NULL OR " +
+		"album_id IN (SELECT id FROM albums WHERE image_url IS NULL)) AND " + spotifyIDCondition).
+		Limit(tracksPerRun).
+		All(&tracks)
+	if err != nil {
+		return 0, errors.Errorf("Could not get tracks to enrich: %v", err)
+	}
+
+	enriched := 0
+	for start := 0; start < len(tracks); start += trackBatchSize {
+		end := start + trackBatchSize
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+		batch := tracks[start:end]
+
+		ids := make([]spotify.ID, len(batch))
+		for i, t := range batch {
+			ids[i] = spotify.ID(t.ID)
+		}
+		full, err := e.client.GetTracks(context.Background(), ids)
+		metrics.ObserveAPIError(err)
+		if permanentClientError(err) {
+			// Requesting the batch again fails again, its tracks are handled like unknown ones
+			e.log.Warnf("Spotify refused tracks %v: %v", ids, err)
+			full, err = nil, nil
+		}
+		if err != nil {
+			return enriched, errors.Errorf("Could not get tracks: %v", err)
+		}
+		found := map[string]*spotify.FullTrack{}
+		for _, t := range full {
+			if t != nil {
+				found[t.ID.String()] = t
+			}
+		}
+
+		for _, track := range batch {
+			t, ok := found[track.ID]
+			if ok && t.Album.ID != "" {
+				album := convertToAlbum(t.Album)
+				err = saveAlbum(e.db, album)
+				if err != nil {
+					return enriched, err
+				}
+				track.AlbumID = nulls.NewString(album.ID)
+				track.DurationMs = t.Duration
+				track.TrackNumber = t.TrackNumber
+				track.DiscNumber = t.DiscNumber
+				track.ISRC = nulls.NewString(t.ExternalIDs["isrc"])
+				enriched++
+			} else {
+				// Do not request unknown tracks again
+				e.log.Warnf("Track %s not found", track.ID)
+				track.ISRC = nulls.NewString("")
+			}
+			track.EnrichedAt = nulls.NewTime(now)
+			err = e.db.UpdateColumns(&track, "album_id", "duration_ms", "track_number", "disc_number", "isrc", "enriched_at")
+			if err != nil {
+				return enriched, errors.Errorf("Could not update track %s: %v", track.ID, err)
+			}
+		}
+	}
+	return enriched, nil
+}
+
+// audioFeatureEnricher fetches audio features of tracks from Spotify.
+type audioFeatureEnricher struct {
+	db     *pop.Connection
+	client *spotify.Client
+	log    *logrus.Entry
+}
+
+func newAudioFeatureEnricher(db *pop.Connection, client *spotify.Client, log *logrus.Entry) audioFeatureEnricher {
+	return audioFeatureEnricher{
+		db:     db,
+		client: client,
+		log:    log,
+	}
+}
+
+// enrichTracks fetches the audio features of all tracks that were not checked yet. Tracks without audio features
+// are only marked as checked. Spotify does not grant every app access to audio features, so the endpoint is not
+// requested again for UnavailableRetryInterval once it refused a request. It returns the number of saved audio features.
+func (e audioFeatureEnricher) enrichTracks(now time.Time) (int, error) {
+	state, err := getEnrichmentState(e.db, audioFeaturesState)
+	if err != nil {
+		return 0, err
+	}
+	if state.Unavailable && now.Before(state.CheckedAt.Add(UnavailableRetryInterval)) {
+		e.log.Debugf("Skip audio features, endpoint unavailable since %v: %s", state.CheckedAt, state.Message)
+		return 0, nil
+	}
+
+	var tracks models.Tracks
+	err = e.db.Where("audio_features_checked_at IS NULL AND " + spotifyIDCondition).Limit(tracksPerRun).All(&tracks)
+	if err != nil {
+		return 0, errors.Errorf("Could not get tracks without audio features: %v", err)
+	}
+
+	enriched := 0
+	for start := 0; start < len(tracks); start += audioFeaturesBatchSize {
+		end := start + audioFeaturesBatchSize
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+		batch := tracks[start:end]
+
+		ids := make([]spotify.ID, len(batch))
+		for i, t := range batch {
+			ids[i] = spotify.ID(t.ID)
+		}
+		features, err := e.client.GetAudioFeatures(context.Background(), ids...)
+		metrics.ObserveAPIError(err)
+		if apiErr, ok := err.(spotify.Error); ok && endpointUnavailable(apiErr.Status) {
+			e.log.Warnf("Audio features are unavailable, retrying after %v: %v", UnavailableRetryInterval, apiErr)
+			state.Unavailable = true
+			state.Status = apiErr.Status
+			state.Message = apiErr.Message
+			state.CheckedAt = now
+			return enriched, saveEnrichmentState(e.db, state)
+		}
+		if permanentClientError(err) {
+			// Requesting the batch again fails again, its tracks are only marked as checked
+			e.log.Warnf("Spotify refused audio features of tracks %v: %v", ids, err)
+			features, err = nil, nil
+		}
+		if err != nil {
+			return enriched, errors.Errorf("Could not get audio features: %v", err)
+		}
+
+		found := map[string]*spotify.AudioFeatures{}
+		for _, f := range features {
+			if f != nil {
+				found[f.ID.String()] = f
+			}
+		}
+		for _, track := range batch {
+			if f, ok := found[track.ID]; ok {
+				err = e.db.Create(convertToAudioFeature(f))
+				if err != nil {
+					return enriched, errors.Errorf("Could not insert audio features of track %s: %v", track.ID, err)
+				}
+				enriched++
+			}
+			track.AudioFeaturesCheckedAt = nulls.NewTime(now)
+			err = e.db.UpdateColumns(&track, "audio_features_checked_at")
+			if err != nil {
+				return enriched, errors.Errorf("Could not update track %s: %v", track.ID, err)
+			}
+		}
+	}
+
+	if state.Unavailable {
+		e.log.Info("Audio features are available again")
+		state.Unavailable = false
+		state.Status = 0
+		state.Message = ""
+		state.CheckedAt = now
+		return enriched, saveEnrichmentState(e.db, state)
+	}
+	return enriched, nil
+}
+
+func convertToAudioFeature(f *spotify.AudioFeatures) *models.TrackAudioFeature {
+	return &models.TrackAudioFeature{
+		ID:               f.ID.String(),
+		Acousticness:     f.Acousticness,
+		Danceability:     f.Danceability,
+		Energy:           f.Energy,
+		Instrumentalness: f.Instrumentalness,
+		PitchClass:       f.Key,
+		Liveness:         f.Liveness,
+		Loudness:         f.Loudness,
+		Mode:             f.Mode,
+		Speechiness:      f.Speechiness,
+		Tempo:            f.Tempo,
+		TimeSignature:    f.TimeSignature,
+		Valence:          f.Valence,
+	}
+}
+
+// endpointUnavailable checks if an HTTP status means the endpoint can not be used by the app.
+func endpointUnavailable(status int) bool {
+	return status == http.StatusForbidden || status == http.StatusNotFound || status == http.StatusGone
+}
+
+// permanentClientError checks if err is a client error of Spotify that occurs again if the same request is repeated,
+// e.g. 400 Bad Request for an invalid ID. Expired tokens and rate limits are temporary.
+func permanentClientError(err error) bool {
+	apiErr, ok := err.(spotify.Error)
+	return ok && apiErr.Status >= http.StatusBadRequest && apiErr.Status < http.StatusInternalServerError &&
+		apiErr.Status != http.StatusUnauthorized && apiErr.Status != http.StatusTooManyRequests
+}
+
+// getEnrichmentState returns the state with id. A new state is returned if it does not exist in database yet.
+func getEnrichmentState(db *pop.Connection, id string) (models.EnrichmentState, error) {
+	state := models.EnrichmentState{}
+	err := db.Find(&state, id)
+	if err != nil && strings.Contains(err.Error(), "sql: no rows in result set") {
+		return models.EnrichmentState{ID: id}, nil
+	}
+	if err != nil {
+		return state, errors.Errorf("Could not get enrichment state %s: %v", id, err)
+	}
+	return state, nil
+}
+
+// saveEnrichmentState inserts state or updates it if it already exists.
+func saveEnrichmentState(db *pop.Connection, state models.EnrichmentState) error {
+	exists, err := db.Where("id = ?", state.ID).Exists(&models.EnrichmentState{})
+	if err != nil {
+		return errors.Errorf("Could not check enrichment state %s: %v", state.ID, err)
+	}
+	if exists {
+		err = db.Update(&state)
+	} else {
+		err = db.Create(&state)
+	}
+	if err != nil {
+		return errors.Errorf("Could not save enrichment state %s: %v", state.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package spotifySaver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArtistEnricher_enrichArtists(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	// Artists of other tests are not enriched in this test
+	err = DB.RawQuery("UPDATE artists SET enriched_at = ?, image_url = ''", now.Add(ArtistRefreshInterval)).Exec()
+	assert.NoError(t, err)
+	artists := models.Artists{
+		{ID: "a_id_enrich", Name: "a_name_old"},
+		{ID: "a_id_enrich_unknown", Name: "a_name_unknown"},
+		// Enriched before images were saved
+		{ID: "a_id_enrich_image", Name: "a_name_image", EnrichedAt: nulls.NewTime(now.Add(-time.Hour))},
+	}
+	err = DB.Create(&artists)
+	assert.NoError(t, err)
+
+	var requested []string
+	genres := `["rock", "indie"]`
+	status := http.StatusOK
+	server := setTestServer(saver, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/artists", r.URL.Path)
+		requested = append(requested, r.URL.Query().Get("ids"))
+		if status != http.StatusOK {
+			writeAPIError(w, status)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"artists": [{"id": "a_id_enrich", "name": "a_name_enrich", "genres": %s,
+			"popularity": 42, "followers": {"total": 1000}, "images": [{"url": "https://i.scdn.co/image/a"}]}, null,
+			{"id": "a_id_enrich_image", "name": "a_name_image", "images": []}]}`, genres)
+	})
+	defer server.Close()
+
+	enricher := newArtistEnricher(DB, saver.client, log)
+	enriched, err := enricher.enrichArtists(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, enriched)
+	assert.Equal(t, []string{"a_id_enrich,a_id_enrich_unknown,a_id_enrich_image"}, requested)
+
+	var artist models.Artist
+	err = DB.Find(&artist, "a_id_enrich")
+	assert.NoError(t, err)
+	assert.Equal(t, "a_name_enrich", artist.Name)
+	assert.Equal(t, "https://i.scdn.co/image/a", artist.ImageURL.String)
+	assert.True(t, artist.EnrichedAt.Valid)
+
+	err = DB.Find(&artist, "a_id_enrich_unknown")
+	assert.NoError(t, err)
+	assert.True(t, artist.EnrichedAt.Valid)
+	assert.Equal(t, nulls.NewString(""), artist.ImageURL)
+
+	// Artists without image are not requested again
+	err = DB.Find(&artist, "a_id_enrich_image")
+	assert.NoError(t, err)
+	assert.Equal(t, nulls.NewString(""), artist.ImageURL)
+
+	count, err := DB.Where("artist_id = ?", "a_id_enrich").Count(&models.ArtistsGenre{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	var stats models.ArtistStats
+	err = DB.Where("artist_id = ?", "a_id_enrich").All(&stats)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, 42, stats[0].Popularity)
+	assert.Equal(t, 1000, stats[0].Followers)
+
+	t.Run("NothingToRefresh", func(t *testing.T) {
+		requested = nil
+		enriched, err = enricher.enrichArtists(now.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, enriched)
+		assert.Empty(t, requested)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		requested = nil
+		genres = `["rock", "pop"]`
+		enriched, err = newArtistEnricher(DB, saver.client, log).enrichArtists(now.Add(ArtistRefreshInterval + time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, enriched)
+
+		var connections models.ArtistsGenres
+		err = DB.Where("artist_id = ?", "a_id_enrich").All(&connections)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(connections))
+
+		count, err = DB.Where("name IN (?, ?, ?)", "rock", "indie", "pop").Count(&models.Genre{})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, count)
+
+		count, err = DB.Where("artist_id = ?", "a_id_enrich").Count(&models.ArtistStat{})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("BadRequest", func(t *testing.T) {
+		err = DB.Create(&models.Artist{ID: "a_id_enrich_invalid", Name: "a_name_invalid"})
+		assert.NoError(t, err)
+		requested = nil
+		status = http.StatusBadRequest
+		enriched, err = enricher.enrichArtists(now.Add(ArtistRefreshInterval + time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, enriched)
+		assert.Equal(t, []string{"a_id_enrich_invalid"}, requested)
+
+		err = DB.Find(&artist, "a_id_enrich_invalid")
+		assert.NoError(t, err)
+		assert.True(t, artist.EnrichedAt.Valid)
+
+		// The batch is not requested again before the next refresh
+		enriched, err = enricher.enrichArtists(now.Add(ArtistRefreshInterval + time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(requested))
+	})
+}
+
+func TestAudioFeatureEnricher_enrichTracks(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	// Tracks of other tests are not enriched in this test
+	err = DB.RawQuery("UPDATE tracks SET audio_features_checked_at = ?", now).Exec()
+	assert.NoError(t, err)
+	tracks := models.Tracks{
+		{ID: "t_id_features", Name: "t_name_features"},
+		{ID: "t_id_features_unknown", Name: "t_name_features_unknown"},
+	}
+	err = DB.Create(&tracks)
+	assert.NoError(t, err)
+
+	var requested []string
+	status := http.StatusOK
+	server := setTestServer(saver, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/audio-features", r.URL.Path)
+		requested = append(requested, r.URL.Query().Get("ids"))
+		if status != http.StatusOK {
+			writeAPIError(w, status)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"audio_features": [{"id": "t_id_features", "energy": 0.8, "tempo": 120.5,
+			"valence": 0.3, "key": 5, "mode": 1, "time_signature": 4}, null]}`)
+	})
+	defer server.Close()
+
+	enricher := newAudioFeatureEnricher(DB, saver.client, log)
+	enriched, err := enricher.enrichTracks(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, enriched)
+	assert.Equal(t, []string{"t_id_features,t_id_features_unknown"}, requested)
+
+	var features models.TrackAudioFeature
+	err = DB.Find(&features, "t_id_features")
+	assert.NoError(t, err)
+	assert.Equal(t, float32(0.8), features.Energy)
+	assert.Equal(t, float32(120.5), features.Tempo)
+	assert.Equal(t, 5, features.PitchClass)
+
+	count, err := DB.Where("audio_features_checked_at IS NULL").Count(&models.Track{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	err = DB.Create(&models.Track{ID: "t_id_features_forbidden"})
+	assert.NoError(t, err)
+
+	t.Run("Unavailable", func(t *testing.T) {
+		requested = nil
+		status = http.StatusForbidden
+		enriched, err = enricher.enrichTracks(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, enriched)
+		assert.Equal(t, []string{"t_id_features_forbidden"}, requested)
+
+		state, err := getEnrichmentState(DB, audioFeaturesState)
+		assert.NoError(t, err)
+		assert.True(t, state.Unavailable)
+		assert.Equal(t, http.StatusForbidden, state.Status)
+		assert.Equal(t, "forbidden", state.Message)
+
+		enriched, err = enricher.enrichTracks(now.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, enriched)
+		assert.Equal(t, 1, len(requested))
+	})
+
+	t.Run("AvailableAgain", func(t *testing.T) {
+		requested = nil
+		status = http.StatusOK
+		enriched, err = enricher.enrichTracks(now.Add(UnavailableRetryInterval))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, enriched)
+		assert.Equal(t, []string{"t_id_features_forbidden"}, requested)
+
+		state, err := getEnrichmentState(DB, audioFeaturesState)
+		assert.NoError(t, err)
+		assert.False(t, state.Unavailable)
+
+		count, err = DB.Where("audio_features_checked_at IS NULL").Count(&models.Track{})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("BadRequest", func(t *testing.T) {
+		err = DB.Create(&models.Track{ID: "t_id_features_invalid"})
+		assert.NoError(t, err)
+		requested = nil
+		status = http.StatusBadRequest
+		enriched, err = enricher.enrichTracks(now.Add(UnavailableRetryInterval))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, enriched)
+		assert.Equal(t, []string{"t_id_features_invalid"}, requested)
+
+		state, err := getEnrichmentState(DB, audioFeaturesState)
+		assert.NoError(t, err)
+		assert.False(t, state.Unavailable)
+
+		// The batch is not requested again
+		enriched, err = enricher.enrichTracks(now.Add(UnavailableRetryInterval))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(requested))
+	})
+}
+
+func TestPermanentClientError(t *testing.T) {
+	assert.True(t, permanentClientError(spotify.Error{Status: http.StatusBadRequest}))
+	assert.True(t, permanentClientError(spotify.Error{Status: http.StatusNotFound}))
+	assert.False(t, permanentClientError(spotify.Error{Status: http.StatusUnauthorized}))
+	assert.False(t, permanentClientError(spotify.Error{Status: http.StatusTooManyRequests}))
+	assert.False(t, permanentClientError(spotify.Error{Status: http.StatusBadGateway}))
+	assert.False(t, permanentClientError(errors.New("connection refused")))
+	assert.False(t, permanentClientError(nil))
+}
+
+func TestEndpointUnavailable(t *testing.T) {
+	assert.True(t, endpointUnavailable(http.StatusForbidden))
+	assert.True(t, endpointUnavailable(http.StatusNotFound))
+	assert.False(t, endpointUnavailable(http.StatusTooManyRequests))
+	assert.False(t, endpointUnavailable(http.StatusInternalServerError))
+}
+
+func TestTrackEnricher_enrichTracks(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	// Tracks of other tests are not enriched in this test
+	err = DB.RawQuery("UPDATE tracks SET enriched_at = ?, isrc = COALESCE(isrc, '')", now).Exec()
+	assert.NoError(t, err)
+	err = DB.RawQuery("UPDATE albums SET image_url = '' WHERE image_url IS NULL").Exec()
+	assert.NoError(t, err)
+	// The album of t_id_album_image was saved before images were saved
+	err = DB.Create(&models.Album{ID: "al_id_image", Name: "al_name_image"})
+	assert.NoError(t, err)
+	tracks := models.Tracks{
+		{ID: "t_id_album", Name: "t_name_album"},
+		{ID: "t_id_album2", Name: "t_name_album2"},
+		{ID: "t_id_album_unknown", Name: "t_name_album_unknown"},
+		{ID: "t_id_album_image", Name: "t_name_album_image", AlbumID: nulls.NewString("al_id_image"),
+			ISRC: nulls.NewString(""), EnrichedAt: nulls.NewTime(now)},
+		// Enriched before ISRCs were saved
+		{ID: "t_id_album_isrc", Name: "t_name_album_isrc", EnrichedAt: nulls.NewTime(now)},
+	}
+	err = DB.Create(&tracks)
+	assert.NoError(t, err)
+
+	var requested []string
+	status := http.StatusOK
+	server := setTestServer(saver, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/tracks", r.URL.Path)
+		requested = append(requested, r.URL.Query().Get("ids"))
+		if status != http.StatusOK {
+			writeAPIError(w, status)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"tracks": [
+			{"id": "t_id_album", "album": {"id": "al_id_enrich", "name": "al_name", "album_type": "album", "release_date": "2021"},
+				"duration_ms": 200000, "track_number": 3, "disc_number": 2, "external_ids": {"isrc": "USRC17607839"}},
+			{"id": "t_id_album2", "album": {"id": "al_id_enrich", "name": "al_name", "album_type": "album", "release_date": "2021"}},
+			null,
+			{"id": "t_id_album_image", "album": {"id": "al_id_image", "name": "al_name_image",
+				"images": [{"url": "https://i.scdn.co/image/al"}]}},
+			{"id": "t_id_album_isrc", "album": {"id": "al_id_enrich", "name": "al_name", "album_type": "album", "release_date": "2021"},
+				"external_ids": {"isrc": "GBAYE0601498"}}]}`)
+	})
+	defer server.Close()
+
+	enricher := newTrackEnricher(DB, saver.client, log)
+	enriched, err := enricher.enrichTracks(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, enriched)
+	assert.Equal(t, []string{"t_id_album,t_id_album2,t_id_album_image,t_id_album_isrc,t_id_album_unknown"}, requested)
+
+	var album models.Album
+	err = DB.Find(&album, "al_id_enrich")
+	assert.NoError(t, err)
+	assert.Equal(t, "album", album.AlbumType)
+	assert.Equal(t, "2021", album.ReleaseDate)
+	assert.Equal(t, nulls.NewString(""), album.ImageURL)
+
+	err = DB.Find(&album, "al_id_image")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://i.scdn.co/image/al", album.ImageURL.String)
+
+	var track models.Track
+	err = DB.Find(&track, "t_id_album")
+	assert.NoError(t, err)
+	assert.Equal(t, "USRC17607839", track.ISRC.String)
+	assert.Equal(t, 200000, track.DurationMs)
+	assert.Equal(t, 3, track.TrackNumber)
+	assert.Equal(t, 2, track.DiscNumber)
+
+	err = DB.Find(&track, "t_id_album2")
+	assert.NoError(t, err)
+	assert.Equal(t, "al_id_enrich", track.AlbumID.String)
+	assert.Equal(t, nulls.NewString(""), track.ISRC)
+
+	err = DB.Find(&track, "t_id_album_isrc")
+	assert.NoError(t, err)
+	assert.Equal(t, "GBAYE0601498", track.ISRC.String)
+
+	err = DB.Find(&track, "t_id_album_unknown")
+	assert.NoError(t, err)
+	assert.False(t, track.AlbumID.Valid)
+	assert.Equal(t, nulls.NewString(""), track.ISRC)
+	assert.True(t, track.EnrichedAt.Valid)
+
+	enriched, err = enricher.enrichTracks(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, enriched)
+	assert.Equal(t, 1, len(requested))
+
+	t.Run("BadRequest", func(t *testing.T) {
+		err = DB.Create(&models.Track{ID: "t_id_album_invalid", Name: "t_name_album_invalid"})
+		assert.NoError(t, err)
+		requested = nil
+		status = http.StatusBadRequest
+		enriched, err = enricher.enrichTracks(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, enriched)
+		assert.Equal(t, []string{"t_id_album_invalid"}, requested)
+
+		err = DB.Find(&track, "t_id_album_invalid")
+		assert.NoError(t, err)
+		assert.True(t, track.EnrichedAt.Valid)
+
+		// The batch is not requested again
+		enriched, err = enricher.enrichTracks(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(requested))
+	})
+}
+
+// writeAPIError writes an error of the Spotify API with status.
+func writeAPIError(w http.ResponseWriter, status int) {
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, `{"error": {"status": %d, "message": "%s"}}`, status, strings.ToLower(http.StatusText(status)))
+}
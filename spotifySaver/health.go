@@ -0,0 +1,81 @@
+package spotifySaver
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Health is the state of the workers reported by the health endpoints.
+// Only runs of the StartLastSongsWorker count as polls, errors of all workers are recorded as last error.
+type Health struct {
+	StartedAt     time.Time
+	LastPollAt    time.Time
+	LastSuccessAt time.Time
+	LastError     string
+	LastErrorAt   time.Time
+	// FailedPolls is the number of polls that failed in a row
+	FailedPolls int
+}
+
+// healthRecorder records the Health of the workers. It is safe for concurrent use.
+type healthRecorder struct {
+	mu     sync.Mutex
+	health Health
+}
+
+// recordPoll records a finished poll. It failed if err is not nil.
+func (h *healthRecorder) recordPoll(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.health.LastPollAt = now
+	if err != nil {
+		h.health.FailedPolls++
+		h.setError(now, err)
+		return
+	}
+	h.health.FailedPolls = 0
+	h.health.LastSuccessAt = now
+}
+
+// recordError records an error of a worker that does not poll the history.
+func (h *healthRecorder) recordError(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.setError(now, err)
+}
+
+func (h *healthRecorder) setError(now time.Time, err error) {
+	h.health.LastError = err.Error()
+	h.health.LastErrorAt = now
+}
+
+func (h *healthRecorder) get() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.health
+}
+
+// Health returns the current state of the workers.
+func (s *SpotifySaver) Health() Health {
+	return s.health.get()
+}
+
+// CheckToken returns the expiry of the current token. An expired token is refreshed first.
+// It will throw an error if the saver is not authenticated or the token could not be refreshed.
+func (s *SpotifySaver) CheckToken() (time.Time, error) {
+	if s.client == nil {
+		return time.Time{}, errors.New("not authenticated")
+	}
+	token, err := s.client.Token()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !token.Valid() {
+		return token.Expiry, errors.New("token is invalid")
+	}
+	return token.Expiry, nil
+}
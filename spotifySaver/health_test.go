@@ -0,0 +1,73 @@
+package spotifySaver
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
+	"testing"
+	"time"
+)
+
+func TestHealthRecorder(t *testing.T) {
+	now := time.Now()
+	h := healthRecorder{}
+
+	h.recordPoll(now, nil)
+	assert.Equal(t, Health{LastPollAt: now, LastSuccessAt: now}, h.get())
+
+	h.recordPoll(now.Add(time.Minute), errors.New("poll failed"))
+	h.recordPoll(now.Add(2*time.Minute), errors.New("poll failed again"))
+	health := h.get()
+	assert.Equal(t, 2, health.FailedPolls)
+	assert.Equal(t, now, health.LastSuccessAt)
+	assert.Equal(t, now.Add(2*time.Minute), health.LastPollAt)
+	assert.Equal(t, "poll failed again", health.LastError)
+
+	h.recordError(now.Add(3*time.Minute), errors.New("enrichment failed"))
+	h.recordPoll(now.Add(4*time.Minute), nil)
+	health = h.get()
+	assert.Equal(t, 0, health.FailedPolls)
+	assert.Equal(t, now.Add(4*time.Minute), health.LastSuccessAt)
+	assert.Equal(t, "enrichment failed", health.LastError)
+	assert.Equal(t, now.Add(3*time.Minute), health.LastErrorAt)
+}
+
+func TestSpotifySaver_Health(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	health := saver.Health()
+	assert.False(t, health.StartedAt.IsZero())
+	assert.True(t, health.LastPollAt.IsZero())
+}
+
+func TestSpotifySaver_CheckToken(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	_, err = saver.CheckToken()
+	assert.Error(t, err)
+
+	expiry := time.Now().Add(time.Hour)
+	saver.auth = spotifyauth.New()
+	saver.client = spotify.New(saver.auth.Client(context.Background(), &oauth2.Token{
+		AccessToken:  "aaaa",
+		TokenType:    "Bearer",
+		RefreshToken: "rrrr",
+		Expiry:       expiry,
+	}))
+	got, err := saver.CheckToken()
+	assert.NoError(t, err)
+	assert.Equal(t, expiry, got)
+
+	saver.client = spotify.New(saver.auth.Client(context.Background(), &oauth2.Token{}))
+	_, err = saver.CheckToken()
+	assert.Error(t, err)
+}
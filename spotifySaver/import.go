@@ -0,0 +1,355 @@
+package spotifySaver
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/elivlo/SpotifyHistorySaver/logging"
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// importMatchWindow is the tolerance used when matching imported plays to existing history entries
+	importMatchWindow = 30 * time.Second
+	// trackBatchSize is the maximum number of tracks that can be requested at once
+	trackBatchSize = 50
+)
+
+// ExtendedHistoryEntry is one entry of Spotify's extended streaming history export (endsong_*.json).
+type ExtendedHistoryEntry struct {
+	Ts                            time.Time `json:"ts"`
+	Username                      string    `json:"username"`
+	Platform                      string    `json:"platform"`
+	MsPlayed                      int       `json:"ms_played"`
+	ConnCountry                   string    `json:"conn_country"`
+	MasterMetadataTrackName       string    `json:"master_metadata_track_name"`
+	MasterMetadataAlbumArtistName string    `json:"master_metadata_album_artist_name"`
+	MasterMetadataAlbumAlbumName  string    `json:"master_metadata_album_album_name"`
+	SpotifyTrackURI               string    `json:"spotify_track_uri"`
+	EpisodeName                   string    `json:"episode_name"`
+	EpisodeShowName               string    `json:"episode_show_name"`
+	SpotifyEpisodeURI             string    `json:"spotify_episode_uri"`
+	ReasonStart                   string    `json:"reason_start"`
+	ReasonEnd                     string    `json:"reason_end"`
+	Shuffle                       bool      `json:"shuffle"`
+	Skipped                       bool      `json:"skipped"`
+	Offline                       bool      `json:"offline"`
+	IncognitoMode                 bool      `json:"incognito_mode"`
+}
+
+// TrackID returns the Spotify ID of the played track or an empty string if the entry is no track.
+func (e ExtendedHistoryEntry) TrackID() string {
+	return idFromURI(e.SpotifyTrackURI, "track")
+}
+
+// EpisodeID returns the Spotify ID of the played podcast episode or an empty string if the entry is no episode.
+func (e ExtendedHistoryEntry) EpisodeID() string {
+	return idFromURI(e.SpotifyEpisodeURI, "episode")
+}
+
+// ImportExtendedHistory will import a file of Spotify's extended streaming history export.
+// Plays that were already saved from the recently played history are completed with play time and platform.
+func (s *SpotifySaver) ImportExtendedHistory(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := readExtendedHistory(f)
+	if err != nil {
+		return errors.Errorf("Could not read extended history %s: %v", file, err)
+	}
+	s.log.Infof("Read %d entries from %s", len(entries), file)
+
+	importer := newExtendedHistoryImporter(s.dbConnection, s.client, s.api, s.log.WithField(logging.FieldCategory, "import"))
+	return importer.importEntries(entries)
+}
+
+// readExtendedHistory decodes a JSON array of extended streaming history entries.
+func readExtendedHistory(r io.Reader) ([]ExtendedHistoryEntry, error) {
+	var entries []ExtendedHistoryEntry
+	err := json.NewDecoder(r).Decode(&entries)
+	return entries, err
+}
+
+// extendedHistoryImporter converts extended streaming history entries and inserts them into database.
+type extendedHistoryImporter struct {
+	db     *pop.Connection
+	client *spotify.Client
+	api    *apiClient
+	log    *logrus.Entry
+}
+
+func newExtendedHistoryImporter(db *pop.Connection, client *spotify.Client, api *apiClient, log *logrus.Entry) extendedHistoryImporter {
+	return extendedHistoryImporter{
+		db:     db,
+		client: client,
+		api:    api,
+		log:    log,
+	}
+}
+
+// importEntries inserts all played tracks and episodes with their devices and history entries.
+func (i extendedHistoryImporter) importEntries(entries []ExtendedHistoryEntry) error {
+	var plays []ExtendedHistoryEntry
+	for _, e := range entries {
+		if (e.TrackID() != "" || e.EpisodeID() != "") && e.MsPlayed >= MinMsPlayed {
+			plays = append(plays, e)
+		}
+	}
+
+	err := i.insertTracks(plays)
+	if err != nil {
+		return err
+	}
+	err = i.insertEpisodes(plays)
+	if err != nil {
+		return err
+	}
+
+	added, updated := 0, 0
+	savedDevices := map[string]bool{}
+	for _, play := range plays {
+		device := platformDevice(play.Platform)
+		if device != nil && !savedDevices[device.ID] {
+			err = saveDevice(i.db, *device)
+			if err != nil {
+				return err
+			}
+			savedDevices[device.ID] = true
+		}
+
+		inserted, err := i.savePlay(play, device)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			added++
+		} else {
+			updated++
+		}
+	}
+	i.log.WithFields(logrus.Fields{"added": added, "updated": updated}).Info("Imported new history entries and completed existing ones")
+	return nil
+}
+
+// insertTracks inserts all tracks of plays that do not exist in database yet.
+// The tracks and their artists are requested from Spotify in batches.
+func (i extendedHistoryImporter) insertTracks(plays []ExtendedHistoryEntry) error {
+	fetched := NewFetchedSongs(i.db, nil)
+	names := map[string]string{}
+	var missing []spotify.ID
+	for _, play := range plays {
+		id := play.TrackID()
+		if id == "" {
+			continue
+		}
+		if _, ok := names[id]; ok {
+			continue
+		}
+		names[id] = play.MasterMetadataTrackName
+
+		inserted, err := fetched.trackAlreadyInserted(id)
+		if err != nil {
+			return errors.Errorf("Could not check track %s: %v", id, err)
+		}
+		if !inserted {
+			missing = append(missing, spotify.ID(id))
+		}
+	}
+
+	for start := 0; start < len(missing); start += trackBatchSize {
+		end := start + trackBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batch := missing[start:end]
+
+		tracks, err := i.client.GetTracks(context.Background(), batch)
+		metrics.ObserveAPIError(err)
+		if err != nil {
+			return errors.Errorf("Could not get tracks: %v", err)
+		}
+		found := map[spotify.ID]*spotify.FullTrack{}
+		for _, track := range tracks {
+			if track != nil {
+				found[track.ID] = track
+			}
+		}
+		for _, id := range batch {
+			if track, ok := found[id]; ok {
+				fetched.addFullTrack(*track, time.Now(), i.log)
+				continue
+			}
+			i.log.Warnf("Track %s not found, saving it without artists", id)
+			fetched.addTrack(spotify.SimpleTrack{ID: id, Name: names[id.String()]}, i.log)
+		}
+	}
+	return fetched.insertTracksAndArtists()
+}
+
+// insertEpisodes inserts all episodes of plays that do not exist in database yet.
+// The episodes and their shows are requested from Spotify in batches.
+func (i extendedHistoryImporter) insertEpisodes(plays []ExtendedHistoryEntry) error {
+	fallback := map[string]ExtendedHistoryEntry{}
+	var missing []string
+	for _, play := range plays {
+		id := play.EpisodeID()
+		if id == "" {
+			continue
+		}
+		if _, ok := fallback[id]; ok {
+			continue
+		}
+		fallback[id] = play
+
+		exists, err := i.db.Where("id = ?", id).Exists(&models.Episode{})
+		if err != nil {
+			return errors.Errorf("Could not check episode %s: %v", id, err)
+		}
+		if !exists {
+			missing = append(missing, id)
+		}
+	}
+
+	for start := 0; start < len(missing); start += episodeBatchSize {
+		end := start + episodeBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batch := missing[start:end]
+
+		episodes, err := i.api.episodes(context.Background(), batch)
+		metrics.ObserveAPIError(err)
+		if err != nil {
+			return errors.Errorf("Could not get episodes: %v", err)
+		}
+		found := map[string]spotify.EpisodePage{}
+		for _, e := range episodes {
+			found[e.ID.String()] = e
+		}
+		for _, id := range batch {
+			if e, ok := found[id]; ok {
+				err = saveEpisode(i.db, convertToEpisode(e), convertToShow(e.Show))
+			} else {
+				i.log.Warnf("Episode %s not found, saving it with exported names", id)
+				play := fallback[id]
+				err = saveEpisode(i.db, models.Episode{ID: id, Name: play.EpisodeName}, exportedShow(play.EpisodeShowName))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// savePlay completes an existing history entry of play with play time and device or inserts a new one.
+// It returns true if a new history entry was inserted.
+func (i extendedHistoryImporter) savePlay(play ExtendedHistoryEntry, device *models.Device) (bool, error) {
+	column, id := "track_id", play.TrackID()
+	if id == "" {
+		column, id = "episode_id", play.EpisodeID()
+	}
+
+	entry := models.HistoryEntry{}
+	err := i.db.Where(column+" = ? AND played_at BETWEEN ? AND ?", id,
+		play.Ts.Add(-importMatchWindow), play.Ts.Add(importMatchWindow)).First(&entry)
+	if err != nil && !strings.Contains(err.Error(), "sql: no rows in result set") {
+		return false, errors.Errorf("Could not find history entry: %v", err)
+	}
+	found := err == nil
+
+	entry.MsPlayed = nulls.NewInt(play.MsPlayed)
+	if device != nil {
+		entry.DeviceID = nulls.NewString(device.ID)
+	}
+	if found {
+		err = i.db.UpdateColumns(&entry, "ms_played", "device_id")
+		if err != nil {
+			return false, errors.Errorf("Could not update history entry %d: %v", entry.ID, err)
+		}
+		return false, nil
+	}
+
+	if play.TrackID() != "" {
+		entry.TrackID = nulls.NewString(id)
+	} else {
+		entry.EpisodeID = nulls.NewString(id)
+	}
+	entry.PlayedAt = play.Ts
+	err = i.db.Create(&entry)
+	if err != nil {
+		return false, errors.Errorf("Could not insert history entry: %v", err)
+	}
+	observeInsertedHistory(entry)
+	return true, nil
+}
+
+// exportedShow creates a show from its exported name. It returns nil if the name is unknown.
+func exportedShow(name string) *models.Show {
+	if name == "" {
+		return nil
+	}
+	sum := sha1.Sum([]byte(name))
+	return &models.Show{
+		ID:   "export:" + hex.EncodeToString(sum[:]),
+		Name: name,
+	}
+}
+
+// platformDevice maps the platform of the extended streaming history to a device.
+// It returns nil if the platform is unknown.
+func platformDevice(platform string) *models.Device {
+	if platform == "" {
+		return nil
+	}
+	sum := sha1.Sum([]byte(platform))
+	return &models.Device{
+		ID:   "platform:" + hex.EncodeToString(sum[:]),
+		Name: platform,
+		Type: platformType(platform),
+	}
+}
+
+// platformType guesses the Spotify device type from a platform description like "Android OS 11 API 30 (Google, Pixel 5)".
+func platformType(platform string) string {
+	p := strings.ToLower(platform)
+	switch {
+	case strings.Contains(p, "ipad") || strings.Contains(p, "tablet"):
+		return "Tablet"
+	case strings.Contains(p, "android_tv") || strings.Contains(p, "tizen") || strings.Contains(p, "webos") || strings.Contains(p, " tv"):
+		return "TV"
+	case strings.Contains(p, "playstation") || strings.Contains(p, "xbox"):
+		return "GameConsole"
+	case strings.Contains(p, "cast") || strings.Contains(p, "sonos") || strings.Contains(p, "speaker") || strings.Contains(p, "alexa"):
+		return "Speaker"
+	case strings.Contains(p, "android") || strings.Contains(p, "ios"):
+		return "Smartphone"
+	case strings.Contains(p, "windows") || strings.Contains(p, "os x") || strings.Contains(p, "macos") ||
+		strings.Contains(p, "linux") || strings.Contains(p, "web_player"):
+		return "Computer"
+	}
+	return "Unknown"
+}
+
+// idFromURI returns the ID of a Spotify URI like "spotify:track:6rqhFgbbKwnb9MLmUQDhG6" if it has the given type.
+func idFromURI(uri, typ string) string {
+	parts := strings.Split(uri, ":")
+	if len(parts) != 3 || parts[0] != "spotify" || parts[1] != typ {
+		return ""
+	}
+	return parts[2]
+}
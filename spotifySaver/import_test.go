@@ -0,0 +1,215 @@
+package spotifySaver
+
+import (
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setTestServer lets saver send all Spotify requests to a local server with handler.
+func setTestServer(saver *SpotifySaver, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(handler)
+	saver.client = spotify.New(server.Client(), spotify.WithBaseURL(server.URL+"/"))
+	saver.api = newAPIClient(server.Client(), server.URL+"/")
+	return server
+}
+
+const extendedHistoryJSON = `[
+  {
+    "ts": "2021-03-20T10:00:00Z",
+    "platform": "Android OS 11 API 30 (Google, Pixel 5)",
+    "ms_played": 180000,
+    "master_metadata_track_name": "t_name_import",
+    "master_metadata_album_artist_name": "a_name_import",
+    "spotify_track_uri": "spotify:track:t_id_import"
+  },
+  {
+    "ts": "2021-03-20T10:05:00Z",
+    "platform": "Android OS 11 API 30 (Google, Pixel 5)",
+    "ms_played": 1000,
+    "master_metadata_track_name": "t_name_import",
+    "spotify_track_uri": "spotify:track:t_id_import"
+  },
+  {
+    "ts": "2021-03-20T11:00:00Z",
+    "platform": "OS X 11.2.3 [x86 8]",
+    "ms_played": 120000,
+    "master_metadata_track_name": "t_name_missing",
+    "spotify_track_uri": "spotify:track:t_id_missing"
+  },
+  {
+    "ts": "2021-03-20T11:05:00Z",
+    "platform": "OS X 11.2.3 [x86 8]",
+    "ms_played": 120000,
+    "master_metadata_track_name": "t_name_unknown",
+    "spotify_track_uri": "spotify:track:t_id_unknown"
+  },
+  {
+    "ts": "2021-03-20T12:00:00Z",
+    "platform": "OS X 11.2.3 [x86 8]",
+    "ms_played": 1200000,
+    "episode_name": "e_name_import",
+    "episode_show_name": "s_name_import",
+    "spotify_episode_uri": "spotify:episode:e_id_import"
+  },
+  {
+    "ts": "2021-03-20T13:00:00Z",
+    "platform": "OS X 11.2.3 [x86 8]",
+    "ms_played": 1200000,
+    "episode_name": "e_name_unknown",
+    "episode_show_name": "s_name_unknown",
+    "spotify_episode_uri": "spotify:episode:e_id_unknown"
+  }
+]`
+
+func TestReadExtendedHistory(t *testing.T) {
+	entries, err := readExtendedHistory(strings.NewReader(extendedHistoryJSON))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(entries))
+
+	assert.Equal(t, "t_id_import", entries[0].TrackID())
+	assert.Equal(t, 180000, entries[0].MsPlayed)
+	assert.Equal(t, time.Date(2021, 3, 20, 10, 0, 0, 0, time.UTC), entries[0].Ts)
+	assert.Equal(t, "", entries[4].TrackID())
+	assert.Equal(t, "e_id_import", entries[4].EpisodeID())
+	assert.Equal(t, "", entries[0].EpisodeID())
+
+	_, err = readExtendedHistory(strings.NewReader("{"))
+	assert.Error(t, err)
+}
+
+func TestSpotifySaver_ImportExtendedHistory(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	var requested []string
+	server := setTestServer(saver, func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path+"?"+r.URL.Query().Get("ids"))
+		if r.URL.Path == "/episodes" {
+			_, _ = fmt.Fprint(w, `{"episodes": [{"id": "e_id_import", "name": "e_name_import", "duration_ms": 3600000,
+				"show": {"id": "s_id_import", "name": "s_name_import"}}, null]}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"tracks": [{"id": "t_id_missing", "name": "t_name_missing", "duration_ms": 120000,
+			"artists": [{"id": "a_id_missing", "name": "a_name_missing"}],
+			"album": {"id": "al_id_missing", "name": "al_name_missing"}}, null]}`)
+	})
+	defer server.Close()
+
+	err = DB.Create(&models.Track{ID: "t_id_import"})
+	assert.NoError(t, err)
+	existing := models.HistoryEntry{
+		TrackID:  nulls.NewString("t_id_import"),
+		PlayedAt: time.Date(2021, 3, 20, 10, 0, 10, 0, time.UTC),
+	}
+	err = DB.Create(&existing)
+	assert.NoError(t, err)
+
+	file, err := ioutil.TempFile("", "endsong_*.json")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(extendedHistoryJSON)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	err = saver.ImportExtendedHistory(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/tracks?t_id_missing,t_id_unknown", "/episodes?e_id_import,e_id_unknown"}, requested)
+
+	err = DB.Reload(&existing)
+	assert.NoError(t, err)
+	assert.Equal(t, 180000, existing.MsPlayed.Int)
+	assert.Equal(t, "Smartphone", deviceType(t, existing.DeviceID.String))
+
+	var imported models.HistoryEntry
+	err = DB.Where("track_id = ?", "t_id_missing").First(&imported)
+	assert.NoError(t, err)
+	assert.Equal(t, 120000, imported.MsPlayed.Int)
+	assert.Equal(t, "Computer", deviceType(t, imported.DeviceID.String))
+
+	var track models.Track
+	err = DB.Find(&track, "t_id_missing")
+	assert.NoError(t, err)
+	assert.Equal(t, "al_id_missing", track.AlbumID.String)
+
+	err = DB.Find(&track, "t_id_unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, "t_name_unknown", track.Name)
+
+	var episode models.Episode
+	err = DB.Find(&episode, "e_id_import")
+	assert.NoError(t, err)
+	assert.Equal(t, "s_id_import", episode.ShowID.String)
+
+	err = DB.Find(&episode, "e_id_unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, "e_name_unknown", episode.Name)
+	assert.Equal(t, exportedShow("s_name_unknown").ID, episode.ShowID.String)
+
+	count, err := DB.Where("episode_id IN (?, ?)", "e_id_import", "e_id_unknown").Count(&models.HistoryEntry{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	count, err = DB.Where("track_id = ?", "t_id_import").Count(&models.HistoryEntry{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	count, err = DB.Where("artist_id = ?", "a_id_missing").Count(&models.ArtistsTrack{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	err = saver.ImportExtendedHistory("not_existing.json")
+	assert.Error(t, err)
+}
+
+func deviceType(t *testing.T, id string) string {
+	var device models.Device
+	err := DB.Find(&device, id)
+	assert.NoError(t, err)
+	return device.Type
+}
+
+func TestPlatformDevice(t *testing.T) {
+	assert.Nil(t, platformDevice(""))
+
+	device := platformDevice("iOS 14.4 (iPhone12,1)")
+	assert.Equal(t, "iOS 14.4 (iPhone12,1)", device.Name)
+	assert.Equal(t, "Smartphone", device.Type)
+	assert.True(t, strings.HasPrefix(device.ID, "platform:"))
+	assert.Equal(t, device.ID, platformDevice("iOS 14.4 (iPhone12,1)").ID)
+}
+
+func TestPlatformType(t *testing.T) {
+	assert.Equal(t, "Smartphone", platformType("Android OS 11 API 30 (Google, Pixel 5)"))
+	assert.Equal(t, "Tablet", platformType("iOS 14.4 (iPad8,1)"))
+	assert.Equal(t, "Computer", platformType("Windows 10 (10.0.19041; x64)"))
+	assert.Equal(t, "Computer", platformType("web_player linux ;chrome 89.0.4389.90;desktop"))
+	assert.Equal(t, "Speaker", platformType("Partner sonos_one Sonos"))
+	assert.Equal(t, "TV", platformType("Partner android_tv Sony"))
+	assert.Equal(t, "Unknown", platformType("something"))
+}
+
+func TestExportedShow(t *testing.T) {
+	assert.Nil(t, exportedShow(""))
+
+	show := exportedShow("s_name")
+	assert.Equal(t, "s_name", show.Name)
+	assert.True(t, strings.HasPrefix(show.ID, "export:"))
+}
+
+func TestIdFromURI(t *testing.T) {
+	assert.Equal(t, "t_id", idFromURI("spotify:track:t_id", "track"))
+	assert.Equal(t, "", idFromURI("spotify:episode:e_id", "track"))
+	assert.Equal(t, "", idFromURI("", "track"))
+}
@@ -0,0 +1,191 @@
+package spotifySaver
+
+import (
+	"github.com/elivlo/SpotifyHistorySaver/listenbrainz"
+	"github.com/elivlo/SpotifyHistorySaver/logging"
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// ListenWindow is the maximum age of plays submitted after a poll. Older plays are only submitted by a backfill.
+	ListenWindow = 14 * 24 * time.Hour
+
+	// listensPerPoll is the maximum number of plays submitted after a poll, one request each
+	listensPerPoll = 100
+	// listensPerImport is the number of plays submitted at once by a backfill
+	listensPerImport = 100
+
+	// spotifyOpenURL is the root of the Spotify URLs submitted as IDs
+	spotifyOpenURL = "https://open.spotify.com/"
+	// submissionClient identifies this app in submitted listens
+	submissionClient = "SpotifyHistorySaver"
+)
+
+// listenSubmitter submits saved plays of tracks to ListenBrainz and records the result per history entry,
+// so failed plays are retried and submitted plays are never submitted twice.
+type listenSubmitter struct {
+	db     *pop.Connection
+	client *listenbrainz.Client
+	log    *logrus.Entry
+}
+
+func newListenSubmitter(db *pop.Connection, client *listenbrainz.Client, log *logrus.Entry) listenSubmitter {
+	return listenSubmitter{
+		db:     db,
+		client: client,
+		log:    log,
+	}
+}
+
+// submitNew submits up to listensPerPoll pending plays of the last ListenWindow before now as single listens.
+// It returns the number of submitted plays.
+func (s listenSubmitter) submitNew(now time.Time) (int, error) {
+	plays, err := models.PendingListens(s.db, now.Add(-ListenWindow), listensPerPoll)
+	if err != nil {
+		return 0, errors.Errorf("Could not get plays to submit: %v", err)
+	}
+	return s.submit(listenbrainz.ListenTypeSingle, plays)
+}
+
+// backfill submits all pending plays as imports in batches of listensPerImport, the oldest first.
+// A batch that failed stops the backfill, running it again resumes with the failed batch.
+// It returns the number of submitted plays.
+func (s listenSubmitter) backfill() (int, error) {
+	submitted := 0
+	for {
+		plays, err := models.PendingListens(s.db, time.Unix(0, 0), listensPerImport)
+		if err != nil {
+			return submitted, errors.Errorf("Could not get plays to submit: %v", err)
+		}
+		n, err := s.submit(listenbrainz.ListenTypeImport, plays)
+		submitted += n
+		if err != nil || len(plays) < listensPerImport {
+			return submitted, err
+		}
+		s.log.WithField(logging.FieldCount, submitted).Info("Backfilled listens")
+	}
+}
+
+// submit submits plays as listens of listenType, single listens one by one, and saves the status of each of them.
+// Plays without artist are rejected. It returns the number of submitted plays.
+func (s listenSubmitter) submit(listenType string, plays []models.ListenPlay) (int, error) {
+	statuses := map[string][]int{}
+	var listens []listenbrainz.Listen
+	var ids []int
+	for _, p := range plays {
+		if len(p.Artists) == 0 {
+			s.log.Warnf("Track %s played at %v has no artist and is not submitted", p.TrackName, p.PlayedAt)
+			statuses[models.ListenStatusRejected] = append(statuses[models.ListenStatusRejected], p.ID)
+			continue
+		}
+		listens = append(listens, convertToListen(p))
+		ids = append(ids, p.ID)
+	}
+
+	var err error
+	if listenType == listenbrainz.ListenTypeSingle {
+		for i := range listens {
+			err = s.submitListens(listenType, listens[i:i+1], ids[i:i+1], statuses)
+			if err != nil {
+				break
+			}
+		}
+	} else {
+		err = s.submitListens(listenType, listens, ids, statuses)
+	}
+
+	for status, statusIDs := range statuses {
+		saveErr := models.SetListenStatus(s.db, status, statusIDs...)
+		if saveErr != nil {
+			return 0, errors.Errorf("Could not save listen status: %v", saveErr)
+		}
+		metrics.Scrobbles.WithLabelValues(metrics.ServiceListenBrainz, status).Add(float64(len(statusIDs)))
+	}
+
+	submitted := len(statuses[models.ListenStatusSubmitted])
+	if err != nil {
+		return submitted, errors.Errorf("Could not submit listens: %v", err)
+	}
+	return submitted, nil
+}
+
+// submitListens submits listens and adds ids to the statuses of the result. A batch ListenBrainz rejected
+// is submitted listen by listen to only reject the invalid ones. Other errors are returned.
+func (s listenSubmitter) submitListens(listenType string, listens []listenbrainz.Listen, ids []int, statuses map[string][]int) error {
+	if len(listens) == 0 {
+		return nil
+	}
+	err := s.client.SubmitListens(listenType, listens)
+	apiErr, ok := err.(listenbrainz.Error)
+	switch {
+	case err == nil:
+		statuses[models.ListenStatusSubmitted] = append(statuses[models.ListenStatusSubmitted], ids...)
+	case ok && apiErr.Code == http.StatusBadRequest && len(listens) > 1:
+		for i := range listens {
+			err = s.submitListens(listenType, listens[i:i+1], ids[i:i+1], statuses)
+			if err != nil {
+				return err
+			}
+		}
+	case ok && apiErr.Code == http.StatusBadRequest:
+		s.log.Warnf("ListenBrainz rejected %s played at %d: %s", listens[0].TrackMetadata.TrackName, listens[0].ListenedAt, apiErr.Message)
+		statuses[models.ListenStatusRejected] = append(statuses[models.ListenStatusRejected], ids...)
+	default:
+		statuses[models.ListenStatusFailed] = append(statuses[models.ListenStatusFailed], ids...)
+		return err
+	}
+	return nil
+}
+
+// convertToListen converts a play with at least one artist to a listen with its ISRC and Spotify IDs.
+// Tracks, albums and artists of imported scrobbles have no Spotify IDs and are submitted with their names only.
+func convertToListen(p models.ListenPlay) listenbrainz.Listen {
+	names := make([]string, len(p.Artists))
+	var artistURLs []string
+	for i, a := range p.Artists {
+		names[i] = a.Name
+		if isSpotifyID(a.ID) {
+			artistURLs = append(artistURLs, spotifyOpenURL+"artist/"+a.ID)
+		}
+	}
+
+	info := listenbrainz.AdditionalInfo{
+		ISRC:             p.ISRC.String,
+		SpotifyArtistIDs: artistURLs,
+		ArtistNames:      names,
+		TrackNumber:      p.TrackNumber,
+		DurationMs:       p.DurationMs,
+		SubmissionClient: submissionClient,
+	}
+	if isSpotifyID(p.TrackID) {
+		trackURL := spotifyOpenURL + "track/" + p.TrackID
+		info.SpotifyID = trackURL
+		info.OriginURL = trackURL
+		info.MusicService = "spotify.com"
+		info.MediaPlayer = "Spotify"
+	}
+	if p.AlbumID.Valid && isSpotifyID(p.AlbumID.String) {
+		info.SpotifyAlbumID = spotifyOpenURL + "album/" + p.AlbumID.String
+	}
+	return listenbrainz.Listen{
+		ListenedAt: p.PlayedAt.Unix(),
+		TrackMetadata: listenbrainz.TrackMetadata{
+			ArtistName:     strings.Join(names, ", "),
+			TrackName:      p.TrackName,
+			ReleaseName:    p.AlbumName.String,
+			AdditionalInfo: info,
+		},
+	}
+}
+
+// isSpotifyID checks if id is an ID of Spotify and not e.g. "import:<hash>".
+func isSpotifyID(id string) bool {
+	return !strings.Contains(id, ":")
+}
@@ -0,0 +1,164 @@
+package spotifySaver
+
+import (
+	"github.com/elivlo/SpotifyHistorySaver/listenbrainz"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestListenSubmitter(t *testing.T) {
+	_, log := getTestLogger()
+
+	now := time.Now().Truncate(time.Second)
+	// Plays of other tests are not submitted in this test
+	err := DB.RawQuery("UPDATE history_entries SET listenbrainz_status = ?", models.ListenStatusSubmitted).Exec()
+	assert.NoError(t, err)
+
+	assert.NoError(t, DB.Create(&models.Album{ID: "al_id_lb", Name: "al_name_lb"}))
+	assert.NoError(t, DB.Create(&models.Artists{
+		{ID: "a_id_lb", Name: "a_name_lb"},
+		{ID: "a_id_lb2", Name: "a_name_lb2"},
+	}))
+	assert.NoError(t, DB.Create(&models.Tracks{
+		{ID: "t_id_lb", Name: "t_name_lb", AlbumID: nulls.NewString("al_id_lb"), TrackNumber: 3, DurationMs: 200000,
+			ISRC: nulls.NewString("USRC17607839")},
+		{ID: "t_id_lb_rejected", Name: "t_name_lb_rejected"},
+		{ID: "t_id_lb_no_artist", Name: "t_name_lb_no_artist"},
+	}))
+	assert.NoError(t, DB.Create(&models.ArtistsTracks{
+		{ArtistID: "a_id_lb", TrackID: "t_id_lb"},
+		{ArtistID: "a_id_lb2", TrackID: "t_id_lb"},
+		{ArtistID: "a_id_lb", TrackID: "t_id_lb_rejected"},
+	}))
+	entries := models.HistoryEntries{
+		{TrackID: nulls.NewString("t_id_lb"), PlayedAt: now.Add(-ListenWindow - 2*time.Hour)},
+		{TrackID: nulls.NewString("t_id_lb_rejected"), PlayedAt: now.Add(-ListenWindow - time.Hour)},
+		{TrackID: nulls.NewString("t_id_lb"), PlayedAt: now.Add(-2 * time.Hour)},
+		{TrackID: nulls.NewString("t_id_lb_rejected"), PlayedAt: now.Add(-time.Hour)},
+		{TrackID: nulls.NewString("t_id_lb_no_artist"), PlayedAt: now.Add(-time.Hour)},
+	}
+	assert.NoError(t, DB.Create(&entries))
+
+	standIn := listenbrainz.NewStandIn("token")
+	defer standIn.Close()
+	standIn.Reject("t_name_lb_rejected")
+	s := newListenSubmitter(DB, standIn.Client("token"), log)
+
+	status := func(entry models.HistoryEntry) nulls.String {
+		assert.NoError(t, DB.Find(&entry, entry.ID))
+		return entry.ListenBrainzStatus
+	}
+
+	t.Run("Failed", func(t *testing.T) {
+		standIn.Fail(http.StatusServiceUnavailable)
+		submitted, err := s.submitNew(now)
+		assert.Error(t, err)
+		assert.Equal(t, 0, submitted)
+		assert.Equal(t, nulls.NewString(models.ListenStatusFailed), status(entries[2]))
+		assert.False(t, status(entries[3]).Valid)
+	})
+
+	t.Run("New", func(t *testing.T) {
+		standIn.Fail(0)
+		submitted, err := s.submitNew(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, submitted)
+		assert.Equal(t, []listenbrainz.Submission{{
+			ListenType: listenbrainz.ListenTypeSingle,
+			Listens: []listenbrainz.Listen{{
+				ListenedAt: entries[2].PlayedAt.Unix(),
+				TrackMetadata: listenbrainz.TrackMetadata{
+					ArtistName:  "a_name_lb, a_name_lb2",
+					TrackName:   "t_name_lb",
+					ReleaseName: "al_name_lb",
+					AdditionalInfo: listenbrainz.AdditionalInfo{
+						ISRC:           "USRC17607839",
+						SpotifyID:      "https://open.spotify.com/track/t_id_lb",
+						SpotifyAlbumID: "https://open.spotify.com/album/al_id_lb",
+						SpotifyArtistIDs: []string{
+							"https://open.spotify.com/artist/a_id_lb",
+							"https://open.spotify.com/artist/a_id_lb2",
+						},
+						ArtistNames:      []string{"a_name_lb", "a_name_lb2"},
+						TrackNumber:      3,
+						DurationMs:       200000,
+						OriginURL:        "https://open.spotify.com/track/t_id_lb",
+						MusicService:     "spotify.com",
+						MediaPlayer:      "Spotify",
+						SubmissionClient: "SpotifyHistorySaver",
+					},
+				},
+			}},
+		}}, standIn.Submissions())
+		assert.Equal(t, nulls.NewString(models.ListenStatusSubmitted), status(entries[2]))
+		assert.Equal(t, nulls.NewString(models.ListenStatusRejected), status(entries[3]))
+		assert.Equal(t, nulls.NewString(models.ListenStatusRejected), status(entries[4]))
+		assert.False(t, status(entries[0]).Valid)
+
+		submitted, err = s.submitNew(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, submitted)
+	})
+
+	t.Run("Backfill", func(t *testing.T) {
+		submitted, err := s.backfill()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, submitted)
+
+		submissions := standIn.Submissions()
+		assert.Equal(t, 2, len(submissions))
+		assert.Equal(t, listenbrainz.ListenTypeImport, submissions[1].ListenType)
+		assert.Equal(t, entries[0].PlayedAt.Unix(), submissions[1].Listens[0].ListenedAt)
+		assert.Equal(t, nulls.NewString(models.ListenStatusSubmitted), status(entries[0]))
+		assert.Equal(t, nulls.NewString(models.ListenStatusRejected), status(entries[1]))
+
+		submitted, err = s.backfill()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, submitted)
+		assert.Equal(t, 2, len(standIn.Submissions()))
+	})
+}
+
+func TestSpotifySaver_BackfillListenBrainz(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+	_, err = saver.BackfillListenBrainz()
+	assert.EqualError(t, err, "ListenBrainz is not set up")
+	// Does nothing without ListenBrainz
+	saver.submitListens(log)
+
+	standIn := listenbrainz.NewStandIn("token")
+	defer standIn.Close()
+	saver.SetListenBrainz(standIn.Client("wrong_token"))
+
+	assert.NoError(t, DB.Create(&models.Artist{ID: "a_id_lb_saver"}))
+	assert.NoError(t, DB.Create(&models.Track{ID: "t_id_lb_saver"}))
+	assert.NoError(t, DB.Create(&models.ArtistsTrack{ArtistID: "a_id_lb_saver", TrackID: "t_id_lb_saver"}))
+	assert.NoError(t, DB.Create(&models.HistoryEntry{TrackID: nulls.NewString("t_id_lb_saver"), PlayedAt: time.Now()}))
+	saver.submitListens(log)
+	assert.Contains(t, saver.Health().LastError, "listenbrainz error 401")
+
+	_, err = saver.BackfillListenBrainz()
+	assert.Contains(t, err.Error(), "listenbrainz error 401")
+}
+
+func TestConvertToListen_imported(t *testing.T) {
+	listen := convertToListen(models.ListenPlay{
+		TrackID:   importIDPrefix + "track",
+		TrackName: "Roads",
+		AlbumID:   nulls.NewString(importIDPrefix + "album"),
+		AlbumName: nulls.NewString("Dummy"),
+		Artists:   []models.Artist{{ID: importIDPrefix + "artist", Name: "Portishead"}},
+	})
+	assert.Equal(t, listenbrainz.AdditionalInfo{
+		ArtistNames:      []string{"Portishead"},
+		SubmissionClient: "SpotifyHistorySaver",
+	}, listen.TrackMetadata.AdditionalInfo)
+	assert.Equal(t, "Dummy", listen.TrackMetadata.ReleaseName)
+}
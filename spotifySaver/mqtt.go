@@ -0,0 +1,147 @@
+package spotifySaver
+
+import (
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/elivlo/SpotifyHistorySaver/mqtt"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// Payload types and results of the MQTT metrics.
+const (
+	mqttTypePlays      = "plays"
+	mqttTypeNowPlaying = "now_playing"
+
+	mqttStatusPublished = "published"
+	mqttStatusFailed    = "failed"
+)
+
+// mqttPublisher publishes the plays saved by the history polls and the changes of the player state to an MQTT broker.
+// Messages that could not be published are not retried, the next play or player state replaces them.
+type mqttPublisher struct {
+	db        *pop.Connection
+	publisher *mqtt.Publisher
+
+	// nowPlaying is the last published player state, it is only used by the playback worker
+	nowPlaying *mqtt.NowPlaying
+}
+
+func newMQTTPublisher(db *pop.Connection, publisher *mqtt.Publisher) mqttPublisher {
+	return mqttPublisher{
+		db:        db,
+		publisher: publisher,
+	}
+}
+
+// publishPlays publishes the plays of the tracks of entries. It returns the number of published plays.
+func (p *mqttPublisher) publishPlays(entries models.HistoryEntries) (int, error) {
+	var ids []int
+	for _, e := range entries {
+		if e.TrackID.Valid {
+			ids = append(ids, e.ID)
+		}
+	}
+	plays, err := models.TrackPlays(p.db, ids...)
+	if err != nil {
+		return 0, errors.Errorf("Could not get plays for MQTT: %v", err)
+	}
+	if len(plays) == 0 {
+		return 0, nil
+	}
+
+	err = p.publisher.PublishPlays(convertToMQTTPlays(plays))
+	if err != nil {
+		metrics.MQTTMessages.WithLabelValues(mqttTypePlays, mqttStatusFailed).Add(float64(len(plays)))
+		return 0, errors.Errorf("Could not publish plays: %v", err)
+	}
+	metrics.MQTTMessages.WithLabelValues(mqttTypePlays, mqttStatusPublished).Add(float64(len(plays)))
+	return len(plays), nil
+}
+
+// publishNowPlaying publishes the player state seen at now if the track or episode, its state or the device changed
+// since the last published one. It returns whether it was published.
+func (p *mqttPublisher) publishNowPlaying(state *playerState, now time.Time) (bool, error) {
+	nowPlaying := convertToNowPlaying(state, now)
+	if p.nowPlaying != nil && sameNowPlaying(*p.nowPlaying, nowPlaying) {
+		return false, nil
+	}
+
+	err := p.publisher.PublishNowPlaying(nowPlaying)
+	if err != nil {
+		metrics.MQTTMessages.WithLabelValues(mqttTypeNowPlaying, mqttStatusFailed).Inc()
+		return false, errors.Errorf("Could not publish player state: %v", err)
+	}
+	metrics.MQTTMessages.WithLabelValues(mqttTypeNowPlaying, mqttStatusPublished).Inc()
+	p.nowPlaying = &nowPlaying
+	return true, nil
+}
+
+// sameNowPlaying checks if a and b only differ in progress and time.
+func sameNowPlaying(a, b mqtt.NowPlaying) bool {
+	a.ProgressMs, b.ProgressMs = 0, 0
+	a.UpdatedAt, b.UpdatedAt = time.Time{}, time.Time{}
+	return a == b
+}
+
+// convertToMQTTPlays converts plays to the payloads of MQTT. Tracks of imported scrobbles have no Spotify URL.
+func convertToMQTTPlays(plays []models.ListenPlay) []mqtt.Play {
+	converted := make([]mqtt.Play, len(plays))
+	for i, p := range plays {
+		artists := make([]string, len(p.Artists))
+		for j, a := range p.Artists {
+			artists[j] = a.Name
+		}
+		play := mqtt.Play{
+			ID:         p.ID,
+			PlayedAt:   p.PlayedAt.UTC(),
+			TrackID:    p.TrackID,
+			Track:      p.TrackName,
+			Artists:    strings.Join(artists, ", "),
+			Album:      p.AlbumName.String,
+			DurationMs: p.DurationMs,
+		}
+		if isSpotifyID(p.TrackID) {
+			play.URL = spotifyOpenURL + "track/" + p.TrackID
+		}
+		converted[i] = play
+	}
+	return converted
+}
+
+// convertToNowPlaying converts the player state seen at now to the payload of MQTT.
+// Episodes have their show as album and its publisher as artist.
+func convertToNowPlaying(state *playerState, now time.Time) mqtt.NowPlaying {
+	nowPlaying := mqtt.NowPlaying{State: mqtt.StateIdle, UpdatedAt: now.UTC()}
+	if state == nil || state.Item == nil {
+		return nowPlaying
+	}
+
+	item := state.Item
+	nowPlaying.State = mqtt.StatePaused
+	if state.Playing {
+		nowPlaying.State = mqtt.StatePlaying
+	}
+	nowPlaying.ItemID = item.ID.String()
+	nowPlaying.Track = item.Name
+	nowPlaying.DurationMs = item.Duration
+	nowPlaying.ProgressMs = state.Progress
+	nowPlaying.Device = state.Device.Name
+	if state.Episode != nil {
+		nowPlaying.Artists = state.Episode.Show.Publisher
+		nowPlaying.Album = state.Episode.Show.Name
+		nowPlaying.URL = spotifyOpenURL + "episode/" + nowPlaying.ItemID
+		return nowPlaying
+	}
+
+	artists := make([]string, len(item.Artists))
+	for i, a := range item.Artists {
+		artists[i] = a.Name
+	}
+	nowPlaying.Artists = strings.Join(artists, ", ")
+	nowPlaying.Album = item.Album.Name
+	nowPlaying.URL = spotifyOpenURL + "track/" + nowPlaying.ItemID
+	return nowPlaying
+}
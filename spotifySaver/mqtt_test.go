@@ -0,0 +1,199 @@
+package spotifySaver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/elivlo/SpotifyHistorySaver/mqtt"
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var testMQTTTopics = mqtt.Topics{
+	Plays:        "spotify/plays",
+	LastPlayed:   "spotify/last_played",
+	NowPlaying:   "spotify/now_playing",
+	Availability: "spotify/availability",
+}
+
+func connectMQTT(t *testing.T, standIn *mqtt.StandIn) *mqtt.Publisher {
+	publisher, err := mqtt.Connect(mqtt.Options{Broker: standIn.Broker(), ClientID: "test", QoS: 1, Topics: testMQTTTopics})
+	assert.NoError(t, err)
+	return publisher
+}
+
+// retainedJSON decodes the retained message of topic into v.
+func retainedJSON(t *testing.T, standIn *mqtt.StandIn, topic string, v interface{}) {
+	m, ok := standIn.Retained(topic)
+	assert.True(t, ok, topic)
+	assert.NoError(t, json.Unmarshal(m.Payload, v))
+}
+
+func TestMQTTPublisher_publishPlays(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	assert.NoError(t, DB.Create(&models.Album{ID: "al_id_mqtt", Name: "al_name_mqtt"}))
+	assert.NoError(t, DB.Create(&models.Artist{ID: "a_id_mqtt", Name: "a_name_mqtt"}))
+	assert.NoError(t, DB.Create(&models.Artist{ID: "a_id_mqtt2", Name: "a_name_mqtt2"}))
+	assert.NoError(t, DB.Create(&models.Track{ID: "t_id_mqtt", Name: "t_name_mqtt", AlbumID: nulls.NewString("al_id_mqtt"), DurationMs: 200000}))
+	assert.NoError(t, DB.Create(&models.ArtistsTrack{ArtistID: "a_id_mqtt", TrackID: "t_id_mqtt"}))
+	assert.NoError(t, DB.Create(&models.ArtistsTrack{ArtistID: "a_id_mqtt2", TrackID: "t_id_mqtt"}))
+	entries := models.HistoryEntries{
+		{TrackID: nulls.NewString("t_id_mqtt"), PlayedAt: now.Add(-5 * time.Minute)},
+		{TrackID: nulls.NewString("t_id_mqtt"), PlayedAt: now.Add(-time.Minute)},
+	}
+	assert.NoError(t, DB.Create(&entries))
+
+	standIn := mqtt.NewStandIn("", "")
+	defer standIn.Close()
+	publisher := connectMQTT(t, standIn)
+	defer publisher.Close()
+	p := newMQTTPublisher(DB, publisher)
+
+	published, err := p.publishPlays(append(entries, models.HistoryEntry{EpisodeID: nulls.NewString("e_id")}))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, published)
+
+	var plays []mqtt.Play
+	for _, m := range standIn.Messages() {
+		if m.Topic == testMQTTTopics.Plays {
+			var play mqtt.Play
+			assert.NoError(t, json.Unmarshal(m.Payload, &play))
+			plays = append(plays, play)
+		}
+	}
+	assert.Equal(t, 2, len(plays))
+	assert.Equal(t, entries[0].ID, plays[0].ID)
+
+	var lastPlayed mqtt.Play
+	retainedJSON(t, standIn, testMQTTTopics.LastPlayed, &lastPlayed)
+	assert.Equal(t, mqtt.Play{
+		ID:         entries[1].ID,
+		PlayedAt:   entries[1].PlayedAt.UTC(),
+		TrackID:    "t_id_mqtt",
+		Track:      "t_name_mqtt",
+		Artists:    "a_name_mqtt, a_name_mqtt2",
+		Album:      "al_name_mqtt",
+		URL:        "https://open.spotify.com/track/t_id_mqtt",
+		DurationMs: 200000,
+	}, lastPlayed)
+
+	published, err = p.publishPlays(models.HistoryEntries{{EpisodeID: nulls.NewString("e_id")}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, published)
+}
+
+func TestMQTTPublisher_publishNowPlaying(t *testing.T) {
+	standIn := mqtt.NewStandIn("", "")
+	defer standIn.Close()
+	publisher := connectMQTT(t, standIn)
+	defer publisher.Close()
+	p := newMQTTPublisher(DB, publisher)
+	now := time.Date(2021, 3, 20, 10, 0, 0, 0, time.UTC)
+
+	published, err := p.publishNowPlaying(getPlayerState("t_id", 1000, true), now)
+	assert.NoError(t, err)
+	assert.True(t, published)
+	var nowPlaying mqtt.NowPlaying
+	retainedJSON(t, standIn, testMQTTTopics.NowPlaying, &nowPlaying)
+	assert.Equal(t, mqtt.NowPlaying{
+		State:      mqtt.StatePlaying,
+		ItemID:     "t_id",
+		Track:      "t_name",
+		URL:        "https://open.spotify.com/track/t_id",
+		DurationMs: 180000,
+		ProgressMs: 1000,
+		Device:     "d_name",
+		UpdatedAt:  now,
+	}, nowPlaying)
+
+	// Progress alone is no change
+	published, err = p.publishNowPlaying(getPlayerState("t_id", 11000, true), now.Add(10*time.Second))
+	assert.NoError(t, err)
+	assert.False(t, published)
+
+	published, err = p.publishNowPlaying(getPlayerState("t_id", 12000, false), now.Add(20*time.Second))
+	assert.NoError(t, err)
+	assert.True(t, published)
+	retainedJSON(t, standIn, testMQTTTopics.NowPlaying, &nowPlaying)
+	assert.Equal(t, mqtt.StatePaused, nowPlaying.State)
+
+	published, err = p.publishNowPlaying(&playerState{}, now.Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.True(t, published)
+	nowPlaying = mqtt.NowPlaying{}
+	retainedJSON(t, standIn, testMQTTTopics.NowPlaying, &nowPlaying)
+	assert.Equal(t, mqtt.NowPlaying{State: mqtt.StateIdle, UpdatedAt: now.Add(30 * time.Second)}, nowPlaying)
+}
+
+func TestConvertToNowPlaying(t *testing.T) {
+	now := time.Date(2021, 3, 20, 10, 0, 0, 0, time.UTC)
+
+	state := getPlayerState("t_id", 1000, true)
+	state.Item.Artists = []spotify.SimpleArtist{{Name: "a_name"}, {Name: "a_name2"}}
+	state.Item.Album = spotify.SimpleAlbum{Name: "al_name"}
+	nowPlaying := convertToNowPlaying(state, now)
+	assert.Equal(t, "a_name, a_name2", nowPlaying.Artists)
+	assert.Equal(t, "al_name", nowPlaying.Album)
+
+	episode := getEpisodeState("e_id", 1000, true)
+	episode.Episode.Show.Publisher = "s_publisher"
+	assert.Equal(t, mqtt.NowPlaying{
+		State:      mqtt.StatePlaying,
+		ItemID:     "e_id",
+		Track:      "t_name",
+		Artists:    "s_publisher",
+		Album:      "s_name",
+		URL:        "https://open.spotify.com/episode/e_id",
+		DurationMs: 3600000,
+		ProgressMs: 1000,
+		Device:     "d_name",
+		UpdatedAt:  now,
+	}, convertToNowPlaying(episode, now))
+
+	assert.Equal(t, mqtt.NowPlaying{State: mqtt.StateIdle, UpdatedAt: now}, convertToNowPlaying(nil, now))
+}
+
+func TestSpotifySaver_SetMQTT(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+	// Does nothing without MQTT
+	saver.publishPlays(log, nil)
+	saver.publishNowPlaying(log, nil, time.Now())
+
+	standIn := mqtt.NewStandIn("", "")
+	defer standIn.Close()
+	publisher := connectMQTT(t, standIn)
+	defer publisher.Close()
+	saver.SetMQTT(publisher)
+
+	err = saver.insertNewSongs(log, []spotify.RecentlyPlayedItem{{
+		Track: spotify.SimpleTrack{
+			ID:      "t_id_mqtt_saver",
+			Name:    "t_name_mqtt_saver",
+			Artists: []spotify.SimpleArtist{{ID: "a_id_mqtt_saver", Name: "a_name_mqtt_saver"}},
+		},
+		PlayedAt: time.Now(),
+	}})
+	assert.NoError(t, err)
+	var lastPlayed mqtt.Play
+	retainedJSON(t, standIn, testMQTTTopics.LastPlayed, &lastPlayed)
+	assert.Equal(t, "t_name_mqtt_saver", lastPlayed.Track)
+	assert.Equal(t, "a_name_mqtt_saver", lastPlayed.Artists)
+
+	server := setTestServer(saver, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"is_playing": true, "progress_ms": 1000, "currently_playing_type": "track",
+			"item": {"id": "t_id_mqtt_saver", "name": "t_name_mqtt_saver", "duration_ms": 180000}}`)
+	})
+	defer server.Close()
+	saver.pollPlayerState(log, &playbackTracker{})
+	var nowPlaying mqtt.NowPlaying
+	retainedJSON(t, standIn, testMQTTTopics.NowPlaying, &nowPlaying)
+	assert.Equal(t, mqtt.StatePlaying, nowPlaying.State)
+	assert.Equal(t, "t_name_mqtt_saver", nowPlaying.Track)
+}
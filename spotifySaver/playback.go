@@ -0,0 +1,135 @@
+package spotifySaver
+
+import (
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/zmb3/spotify/v2"
+	"time"
+)
+
+const (
+	// PlaybackPollInterval is the interval in which the player state worker requests the currently playing track
+	PlaybackPollInterval = 10 * time.Second
+
+	// skipToleranceMs is the remaining play time below which a track counts as played to the end
+	skipToleranceMs = int(2 * PlaybackPollInterval / time.Millisecond)
+	// reconcileWindow is the tolerance used when matching playback sessions to history entries
+	reconcileWindow = time.Minute
+	// reconcileLookback is how far back unlinked playback sessions are matched against the history
+	reconcileLookback = 24 * time.Hour
+)
+
+// observedSession is a playback session together with the track or episode that was played and the device it was played on.
+type observedSession struct {
+	track   spotify.FullTrack
+	episode *spotify.EpisodePage
+	device  *models.Device
+	session models.PlaybackSession
+
+	// listenedMs is the observed time the session was playing
+	listenedMs int
+	playing    bool
+	lastSeen   time.Time
+}
+
+// playbackTracker turns consecutive player state observations into playback sessions.
+type playbackTracker struct {
+	current *observedSession
+}
+
+// observe updates the tracker with the player state seen at now.
+// It returns the session that ended with this observation or nil if the current session continues.
+func (p *playbackTracker) observe(state *playerState, now time.Time) *observedSession {
+	if state == nil || state.Item == nil {
+		return p.finish()
+	}
+
+	if p.continues(state) {
+		current := p.current
+		session := &current.session
+		session.ProgressMs = state.Progress
+		session.Shuffle = state.ShuffleState
+		session.RepeatState = state.RepeatState
+		current.setDevice(state.Device)
+		if state.Playing {
+			session.EndedAt = now
+			if current.playing {
+				current.listenedMs += int(now.Sub(current.lastSeen) / time.Millisecond)
+			}
+		}
+		current.playing = state.Playing
+		current.lastSeen = now
+		return nil
+	}
+
+	finished := p.finish()
+	if state.Playing {
+		p.current = newObservedSession(state, now)
+	}
+	return finished
+}
+
+// continues checks if state belongs to the current session.
+// A track that jumped back to its beginning (e.g. on repeat) starts a new session.
+func (p *playbackTracker) continues(state *playerState) bool {
+	if p.current == nil || p.current.itemID() != state.Item.ID.String() {
+		return false
+	}
+	restarted := state.Progress < p.current.session.ProgressMs && state.Progress < skipToleranceMs
+	return !restarted
+}
+
+// finish ends the current session and returns it. It returns nil if there is no current session.
+func (p *playbackTracker) finish() *observedSession {
+	finished := p.current
+	p.current = nil
+	if finished != nil {
+		finished.session.Skipped = finished.session.ProgressMs+skipToleranceMs < finished.session.DurationMs
+	}
+	return finished
+}
+
+// newObservedSession starts a new session for the track or episode in state.
+func newObservedSession(state *playerState, now time.Time) *observedSession {
+	item := state.Item
+	observed := &observedSession{
+		session: models.PlaybackSession{
+			StartedAt:   now.Add(-time.Duration(state.Progress) * time.Millisecond),
+			EndedAt:     now,
+			ProgressMs:  state.Progress,
+			DurationMs:  item.Duration,
+			Shuffle:     state.ShuffleState,
+			RepeatState: state.RepeatState,
+			ContextURI:  string(state.PlaybackContext.URI),
+		},
+		playing:  true,
+		lastSeen: now,
+	}
+	if state.Episode != nil {
+		observed.episode = state.Episode
+		observed.session.EpisodeID = nulls.NewString(state.Episode.ID.String())
+	} else {
+		observed.track = *item
+		observed.session.TrackID = nulls.NewString(item.ID.String())
+	}
+	observed.setDevice(state.Device)
+	return observed
+}
+
+// itemID returns the ID of the played track or episode.
+func (o *observedSession) itemID() string {
+	if o.episode != nil {
+		return o.session.EpisodeID.String
+	}
+	return o.session.TrackID.String
+}
+
+// setDevice sets the device the session is played on. Unknown devices are ignored.
+func (o *observedSession) setDevice(d spotify.PlayerDevice) {
+	device, ok := convertToDevice(d)
+	if !ok {
+		return
+	}
+	o.device = &device
+	o.session.DeviceID = nulls.NewString(device.ID)
+}
@@ -0,0 +1,159 @@
+package spotifySaver
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+	"testing"
+	"time"
+)
+
+func getPlayerState(trackID string, progress int, playing bool) *playerState {
+	return &playerState{PlayerState: spotify.PlayerState{
+		CurrentlyPlaying: spotify.CurrentlyPlaying{
+			Progress: progress,
+			Playing:  playing,
+			Item: &spotify.FullTrack{
+				SimpleTrack: spotify.SimpleTrack{
+					ID:       spotify.ID(trackID),
+					Name:     "t_name",
+					Duration: 180000,
+				},
+			},
+			PlaybackContext: spotify.PlaybackContext{URI: "spotify:playlist:p_id"},
+		},
+		Device: spotify.PlayerDevice{
+			ID:   "d_id",
+			Name: "d_name",
+			Type: "Smartphone",
+		},
+		ShuffleState: true,
+		RepeatState:  "off",
+	}}
+}
+
+func getEpisodeState(episodeID string, progress int, playing bool) *playerState {
+	state := getPlayerState(episodeID, progress, playing)
+	state.Item.Duration = 3600000
+	state.Episode = &spotify.EpisodePage{
+		ID:          spotify.ID(episodeID),
+		Name:        "e_name",
+		Duration_ms: 3600000,
+		Show: spotify.SimpleShow{
+			ID:   "s_id",
+			Name: "s_name",
+		},
+	}
+	return state
+}
+
+func TestPlaybackTracker_observe(t *testing.T) {
+	now := time.Now()
+
+	t.Run("NothingPlaying", func(t *testing.T) {
+		tracker := playbackTracker{}
+		assert.Nil(t, tracker.observe(nil, now))
+		assert.Nil(t, tracker.observe(&playerState{}, now))
+		assert.Nil(t, tracker.current)
+	})
+
+	t.Run("PausedWithoutSession", func(t *testing.T) {
+		tracker := playbackTracker{}
+		assert.Nil(t, tracker.observe(getPlayerState("t_id", 1000, false), now))
+		assert.Nil(t, tracker.current)
+	})
+
+	t.Run("PlayedToEnd", func(t *testing.T) {
+		tracker := playbackTracker{}
+		assert.Nil(t, tracker.observe(getPlayerState("t_id", 10000, true), now))
+		assert.Equal(t, now.Add(-10*time.Second), tracker.current.session.StartedAt)
+
+		assert.Nil(t, tracker.observe(getPlayerState("t_id", 175000, true), now.Add(165*time.Second)))
+
+		finished := tracker.observe(getPlayerState("t_id2", 1000, true), now.Add(175*time.Second))
+		assert.NotNil(t, finished)
+		assert.Equal(t, "t_id", finished.session.TrackID.String)
+		assert.Equal(t, 175000, finished.session.ProgressMs)
+		assert.Equal(t, 180000, finished.session.DurationMs)
+		assert.Equal(t, now.Add(165*time.Second), finished.session.EndedAt)
+		assert.Equal(t, "d_id", finished.session.DeviceID.String)
+		assert.Equal(t, "Smartphone", finished.device.Type)
+		assert.Equal(t, "spotify:playlist:p_id", finished.session.ContextURI)
+		assert.True(t, finished.session.Shuffle)
+		assert.False(t, finished.session.Skipped)
+		assert.Equal(t, "t_id2", tracker.current.session.TrackID.String)
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		tracker := playbackTracker{}
+		tracker.observe(getPlayerState("t_id", 1000, true), now)
+		tracker.observe(getPlayerState("t_id", 31000, true), now.Add(30*time.Second))
+
+		finished := tracker.observe(getPlayerState("t_id2", 1000, true), now.Add(40*time.Second))
+		assert.True(t, finished.session.Skipped)
+	})
+
+	t.Run("Paused", func(t *testing.T) {
+		tracker := playbackTracker{}
+		tracker.observe(getPlayerState("t_id", 1000, true), now)
+		assert.Nil(t, tracker.observe(getPlayerState("t_id", 5000, false), now.Add(time.Hour)))
+
+		assert.Equal(t, now, tracker.current.session.EndedAt)
+		assert.Equal(t, 5000, tracker.current.session.ProgressMs)
+	})
+
+	t.Run("Repeated", func(t *testing.T) {
+		tracker := playbackTracker{}
+		tracker.observe(getPlayerState("t_id", 175000, true), now)
+
+		finished := tracker.observe(getPlayerState("t_id", 2000, true), now.Add(10*time.Second))
+		assert.NotNil(t, finished)
+		assert.False(t, finished.session.Skipped)
+		assert.Equal(t, 2000, tracker.current.session.ProgressMs)
+	})
+
+	t.Run("Episode", func(t *testing.T) {
+		tracker := playbackTracker{}
+		tracker.observe(getEpisodeState("e_id", 600000, true), now)
+		tracker.observe(getEpisodeState("e_id", 610000, true), now.Add(10*time.Second))
+		tracker.observe(getEpisodeState("e_id", 615000, false), now.Add(20*time.Second))
+		tracker.observe(getEpisodeState("e_id", 615000, true), now.Add(time.Hour))
+
+		finished := tracker.observe(getPlayerState("t_id", 1000, true), now.Add(time.Hour+10*time.Second))
+		assert.NotNil(t, finished)
+		assert.Equal(t, "e_id", finished.session.EpisodeID.String)
+		assert.False(t, finished.session.TrackID.Valid)
+		assert.Equal(t, "s_id", finished.episode.Show.ID.String())
+		assert.Equal(t, 10000, finished.listenedMs)
+		assert.True(t, finished.session.Skipped)
+	})
+
+	t.Run("Stopped", func(t *testing.T) {
+		tracker := playbackTracker{}
+		tracker.observe(getPlayerState("t_id", 1000, true), now)
+
+		finished := tracker.observe(&playerState{}, now.Add(10*time.Second))
+		assert.NotNil(t, finished)
+		assert.Nil(t, tracker.current)
+	})
+}
+
+func TestObservedSession_setDevice(t *testing.T) {
+	observed := observedSession{}
+	observed.setDevice(spotify.PlayerDevice{})
+	assert.Nil(t, observed.device)
+	assert.False(t, observed.session.DeviceID.Valid)
+
+	observed.setDevice(spotify.PlayerDevice{ID: "d_id", Name: "d_name"})
+	assert.Equal(t, "d_name", observed.device.Name)
+	assert.Equal(t, "d_id", observed.session.DeviceID.String)
+}
+
+func TestPlaybackTracker_finish(t *testing.T) {
+	tracker := playbackTracker{}
+	assert.Nil(t, tracker.finish())
+
+	tracker.observe(getPlayerState("t_id", 1000, true), time.Now())
+	finished := tracker.finish()
+	assert.True(t, finished.session.Skipped)
+	assert.Nil(t, tracker.current)
+}
@@ -0,0 +1,108 @@
+package spotifySaver
+
+import (
+	"github.com/elivlo/SpotifyHistorySaver/lastfm"
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// ScrobbleWindow is the maximum age of plays that are scrobbled. Last.fm ignores older plays.
+const ScrobbleWindow = 14 * 24 * time.Hour
+
+// scrobbler forwards saved plays of tracks to Last.fm and records the result per history entry,
+// so failed plays are retried and accepted plays are never scrobbled twice.
+type scrobbler struct {
+	db      *pop.Connection
+	client  *lastfm.Client
+	session lastfm.Session
+	log     *logrus.Entry
+}
+
+func newScrobbler(db *pop.Connection, client *lastfm.Client, session lastfm.Session, log *logrus.Entry) scrobbler {
+	return scrobbler{
+		db:      db,
+		client:  client,
+		session: session,
+		log:     log,
+	}
+}
+
+// scrobble scrobbles all pending plays of the last ScrobbleWindow before now in batches of lastfm.MaxBatchSize.
+// Plays that failed stop the run, they are retried by the next one. It returns the number of accepted plays.
+func (s scrobbler) scrobble(now time.Time) (int, error) {
+	scrobbled := 0
+	for {
+		plays, err := models.PendingScrobbles(s.db, now.Add(-ScrobbleWindow), lastfm.MaxBatchSize)
+		if err != nil {
+			return scrobbled, errors.Errorf("Could not get plays to scrobble: %v", err)
+		}
+		if len(plays) == 0 {
+			return scrobbled, nil
+		}
+
+		accepted, failed, err := s.scrobbleBatch(plays)
+		scrobbled += accepted
+		if err != nil {
+			return scrobbled, err
+		}
+		if failed > 0 || len(plays) < lastfm.MaxBatchSize {
+			return scrobbled, nil
+		}
+	}
+}
+
+// scrobbleBatch scrobbles plays at once and saves the status of each of them.
+// Plays without artist cannot be scrobbled and are ignored. It returns the number of accepted and failed plays.
+func (s scrobbler) scrobbleBatch(plays []models.ScrobblePlay) (int, int, error) {
+	statuses := map[string][]int{}
+	var batch []lastfm.Scrobble
+	var batchIDs []int
+	for _, p := range plays {
+		if !p.ArtistName.Valid {
+			s.log.Warnf("Track %s played at %v has no artist and is not scrobbled", p.TrackName, p.PlayedAt)
+			statuses[models.ScrobbleStatusIgnored] = append(statuses[models.ScrobbleStatusIgnored], p.ID)
+			continue
+		}
+		batch = append(batch, lastfm.Scrobble{
+			Artist:    p.ArtistName.String,
+			Track:     p.TrackName,
+			Album:     p.AlbumName.String,
+			Timestamp: p.PlayedAt,
+			Duration:  time.Duration(p.DurationMs) * time.Millisecond,
+		})
+		batchIDs = append(batchIDs, p.ID)
+	}
+
+	results, scrobbleErr := s.client.Scrobble(s.session.Key, batch)
+	if scrobbleErr != nil {
+		statuses[models.ScrobbleStatusFailed] = append(statuses[models.ScrobbleStatusFailed], batchIDs...)
+	}
+	for i, r := range results {
+		status := models.ScrobbleStatusScrobbled
+		switch {
+		case r.Temporary():
+			status = models.ScrobbleStatusFailed
+		case !r.Accepted:
+			status = models.ScrobbleStatusIgnored
+			s.log.Debugf("Last.fm ignored %s - %s: %s", batch[i].Artist, batch[i].Track, r.IgnoredMessage)
+		}
+		statuses[status] = append(statuses[status], batchIDs[i])
+	}
+
+	for status, ids := range statuses {
+		err := models.SetScrobbleStatus(s.db, status, ids...)
+		if err != nil {
+			return 0, 0, errors.Errorf("Could not save scrobble status: %v", err)
+		}
+		metrics.Scrobbles.WithLabelValues(metrics.ServiceLastFM, status).Add(float64(len(ids)))
+	}
+
+	if scrobbleErr != nil {
+		return 0, len(batchIDs), errors.Errorf("Could not scrobble plays: %v", scrobbleErr)
+	}
+	return len(statuses[models.ScrobbleStatusScrobbled]), len(statuses[models.ScrobbleStatusFailed]), nil
+}
@@ -0,0 +1,572 @@
+package spotifySaver
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/elivlo/SpotifyHistorySaver/lastfm"
+	"github.com/elivlo/SpotifyHistorySaver/listenbrainz"
+	"github.com/elivlo/SpotifyHistorySaver/logging"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// importIDPrefix prefixes the IDs of tracks, artists and albums of imported scrobbles that are unknown to Spotify
+	importIDPrefix = "import:"
+	// importDefaultDuration is the assumed length of tracks without duration when looking for duplicate plays
+	importDefaultDuration = 5 * time.Minute
+)
+
+var (
+	// versionPattern matches additions to track names that differ between services, e.g. "(feat. X)" or "- 2011 Remaster"
+	versionPattern = regexp.MustCompile(`(?i)\s*([(\[][^)\]]*\b(feat|ft|featuring|with|remaster|remastered|radio edit|single version|album version|mono|stereo)\b[^)\]]*[)\]]` +
+		`|\s-\s.*\b(remaster|remastered|radio edit|single version|album version|mono|stereo)\b.*$` +
+		`|\s(feat|ft|featuring)\b.*$)`)
+	// artistSeparatorPattern separates the first of several artists named together, e.g. in "A, B" or "A feat. B"
+	artistSeparatorPattern = regexp.MustCompile(`(?i)\s*(,|;|\s(feat|ft|featuring|x|vs)\b\.?\s)`)
+	// featuringPattern matches featured artists named with the main artist, e.g. in "A feat. B"
+	featuringPattern = regexp.MustCompile(`(?i)\s+(feat|ft|featuring)\b.*$`)
+)
+
+// importedScrobble is a scrobble of a Last.fm or ListenBrainz export. Empty fields are unknown.
+type importedScrobble struct {
+	PlayedAt   time.Time
+	Artist     string
+	ArtistMBID string
+	Album      string
+	Track      string
+	TrackMBID  string
+	ISRC       string
+	SpotifyID  string
+	DurationMs int
+}
+
+// ImportScrobbles will import a Last.fm or ListenBrainz export, source is models.SourceLastFM or models.SourceListenBrainz.
+// Files with extension .csv are read as CSV, all others as JSON. Scrobbles are matched to saved tracks and
+// plays that were already saved from Spotify are skipped.
+func (s *SpotifySaver) ImportScrobbles(source, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scrobbles, err := readScrobbles(source, filepath.Ext(file), f)
+	if err != nil {
+		return errors.Errorf("Could not read %s export %s: %v", source, file, err)
+	}
+	s.log.Infof("Read %d scrobbles from %s", len(scrobbles), file)
+
+	importer := newScrobbleImporter(s.dbConnection, source, s.log.WithField(logging.FieldCategory, "import"))
+	return importer.importScrobbles(scrobbles)
+}
+
+// readScrobbles reads an export of source as CSV if ext is ".csv" and as JSON otherwise.
+func readScrobbles(source, ext string, r io.Reader) ([]importedScrobble, error) {
+	csv := strings.EqualFold(ext, ".csv")
+	switch source {
+	case models.SourceLastFM:
+		read := lastfm.ReadJSONExport
+		if csv {
+			read = lastfm.ReadCSVExport
+		}
+		exported, err := read(r)
+		if err != nil {
+			return nil, err
+		}
+		scrobbles := make([]importedScrobble, len(exported))
+		for i, e := range exported {
+			scrobbles[i] = convertLastFMScrobble(e)
+		}
+		return scrobbles, nil
+	case models.SourceListenBrainz:
+		read := listenbrainz.ReadJSONExport
+		if csv {
+			read = listenbrainz.ReadCSVExport
+		}
+		listens, err := read(r)
+		if err != nil {
+			return nil, err
+		}
+		scrobbles := make([]importedScrobble, len(listens))
+		for i, l := range listens {
+			scrobbles[i] = convertListen(l)
+		}
+		return scrobbles, nil
+	}
+	return nil, errors.Errorf("Unknown source %q", source)
+}
+
+// convertLastFMScrobble converts a scrobble of a Last.fm export.
+func convertLastFMScrobble(e lastfm.ExportedScrobble) importedScrobble {
+	return importedScrobble{
+		PlayedAt:   e.Timestamp,
+		Artist:     e.Artist,
+		ArtistMBID: e.ArtistMBID,
+		Album:      e.Album,
+		Track:      e.Track,
+		TrackMBID:  e.TrackMBID,
+	}
+}
+
+// convertListen converts a listen of a ListenBrainz export. The first of several artists is used as artist.
+func convertListen(l listenbrainz.Listen) importedScrobble {
+	info := l.TrackMetadata.AdditionalInfo
+	mbids := l.MBIDs()
+	scrobble := importedScrobble{
+		PlayedAt:   time.Unix(l.ListenedAt, 0).UTC(),
+		Artist:     l.TrackMetadata.ArtistName,
+		Album:      l.TrackMetadata.ReleaseName,
+		Track:      l.TrackMetadata.TrackName,
+		TrackMBID:  mbids.RecordingMBID,
+		ISRC:       info.ISRC,
+		SpotifyID:  spotifyTrackID(info.SpotifyID),
+		DurationMs: info.DurationMs,
+	}
+	if len(info.ArtistNames) > 0 {
+		scrobble.Artist = info.ArtistNames[0]
+	}
+	if len(mbids.ArtistMBIDs) > 0 {
+		scrobble.ArtistMBID = mbids.ArtistMBIDs[0]
+	}
+	return scrobble
+}
+
+// spotifyTrackID returns the ID of a Spotify track URL, URI or ID. It returns an empty string for other values.
+func spotifyTrackID(value string) string {
+	if id := idFromURI(value, "track"); id != "" {
+		return id
+	}
+	if strings.HasPrefix(value, spotifyOpenURL+"track/") {
+		id := strings.TrimPrefix(value, spotifyOpenURL+"track/")
+		return strings.SplitN(id, "?", 2)[0]
+	}
+	if value != "" && !strings.ContainsAny(value, ":/") {
+		return value
+	}
+	return ""
+}
+
+// normalizeName simplifies a track or artist name to match it across services. It removes featured artists and
+// version additions, replaces "&" with "and" and keeps lower case letters and digits only.
+func normalizeName(name string) string {
+	name = versionPattern.ReplaceAllString(name, "")
+	name = strings.ReplaceAll(strings.ToLower(name), "&", "and")
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return strings.TrimSpace(name)
+	}
+	return b.String()
+}
+
+// firstArtist returns the first of several artists named together like "A, B" or "A feat. B".
+func firstArtist(name string) string {
+	return artistSeparatorPattern.Split(name, 2)[0]
+}
+
+// mainArtist removes featured artists from name. Other artists named together are kept, as in "Earth, Wind & Fire".
+func mainArtist(name string) string {
+	return featuringPattern.ReplaceAllString(name, "")
+}
+
+// nameKey is the key of a track by its normalized first artist and name.
+func nameKey(artist, track string) string {
+	return normalizeName(artist) + "\x00" + normalizeName(track)
+}
+
+// importID creates the ID of a track, artist or album unknown to Spotify from the normalized names identifying it.
+func importID(names ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(names, "\x00")))
+	return importIDPrefix + hex.EncodeToString(sum[:])
+}
+
+// playInterval is the time a scrobble of a saved play may have to be a duplicate of it.
+type playInterval struct {
+	from, to time.Time
+}
+
+// indexedTrack is a saved track with its first artist and the IDs it is matched by.
+type indexedTrack struct {
+	ID         string       `db:"id"`
+	Name       string       `db:"name"`
+	ISRC       nulls.String `db:"isrc"`
+	MBID       nulls.String `db:"mbid"`
+	ArtistName nulls.String `db:"artist_name"`
+}
+
+// indexedPlay is a saved play of a track with the names of the track and its first artist.
+type indexedPlay struct {
+	TrackID    string       `db:"track_id"`
+	PlayedAt   time.Time    `db:"played_at"`
+	Source     nulls.String `db:"source"`
+	DurationMs int          `db:"duration_ms"`
+	TrackName  string       `db:"track_name"`
+	ArtistName nulls.String `db:"artist_name"`
+}
+
+// scrobbleImporter matches imported scrobbles to saved tracks and artists and inserts them as history entries.
+// Tracks and artists are matched by MusicBrainz ID, ISRC, Spotify ID and finally by their normalized names.
+// Scrobbles that were not matched create tracks and artists with IDs prefixed by importIDPrefix.
+type scrobbleImporter struct {
+	db     *pop.Connection
+	source string
+	log    *logrus.Entry
+
+	// tracks are the IDs of saved tracks by "mbid:", "isrc:", "id:" or "name:" keys
+	tracks map[string]string
+	// trackMBIDs are the MusicBrainz IDs of saved tracks
+	trackMBIDs map[string]bool
+	// artists are the saved artists by "mbid:" or "name:" keys
+	artists map[string]*models.Artist
+	// albums are the IDs of saved albums of imported scrobbles
+	albums map[string]bool
+	// plays are the times of saved plays by "track:" or "name:" keys
+	plays map[string][]playInterval
+}
+
+func newScrobbleImporter(db *pop.Connection, source string, log *logrus.Entry) *scrobbleImporter {
+	return &scrobbleImporter{
+		db:         db,
+		source:     source,
+		log:        log,
+		tracks:     map[string]string{},
+		trackMBIDs: map[string]bool{},
+		artists:    map[string]*models.Artist{},
+		albums:     map[string]bool{},
+		plays:      map[string][]playInterval{},
+	}
+}
+
+// importScrobbles inserts all scrobbles that are no duplicates of saved plays or other scrobbles.
+func (i *scrobbleImporter) importScrobbles(scrobbles []importedScrobble) error {
+	if len(scrobbles) == 0 {
+		return nil
+	}
+	err := i.loadTracks()
+	if err != nil {
+		return err
+	}
+	err = i.loadArtists()
+	if err != nil {
+		return err
+	}
+	err = i.loadPlays(scrobbles)
+	if err != nil {
+		return err
+	}
+
+	added, duplicates, created, skipped := 0, 0, 0, 0
+	for _, scrobble := range scrobbles {
+		if strings.TrimSpace(scrobble.Artist) == "" || strings.TrimSpace(scrobble.Track) == "" {
+			skipped++
+			continue
+		}
+
+		trackID, ok, err := i.matchTrack(scrobble)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			trackID, err = i.createTrack(scrobble)
+			if err != nil {
+				return err
+			}
+			created++
+		}
+
+		if i.isDuplicate(trackID, scrobble) {
+			duplicates++
+			continue
+		}
+		err = i.insertPlay(trackID, scrobble)
+		if err != nil {
+			return err
+		}
+		added++
+	}
+	if skipped > 0 {
+		i.log.Warnf("Skipped %d scrobbles without artist or track", skipped)
+	}
+	i.log.WithFields(logrus.Fields{"added": added, "duplicates": duplicates, "created_tracks": created}).
+		Info("Imported scrobbles")
+	return nil
+}
+
+// loadTracks indexes all saved tracks by their IDs and names. Tracks of Spotify win over imported ones with the same name.
+func (i *scrobbleImporter) loadTracks() error {
+	var tracks []indexedTrack
+	err := i.db.RawQuery(`SELECT t.id AS id, t.name AS name, t.isrc AS isrc, t.mbid AS mbid,
+			(SELECT a.name FROM artists_tracks at JOIN artists a ON a.id = at.artist_id
+				WHERE at.track_id = t.id ORDER BY at.id LIMIT 1) AS artist_name
+		FROM tracks t`).All(&tracks)
+	if err != nil {
+		return errors.Errorf("Could not get tracks: %v", err)
+	}
+	for _, t := range tracks {
+		i.tracks["id:"+t.ID] = t.ID
+		if t.MBID.Valid {
+			i.tracks["mbid:"+t.MBID.String] = t.ID
+			i.trackMBIDs[t.ID] = true
+		}
+		// Tracks without ISRC at Spotify have an empty one
+		if t.ISRC.String != "" {
+			i.tracks["isrc:"+strings.ToUpper(t.ISRC.String)] = t.ID
+		}
+		if t.ArtistName.Valid {
+			key := "name:" + nameKey(t.ArtistName.String, t.Name)
+			if existing, ok := i.tracks[key]; !ok || strings.HasPrefix(existing, importIDPrefix) {
+				i.tracks[key] = t.ID
+			}
+		}
+	}
+	return nil
+}
+
+// loadArtists indexes all saved artists by their MusicBrainz IDs and names.
+func (i *scrobbleImporter) loadArtists() error {
+	var artists models.Artists
+	err := i.db.All(&artists)
+	if err != nil {
+		return errors.Errorf("Could not get artists: %v", err)
+	}
+	for j := range artists {
+		i.indexArtist(&artists[j])
+	}
+	return nil
+}
+
+func (i *scrobbleImporter) indexArtist(artist *models.Artist) {
+	if artist.MBID.Valid {
+		i.artists["mbid:"+artist.MBID.String] = artist
+	}
+	key := "name:" + normalizeName(artist.Name)
+	if existing, ok := i.artists[key]; !ok || strings.HasPrefix(existing.ID, importIDPrefix) {
+		i.artists[key] = artist
+	}
+}
+
+// loadPlays indexes the saved plays of tracks in the time range of scrobbles. The played_at of plays saved from
+// Spotify is the end of the play, so a scrobble of its start may be up to the duration of the track earlier.
+// Plays ending up to an hour after the last scrobble are loaded.
+func (i *scrobbleImporter) loadPlays(scrobbles []importedScrobble) error {
+	from, to := scrobbles[0].PlayedAt, scrobbles[0].PlayedAt
+	for _, s := range scrobbles {
+		if s.PlayedAt.Before(from) {
+			from = s.PlayedAt
+		}
+		if s.PlayedAt.After(to) {
+			to = s.PlayedAt
+		}
+	}
+
+	var plays []indexedPlay
+	err := i.db.RawQuery(`SELECT h.track_id AS track_id, h.played_at AS played_at, h.source AS source,
+			t.duration_ms AS duration_ms, t.name AS track_name,
+			(SELECT a.name FROM artists_tracks at JOIN artists a ON a.id = at.artist_id
+				WHERE at.track_id = t.id ORDER BY at.id LIMIT 1) AS artist_name
+		FROM history_entries h JOIN tracks t ON t.id = h.track_id
+		WHERE h.played_at BETWEEN ? AND ?`, from.Add(-importMatchWindow),
+		to.Add(time.Hour+importMatchWindow)).All(&plays)
+	if err != nil {
+		return errors.Errorf("Could not get saved plays: %v", err)
+	}
+	for _, p := range plays {
+		interval := playInterval{from: p.PlayedAt.Add(-importMatchWindow), to: p.PlayedAt.Add(importMatchWindow)}
+		if !p.Source.Valid {
+			duration := time.Duration(p.DurationMs) * time.Millisecond
+			if duration == 0 {
+				duration = importDefaultDuration
+			}
+			interval.from = interval.from.Add(-duration)
+		}
+		i.addPlay(p.TrackID, p.ArtistName.String, p.TrackName, interval)
+	}
+	return nil
+}
+
+func (i *scrobbleImporter) addPlay(trackID, artist, track string, interval playInterval) {
+	i.plays["track:"+trackID] = append(i.plays["track:"+trackID], interval)
+	if artist != "" {
+		key := "name:" + nameKey(artist, track)
+		i.plays[key] = append(i.plays[key], interval)
+	}
+}
+
+// isDuplicate checks if the track of scrobble or a track with the same names was played at the time of the scrobble.
+func (i *scrobbleImporter) isDuplicate(trackID string, scrobble importedScrobble) bool {
+	keys := []string{
+		"track:" + trackID,
+		"name:" + nameKey(scrobble.Artist, scrobble.Track),
+		"name:" + nameKey(firstArtist(scrobble.Artist), scrobble.Track),
+	}
+	for _, key := range keys {
+		for _, interval := range i.plays[key] {
+			if !scrobble.PlayedAt.Before(interval.from) && !scrobble.PlayedAt.After(interval.to) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchTrack finds the saved track of scrobble and completes it and its artist with the MusicBrainz IDs of scrobble.
+// It returns false if no track matches.
+func (i *scrobbleImporter) matchTrack(scrobble importedScrobble) (string, bool, error) {
+	var keys []string
+	if scrobble.TrackMBID != "" {
+		keys = append(keys, "mbid:"+scrobble.TrackMBID)
+	}
+	if scrobble.ISRC != "" {
+		keys = append(keys, "isrc:"+strings.ToUpper(scrobble.ISRC))
+	}
+	if scrobble.SpotifyID != "" {
+		keys = append(keys, "id:"+scrobble.SpotifyID)
+	}
+	keys = append(keys, "name:"+nameKey(scrobble.Artist, scrobble.Track),
+		"name:"+nameKey(firstArtist(scrobble.Artist), scrobble.Track))
+
+	for _, key := range keys {
+		trackID, ok := i.tracks[key]
+		if !ok {
+			continue
+		}
+		if scrobble.TrackMBID != "" && !i.trackMBIDs[trackID] {
+			err := i.db.RawQuery("UPDATE tracks SET mbid = ? WHERE id = ? AND mbid IS NULL", scrobble.TrackMBID, trackID).Exec()
+			if err != nil {
+				return "", false, errors.Errorf("Could not save MusicBrainz ID of track %s: %v", trackID, err)
+			}
+			i.trackMBIDs[trackID] = true
+			i.tracks["mbid:"+scrobble.TrackMBID] = trackID
+		}
+		if scrobble.ArtistMBID != "" {
+			if artist, ok := i.artists["name:"+normalizeName(firstArtist(scrobble.Artist))]; ok && !artist.MBID.Valid {
+				err := i.db.RawQuery("UPDATE artists SET mbid = ? WHERE id = ?", scrobble.ArtistMBID, artist.ID).Exec()
+				if err != nil {
+					return "", false, errors.Errorf("Could not save MusicBrainz ID of artist %s: %v", artist.ID, err)
+				}
+				artist.MBID = nulls.NewString(scrobble.ArtistMBID)
+				i.indexArtist(artist)
+			}
+		}
+		return trackID, true, nil
+	}
+	return "", false, nil
+}
+
+// createTrack inserts the track of a scrobble that matched no saved track, its artist and album if they are unknown, too.
+func (i *scrobbleImporter) createTrack(scrobble importedScrobble) (string, error) {
+	artistName := mainArtist(scrobble.Artist)
+	artist, err := i.artist(artistName, scrobble.ArtistMBID)
+	if err != nil {
+		return "", err
+	}
+
+	id := importID(normalizeName(artistName), normalizeName(scrobble.Track))
+	if _, ok := i.tracks["id:"+id]; ok {
+		return id, nil
+	}
+	track := models.Track{
+		ID:         id,
+		Name:       scrobble.Track,
+		DurationMs: scrobble.DurationMs,
+	}
+	if scrobble.ISRC != "" {
+		track.ISRC = nulls.NewString(strings.ToUpper(scrobble.ISRC))
+	}
+	if scrobble.TrackMBID != "" {
+		track.MBID = nulls.NewString(scrobble.TrackMBID)
+	}
+	if scrobble.Album != "" {
+		album := models.Album{ID: importID(normalizeName(artistName), normalizeName(scrobble.Album)), Name: scrobble.Album}
+		if !i.albums[album.ID] {
+			err = saveAlbum(i.db, album)
+			if err != nil {
+				return "", err
+			}
+			i.albums[album.ID] = true
+		}
+		track.AlbumID = nulls.NewString(album.ID)
+	}
+
+	err = i.db.Create(&track)
+	if err != nil {
+		return "", errors.Errorf("Could not insert track %s: %v", track.Name, err)
+	}
+	err = i.db.Create(&models.ArtistsTrack{ArtistID: artist.ID, TrackID: track.ID})
+	if err != nil {
+		return "", errors.Errorf("Could not link artist %s to track %s: %v", artist.Name, track.Name, err)
+	}
+
+	i.tracks["id:"+track.ID] = track.ID
+	if track.MBID.Valid {
+		i.tracks["mbid:"+track.MBID.String] = track.ID
+		i.trackMBIDs[track.ID] = true
+	}
+	if track.ISRC.Valid {
+		i.tracks["isrc:"+track.ISRC.String] = track.ID
+	}
+	i.tracks["name:"+nameKey(artistName, track.Name)] = track.ID
+	return track.ID, nil
+}
+
+// artist returns the saved artist with mbid or name and inserts it if it is unknown.
+func (i *scrobbleImporter) artist(name, mbid string) (*models.Artist, error) {
+	if artist, ok := i.artists["mbid:"+mbid]; ok && mbid != "" {
+		return artist, nil
+	}
+	if artist, ok := i.artists["name:"+normalizeName(name)]; ok {
+		return artist, nil
+	}
+
+	artist := &models.Artist{ID: importID(normalizeName(name)), Name: name}
+	if mbid != "" {
+		artist.MBID = nulls.NewString(mbid)
+	}
+	err := i.db.Create(artist)
+	if err != nil {
+		return nil, errors.Errorf("Could not insert artist %s: %v", name, err)
+	}
+	i.indexArtist(artist)
+	return artist, nil
+}
+
+// insertPlay inserts a history entry of scrobble. It is marked as already sent to the service it was imported from.
+func (i *scrobbleImporter) insertPlay(trackID string, scrobble importedScrobble) error {
+	entry := models.HistoryEntry{
+		TrackID:  nulls.NewString(trackID),
+		PlayedAt: scrobble.PlayedAt,
+		Source:   nulls.NewString(i.source),
+	}
+	switch i.source {
+	case models.SourceLastFM:
+		entry.ScrobbleStatus = nulls.NewString(models.ScrobbleStatusScrobbled)
+	case models.SourceListenBrainz:
+		entry.ListenBrainzStatus = nulls.NewString(models.ListenStatusSubmitted)
+	}
+	err := i.db.Create(&entry)
+	if err != nil {
+		return errors.Errorf("Could not insert history entry: %v", err)
+	}
+	observeInsertedHistory(entry)
+
+	i.addPlay(trackID, firstArtist(scrobble.Artist), scrobble.Track, playInterval{
+		from: scrobble.PlayedAt.Add(-importMatchWindow),
+		to:   scrobble.PlayedAt.Add(importMatchWindow),
+	})
+	return nil
+}
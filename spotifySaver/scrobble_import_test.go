@@ -0,0 +1,171 @@
+package spotifySaver
+
+import (
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const lastFMExportCSV = `Radiohead,OK Computer,Karma Police,01 May 2019 10:00
+Radiohead,OK Computer,Karma Police,01 May 2019 12:00
+Muse,The Resistance,Uprising (Radio Edit),01 May 2019 12:10
+Portishead,Dummy,Roads,01 May 2019 12:20
+Portishead,Dummy,Roads,01 May 2019 12:20
+`
+
+const listenBrainzExportJSONL = `{"listened_at": 1556791200, "track_metadata": {"artist_name": "MUSE", "track_name": "Starlight (Live)", "mbid_mapping": {"recording_mbid": "rec_mbid_sc3"}}}
+{"listened_at": 1556791500, "track_metadata": {"artist_name": "Radiohead", "track_name": "Karma Police - Remastered", "additional_info": {"isrc": "gbaye9700021"}}}
+{"listened_at": 1556791800, "track_metadata": {"artist_name": "Muse", "track_name": "Uprising", "additional_info": {"spotify_id": "https://open.spotify.com/track/t_id_sc2"}}}
+{"listened_at": 1556792100, "track_metadata": {"artist_name": "Portishead, Beth Gibbons", "track_name": "Roads", "additional_info": {"artist_mbids": ["a_mbid_portishead"]}}}
+{"listened_at": 1556713210, "track_metadata": {"artist_name": "Portishead", "track_name": "Roads"}}
+`
+
+func TestScrobbleImporter_importScrobbles(t *testing.T) {
+	_, log := getTestLogger()
+
+	assert.NoError(t, DB.Create(&models.Artists{
+		{ID: "a_id_sc1", Name: "Radiohead"},
+		{ID: "a_id_sc2", Name: "Muse"},
+	}))
+	assert.NoError(t, DB.Create(&models.Tracks{
+		{ID: "t_id_sc1", Name: "Karma Police", DurationMs: 264000, ISRC: nulls.NewString("GBAYE9700021")},
+		{ID: "t_id_sc2", Name: "Uprising", DurationMs: 305000},
+		{ID: "t_id_sc3", Name: "Starlight", DurationMs: 240000, MBID: nulls.NewString("rec_mbid_sc3")},
+	}))
+	assert.NoError(t, DB.Create(&models.ArtistsTracks{
+		{ArtistID: "a_id_sc1", TrackID: "t_id_sc1"},
+		{ArtistID: "a_id_sc2", TrackID: "t_id_sc2"},
+		{ArtistID: "a_id_sc2", TrackID: "t_id_sc3"},
+	}))
+	// Saved from Spotify at the end of the play
+	assert.NoError(t, DB.Create(&models.HistoryEntry{
+		TrackID:  nulls.NewString("t_id_sc1"),
+		PlayedAt: time.Date(2019, 5, 1, 10, 4, 30, 0, time.UTC),
+	}))
+
+	countSource := func(source string) int {
+		count, err := DB.Where("source = ?", source).Count(&models.HistoryEntry{})
+		assert.NoError(t, err)
+		return count
+	}
+	countTrack := func(trackID string) int {
+		count, err := DB.Where("track_id = ?", trackID).Count(&models.HistoryEntry{})
+		assert.NoError(t, err)
+		return count
+	}
+	roadsID := importID("portishead", "roads")
+
+	t.Run("LastFM", func(t *testing.T) {
+		scrobbles, err := readScrobbles(models.SourceLastFM, ".csv", strings.NewReader(lastFMExportCSV))
+		assert.NoError(t, err)
+		err = newScrobbleImporter(DB, models.SourceLastFM, log).importScrobbles(scrobbles)
+		assert.NoError(t, err)
+
+		assert.Equal(t, 3, countSource(models.SourceLastFM))
+		assert.Equal(t, 2, countTrack("t_id_sc1"))
+		assert.Equal(t, 1, countTrack("t_id_sc2"))
+		assert.Equal(t, 1, countTrack(roadsID))
+
+		track := models.Track{}
+		assert.NoError(t, DB.Find(&track, roadsID))
+		assert.Equal(t, "Roads", track.Name)
+		assert.Equal(t, nulls.NewString(importID("portishead", "dummy")), track.AlbumID)
+		artist := models.Artist{}
+		assert.NoError(t, DB.Find(&artist, importID("portishead")))
+		assert.Equal(t, "Portishead", artist.Name)
+
+		entry := models.HistoryEntry{}
+		assert.NoError(t, DB.Where("track_id = ?", roadsID).First(&entry))
+		assert.Equal(t, nulls.NewString(models.ScrobbleStatusScrobbled), entry.ScrobbleStatus)
+		assert.False(t, entry.ListenBrainzStatus.Valid)
+
+		// Importing again adds nothing
+		err = newScrobbleImporter(DB, models.SourceLastFM, log).importScrobbles(scrobbles)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, countSource(models.SourceLastFM))
+	})
+
+	t.Run("ListenBrainz", func(t *testing.T) {
+		scrobbles, err := readScrobbles(models.SourceListenBrainz, ".jsonl", strings.NewReader(listenBrainzExportJSONL))
+		assert.NoError(t, err)
+		err = newScrobbleImporter(DB, models.SourceListenBrainz, log).importScrobbles(scrobbles)
+		assert.NoError(t, err)
+
+		assert.Equal(t, 4, countSource(models.SourceListenBrainz))
+		assert.Equal(t, 1, countTrack("t_id_sc3"))
+		assert.Equal(t, 3, countTrack("t_id_sc1"))
+		assert.Equal(t, 2, countTrack("t_id_sc2"))
+		assert.Equal(t, 2, countTrack(roadsID))
+
+		artist := models.Artist{}
+		assert.NoError(t, DB.Find(&artist, importID("portishead")))
+		assert.Equal(t, nulls.NewString("a_mbid_portishead"), artist.MBID)
+
+		entry := models.HistoryEntry{}
+		assert.NoError(t, DB.Where("track_id = ? AND source = ?", "t_id_sc3", models.SourceListenBrainz).First(&entry))
+		assert.Equal(t, nulls.NewString(models.ListenStatusSubmitted), entry.ListenBrainzStatus)
+		assert.False(t, entry.ScrobbleStatus.Valid)
+	})
+
+	t.Run("LastEntry", func(t *testing.T) {
+		// Imported plays do not move the start of the next poll
+		last, err := getLastHistoryEntry(DB)
+		assert.NoError(t, err)
+		assert.False(t, last.Source.Valid)
+	})
+}
+
+func TestSpotifySaver_ImportScrobbles(t *testing.T) {
+	_, log := getTestLogger()
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "scrobbles.csv")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("artist,track,uts\na_name_sc_saver,t_name_sc_saver,1556713200\n"), 0600))
+	assert.NoError(t, saver.ImportScrobbles(models.SourceLastFM, file))
+	count, err := DB.Where("track_id = ?", importID("anamescsaver", "tnamescsaver")).Count(&models.HistoryEntry{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	err = saver.ImportScrobbles("unknown", file)
+	assert.Contains(t, err.Error(), `Unknown source "unknown"`)
+
+	err = saver.ImportScrobbles(models.SourceLastFM, "missing.csv")
+	assert.Error(t, err)
+}
+
+func TestNormalizeName(t *testing.T) {
+	assert.Equal(t, "karmapolice", normalizeName("Karma Police - 2017 Remaster"))
+	assert.Equal(t, "uprising", normalizeName("Uprising (Radio Edit)"))
+	assert.Equal(t, "stay", normalizeName("Stay (with Justin Bieber)"))
+	assert.Equal(t, "lovesong", normalizeName("Love Song feat. Someone"))
+	assert.Equal(t, "simonandgarfunkel", normalizeName("Simon & Garfunkel"))
+	assert.Equal(t, "beyoncé", normalizeName("Beyoncé"))
+	assert.Equal(t, "!!!", normalizeName("!!!"))
+	assert.Equal(t, "dancingwithmyself", normalizeName("Dancing with Myself"))
+}
+
+func TestFirstArtist(t *testing.T) {
+	assert.Equal(t, "Portishead", firstArtist("Portishead, Beth Gibbons"))
+	assert.Equal(t, "Calvin Harris", firstArtist("Calvin Harris feat. Rihanna"))
+	assert.Equal(t, "Simon & Garfunkel", firstArtist("Simon & Garfunkel"))
+	assert.Equal(t, "Muse", firstArtist("Muse"))
+}
+
+func TestMainArtist(t *testing.T) {
+	assert.Equal(t, "Calvin Harris", mainArtist("Calvin Harris feat. Rihanna"))
+	assert.Equal(t, "Earth, Wind & Fire", mainArtist("Earth, Wind & Fire"))
+}
+
+func TestSpotifyTrackID(t *testing.T) {
+	assert.Equal(t, "t_id", spotifyTrackID("https://open.spotify.com/track/t_id?si=123"))
+	assert.Equal(t, "t_id", spotifyTrackID("spotify:track:t_id"))
+	assert.Equal(t, "t_id", spotifyTrackID("t_id"))
+	assert.Equal(t, "", spotifyTrackID("https://example.com/track/t_id"))
+	assert.Equal(t, "", spotifyTrackID(""))
+}
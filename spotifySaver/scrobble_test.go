@@ -0,0 +1,130 @@
+package spotifySaver
+
+import (
+	"github.com/elivlo/SpotifyHistorySaver/lastfm"
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/nulls"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestScrobbler_scrobble(t *testing.T) {
+	_, log := getTestLogger()
+
+	now := time.Now().Truncate(time.Second)
+	// Plays of other tests are not scrobbled in this test
+	err := DB.RawQuery("UPDATE history_entries SET scrobble_status = ?", models.ScrobbleStatusIgnored).Exec()
+	assert.NoError(t, err)
+
+	assert.NoError(t, DB.Create(&models.Artist{ID: "a_id_scrobble", Name: "a_name_scrobble"}))
+	tracks := models.Tracks{
+		{ID: "t_id_scrobble", Name: "t_name_scrobble", DurationMs: 200000},
+		{ID: "t_id_scrobble_ignored", Name: "t_name_scrobble_ignored"},
+		{ID: "t_id_scrobble_no_artist", Name: "t_name_scrobble_no_artist"},
+	}
+	assert.NoError(t, DB.Create(&tracks))
+	assert.NoError(t, DB.Create(&models.ArtistsTracks{
+		{ArtistID: "a_id_scrobble", TrackID: "t_id_scrobble"},
+		{ArtistID: "a_id_scrobble", TrackID: "t_id_scrobble_ignored"},
+	}))
+
+	// More plays than fit into one batch, the first one is too old to be scrobbled
+	var entries models.HistoryEntries
+	for i := 0; i <= lastfm.MaxBatchSize; i++ {
+		entries = append(entries, models.HistoryEntry{
+			TrackID:  nulls.NewString("t_id_scrobble"),
+			PlayedAt: now.Add(-ScrobbleWindow).Add(time.Duration(i-1) * 5 * time.Minute),
+		})
+	}
+	entries = append(entries,
+		models.HistoryEntry{TrackID: nulls.NewString("t_id_scrobble_ignored"), PlayedAt: now.Add(-time.Hour)},
+		models.HistoryEntry{TrackID: nulls.NewString("t_id_scrobble_no_artist"), PlayedAt: now.Add(-time.Hour)},
+	)
+	assert.NoError(t, DB.Create(&entries))
+
+	standIn := lastfm.NewStandIn("key", "secret")
+	defer standIn.Close()
+	standIn.Ignore("t_name_scrobble_ignored", lastfm.IgnoredTrack)
+	s := newScrobbler(DB, standIn.Client(), lastfm.Session{Name: "user", Key: "sk_token1"}, log)
+
+	t.Run("Failed", func(t *testing.T) {
+		standIn.Fail(lastfm.ErrorServiceOffline)
+		scrobbled, err := s.scrobble(now)
+		assert.Error(t, err)
+		assert.Equal(t, 0, scrobbled)
+
+		var entry models.HistoryEntry
+		assert.NoError(t, DB.Find(&entry, entries[1].ID))
+		assert.Equal(t, nulls.NewString(models.ScrobbleStatusFailed), entry.ScrobbleStatus)
+	})
+
+	t.Run("Retried", func(t *testing.T) {
+		before := testutil.ToFloat64(metrics.Scrobbles.WithLabelValues(metrics.ServiceLastFM, models.ScrobbleStatusScrobbled))
+		standIn.Fail(0)
+		scrobbled, err := s.scrobble(now)
+		assert.NoError(t, err)
+		assert.Equal(t, lastfm.MaxBatchSize, scrobbled)
+		assert.Equal(t, before+lastfm.MaxBatchSize,
+			testutil.ToFloat64(metrics.Scrobbles.WithLabelValues(metrics.ServiceLastFM, models.ScrobbleStatusScrobbled)))
+
+		scrobbles := standIn.Scrobbles()
+		assert.Equal(t, lastfm.MaxBatchSize, len(scrobbles))
+		assert.Equal(t, lastfm.Scrobble{
+			Artist:    "a_name_scrobble",
+			Track:     "t_name_scrobble",
+			Timestamp: entries[1].PlayedAt,
+			Duration:  200 * time.Second,
+		}, scrobbles[0])
+
+		var entry models.HistoryEntry
+		assert.NoError(t, DB.Find(&entry, entries[0].ID))
+		assert.False(t, entry.ScrobbleStatus.Valid)
+		for _, e := range entries[len(entries)-2:] {
+			assert.NoError(t, DB.Find(&entry, e.ID))
+			assert.Equal(t, nulls.NewString(models.ScrobbleStatusIgnored), entry.ScrobbleStatus)
+		}
+	})
+
+	t.Run("NotScrobbledTwice", func(t *testing.T) {
+		scrobbled, err := s.scrobble(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, scrobbled)
+		assert.Equal(t, lastfm.MaxBatchSize, len(standIn.Scrobbles()))
+	})
+
+	t.Run("DailyLimitExceeded", func(t *testing.T) {
+		standIn.Ignore("t_name_scrobble", lastfm.IgnoredDailyLimitExceeded)
+		entry := models.HistoryEntry{TrackID: nulls.NewString("t_id_scrobble"), PlayedAt: now}
+		assert.NoError(t, DB.Create(&entry))
+
+		scrobbled, err := s.scrobble(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, scrobbled)
+		assert.NoError(t, DB.Find(&entry, entry.ID))
+		assert.Equal(t, nulls.NewString(models.ScrobbleStatusFailed), entry.ScrobbleStatus)
+	})
+}
+
+func TestSpotifySaver_scrobblePlays(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+	// Does nothing without Last.fm
+	saver.scrobblePlays(log)
+
+	standIn := lastfm.NewStandIn("key", "secret")
+	defer standIn.Close()
+	standIn.Fail(lastfm.ErrorInvalidSession)
+	saver.SetLastFM(standIn.Client(), lastfm.Session{Name: "user", Key: "sk_token1"})
+
+	assert.NoError(t, DB.Create(&models.Artist{ID: "a_id_scrobble_saver"}))
+	assert.NoError(t, DB.Create(&models.Track{ID: "t_id_scrobble_saver"}))
+	assert.NoError(t, DB.Create(&models.ArtistsTrack{ArtistID: "a_id_scrobble_saver", TrackID: "t_id_scrobble_saver"}))
+	assert.NoError(t, DB.Create(&models.HistoryEntry{TrackID: nulls.NewString("t_id_scrobble_saver"), PlayedAt: time.Now()}))
+	saver.scrobblePlays(log)
+	assert.Contains(t, saver.Health().LastError, "last.fm error 9")
+}
@@ -0,0 +1,211 @@
+package spotifySaver
+
+import (
+	"encoding/json"
+	"github.com/elivlo/SpotifyHistorySaver/metrics"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/elivlo/SpotifyHistorySaver/webhook"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// WebhookInterval is the time between deliveries of the webhook outbox
+	WebhookInterval = 30 * time.Second
+	// DefaultWebhookAttempts is the default number of attempts before a delivery is given up
+	DefaultWebhookAttempts = 10
+
+	// webhookBackoff is the time before the first retry of a delivery, it doubles with every further attempt
+	webhookBackoff = 30 * time.Second
+	// webhookMaxBackoff is the maximum time between two attempts of a delivery
+	webhookMaxBackoff = 2 * time.Hour
+	// webhookDeliveriesPerRun is the maximum number of deliveries attempted by a run of the webhook worker
+	webhookDeliveriesPerRun = 100
+)
+
+// Results of an attempt to deliver a payload.
+const (
+	webhookStatusDelivered = "delivered"
+	webhookStatusRetry     = "retry"
+	webhookStatusFailed    = "failed"
+)
+
+// webhookDispatcher saves payloads of new plays to the outbox of every webhook target and delivers them.
+// The outbox is a database table, so deliveries that failed are retried with backoff even after a restart.
+type webhookDispatcher struct {
+	db          *pop.Connection
+	client      *webhook.Client
+	targets     map[string]webhook.Target
+	maxAttempts int
+	log         *logrus.Entry
+}
+
+func newWebhookDispatcher(db *pop.Connection, client *webhook.Client, targets []webhook.Target, maxAttempts int, log *logrus.Entry) webhookDispatcher {
+	byName := map[string]webhook.Target{}
+	for _, t := range targets {
+		byName[t.Name] = t
+	}
+	return webhookDispatcher{
+		db:          db,
+		client:      client,
+		targets:     byName,
+		maxAttempts: maxAttempts,
+		log:         log,
+	}
+}
+
+// enqueue saves a payload with the plays of the tracks of entries to the outbox of every target with tx, the
+// transaction that inserted entries. It returns the number of saved deliveries.
+func (d webhookDispatcher) enqueue(tx *pop.Connection, now time.Time, entries models.HistoryEntries) (int, error) {
+	var ids []int
+	for _, e := range entries {
+		if e.TrackID.Valid {
+			ids = append(ids, e.ID)
+		}
+	}
+	if len(ids) == 0 || len(d.targets) == 0 {
+		return 0, nil
+	}
+	plays, err := models.TrackPlays(tx, ids...)
+	if err != nil {
+		return 0, errors.Errorf("Could not get plays for webhooks: %v", err)
+	}
+	body, err := json.Marshal(webhook.Payload{
+		Event:     webhook.EventPlays,
+		CreatedAt: now,
+		Plays:     convertToWebhookPlays(plays),
+	})
+	if err != nil {
+		return 0, errors.Errorf("Could not encode webhook payload: %v", err)
+	}
+
+	names := make([]string, 0, len(d.targets))
+	for name := range d.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// The database may round up fractions of seconds, which would delay the first attempt to the next run
+	due := now.Truncate(time.Second)
+	deliveries := models.WebhookDeliveries{}
+	for _, name := range names {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			Target:        name,
+			Event:         webhook.EventPlays,
+			Payload:       string(body),
+			NextAttemptAt: due,
+		})
+	}
+	err = tx.Create(&deliveries)
+	if err != nil {
+		return 0, errors.Errorf("Could not save webhook deliveries: %v", err)
+	}
+	return len(deliveries), nil
+}
+
+// deliver attempts all deliveries that are due at now, the oldest first. A target that failed is not called
+// again in this run. Failed deliveries are retried with exponential backoff until maxAttempts, deliveries a
+// target rejected permanently or of targets that are no longer configured are given up at once.
+// It returns the number of delivered payloads.
+func (d webhookDispatcher) deliver(now time.Time) (int, error) {
+	deliveries := models.WebhookDeliveries{}
+	err := d.db.Where("delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?", now).
+		Order("id ASC").
+		Limit(webhookDeliveriesPerRun).
+		All(&deliveries)
+	if err != nil {
+		return 0, errors.Errorf("Could not get webhook deliveries: %v", err)
+	}
+
+	delivered := 0
+	unavailable := map[string]bool{}
+	for _, delivery := range deliveries {
+		if unavailable[delivery.Target] {
+			continue
+		}
+		status := d.attempt(now, &delivery)
+		err = d.db.UpdateColumns(&delivery, "attempts", "next_attempt_at", "last_error", "delivered_at", "failed_at")
+		if err != nil {
+			return delivered, errors.Errorf("Could not save webhook delivery %d: %v", delivery.ID, err)
+		}
+		metrics.WebhookDeliveries.WithLabelValues(delivery.Target, status).Inc()
+
+		switch status {
+		case webhookStatusDelivered:
+			delivered++
+		case webhookStatusRetry:
+			unavailable[delivery.Target] = true
+		}
+	}
+	return delivered, nil
+}
+
+// attempt delivers the payload of delivery once and updates it with the result, which it returns.
+func (d webhookDispatcher) attempt(now time.Time, delivery *models.WebhookDelivery) string {
+	delivery.Attempts++
+	target, ok := d.targets[delivery.Target]
+	if !ok {
+		d.log.Warnf("Webhook target %s is not configured, giving up delivery %d", delivery.Target, delivery.ID)
+		delivery.LastError = nulls.NewString("target is not configured")
+		delivery.FailedAt = nulls.NewTime(now)
+		return webhookStatusFailed
+	}
+
+	err := d.client.Deliver(target, delivery.Event, strconv.Itoa(delivery.ID), []byte(delivery.Payload))
+	if err == nil {
+		delivery.LastError = nulls.String{}
+		delivery.DeliveredAt = nulls.NewTime(now)
+		return webhookStatusDelivered
+	}
+
+	delivery.LastError = nulls.NewString(err.Error())
+	apiErr, isAPIErr := err.(webhook.Error)
+	if (isAPIErr && !apiErr.Temporary()) || delivery.Attempts >= d.maxAttempts {
+		d.log.Warnf("Giving up delivery %d to webhook %s after %d attempts: %v", delivery.ID, target.Name, delivery.Attempts, err)
+		delivery.FailedAt = nulls.NewTime(now)
+		return webhookStatusFailed
+	}
+	delivery.NextAttemptAt = now.Add(webhookRetryDelay(delivery.Attempts))
+	d.log.Warnf("Could not deliver %d to webhook %s, retrying at %v: %v", delivery.ID, target.Name, delivery.NextAttemptAt, err)
+	return webhookStatusRetry
+}
+
+// webhookRetryDelay is the time before the next attempt of a delivery that failed attempts times.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookBackoff
+	for i := 1; i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return delay
+}
+
+// convertToWebhookPlays converts plays to the plays of a payload. Tracks of imported scrobbles have no Spotify URL.
+func convertToWebhookPlays(plays []models.ListenPlay) []webhook.Play {
+	converted := make([]webhook.Play, len(plays))
+	for i, p := range plays {
+		track := webhook.Track{
+			ID:         p.TrackID,
+			Name:       p.TrackName,
+			DurationMs: p.DurationMs,
+			Artists:    make([]webhook.Artist, len(p.Artists)),
+		}
+		if isSpotifyID(p.TrackID) {
+			track.URL = spotifyOpenURL + "track/" + p.TrackID
+		}
+		if p.AlbumID.Valid {
+			track.Album = &webhook.Album{ID: p.AlbumID.String, Name: p.AlbumName.String}
+		}
+		for j, a := range p.Artists {
+			track.Artists[j] = webhook.Artist{ID: a.ID, Name: a.Name}
+		}
+		converted[i] = webhook.Play{ID: p.ID, PlayedAt: p.PlayedAt.UTC(), Track: track}
+	}
+	return converted
+}
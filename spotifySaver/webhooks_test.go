@@ -0,0 +1,190 @@
+package spotifySaver
+
+import (
+	"encoding/json"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/elivlo/SpotifyHistorySaver/webhook"
+	"github.com/gobuffalo/nulls"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhookDispatcher(t *testing.T) {
+	_, log := getTestLogger()
+
+	now := time.Now().Truncate(time.Second)
+	// Deliveries of other tests are not delivered in this test
+	err := DB.RawQuery("UPDATE webhook_deliveries SET delivered_at = ?", now).Exec()
+	assert.NoError(t, err)
+
+	assert.NoError(t, DB.Create(&models.Album{ID: "al_id_wh", Name: "al_name_wh"}))
+	assert.NoError(t, DB.Create(&models.Artist{ID: "a_id_wh", Name: "a_name_wh"}))
+	assert.NoError(t, DB.Create(&models.Track{ID: "t_id_wh", Name: "t_name_wh", AlbumID: nulls.NewString("al_id_wh"), DurationMs: 200000}))
+	assert.NoError(t, DB.Create(&models.ArtistsTrack{ArtistID: "a_id_wh", TrackID: "t_id_wh"}))
+	entries := models.HistoryEntries{
+		{TrackID: nulls.NewString("t_id_wh"), PlayedAt: now.Add(-time.Minute)},
+	}
+	assert.NoError(t, DB.Create(&entries))
+
+	home := webhook.NewStandIn("secret")
+	defer home.Close()
+	chat := webhook.NewStandIn("")
+	defer chat.Close()
+	d := newWebhookDispatcher(DB, webhook.NewClient(), []webhook.Target{
+		home.Target("home", "secret"),
+		chat.Target("chat", ""),
+	}, 2, log)
+
+	delivery := func(target string) models.WebhookDelivery {
+		var deliveries models.WebhookDeliveries
+		assert.NoError(t, DB.Where("target = ?", target).Order("id DESC").All(&deliveries))
+		return deliveries[0]
+	}
+
+	t.Run("Enqueue", func(t *testing.T) {
+		n, err := d.enqueue(DB, now, entries)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		n, err = d.enqueue(DB, now, models.HistoryEntries{{EpisodeID: nulls.NewString("e_id")}})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("Deliver", func(t *testing.T) {
+		chat.Fail(http.StatusServiceUnavailable)
+		delivered, err := d.deliver(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, delivered)
+
+		requests := home.Requests()
+		assert.Equal(t, 1, len(requests))
+		assert.True(t, requests[0].Signed)
+		assert.Equal(t, webhook.EventPlays, requests[0].Event)
+		var payload webhook.Payload
+		assert.NoError(t, json.Unmarshal(requests[0].Body, &payload))
+		assert.Equal(t, []webhook.Play{{
+			ID:       entries[0].ID,
+			PlayedAt: entries[0].PlayedAt.UTC(),
+			Track: webhook.Track{
+				ID:         "t_id_wh",
+				Name:       "t_name_wh",
+				URL:        "https://open.spotify.com/track/t_id_wh",
+				DurationMs: 200000,
+				Album:      &webhook.Album{ID: "al_id_wh", Name: "al_name_wh"},
+				Artists:    []webhook.Artist{{ID: "a_id_wh", Name: "a_name_wh"}},
+			},
+		}}, payload.Plays)
+		assert.True(t, delivery("home").DeliveredAt.Valid)
+
+		failed := delivery("chat")
+		assert.Equal(t, 1, failed.Attempts)
+		assert.True(t, now.Add(webhookBackoff).Equal(failed.NextAttemptAt))
+		assert.Contains(t, failed.LastError.String, "status 503")
+		assert.False(t, failed.FailedAt.Valid)
+		assert.Equal(t, strconv.Itoa(failed.ID), chat.Requests()[0].Delivery)
+
+		// The retry is not due yet
+		delivered, err = d.deliver(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, delivered)
+		assert.Equal(t, 1, len(chat.Requests()))
+
+		// The last attempt gives the delivery up
+		delivered, err = d.deliver(now.Add(webhookBackoff))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, delivered)
+		failed = delivery("chat")
+		assert.Equal(t, 2, failed.Attempts)
+		assert.True(t, failed.FailedAt.Valid)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		chat.Fail(http.StatusNotFound)
+		_, err := d.enqueue(DB, now, entries)
+		assert.NoError(t, err)
+		delivered, err := d.deliver(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, delivered)
+
+		failed := delivery("chat")
+		assert.Equal(t, 1, failed.Attempts)
+		assert.True(t, failed.FailedAt.Valid)
+	})
+
+	t.Run("UnknownTarget", func(t *testing.T) {
+		_, err := d.enqueue(DB, now, entries)
+		assert.NoError(t, err)
+		removed := newWebhookDispatcher(DB, webhook.NewClient(), []webhook.Target{home.Target("home", "secret")}, 2, log)
+		delivered, err := removed.deliver(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, delivered)
+
+		failed := delivery("chat")
+		assert.Equal(t, nulls.NewString("target is not configured"), failed.LastError)
+		assert.True(t, failed.FailedAt.Valid)
+	})
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	assert.Equal(t, 30*time.Second, webhookRetryDelay(1))
+	assert.Equal(t, time.Minute, webhookRetryDelay(2))
+	assert.Equal(t, 4*time.Minute, webhookRetryDelay(4))
+	assert.Equal(t, webhookMaxBackoff, webhookRetryDelay(9))
+	assert.Equal(t, webhookMaxBackoff, webhookRetryDelay(100))
+}
+
+func TestSpotifySaver_SetWebhooks(t *testing.T) {
+	_, log := getTestLogger()
+
+	saver, err := NewSpotifySaver(log, "test")
+	assert.NoError(t, err)
+	// Does nothing without webhooks
+	saver.deliverWebhooks()
+
+	standIn := webhook.NewStandIn("secret")
+	defer standIn.Close()
+	saver.SetWebhooks(webhook.NewClient(), []webhook.Target{standIn.Target("saver", "secret")}, DefaultWebhookAttempts)
+
+	err = saver.insertNewSongs(log, []spotify.RecentlyPlayedItem{{
+		Track: spotify.SimpleTrack{
+			ID:      "t_id_wh_saver",
+			Name:    "t_name_wh_saver",
+			Artists: []spotify.SimpleArtist{{ID: "a_id_wh_saver", Name: "a_name_wh_saver"}},
+		},
+		PlayedAt: time.Now(),
+	}})
+	assert.NoError(t, err)
+
+	saver.deliverWebhooks()
+	requests := standIn.Requests()
+	assert.Equal(t, 1, len(requests))
+	assert.True(t, requests[0].Signed)
+	assert.Contains(t, string(requests[0].Body), "t_name_wh_saver")
+
+	t.Run("Rollback", func(t *testing.T) {
+		playedAt := time.Now()
+		err = DB.RawQuery("RENAME TABLE webhook_deliveries TO webhook_deliveries_renamed").Exec()
+		assert.NoError(t, err)
+		err = saver.insertNewSongs(log, []spotify.RecentlyPlayedItem{{
+			Track: spotify.SimpleTrack{
+				ID:      "t_id_wh_rollback",
+				Name:    "t_name_wh_rollback",
+				Artists: []spotify.SimpleArtist{{ID: "a_id_wh_rollback", Name: "a_name_wh_rollback"}},
+			},
+			PlayedAt: playedAt,
+		}})
+		assert.Error(t, err)
+		err = DB.RawQuery("RENAME TABLE webhook_deliveries_renamed TO webhook_deliveries").Exec()
+		assert.NoError(t, err)
+
+		// The play is inserted again by the next poll, so it is not saved without its deliveries
+		count, err := DB.Where("track_id = ?", "t_id_wh_rollback").Count(&models.HistoryEntry{})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+}
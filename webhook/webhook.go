@@ -0,0 +1,169 @@
+// Package webhook posts signed JSON payloads of new plays to HTTP targets.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Headers of every delivery.
+const (
+	// EventHeader names the event of the payload
+	EventHeader = "X-Webhook-Event"
+	// DeliveryHeader is the ID of the delivery. It is the same for every retry, so targets can ignore duplicates.
+	DeliveryHeader = "X-Webhook-Delivery"
+	// SignatureHeader is "sha256=" followed by the hex HMAC-SHA256 of the body with the secret of the target.
+	// It is only sent to targets with a secret.
+	SignatureHeader = "X-Webhook-Signature-256"
+)
+
+// Events of payloads.
+const (
+	// EventPlays is the event of newly saved plays
+	EventPlays = "plays"
+	// EventTest is the event of test deliveries
+	EventTest = "test"
+)
+
+const (
+	// requestTimeout is the maximum time of a delivery
+	requestTimeout = 10 * time.Second
+	// userAgent identifies this app to targets
+	userAgent = "SpotifyHistorySaver"
+	// maxErrorBody is the maximum length of a response body kept in an Error
+	maxErrorBody = 200
+)
+
+// Target is a URL payloads are posted to. Payloads are signed if Secret is set.
+type Target struct {
+	Name   string
+	URL    string
+	Secret string
+}
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Plays     []Play    `json:"plays"`
+}
+
+// Play is a saved play of a track.
+type Play struct {
+	ID       int       `json:"id"`
+	PlayedAt time.Time `json:"played_at"`
+	Track    Track     `json:"track"`
+}
+
+// Track is a played track. URL is empty for tracks unknown to Spotify.
+type Track struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	URL        string   `json:"url,omitempty"`
+	DurationMs int      `json:"duration_ms"`
+	Album      *Album   `json:"album,omitempty"`
+	Artists    []Artist `json:"artists"`
+}
+
+// Album is the album of a track.
+type Album struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Artist is an artist of a track.
+type Artist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Error is a response of a target with a status code other than 2xx.
+type Error struct {
+	Code int
+	Body string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("webhook target responded with status %d: %s", e.Code, e.Body)
+}
+
+// Temporary checks if the delivery may succeed when it is retried later.
+func (e Error) Temporary() bool {
+	return e.Code == http.StatusRequestTimeout || e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+// Sign returns the value of SignatureHeader for body signed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks if signature is the value of SignatureHeader for body signed with secret.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Client delivers payloads to targets.
+type Client struct {
+	http *http.Client
+}
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithHTTPClient replaces the HTTP client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// NewClient creates a Client.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		http: &http.Client{Timeout: requestTimeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Deliver posts body, a JSON payload of event, to target. deliveryID identifies the delivery across retries.
+// Responses other than 2xx return an Error.
+func (c *Client) Deliver(target Target, event, deliveryID string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	if target.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(target.Secret, body))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	message := strings.TrimSpace(string(data))
+	if len(message) > maxErrorBody {
+		message = message[:maxErrorBody]
+	}
+	return Error{Code: resp.StatusCode, Body: message}
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Request is a delivery received by a StandIn.
+type Request struct {
+	Event    string
+	Delivery string
+	// Signed is true if the request was signed with the secret of the stand-in
+	Signed bool
+	Body   []byte
+}
+
+// StandIn is a local webhook target for tests. It records all requests and verifies their signatures.
+type StandIn struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	requests []Request
+	failCode int
+}
+
+// NewStandIn starts a StandIn expecting requests signed with secret. Close it after use.
+func NewStandIn(secret string) *StandIn {
+	s := &StandIn{secret: secret}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Target returns a target named name posting to the stand-in and signing with secret.
+func (s *StandIn) Target(name, secret string) Target {
+	return Target{Name: name, URL: s.URL, Secret: secret}
+}
+
+// Fail makes all following requests fail with the HTTP status code. 0 lets them succeed again.
+func (s *StandIn) Fail(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failCode = code
+}
+
+// Requests returns all received requests, including failed ones.
+func (s *StandIn) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *StandIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	s.requests = append(s.requests, Request{
+		Event:    r.Header.Get(EventHeader),
+		Delivery: r.Header.Get(DeliveryHeader),
+		Signed:   Verify(s.secret, body, r.Header.Get(SignatureHeader)),
+		Body:     body,
+	})
+	if s.failCode != 0 {
+		http.Error(w, http.StatusText(s.failCode), s.failCode)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestError(t *testing.T) {
+	err := Error{Code: http.StatusNotFound, Body: "not found"}
+	assert.EqualError(t, err, "webhook target responded with status 404: not found")
+	assert.False(t, err.Temporary())
+	assert.True(t, Error{Code: http.StatusTooManyRequests}.Temporary())
+	assert.True(t, Error{Code: http.StatusBadGateway}.Temporary())
+}
+
+func TestSign(t *testing.T) {
+	// Example of GitHub's webhook documentation
+	signature := Sign("It's a Secret to Everybody", []byte("Hello, World!"))
+	assert.Equal(t, "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17", signature)
+	assert.True(t, Verify("It's a Secret to Everybody", []byte("Hello, World!"), signature))
+	assert.False(t, Verify("wrong", []byte("Hello, World!"), signature))
+	assert.False(t, Verify("It's a Secret to Everybody", []byte("Hello, World!"), ""))
+}
+
+func TestPayload_json(t *testing.T) {
+	data, err := json.Marshal(Payload{
+		Event:     EventPlays,
+		CreatedAt: time.Date(2021, 3, 20, 10, 0, 0, 0, time.UTC),
+		Plays: []Play{{
+			ID:       1,
+			PlayedAt: time.Date(2021, 3, 20, 9, 58, 0, 0, time.UTC),
+			Track: Track{
+				ID:         "t_id",
+				Name:       "t_name",
+				URL:        "https://open.spotify.com/track/t_id",
+				DurationMs: 120000,
+				Artists:    []Artist{{ID: "a_id", Name: "a_name"}},
+			},
+		}},
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"event": "plays", "created_at": "2021-03-20T10:00:00Z", "plays": [{"id": 1,
+		"played_at": "2021-03-20T09:58:00Z", "track": {"id": "t_id", "name": "t_name",
+		"url": "https://open.spotify.com/track/t_id", "duration_ms": 120000, "artists": [{"id": "a_id", "name": "a_name"}]}}]}`,
+		string(data))
+}
+
+func TestClient_Deliver(t *testing.T) {
+	standIn := NewStandIn("secret")
+	defer standIn.Close()
+	c := NewClient()
+	body := []byte(`{"event": "test"}`)
+
+	assert.NoError(t, c.Deliver(standIn.Target("signed", "secret"), EventTest, "1", body))
+	assert.NoError(t, c.Deliver(standIn.Target("unsigned", ""), EventTest, "2", body))
+	assert.Equal(t, []Request{
+		{Event: EventTest, Delivery: "1", Signed: true, Body: body},
+		{Event: EventTest, Delivery: "2", Signed: false, Body: body},
+	}, standIn.Requests())
+
+	standIn.Fail(http.StatusServiceUnavailable)
+	err := c.Deliver(standIn.Target("signed", "secret"), EventTest, "3", body)
+	assert.EqualError(t, err, "webhook target responded with status 503: Service Unavailable")
+	assert.True(t, err.(Error).Temporary())
+
+	err = c.Deliver(Target{Name: "invalid", URL: "http://127.0.0.1:0"}, EventTest, "4", body)
+	assert.Error(t, err)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/gobuffalo/pop/v5"
+	"html/template"
+	"io"
+	"os"
+	"time"
+)
+
+// heatmapCell is a day of the listening heatmap.
+type heatmapCell struct {
+	Date    string
+	Minutes int64
+	// Level is the intensity from 0 (nothing played) to 4 (most played)
+	Level  int
+	InYear bool
+}
+
+// wrappedPage is the data of the Wrapped HTML template.
+type wrappedPage struct {
+	*models.Wrapped
+	Minutes int64
+	// Heatmap holds the weeks of the year, each with 7 days starting on Monday
+	Heatmap   [][]heatmapCell
+	Generated time.Time
+}
+
+var wrappedTemplate = template.Must(template.New("wrapped").Funcs(template.FuncMap{
+	"minutes": func(ms int64) int64 { return ms / 60000 },
+	"date":    func(t time.Time) string { return t.Format("January 2") },
+}).Parse(wrappedHTML))
+
+// reportWrapped writes the Wrapped summary of a year as self-contained HTML file.
+func reportWrapped(db *pop.Connection, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("report wrapped", flag.ContinueOnError)
+	year := fs.Int("year", time.Now().Year(), "year: summarize this year")
+	output := fs.String("output", "", "output: HTML file to write, - for stdout (default wrapped-<year>.html)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	wrapped, err := models.NewWrapped(db, *year, time.Local)
+	if err != nil {
+		return fmt.Errorf("could not compute wrapped: %v", err)
+	}
+
+	if *output == "-" {
+		return writeWrapped(out, wrapped, time.Now())
+	}
+	if *output == "" {
+		*output = fmt.Sprintf("wrapped-%d.html", *year)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %v", *output, err)
+	}
+	err = writeWrapped(f, wrapped, time.Now())
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	log.Infof("Wrote wrapped %d to %s", *year, *output)
+	return f.Close()
+}
+
+// writeWrapped renders wrapped as HTML page to out.
+func writeWrapped(out io.Writer, wrapped *models.Wrapped, generated time.Time) error {
+	page := wrappedPage{
+		Wrapped:   wrapped,
+		Minutes:   wrapped.Total.MsPlayed / 60000,
+		Heatmap:   heatmap(wrapped.Year, wrapped.Days, time.Local),
+		Generated: generated,
+	}
+	return wrappedTemplate.Execute(out, page)
+}
+
+// heatmap arranges the days of year in weeks starting on Monday. The level of a day is relative to the most played day.
+func heatmap(year int, days []models.PeriodListeningTime, loc *time.Location) [][]heatmapCell {
+	minutes := map[string]int64{}
+	var max int64
+	for _, d := range days {
+		m := d.MsPlayed / 60000
+		minutes[d.Label] = m
+		if m > max {
+			max = m
+		}
+	}
+
+	first := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	day := first.AddDate(0, 0, -(int(first.Weekday())+6)%7)
+	var weeks [][]heatmapCell
+	for day.Year() <= year {
+		week := make([]heatmapCell, 7)
+		for i := range week {
+			label := day.Format(DateLayout)
+			cell := heatmapCell{Date: label, Minutes: minutes[label], InYear: day.Year() == year}
+			if cell.Minutes > 0 && max > 0 {
+				cell.Level = int(1 + 3*cell.Minutes/max)
+				if cell.Level > 4 {
+					cell.Level = 4
+				}
+			}
+			week[i] = cell
+			day = day.AddDate(0, 0, 1)
+		}
+		weeks = append(weeks, week)
+	}
+	return weeks
+}
+
+const wrappedHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Your {{.Year}} Wrapped</title>
+<style>
+body { margin: 0; font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; background: #121212; color: #fff; }
+main { max-width: 960px; margin: 0 auto; padding: 32px 16px; }
+h1 { font-size: 48px; margin: 0 0 8px; color: #1db954; }
+h2 { margin-top: 40px; border-bottom: 1px solid #333; padding-bottom: 8px; }
+.cards { display: flex; flex-wrap: wrap; gap: 16px; }
+.card { flex: 1 1 200px; background: #1e1e1e; border-radius: 8px; padding: 16px; }
+.card .value { font-size: 32px; font-weight: bold; color: #1db954; }
+.lists { display: flex; flex-wrap: wrap; gap: 24px; }
+.lists section { flex: 1 1 280px; }
+ol { padding-left: 24px; }
+li { margin: 6px 0; }
+.plays { color: #b3b3b3; font-size: 14px; }
+.heatmap { display: flex; gap: 3px; overflow-x: auto; }
+.week { display: flex; flex-direction: column; gap: 3px; }
+.day { width: 12px; height: 12px; border-radius: 2px; background: #2a2a2a; }
+.day.out { visibility: hidden; }
+.l1 { background: #0e4429; } .l2 { background: #006d32; } .l3 { background: #26a641; } .l4 { background: #39d353; }
+footer { margin-top: 40px; color: #777; font-size: 12px; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Year}} Wrapped</h1>
+<p>Your year in music, computed from your own listening history.</p>
+
+<div class="cards">
+  <div class="card"><div class="value">{{.Minutes}}</div>minutes listened</div>
+  <div class="card"><div class="value">{{.Total.Plays}}</div>plays</div>
+  <div class="card"><div class="value">{{len .NewArtists}}</div>new artists discovered</div>
+  {{with .MostPlayedDay}}<div class="card"><div class="value">{{date .Start}}</div>your biggest day with {{minutes .MsPlayed}} minutes</div>{{end}}
+</div>
+
+<div class="lists">
+  <section>
+    <h2>Top artists</h2>
+    <ol>{{range .TopArtists}}<li>{{.Name}} <span class="plays">{{.Plays}} plays</span></li>{{else}}<p>Nothing played.</p>{{end}}</ol>
+  </section>
+  <section>
+    <h2>Top tracks</h2>
+    <ol>{{range .TopTracks}}<li>{{.Name}} <span class="plays">{{.Plays}} plays</span></li>{{else}}<p>Nothing played.</p>{{end}}</ol>
+  </section>
+  <section>
+    <h2>Top albums</h2>
+    <ol>{{range .TopAlbums}}<li>{{.Name}} <span class="plays">{{.Plays}} plays</span></li>{{else}}<p>Nothing played.</p>{{end}}</ol>
+  </section>
+</div>
+
+<h2>Listening heatmap</h2>
+<div class="heatmap">
+{{- range .Heatmap}}
+  <div class="week">{{range .}}<div class="day{{if not .InYear}} out{{else if .Level}} l{{.Level}}{{end}}" title="{{.Date}}: {{.Minutes}} minutes"></div>{{end}}</div>
+{{- end}}
+</div>
+
+<div class="lists">
+  <section>
+    <h2>Longest streaks</h2>
+    <ol>{{range .Streaks}}<li>{{.Days}} days <span class="plays">{{date .Start}} &ndash; {{date .End}}</span></li>{{else}}<p>No streaks.</p>{{end}}</ol>
+  </section>
+  <section>
+    <h2>First plays of new artists</h2>
+    <ol>{{range $i, $a := .NewArtists}}{{if lt $i 20}}<li>{{$a.Name}} <span class="plays">{{date $a.FirstPlayedAt}}</span></li>{{end}}{{else}}<p>No new artists.</p>{{end}}</ol>
+  </section>
+</div>
+
+<footer>Generated by SpotifyHistorySaver on {{.Generated.Format "2006-01-02 15:04"}}.</footer>
+</main>
+</body>
+</html>
+`
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"github.com/elivlo/SpotifyHistorySaver/models"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReportWrapped(t *testing.T) {
+	var out bytes.Buffer
+
+	err := runReport(DB, []string{"wrapped", "--year", "2021", "--output", "-"}, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "<title>Your 2021 Wrapped</title>")
+
+	dir, err := ioutil.TempDir("", "wrapped")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "wrapped.html")
+	err = runReport(DB, []string{"wrapped", "--year", "2021", "--output", file}, &out)
+	assert.NoError(t, err)
+	html, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(html), "2021 Wrapped")
+
+	err = runReport(DB, []string{"wrapped", "--output", filepath.Join(dir, "missing", "wrapped.html")}, &out)
+	assert.Contains(t, err.Error(), "could not create")
+}
+
+func TestWriteWrapped(t *testing.T) {
+	day := models.PeriodListeningTime{
+		ListeningTime: models.ListeningTime{Plays: 20, MsPlayed: 3600000},
+		Label:         "2021-03-01",
+		Start:         time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local),
+	}
+	wrapped := &models.Wrapped{
+		Year:          2021,
+		Total:         day.ListeningTime,
+		TopArtists:    models.TopEntries{{ID: "a_id", Name: "Simon & Garfunkel", Plays: 20}},
+		Days:          []models.PeriodListeningTime{day},
+		MostPlayedDay: &day,
+		NewArtists:    []models.FirstPlay{{ID: "a_id", Name: "Simon & Garfunkel", FirstPlayedAt: day.Start}},
+		Streaks:       []models.Streak{{Start: day.Start, End: day.Start, Days: 1}},
+	}
+
+	var out bytes.Buffer
+	err := writeWrapped(&out, wrapped, time.Now())
+	assert.NoError(t, err)
+	html := out.String()
+	assert.Contains(t, html, `<div class="value">60</div>minutes listened`)
+	assert.Contains(t, html, "Simon &amp; Garfunkel")
+	assert.Contains(t, html, "March 1")
+	assert.Contains(t, html, `class="day l4" title="2021-03-01: 60 minutes"`)
+}
+
+func TestHeatmap(t *testing.T) {
+	days := []models.PeriodListeningTime{
+		{ListeningTime: models.ListeningTime{MsPlayed: 60 * 60000}, Label: "2021-01-01"},
+		{ListeningTime: models.ListeningTime{MsPlayed: 10 * 60000}, Label: "2021-01-04"},
+	}
+
+	weeks := heatmap(2021, days, time.UTC)
+	assert.Equal(t, 53, len(weeks))
+	// 2021-01-01 is a Friday, so the first week starts on Monday 2020-12-28
+	assert.Equal(t, heatmapCell{Date: "2020-12-28"}, weeks[0][0])
+	assert.Equal(t, heatmapCell{Date: "2021-01-01", Minutes: 60, Level: 4, InYear: true}, weeks[0][4])
+	assert.Equal(t, heatmapCell{Date: "2021-01-04", Minutes: 10, Level: 1, InYear: true}, weeks[1][0])
+	assert.Equal(t, "2022-01-02", weeks[52][6].Date)
+	assert.False(t, weeks[52][6].InYear)
+}